@@ -53,7 +53,8 @@ if [[ "$BUILD_MODE" != "dev" && "$BUILD_MODE" != "prod" ]]; then
 fi
 
 # 设置环境变量
-export CGO_ENABLED=0
+# 按二维码拆分学生使用的go-fitz需要cgo链接MuPDF，构建机需要安装gcc；在alpine等musl系统上构建时加上 -tags musl
+export CGO_ENABLED=1
 export GOOS=linux
 export GOARCH=amd64
 
@@ -0,0 +1,175 @@
+// fakellm 是一个按脚本回放响应的假大模型服务，实现了OpenAI兼容的/chat/completions接口
+//
+// 用法:
+//
+//	go run ./cmd/fakellm -addr :9090 -script cmd/fakellm/example.json
+//
+// 然后以 LLM_PROVIDER=openai OPENAI_BASE_URL=http://localhost:9090/v1 OPENAI_MODEL=fake
+// 启动后端服务，即可在没有网络的情况下运行完整的上传、拆分、批改流程
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/GiantClam/homework_marking/services"
+)
+
+// chatRequest OpenAI兼容的聊天补全请求（只解析需要的字段）
+type chatRequest struct {
+	Model    string `json:"model"`
+	Stream   bool   `json:"stream"`
+	Messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+}
+
+// contentPart 多模态消息中的内容片段
+type contentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+	File *struct {
+		Filename string `json:"filename"`
+		FileData string `json:"file_data"`
+	} `json:"file"`
+}
+
+// toCall 将请求转换为假大模型的调用记录，用于匹配脚本中的响应
+func (r *chatRequest) toCall() services.FakeLLMCall {
+	var call services.FakeLLMCall
+	var prompts []string
+
+	for _, msg := range r.Messages {
+		// 内容可以是字符串或内容片段数组
+		var text string
+		if err := json.Unmarshal(msg.Content, &text); err == nil {
+			if msg.Role == "system" {
+				call.SystemInstruction = text
+			} else {
+				prompts = append(prompts, text)
+			}
+			continue
+		}
+
+		var parts []contentPart
+		if err := json.Unmarshal(msg.Content, &parts); err != nil {
+			continue
+		}
+		for _, part := range parts {
+			switch part.Type {
+			case "text":
+				prompts = append(prompts, part.Text)
+			case "file":
+				if part.File != nil {
+					call.FileName = part.File.Filename
+					call.MimeType = dataURLMimeType(part.File.FileData)
+				}
+			case "image_url":
+				if part.ImageURL != nil {
+					call.MimeType = dataURLMimeType(part.ImageURL.URL)
+				}
+			}
+		}
+	}
+
+	call.Prompt = strings.Join(prompts, "\n")
+	return call
+}
+
+// dataURLMimeType 从data URL中提取MIME类型
+func dataURLMimeType(dataURL string) string {
+	if !strings.HasPrefix(dataURL, "data:") {
+		return ""
+	}
+	mimeType, _, _ := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ";")
+	return mimeType
+}
+
+func main() {
+	addr := flag.String("addr", ":9090", "监听地址")
+	scriptPath := flag.String("script", "cmd/fakellm/example.json", "响应脚本(JSON)路径")
+	flag.Parse()
+
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	script, err := services.LoadFakeLLMScript(*scriptPath)
+	if err != nil {
+		log.Fatalf("加载脚本失败: %v", err)
+	}
+	provider := services.NewFakeLLMProvider(script.Responses...)
+	log.Printf("已加载 %d 条预设响应", len(script.Responses))
+
+	http.HandleFunc("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("请求格式错误: %v", err))
+			return
+		}
+
+		call := req.toCall()
+		log.Printf("收到请求, 模型: %s, 文件: %s, 提示词长度: %d", req.Model, call.FileName, len(call.Prompt))
+
+		text, err := provider.Respond(r.Context(), call)
+		finishReason := "stop"
+		if err != nil {
+			// 安全策略拦截以content_filter返回，超时以504返回，其他错误以500返回
+			if strings.Contains(err.Error(), "安全策略") {
+				text, finishReason = "", "content_filter"
+			} else if errors.Is(err, context.DeadlineExceeded) {
+				writeError(w, http.StatusGatewayTimeout, err.Error())
+				return
+			} else {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+
+		if req.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			chunk, _ := json.Marshal(map[string]interface{}{
+				"choices": []map[string]interface{}{
+					{"delta": map[string]string{"content": text}, "finish_reason": finishReason},
+				},
+			})
+			fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", chunk)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"model": req.Model,
+			"choices": []map[string]interface{}{
+				{"message": map[string]string{"role": "assistant", "content": text}, "finish_reason": finishReason},
+			},
+		})
+	})
+
+	log.Printf("假大模型服务启动在 http://localhost%s/v1", *addr)
+	if err := http.ListenAndServe(*addr, nil); err != nil {
+		log.Fatalf("启动服务失败: %v", err)
+	}
+}
+
+// writeError 以OpenAI兼容的格式返回错误
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"message": message},
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -95,7 +96,7 @@ func main() {
 
 	// 尝试生成内容
 	log.Printf("尝试调用 Vertex AI...")
-	response, err := vertexClient.GenerateContent(sysInstruction, prompt)
+	response, err := vertexClient.GenerateContent(context.Background(), sysInstruction, prompt)
 	if err != nil {
 		log.Fatalf("Vertex AI 请求失败: %v", err)
 	}
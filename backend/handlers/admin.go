@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/GiantClam/homework_marking/services"
+	"github.com/GiantClam/homework_marking/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// builtinHomeworkTypes 有内置系统指令的作业类型
+var builtinHomeworkTypes = []string{"general", "english", "math", "chinese", services.HomeworkTypeEssay}
+
+// AdminHandler 处理管理员的用户管理和全局提示词模板请求
+type AdminHandler struct {
+	auth    *services.AuthService
+	prompts *services.PromptTemplates
+}
+
+// NewAdminHandler 创建管理员处理器
+func NewAdminHandler(auth *services.AuthService, prompts *services.PromptTemplates) *AdminHandler {
+	return &AdminHandler{
+		auth:    auth,
+		prompts: prompts,
+	}
+}
+
+// ListUsers 列出所有账号
+func (h *AdminHandler) ListUsers(c *gin.Context) {
+	users, err := h.auth.ListUsers()
+	if err != nil {
+		log.Printf("[ERROR] 获取用户列表失败: %v", err)
+		utils.RespondWithError(c, http.StatusInternalServerError, "获取用户列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    users,
+	})
+}
+
+// CreateUser 创建指定角色的账号
+// 请求体为 {"username":"wang","password":"...","name":"王组长","role":"viewer","classIds":["class-1"]}
+func (h *AdminHandler) CreateUser(c *gin.Context) {
+	var req struct {
+		Username string   `json:"username" binding:"required"`
+		Password string   `json:"password" binding:"required"`
+		Name     string   `json:"name"`
+		Role     string   `json:"role" binding:"required"`
+		ClassIDs []string `json:"classIds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请提供用户名、密码和角色")
+		return
+	}
+
+	user, err := h.auth.CreateUser(req.Username, req.Password, req.Name, req.Role, req.ClassIDs)
+	if err != nil {
+		if errors.Is(err, services.ErrUsernameTaken) {
+			utils.RespondWithError(c, http.StatusConflict, err.Error())
+		} else {
+			utils.RespondWithError(c, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    user,
+	})
+}
+
+// UpdateUser 修改账号的姓名、角色、可查看的班级或重置密码，修改后已登录的会话立即按新的角色和班级校验权限
+func (h *AdminHandler) UpdateUser(c *gin.Context) {
+	var update services.UserUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请求格式错误: "+err.Error())
+		return
+	}
+
+	user, err := h.auth.UpdateUser(c.Param("userId"), update)
+	if err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    user,
+	})
+}
+
+// DeleteUser 删除账号
+func (h *AdminHandler) DeleteUser(c *gin.Context) {
+	if err := h.auth.DeleteUser(c.Param("userId")); err != nil {
+		respondUserError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Result:  "账号已删除",
+	})
+}
+
+// respondUserError 根据账号管理的错误类型返回对应的状态码
+func respondUserError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		utils.RespondWithError(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrLastAdmin):
+		utils.RespondWithError(c, http.StatusConflict, err.Error())
+	default:
+		utils.RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+}
+
+// ListPromptTemplates 列出已保存的提示词模板，以及内置系统指令的作业类型
+func (h *AdminHandler) ListPromptTemplates(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"templates":     h.prompts.List(),
+			"builtin_types": builtinHomeworkTypes,
+		},
+	})
+}
+
+// GetPromptTemplate 返回作业类型当前使用的系统指令，没有模板时返回内置的系统指令
+func (h *AdminHandler) GetPromptTemplate(c *gin.Context) {
+	homeworkType := c.Param("homeworkType")
+	instruction, custom := h.prompts.SystemInstruction(homeworkType)
+	if !custom {
+		instruction = getSystemInstructionByType(homeworkType)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"homeworkType":      homeworkType,
+			"systemInstruction": instruction,
+			"custom":            custom,
+		},
+	})
+}
+
+// SavePromptTemplate 保存作业类型的系统指令模板，之后上传的该类型作业使用此模板批改
+// 请求体为 {"systemInstruction":"..."}，返回JSON格式的要求需要保留在模板中
+func (h *AdminHandler) SavePromptTemplate(c *gin.Context) {
+	var req struct {
+		SystemInstruction string `json:"systemInstruction" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请提供系统指令")
+		return
+	}
+
+	template, err := h.prompts.Save(c.Param("homeworkType"), req.SystemInstruction, c.GetString("userId"))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    template,
+	})
+}
+
+// DeletePromptTemplate 删除作业类型的系统指令模板，恢复使用内置的系统指令
+func (h *AdminHandler) DeletePromptTemplate(c *gin.Context) {
+	deleted, err := h.prompts.Delete(c.Param("homeworkType"))
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		utils.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !deleted {
+		utils.RespondWithError(c, http.StatusNotFound, "提示词模板不存在")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Result:  "已恢复内置的系统指令",
+	})
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/GiantClam/homework_marking/middleware"
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/GiantClam/homework_marking/services"
+	"github.com/GiantClam/homework_marking/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHandler 处理API密钥的创建、列出和吊销请求
+type APIKeyHandler struct {
+	apiKeys *services.APIKeyService
+}
+
+// NewAPIKeyHandler 创建API密钥处理器
+func NewAPIKeyHandler(apiKeys *services.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeys: apiKeys,
+	}
+}
+
+// ListAPIKeys 列出当前用户的API密钥，管理员列出所有密钥；不返回密钥明文
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.apiKeys.List(middleware.CurrentPrincipal(c))
+	if err != nil {
+		log.Printf("[ERROR] 获取API密钥列表失败: %v", err)
+		utils.RespondWithError(c, http.StatusInternalServerError, "获取API密钥列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    keys,
+	})
+}
+
+// CreateAPIKey 创建API密钥，密钥明文只在响应中返回这一次
+// 请求体为 {"name":"教务系统","scopes":["grade","tasks:read"],"classIds":["class-1"],"rateLimit":60}
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	var req struct {
+		Name      string   `json:"name" binding:"required"`
+		Scopes    []string `json:"scopes" binding:"required"`
+		ClassIDs  []string `json:"classIds"`
+		RateLimit int      `json:"rateLimit"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请提供密钥名称和权限范围")
+		return
+	}
+
+	key, plaintext, err := h.apiKeys.Create(c.GetString("userId"), req.Name, req.Scopes, req.ClassIDs, req.RateLimit)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"key":    plaintext,
+			"apiKey": key,
+		},
+	})
+}
+
+// RevokeAPIKey 吊销API密钥
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	key, err := h.apiKeys.Revoke(middleware.CurrentPrincipal(c), c.Param("keyId"))
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			utils.RespondWithError(c, http.StatusNotFound, err.Error())
+		} else {
+			log.Printf("[ERROR] %v", err)
+			utils.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    key,
+	})
+}
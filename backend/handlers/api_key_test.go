@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GiantClam/homework_marking/services"
+	"github.com/GiantClam/homework_marking/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// TestAPIKeyAccess 测试API密钥以所属教师的身份访问任务，并受权限范围、班级和每分钟请求次数的限制
+func TestAPIKeyAccess(t *testing.T) {
+	taskQueue := services.NewTaskQueue(1)
+	router := newAuthTestRouter(taskQueue)
+
+	register := func(username string) string {
+		return tokenFromResponse(t, sendJSON(router, http.MethodPost, "/api/auth/register", "", gin.H{"username": username, "password": "test-password"}))
+	}
+	register("admin")
+	ownerToken := register("owner")
+	claims, err := utils.ParseJWT(ownerToken)
+	if err != nil {
+		t.Fatalf("解析令牌失败: %v", err)
+	}
+	class1Task := taskQueue.CreateTask("homework_processing", "")
+	taskQueue.SetTaskOwner(class1Task, claims.UserID, "class-1")
+	class2Task := taskQueue.CreateTask("homework_processing", "")
+	taskQueue.SetTaskOwner(class2Task, claims.UserID, "class-2")
+
+	if resp := sendJSON(router, http.MethodPost, "/api/keys", ownerToken, gin.H{"name": "教务系统", "scopes": []string{"tasks:write"}}); resp.Code != http.StatusBadRequest {
+		t.Errorf("无效的权限范围预期400，实际: %d", resp.Code)
+	}
+
+	resp := sendJSON(router, http.MethodPost, "/api/keys", ownerToken, gin.H{"name": "教务系统", "scopes": []string{"tasks:read"}, "classIds": []string{"class-1"}, "rateLimit": 5})
+	var created struct {
+		Data struct {
+			Key    string `json:"key"`
+			APIKey struct {
+				ID string `json:"id"`
+			} `json:"apiKey"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &created); err != nil || resp.Code != http.StatusCreated || created.Data.Key == "" {
+		t.Fatalf("创建API密钥失败: %d %s", resp.Code, resp.Body.String())
+	}
+	key := created.Data.Key
+
+	// 通过X-API-Key请求头访问
+	req := httptest.NewRequest(http.MethodGet, "/api/tasks/"+class1Task, nil)
+	req.Header.Set("X-API-Key", key)
+	headerResp := httptest.NewRecorder()
+	router.ServeHTTP(headerResp, req)
+	if headerResp.Code != http.StatusOK {
+		t.Errorf("密钥查看所属教师的任务预期200，实际: %d %s", headerResp.Code, headerResp.Body.String())
+	}
+
+	// 查询参数中的API密钥一律拒绝，包括接受token查询参数的任务事件接口
+	if resp := sendJSON(router, http.MethodGet, "/api/tasks/"+class1Task+"/events?token="+key, "", nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("查询参数传递API密钥预期401，实际: %d %s", resp.Code, resp.Body.String())
+	}
+
+	if resp := sendJSON(router, http.MethodGet, "/api/tasks/"+class2Task, key, nil); resp.Code != http.StatusNotFound {
+		t.Errorf("密钥限制班级之外的任务预期404，实际: %d", resp.Code)
+	}
+	if resp := sendJSON(router, http.MethodDelete, "/api/tasks/"+class1Task, key, nil); resp.Code != http.StatusForbidden {
+		t.Errorf("没有tasks:manage权限取消任务预期403，实际: %d", resp.Code)
+	}
+	if resp := sendJSON(router, http.MethodGet, "/api/keys", key, nil); resp.Code != http.StatusForbidden {
+		t.Errorf("密钥管理接口不能使用API密钥访问，预期403，实际: %d", resp.Code)
+	}
+	sendJSON(router, http.MethodGet, "/api/tasks", key, nil)
+
+	// 每分钟5次已用完
+	resp = sendJSON(router, http.MethodGet, "/api/tasks/"+class1Task, key, nil)
+	if resp.Code != http.StatusTooManyRequests || resp.Header().Get("Retry-After") == "" {
+		t.Errorf("超过请求次数预期429并返回Retry-After，实际: %d %q", resp.Code, resp.Header().Get("Retry-After"))
+	}
+
+	if resp := sendJSON(router, http.MethodDelete, "/api/keys/"+created.Data.APIKey.ID, ownerToken, nil); resp.Code != http.StatusOK {
+		t.Fatalf("吊销API密钥失败: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := sendJSON(router, http.MethodGet, "/api/tasks/"+class1Task, key, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("吊销后预期401，实际: %d", resp.Code)
+	}
+}
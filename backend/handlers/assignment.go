@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GiantClam/homework_marking/middleware"
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/GiantClam/homework_marking/services"
+	"github.com/GiantClam/homework_marking/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// AssignmentHandler 处理作业的创建、修改和各班级成绩对比的请求
+type AssignmentHandler struct {
+	assignments *services.Assignments
+	taskQueue   *services.TaskQueue
+	rosters     *services.Rosters // 班级名单，为nil时不校验作业布置的班级
+	llm         services.LLMProvider
+}
+
+// NewAssignmentHandler 创建作业处理器
+func NewAssignmentHandler(assignments *services.Assignments, taskQueue *services.TaskQueue, rosters *services.Rosters, llm services.LLMProvider) *AssignmentHandler {
+	return &AssignmentHandler{
+		assignments: assignments,
+		taskQueue:   taskQueue,
+		rosters:     rosters,
+		llm:         llm,
+	}
+}
+
+// assignmentRequest 创建和修改作业的请求体
+// 截止日期可以是RFC3339时间或yyyy-mm-dd日期；标准答案可以是JSON对象、JSON数组或CSV文本；评分标准为JSON对象
+type assignmentRequest struct {
+	models.AssignmentSettings
+	DueDate   string          `json:"dueDate"`
+	AnswerKey json.RawMessage `json:"answerKey"`
+	Rubric    json.RawMessage `json:"rubric"`
+}
+
+// settings 解析请求中的截止日期、标准答案和评分标准
+func (r assignmentRequest) settings() (models.AssignmentSettings, error) {
+	settings := r.AssignmentSettings
+
+	if dueDate := strings.TrimSpace(r.DueDate); dueDate != "" {
+		due, err := time.Parse(time.RFC3339, dueDate)
+		if err != nil {
+			if due, err = time.ParseInLocation("2006-01-02", dueDate, time.Local); err != nil {
+				return settings, fmt.Errorf("截止日期格式错误: %s", dueDate)
+			}
+		}
+		settings.DueDate = &due
+	}
+
+	if raw := bytes.TrimSpace(r.AnswerKey); len(raw) > 0 && !bytes.Equal(raw, []byte("null")) {
+		var err error
+		var text string
+		if json.Unmarshal(raw, &text) == nil {
+			text = strings.TrimSpace(text)
+			if strings.HasPrefix(text, "{") || strings.HasPrefix(text, "[") {
+				settings.AnswerKey, err = services.ParseAnswerKeyJSON([]byte(text))
+			} else if text != "" {
+				settings.AnswerKey, err = services.ParseAnswerKeyCSV([]byte(text))
+			}
+		} else {
+			settings.AnswerKey, err = services.ParseAnswerKeyJSON(raw)
+		}
+		if err != nil {
+			return settings, err
+		}
+	}
+
+	if raw := bytes.TrimSpace(r.Rubric); len(raw) > 0 && !bytes.Equal(raw, []byte("null")) {
+		rubric, err := services.ParseRubricJSON(raw)
+		if err != nil {
+			return settings, err
+		}
+		settings.Rubric = rubric
+	}
+	return settings, nil
+}
+
+// ListAssignments 列出当前用户可以查看的作业
+func (h *AssignmentHandler) ListAssignments(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    h.assignments.List(middleware.CurrentPrincipal(c).CanReadAssignment),
+	})
+}
+
+// CreateAssignment 创建作业，由当前教师管理
+// 请求体为 {"title":"第三单元练习","subject":"math","dueDate":"2026-10-20","answerKey":{"items":[...]},"pagesPerStudent":2,"layout":"single","classIds":["..."]}
+func (h *AssignmentHandler) CreateAssignment(c *gin.Context) {
+	settings, ok := h.readSettings(c)
+	if !ok {
+		return
+	}
+
+	assignment, err := h.assignments.Create(c.GetString("userId"), settings)
+	if err != nil {
+		respondAssignmentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    assignment,
+	})
+}
+
+// GetAssignment 返回作业的全部设置
+func (h *AssignmentHandler) GetAssignment(c *gin.Context) {
+	assignment, ok := h.authorizedAssignment(c, false)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    assignment,
+	})
+}
+
+// UpdateAssignment 以请求体替换作业的全部设置，请求体与创建作业相同
+func (h *AssignmentHandler) UpdateAssignment(c *gin.Context) {
+	assignment, ok := h.authorizedAssignment(c, true)
+	if !ok {
+		return
+	}
+	settings, ok := h.readSettings(c)
+	if !ok {
+		return
+	}
+
+	assignment, err := h.assignments.Update(assignment.ID, settings)
+	if err != nil {
+		respondAssignmentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    assignment,
+	})
+}
+
+// DeleteAssignment 删除作业，已完成的批改结果不受影响
+func (h *AssignmentHandler) DeleteAssignment(c *gin.Context) {
+	assignment, ok := h.authorizedAssignment(c, true)
+	if !ok {
+		return
+	}
+
+	if err := h.assignments.Delete(assignment.ID); err != nil {
+		respondAssignmentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Result:  "作业已删除",
+	})
+}
+
+// UploadAnswerKey 替换作业的标准答案，与上传作业时相同，answerKey可以是JSON、CSV或教师版PDF文件，也可以是文本字段
+// 教师版PDF在请求内由大模型提取，提取一次后各班级上传时直接使用
+func (h *AssignmentHandler) UploadAnswerKey(c *gin.Context) {
+	assignment, ok := h.authorizedAssignment(c, true)
+	if !ok {
+		return
+	}
+
+	key, pdfPath, err := readAnswerKey(c)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if pdfPath != "" {
+		if key, err = services.ExtractAnswerKeyFromPDF(c.Request.Context(), h.llm, pdfPath); err != nil {
+			log.Printf("[ERROR] 提取作业 %s 的标准答案失败: %v", assignment.ID, err)
+			utils.RespondWithError(c, http.StatusBadGateway, err.Error())
+			return
+		}
+	}
+	if key == nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请提供标准答案")
+		return
+	}
+
+	assignment, err = h.assignments.SetAnswerKey(assignment.ID, key)
+	if err != nil {
+		respondAssignmentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    assignment,
+	})
+}
+
+// GetAssignmentReport 按班级对比引用了该作业的任务的成绩，只统计当前用户可以查看的任务
+func (h *AssignmentHandler) GetAssignmentReport(c *gin.Context) {
+	assignment, ok := h.authorizedAssignment(c, false)
+	if !ok {
+		return
+	}
+
+	principal := middleware.CurrentPrincipal(c)
+	tasks := h.taskQueue.ListTasks(func(task *services.HomeworkTask) bool {
+		return task.AssignmentID == assignment.ID && principal.CanReadTask(task)
+	})
+
+	var className func(classID string) string
+	if h.rosters != nil {
+		className = func(classID string) string {
+			class, err := h.rosters.GetClass(classID)
+			if err != nil {
+				return ""
+			}
+			return class.Name
+		}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    services.BuildAssignmentReport(assignment, tasks, className),
+	})
+}
+
+// readSettings 读取请求体中的作业设置，并检查作业布置的班级都是当前用户管理的班级
+func (h *AssignmentHandler) readSettings(c *gin.Context) (models.AssignmentSettings, bool) {
+	var req assignmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请求格式错误: "+err.Error())
+		return req.AssignmentSettings, false
+	}
+	settings, err := req.settings()
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return settings, false
+	}
+
+	if h.rosters != nil {
+		principal := middleware.CurrentPrincipal(c)
+		for _, classID := range settings.ClassIDs {
+			class, err := h.rosters.GetClass(classID)
+			if err != nil {
+				utils.RespondWithError(c, http.StatusBadRequest, fmt.Sprintf("%v: %s", err, classID))
+				return settings, false
+			}
+			if !principal.CanManageClass(class) {
+				utils.RespondWithError(c, http.StatusForbidden, "没有权限为班级"+class.Name+"布置作业")
+				return settings, false
+			}
+		}
+	}
+	return settings, true
+}
+
+// authorizedAssignment 读取路径中的作业并检查权限，无权查看时返回404，可以查看但无权修改时返回403
+func (h *AssignmentHandler) authorizedAssignment(c *gin.Context, manage bool) (models.Assignment, bool) {
+	principal := middleware.CurrentPrincipal(c)
+	assignment, err := h.assignments.Get(c.Param("assignmentId"))
+	if err != nil || !principal.CanReadAssignment(assignment) {
+		utils.RespondWithError(c, http.StatusNotFound, services.ErrAssignmentNotFound.Error())
+		return assignment, false
+	}
+	if manage && !principal.CanManageAssignment(assignment) {
+		utils.RespondWithError(c, http.StatusForbidden, "没有权限修改该作业")
+		return assignment, false
+	}
+	return assignment, true
+}
+
+// respondAssignmentError 根据作业操作的错误类型返回对应的状态码
+func respondAssignmentError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrAssignmentNotFound) {
+		utils.RespondWithError(c, http.StatusNotFound, err.Error())
+		return
+	}
+	utils.RespondWithError(c, http.StatusBadRequest, err.Error())
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/GiantClam/homework_marking/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TestAssignmentAcrossClasses 测试多个班级上传时引用同一份作业，沿用作业的设置并按班级对比成绩
+func TestAssignmentAcrossClasses(t *testing.T) {
+	chdirTemp(t)
+
+	rosters, _ := services.NewRosters(nil)
+	classOne, _ := rosters.CreateClass("三年级一班", "三年级", "teacher-1")
+	classTwo, _ := rosters.CreateClass("三年级二班", "三年级", "teacher-1")
+	otherClass, _ := rosters.CreateClass("三年级三班", "三年级", "teacher-2")
+	assignments, _ := services.NewAssignments(nil)
+	otherAssignment, _ := assignments.Create("teacher-2", models.AssignmentSettings{Title: "其他教师的作业"})
+
+	llm := services.NewFakeLLMProvider(
+		services.FakeLLMResponse{Match: "student_1.pdf", Text: `{"name":"张三","class":"","answers":[{"questionNumber":"1","studentAnswer":"A","isCorrect":true,"correctAnswer":"A","maxPoints":1,"awardedPoints":1},{"questionNumber":"2","studentAnswer":"C","isCorrect":false,"correctAnswer":"B","maxPoints":1,"awardedPoints":0}],"overallScore":"50","feedback":"继续努力"}`},
+		services.FakeLLMResponse{Match: "student_2.pdf", Text: `{"name":"李四","class":"","answers":[{"questionNumber":"1","studentAnswer":"A","isCorrect":true,"correctAnswer":"A","maxPoints":1,"awardedPoints":1},{"questionNumber":"2","studentAnswer":"B","isCorrect":true,"correctAnswer":"B","maxPoints":1,"awardedPoints":1}],"overallScore":"100","feedback":"很好"}`},
+	)
+	// newTestRouter 将重试等待设为0，这里另建带作业和登录用户的路由
+	newTestRouter(t, llm)
+	taskQueue := services.NewTaskQueue(1)
+	homeworkHandler := NewHomeworkHandler(taskQueue, llm, nil, nil, rosters, assignments)
+	taskHandler := NewTaskHandler(taskQueue, rosters)
+	assignmentHandler := NewAssignmentHandler(assignments, taskQueue, rosters, llm)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("userId", "teacher-1")
+		c.Set("role", models.RoleTeacher)
+		c.Next()
+	})
+	router.POST("/api/homework/upload", homeworkHandler.UploadHomework)
+	router.GET("/api/tasks/:taskId", taskHandler.GetTaskStatus)
+	router.POST("/api/assignments", assignmentHandler.CreateAssignment)
+	router.GET("/api/assignments/:assignmentId/report", assignmentHandler.GetAssignmentReport)
+
+	// 只能为自己管理的班级布置作业
+	request := gin.H{
+		"title":           "第三单元练习",
+		"subject":         "math",
+		"dueDate":         "2026-10-20",
+		"answerKey":       "题号,答案,分值\n1,A,2\n2,B,2\n",
+		"questionPoints":  gin.H{"2": 3},
+		"pagesPerStudent": 1,
+		"classIds":        []string{classOne.ID, classTwo.ID, otherClass.ID},
+	}
+	if resp := sendJSON(router, http.MethodPost, "/api/assignments", "", request); resp.Code != http.StatusForbidden {
+		t.Errorf("其他教师的班级预期403，实际: %d %s", resp.Code, resp.Body.String())
+	}
+	request["classIds"] = []string{classOne.ID, classTwo.ID}
+	resp := sendJSON(router, http.MethodPost, "/api/assignments", "", request)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("创建作业失败: %d %s", resp.Code, resp.Body.String())
+	}
+	var created struct {
+		Data models.Assignment `json:"data"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &created)
+	assignment := created.Data
+	if assignment.DueDate == nil || assignment.AnswerKey == nil || len(assignment.AnswerKey.Items) != 2 || assignment.Layout != services.LayoutSingle {
+		t.Fatalf("作业设置错误: %+v", assignment)
+	}
+
+	// 两个班级上传时引用同一份作业，上传请求中的作业类型被作业的设置替换
+	taskOne := uploadTestPDFWithFields(t, router, t.TempDir(), 2, map[string]string{"assignmentId": assignment.ID, "classId": classOne.ID, "type": "essay"})
+	taskTwo := uploadTestPDFWithFields(t, router, t.TempDir(), 1, map[string]string{"assignmentId": assignment.ID, "classId": classTwo.ID})
+	for _, taskID := range []string{taskOne, taskTwo} {
+		status := waitForTask(t, router, taskID, func(s taskStatusResponse) bool {
+			return s.Status != "pending" && s.Status != "processing"
+		})
+		if status.Status != "completed" {
+			t.Fatalf("任务未完成: %+v", status)
+		}
+		// 第2题按作业设置的分值计3分，未设置满分时以标准答案的总分5分为满分，答错时得2分
+		if status.Results[0].OverallScore != "2" || status.Results[0].FullMarks != 5 {
+			t.Errorf("总得分应按作业的标准答案和每题分值计算，实际: %s", status.Results[0].OverallScore)
+		}
+	}
+	if calls := llm.Calls(); len(calls) == 0 || !strings.Contains(calls[0].SystemInstruction, "2: B（3分）") {
+		t.Errorf("系统指令中缺少作业的标准答案: %+v", calls)
+	}
+
+	// 作业只布置给了两个班级，且不能引用其他教师的作业
+	if resp := uploadTestImage(t, router, otherClass.ID); resp.Code != http.StatusForbidden {
+		t.Errorf("其他教师的班级预期403，实际: %d", resp.Code)
+	}
+	for name, fields := range map[string]map[string]string{
+		"未布置的班级":  {"assignmentId": assignment.ID},
+		"其他教师的作业": {"assignmentId": otherAssignment.ID},
+	} {
+		if status := uploadAssignmentStatus(t, router, fields); status == http.StatusOK {
+			t.Errorf("%s不应上传成功", name)
+		}
+	}
+
+	resp = sendJSON(router, http.MethodGet, "/api/assignments/"+assignment.ID+"/report", "", nil)
+	var report struct {
+		Data models.AssignmentReport `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &report); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("获取成绩对比失败: %d %s", resp.Code, resp.Body.String())
+	}
+	classes := report.Data.Classes
+	if len(classes) != 2 || classes[0].ClassName != "三年级一班" || classes[1].ClassName != "三年级二班" {
+		t.Fatalf("成绩对比应按班级分组: %+v", classes)
+	}
+	if report.Data.FullMarks != 5 {
+		t.Errorf("成绩对比的满分应为标准答案的总分，实际: %v", report.Data.FullMarks)
+	}
+	if classes[0].Students != 2 || classes[0].Average != 3.5 || classes[0].PassRate != 0.5 || classes[1].Students != 1 || classes[1].Average != 2 {
+		t.Errorf("班级成绩统计错误: %+v", classes)
+	}
+	if overall := report.Data.Overall; overall.Students != 3 || overall.Average != 3 || len(overall.Questions) != 2 || overall.Questions[1].ScoreRate != 0.33 {
+		t.Errorf("合计成绩统计错误: %+v", overall)
+	}
+}
+
+// uploadAssignmentStatus 上传一页的测试PDF，返回上传接口的状态码
+func uploadAssignmentStatus(t *testing.T, router *gin.Engine, fields map[string]string) int {
+	t.Helper()
+
+	pdfPath := filepath.Join(t.TempDir(), "homework.pdf")
+	writeTestPDF(t, pdfPath, 1)
+	content, _ := os.ReadFile(pdfPath)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("homework", "homework.pdf")
+	part.Write(content)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/homework/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp.Code
+}
+
+// TestAssignmentEnsemble 测试作业设置了多次批改时逐题多数表决，并记录判定不一致的题目
+func TestAssignmentEnsemble(t *testing.T) {
+	chdirTemp(t)
+
+	// 第一次批改把第1题识别为B，之后两次识别为A
+	answers := `"answers":[{"questionNumber":"1","studentAnswer":"%s","isCorrect":true,"correctAnswer":"A","maxPoints":1,"awardedPoints":1},{"questionNumber":"2","studentAnswer":"B","isCorrect":true,"correctAnswer":"B","maxPoints":1,"awardedPoints":1}]`
+	llm := services.NewFakeLLMProvider(
+		services.FakeLLMResponse{Times: 1, Text: `{"name":"张三","class":"",` + fmt.Sprintf(answers, "B") + `,"feedback":"很好"}`},
+		services.FakeLLMResponse{Text: `{"name":"张三","class":"",` + fmt.Sprintf(answers, "A") + `,"feedback":"很好"}`},
+	)
+	newTestRouter(t, llm)
+	assignments, _ := services.NewAssignments(nil)
+	answerKey := &models.AnswerKey{Items: []models.AnswerKeyItem{{QuestionNumber: "1", Answer: "A", Points: 1}, {QuestionNumber: "2", Answer: "B", Points: 1}}}
+	assignment, _ := assignments.Create("teacher-1", models.AssignmentSettings{Title: "练习", AnswerKey: answerKey, Ensemble: &models.EnsembleSettings{Runs: 3}})
+	crossModel, _ := assignments.Create("teacher-1", models.AssignmentSettings{Title: "练习", Ensemble: &models.EnsembleSettings{CrossModel: true}})
+
+	taskQueue := services.NewTaskQueue(1)
+	homeworkHandler := NewHomeworkHandler(taskQueue, llm, nil, nil, nil, assignments)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("userId", "teacher-1")
+		c.Set("role", models.RoleTeacher)
+		c.Next()
+	})
+	router.POST("/api/homework/upload", homeworkHandler.UploadHomework)
+	router.GET("/api/tasks/:taskId", NewTaskHandler(taskQueue, nil).GetTaskStatus)
+
+	// 服务没有配置第二个大模型时不能使用多模型批改
+	if status := uploadAssignmentStatus(t, router, map[string]string{"assignmentId": crossModel.ID}); status != http.StatusBadRequest {
+		t.Errorf("没有第二个大模型时预期400，实际: %d", status)
+	}
+
+	taskID := uploadTestPDFWithFields(t, router, t.TempDir(), 1, map[string]string{"assignmentId": assignment.ID})
+	status := waitForTask(t, router, taskID, func(s taskStatusResponse) bool {
+		return s.Status != "pending" && s.Status != "processing"
+	})
+	if status.Status != "completed" || len(llm.Calls()) != 3 {
+		t.Fatalf("任务应完成且批改3次: %+v, 调用次数: %d", status, len(llm.Calls()))
+	}
+
+	result := status.Results[0]
+	if result.OverallScore != "2" || result.Ensemble == nil || result.Ensemble.Disagreements != 1 || result.Ensemble.DisagreementRate != 0.5 {
+		t.Errorf("应按多数判定第1题正确并记录表决情况: %s %+v", result.OverallScore, result.Ensemble)
+	}
+	if votes := result.Answers[0].Votes; len(votes) != 3 || votes[0].StudentAnswer != "B" || *votes[0].IsCorrect {
+		t.Errorf("第1题应记录每次批改的判定: %+v", votes)
+	}
+}
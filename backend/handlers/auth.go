@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/GiantClam/homework_marking/services"
+	"github.com/GiantClam/homework_marking/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// AuthHandler 处理注册、登录等账号相关请求
+type AuthHandler struct {
+	auth *services.AuthService
+}
+
+// NewAuthHandler 创建账号处理器
+func NewAuthHandler(auth *services.AuthService) *AuthHandler {
+	return &AuthHandler{
+		auth: auth,
+	}
+}
+
+// credentialsRequest 注册和登录的请求体
+type credentialsRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Name     string `json:"name"`
+}
+
+// Register 注册教师账号，成功后直接返回登录令牌
+func (h *AuthHandler) Register(c *gin.Context) {
+	var req credentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请提供用户名和密码")
+		return
+	}
+
+	user, err := h.auth.Register(req.Username, req.Password, req.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUsernameTaken):
+			utils.RespondWithError(c, http.StatusConflict, err.Error())
+		case errors.Is(err, services.ErrRegistrationClosed):
+			utils.RespondWithError(c, http.StatusForbidden, err.Error())
+		default:
+			utils.RespondWithError(c, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	h.respondWithToken(c, http.StatusCreated, user)
+}
+
+// Login 校验用户名和密码，返回登录令牌
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req credentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请提供用户名和密码")
+		return
+	}
+
+	user, err := h.auth.Login(req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			utils.RespondWithError(c, http.StatusUnauthorized, err.Error())
+		} else {
+			log.Printf("[ERROR] 登录失败: %v", err)
+			utils.RespondWithError(c, http.StatusInternalServerError, "登录失败")
+		}
+		return
+	}
+
+	h.respondWithToken(c, http.StatusOK, user)
+}
+
+// Me 返回当前登录的用户
+func (h *AuthHandler) Me(c *gin.Context) {
+	user, err := h.auth.GetUser(c.GetString("userId"))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusUnauthorized, "用户不存在")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    user,
+	})
+}
+
+// respondWithToken 为用户签发令牌并返回
+func (h *AuthHandler) respondWithToken(c *gin.Context, status int, user *models.User) {
+	token, err := utils.GenerateJWT(user.ID, user.Role, user.ClassIDs)
+	if err != nil {
+		log.Printf("[ERROR] 生成令牌失败: %v", err)
+		utils.RespondWithError(c, http.StatusInternalServerError, "生成令牌失败")
+		return
+	}
+
+	c.JSON(status, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"token": token,
+			"user":  user,
+		},
+	})
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GiantClam/homework_marking/middleware"
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/GiantClam/homework_marking/services"
+	"github.com/GiantClam/homework_marking/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// sendJSON 发送带令牌的请求，body不为nil时以JSON格式发送
+func sendJSON(router *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+// tokenFromResponse 从注册或登录的响应中读取令牌
+func tokenFromResponse(t *testing.T, resp *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var tokenResp struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &tokenResp); err != nil || tokenResp.Data.Token == "" {
+		t.Fatalf("响应中没有令牌: %d %s", resp.Code, resp.Body.String())
+	}
+	return tokenResp.Data.Token
+}
+
+// newAuthTestRouter 创建带认证和角色检查的账号、管理员、API密钥和任务路由，任务事件接口接受token查询参数
+func newAuthTestRouter(taskQueue *services.TaskQueue) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	authService := services.NewAuthService(services.NewMemoryUserStore(), true)
+	apiKeys := services.NewAPIKeyService(services.NewMemoryAPIKeyStore(), authService)
+	prompts, _ := services.NewPromptTemplates(nil)
+	authHandler := NewAuthHandler(authService)
+	adminHandler := NewAdminHandler(authService, prompts)
+	apiKeyHandler := NewAPIKeyHandler(apiKeys)
+	taskHandler := NewTaskHandler(taskQueue, nil)
+	requireAuth := middleware.AuthMiddleware(authService, apiKeys)
+	requireGrader := middleware.RequireRole(models.RoleAdmin, models.RoleTeacher)
+
+	router := gin.New()
+	router.POST("/api/auth/register", authHandler.Register)
+	router.POST("/api/auth/login", authHandler.Login)
+	admin := router.Group("/api/admin", requireAuth, middleware.RequireSession(), middleware.RequireRole(models.RoleAdmin))
+	admin.POST("/users", adminHandler.CreateUser)
+	admin.PUT("/users/:userId", adminHandler.UpdateUser)
+	admin.DELETE("/users/:userId", adminHandler.DeleteUser)
+	admin.PUT("/prompts/:homeworkType", adminHandler.SavePromptTemplate)
+	keys := router.Group("/api/keys", requireAuth, middleware.RequireSession(), requireGrader)
+	keys.GET("", apiKeyHandler.ListAPIKeys)
+	keys.POST("", apiKeyHandler.CreateAPIKey)
+	keys.DELETE("/:keyId", apiKeyHandler.RevokeAPIKey)
+	tasks := router.Group("/api/tasks", requireAuth)
+	tasks.GET("", middleware.RequireScope(models.ScopeTasksRead), taskHandler.GetAllTasks)
+	tasks.GET("/:taskId", middleware.RequireScope(models.ScopeTasksRead), taskHandler.GetTaskStatus)
+	tasks.DELETE("/:taskId", requireGrader, middleware.RequireScope(models.ScopeTasksManage), taskHandler.CancelTask)
+	tasks.PUT("/:taskId/results/:studentIndex/student", requireGrader, middleware.RequireScope(models.ScopeTasksManage), taskHandler.AssignResultStudent)
+	router.GET("/api/tasks/:taskId/events", middleware.QueryTokenAuthMiddleware(authService, apiKeys), middleware.RequireScope(models.ScopeTasksRead), taskHandler.StreamTaskEvents)
+	return router
+}
+
+// TestTaskAccessByRole 测试任务接口需要登录：教师只能查看自己的任务，只读用户只能查看分配的班级且不能修改，管理员可以查看所有任务
+func TestTaskAccessByRole(t *testing.T) {
+	taskQueue := services.NewTaskQueue(1)
+	router := newAuthTestRouter(taskQueue)
+
+	// 第一个注册的账号为管理员，之后注册的为教师
+	register := func(username string) string {
+		return tokenFromResponse(t, sendJSON(router, http.MethodPost, "/api/auth/register", "", gin.H{"username": username, "password": "test-password"}))
+	}
+	adminToken := register("admin")
+	ownerToken := register("owner")
+	otherToken := register("other")
+
+	// 管理员创建只读账号，分配一班
+	resp := sendJSON(router, http.MethodPost, "/api/admin/users", adminToken, gin.H{"username": "viewer", "password": "test-password", "role": "viewer", "classIds": []string{"class-1"}})
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("创建只读账号失败: %d %s", resp.Code, resp.Body.String())
+	}
+	viewerToken := tokenFromResponse(t, sendJSON(router, http.MethodPost, "/api/auth/login", "", gin.H{"username": "viewer", "password": "test-password"}))
+
+	// 教师不能访问管理员接口
+	if resp := sendJSON(router, http.MethodPost, "/api/admin/users", ownerToken, gin.H{"username": "x", "password": "test-password", "role": "admin"}); resp.Code != http.StatusForbidden {
+		t.Errorf("教师访问管理员接口预期403，实际: %d", resp.Code)
+	}
+
+	// 令牌中的用户作为任务的创建者
+	claims, err := utils.ParseJWT(ownerToken)
+	if err != nil {
+		t.Fatalf("解析令牌失败: %v", err)
+	}
+	taskID := taskQueue.CreateTask("homework_processing", "")
+	taskQueue.SetTaskOwner(taskID, claims.UserID, "class-1")
+	path := "/api/tasks/" + taskID
+
+	if resp := sendJSON(router, http.MethodGet, path, "", nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("未登录预期401，实际: %d", resp.Code)
+	}
+	for name, token := range map[string]string{"admin": adminToken, "owner": ownerToken, "viewer": viewerToken} {
+		if resp := sendJSON(router, http.MethodGet, path, token, nil); resp.Code != http.StatusOK {
+			t.Errorf("%s 查看任务预期200，实际: %d %s", name, resp.Code, resp.Body.String())
+		}
+	}
+	if resp := sendJSON(router, http.MethodGet, path, otherToken, nil); resp.Code != http.StatusNotFound {
+		t.Errorf("其他教师的任务预期404，实际: %d", resp.Code)
+	}
+	if resp := sendJSON(router, http.MethodDelete, path, viewerToken, nil); resp.Code != http.StatusForbidden {
+		t.Errorf("只读用户取消任务预期403，实际: %d", resp.Code)
+	}
+
+	var list struct {
+		Tasks []map[string]interface{} `json:"tasks"`
+	}
+	json.Unmarshal(sendJSON(router, http.MethodGet, "/api/tasks", otherToken, nil).Body.Bytes(), &list)
+	if len(list.Tasks) != 0 {
+		t.Errorf("其他教师不应看到该任务: %+v", list.Tasks)
+	}
+	json.Unmarshal(sendJSON(router, http.MethodGet, "/api/tasks", ownerToken, nil).Body.Bytes(), &list)
+	if len(list.Tasks) != 1 || list.Tasks[0]["task_id"] != taskID {
+		t.Errorf("任务列表错误: %+v", list.Tasks)
+	}
+
+	if resp := sendJSON(router, http.MethodDelete, path, ownerToken, nil); resp.Code != http.StatusOK {
+		t.Errorf("教师取消自己的任务预期200，实际: %d %s", resp.Code, resp.Body.String())
+	}
+}
+
+// TestRoleChangeTakesEffectImmediately 测试管理员降级或删除账号后，已签发的令牌立即按新的角色校验
+func TestRoleChangeTakesEffectImmediately(t *testing.T) {
+	taskQueue := services.NewTaskQueue(1)
+	router := newAuthTestRouter(taskQueue)
+
+	register := func(username string) string {
+		return tokenFromResponse(t, sendJSON(router, http.MethodPost, "/api/auth/register", "", gin.H{"username": username, "password": "test-password"}))
+	}
+	adminToken := register("admin")
+	teacherToken := register("teacher")
+	claims, err := utils.ParseJWT(teacherToken)
+	if err != nil {
+		t.Fatalf("解析令牌失败: %v", err)
+	}
+
+	taskID := taskQueue.CreateTask("homework_processing", "")
+	taskQueue.SetTaskOwner(taskID, claims.UserID, "class-1")
+	path := "/api/tasks/" + taskID
+
+	// 降级为只读用户后，原令牌不能再取消任务
+	userPath := "/api/admin/users/" + claims.UserID
+	if resp := sendJSON(router, http.MethodPut, userPath, adminToken, gin.H{"role": "viewer", "classIds": []string{"class-1"}}); resp.Code != http.StatusOK {
+		t.Fatalf("降级账号失败: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := sendJSON(router, http.MethodDelete, path, teacherToken, nil); resp.Code != http.StatusForbidden {
+		t.Errorf("降级后取消任务预期403，实际: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := sendJSON(router, http.MethodGet, path, teacherToken, nil); resp.Code != http.StatusOK {
+		t.Errorf("降级后仍可查看分配班级的任务，实际: %d", resp.Code)
+	}
+
+	// 删除账号后原令牌失效
+	if resp := sendJSON(router, http.MethodDelete, userPath, adminToken, nil); resp.Code != http.StatusOK {
+		t.Fatalf("删除账号失败: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := sendJSON(router, http.MethodGet, path, teacherToken, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("账号删除后预期401，实际: %d", resp.Code)
+	}
+}
+
+// TestQueryTokenOnlyForEventStreams 测试token查询参数只在任务事件接口生效，其他接口仍然要求Authorization请求头
+func TestQueryTokenOnlyForEventStreams(t *testing.T) {
+	taskQueue := services.NewTaskQueue(1)
+	router := newAuthTestRouter(taskQueue)
+
+	register := func(username string) string {
+		return tokenFromResponse(t, sendJSON(router, http.MethodPost, "/api/auth/register", "", gin.H{"username": username, "password": "test-password"}))
+	}
+	register("admin")
+	ownerToken := register("owner")
+	otherToken := register("other")
+	claims, err := utils.ParseJWT(ownerToken)
+	if err != nil {
+		t.Fatalf("解析令牌失败: %v", err)
+	}
+	taskID := taskQueue.CreateTask("homework_processing", "")
+	taskQueue.SetTaskOwner(taskID, claims.UserID, "class-1")
+
+	if resp := sendJSON(router, http.MethodGet, "/api/tasks/"+taskID+"?token="+ownerToken, "", nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("普通接口使用查询参数预期401，实际: %d", resp.Code)
+	}
+	// 查询参数通过认证后按任务权限校验，其他教师得到404而不是401
+	if resp := sendJSON(router, http.MethodGet, "/api/tasks/"+taskID+"/events?token="+otherToken, "", nil); resp.Code != http.StatusNotFound {
+		t.Errorf("其他教师订阅任务事件预期404，实际: %d %s", resp.Code, resp.Body.String())
+	}
+}
+
+// TestAssignResultStudentWithoutRosters 测试没有启用班级名单时不能为结果指定学生
+func TestAssignResultStudentWithoutRosters(t *testing.T) {
+	taskQueue := services.NewTaskQueue(1)
+	router := newAuthTestRouter(taskQueue)
+
+	register := func(username string) string {
+		return tokenFromResponse(t, sendJSON(router, http.MethodPost, "/api/auth/register", "", gin.H{"username": username, "password": "test-password"}))
+	}
+	register("admin")
+	ownerToken := register("owner")
+	claims, err := utils.ParseJWT(ownerToken)
+	if err != nil {
+		t.Fatalf("解析令牌失败: %v", err)
+	}
+	taskID := taskQueue.CreateTask("homework_processing", "")
+	taskQueue.SetTaskOwner(taskID, claims.UserID, "")
+
+	if resp := sendJSON(router, http.MethodPut, "/api/tasks/"+taskID+"/results/0/student", ownerToken, gin.H{"studentId": "student-1"}); resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("没有班级名单时指定学生预期503，实际: %d %s", resp.Code, resp.Body.String())
+	}
+}
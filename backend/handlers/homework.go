@@ -1,22 +1,25 @@
 ﻿package handlers
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/GiantClam/homework_marking/middleware"
 	"github.com/GiantClam/homework_marking/models"
 	"github.com/GiantClam/homework_marking/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
 )
 
 // 提示词模板
@@ -70,17 +73,118 @@ const (
 请以JSON格式返回分析结果。`
 )
 
+// gradingOptions 一次上传的批改参数
+type gradingOptions struct {
+	homeworkType   string
+	basePrompt     string // 管理员维护的系统指令模板，为空时使用作业类型内置的系统指令
+	customPrompt   string
+	answerKey      *models.AnswerKey // 标准答案，为nil时由大模型自行判断
+	rubric         *models.Rubric    // 作文的评分标准，仅用于essay类型
+	scoring        services.ScoringOptions
+	questionPoints map[string]float64      // 作业中设置的每题分值，没有标准答案时使用
+	roster         *services.RosterMatcher // 班级名单，为nil时不匹配学生
+	ensemble       *models.EnsembleSettings // 作业设置的多次批改，为nil时只批改一次
+	secondaryLLM   services.LLMProvider     // 多模型批改时与主模型交替使用的第二个大模型
+}
+
+// systemInstruction 根据作业类型、标准答案和评分标准生成系统指令
+func (o gradingOptions) systemInstruction() string {
+	base := o.basePrompt
+	if base == "" {
+		base = getSystemInstructionByType(o.homeworkType)
+	}
+	if o.homeworkType == services.HomeworkTypeEssay {
+		return base + services.RubricInstruction(o.rubric)
+	}
+	return base + services.AnswerKeyInstruction(o.answerKey)
+}
+
+// grade 调用大模型批改单个学生的作业，作文按评分标准批改
+// 作业设置了多次批改时批改多次后逐题表决，有标准答案时先按标准答案判定每次的结果再表决
+func (o gradingOptions) grade(ctx context.Context, llm services.LLMProvider, systemInstruction, filePath, mimeType, textPrompt string) (models.HomeworkResult, error) {
+	if o.homeworkType == services.HomeworkTypeEssay {
+		return services.GradeEssayFile(ctx, llm, systemInstruction, filePath, mimeType, textPrompt, o.rubric)
+	}
+	if o.ensemble == nil {
+		return services.GradeHomeworkFile(ctx, llm, systemInstruction, filePath, mimeType, textPrompt)
+	}
+
+	providers := []services.LLMProvider{llm}
+	if o.ensemble.CrossModel {
+		providers = append(providers, o.secondaryLLM)
+	}
+	return services.GradeEnsemble(ctx, providers, o.ensemble.Runs, func(ctx context.Context, llm services.LLMProvider) (models.HomeworkResult, error) {
+		result, err := services.GradeHomeworkFile(ctx, llm, systemInstruction, filePath, mimeType, textPrompt)
+		if err == nil {
+			services.ApplyAnswerKey(&result, o.answerKey)
+		}
+		return result, err
+	})
+}
+
+// score 在服务端计算总得分：作文按评分项加权汇总，其他作业按标准答案判分后按每题得分汇总
+func (o gradingOptions) score(result *models.HomeworkResult) {
+	if o.homeworkType == services.HomeworkTypeEssay {
+		services.ScoreEssayResult(result, o.rubric, o.scoring)
+		return
+	}
+	if o.answerKey != nil {
+		services.ApplyAnswerKey(result, o.answerKey)
+	} else {
+		services.ApplyQuestionPoints(result, o.questionPoints)
+	}
+	services.ScoreResult(result, o.scoring)
+}
+
+// 同步批改接口的默认超时时间和页数上限，可通过MARKING_SYNC_TIMEOUT（如90s）和MARKING_SYNC_MAX_PAGES配置
+const (
+	defaultSyncTimeout  = 60 * time.Second
+	defaultSyncMaxPages = 10
+)
+
 // HomeworkHandler handles homework related requests
 type HomeworkHandler struct {
-	taskQueue *services.TaskQueue
-	mutex     *sync.Mutex
+	taskQueue    *services.TaskQueue
+	llm          services.LLMProvider
+	secondaryLLM services.LLMProvider       // 多模型批改使用的第二个大模型，为nil时作业不能使用多模型批改
+	prompts      *services.PromptTemplates // 全局提示词模板，为nil时使用内置的系统指令
+	rosters      *services.Rosters         // 班级名单，为nil时不校验班级、不匹配学生
+	assignments  *services.Assignments     // 作业，为nil时上传不能引用作业
+	mutex        *sync.Mutex
+	syncTimeout  time.Duration // 同步批改等待结果的最长时间
+	syncMaxPages int           // 同步批改的PDF页数上限
 }
 
 // NewHomeworkHandler creates a new homework handler
-func NewHomeworkHandler(taskQueue *services.TaskQueue) *HomeworkHandler {
+func NewHomeworkHandler(taskQueue *services.TaskQueue, llm services.LLMProvider, secondaryLLM services.LLMProvider, prompts *services.PromptTemplates, rosters *services.Rosters, assignments *services.Assignments) *HomeworkHandler {
+	syncTimeout := defaultSyncTimeout
+	if value := os.Getenv("MARKING_SYNC_TIMEOUT"); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			syncTimeout = timeout
+		} else {
+			log.Printf("[WARN] MARKING_SYNC_TIMEOUT 格式错误: %s，使用默认值 %v", value, defaultSyncTimeout)
+		}
+	}
+
+	syncMaxPages := defaultSyncMaxPages
+	if value := os.Getenv("MARKING_SYNC_MAX_PAGES"); value != "" {
+		if pages, err := strconv.Atoi(value); err == nil && pages > 0 {
+			syncMaxPages = pages
+		} else {
+			log.Printf("[WARN] MARKING_SYNC_MAX_PAGES 格式错误: %s，使用默认值 %d", value, defaultSyncMaxPages)
+		}
+	}
+
 	return &HomeworkHandler{
-		taskQueue: taskQueue,
-		mutex:     &sync.Mutex{},
+		taskQueue:    taskQueue,
+		llm:          llm,
+		secondaryLLM: secondaryLLM,
+		prompts:      prompts,
+		rosters:      rosters,
+		assignments:  assignments,
+		mutex:        &sync.Mutex{},
+		syncTimeout:  syncTimeout,
+		syncMaxPages: syncMaxPages,
 	}
 }
 
@@ -96,51 +200,137 @@ func (h *HomeworkHandler) UploadHomework(c *gin.Context) {
 		return
 	}
 
-	// 检查文件类型
-	filename := file.Filename
-	extension := strings.ToLower(filepath.Ext(filename))
-	if extension != ".pdf" && extension != ".jpg" && extension != ".jpeg" && extension != ".png" {
+	// 一次可以上传多张作业图片（如家长分页拍摄的作业），按group字段分组后每组合并为一个学生的作业
+	files := []*multipart.FileHeader{file}
+	if form, err := c.MultipartForm(); err == nil && len(form.File["homework"]) > 1 {
+		files = form.File["homework"]
+	}
+
+	// 每张图片所属的学生，未提供分组时所有图片属于同一个学生
+	var imageGroups [][]int
+	if len(files) > 1 {
+		if imageGroups, err = services.GroupSubmissionFiles(len(files), c.PostFormArray("group")); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	// 获取拆分学生的方式：fixed按每个学生的页数拆分，auto识别每个学生的起始页并等待教师确认，qr按封面二维码拆分
+	splitMode := c.DefaultPostForm("splitMode", services.SplitModeFixed)
+	if splitMode != services.SplitModeFixed && splitMode != services.SplitModeAuto && splitMode != services.SplitModeQR {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
-			Error:   "只支持PDF、JPG、JPEG和PNG格式的文件",
+			Error:   "拆分方式只支持fixed、auto和qr",
+		})
+		return
+	}
+	if splitMode == services.SplitModeQR && !services.QRSplitAvailable {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   "服务未启用cgo编译，不支持按二维码拆分（splitMode=qr）",
 		})
 		return
 	}
 
-	// 获取作业类型
-	homeworkType := c.DefaultPostForm("type", "general")
-
-	// 获取自定义提示词
-	customPrompt := c.DefaultPostForm("prompt", "")
-
-	// 获取每个学生的页数
-	pagesPerStudent := 1
-	if pagesPerStudentStr := c.DefaultPostForm("pagesPerStudent", "1"); pagesPerStudentStr != "" {
-		if pages, err := strconv.Atoi(pagesPerStudentStr); err == nil && pages > 0 {
-			pagesPerStudent = pages
-		}
+	// 获取批改参数
+	job, err := h.readHomeworkJob(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
 	}
+	job.splitMode = splitMode
 
-	// 获取布局方式
-	layout := c.DefaultPostForm("layout", "single")
+	// 没有创建任务就返回时删除已保存的教师版标准答案
+	taskCreated := false
+	defer func() {
+		if !taskCreated {
+			job.discardAnswerKey()
+		}
+	}()
 
-	// 创建唯一的文件名
-	uniqueID := uuid.New().String()
-	uploadDir := "uploads"
-	uploadPath := filepath.Join(uploadDir, uniqueID+extension)
+	// 引用了作业时沿用作业的批改设置
+	if status, err := h.bindAssignment(c, &job); err != nil {
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
 
-	// 保存文件
-	if err := c.SaveUploadedFile(file, uploadPath); err != nil {
-		log.Printf("[ERROR] 保存文件失败: %v", err)
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+	// 校验班级并准备按班级名单匹配学生
+	if status, err := h.bindRoster(c, &job); err != nil {
+		c.JSON(status, models.APIResponse{
 			Success: false,
-			Error:   "保存文件失败",
+			Error:   err.Error(),
 		})
 		return
 	}
 
-	// 创建异步任务
+	// 保存文件（使用唯一的文件名），按文件内容而不是扩展名识别类型，不支持或损坏的文件在上传时拒绝
+	uploadDir := "uploads"
+	var uploadPaths, mimeTypes []string
+	for _, f := range files {
+		path := filepath.Join(uploadDir, uuid.New().String()+strings.ToLower(filepath.Ext(f.Filename)))
+		if err := c.SaveUploadedFile(f, path); err != nil {
+			log.Printf("[ERROR] 保存文件失败: %v", err)
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Error:   "保存文件失败",
+			})
+			return
+		}
+		uploadPaths = append(uploadPaths, path)
+
+		mimeType, err := services.InspectUpload(path)
+		if err == nil && len(files) > 1 && !services.IsImageType(mimeType) {
+			err = fmt.Errorf("上传多个文件时只支持图片")
+		}
+		if err != nil {
+			log.Printf("[ERROR] 上传文件 %s 无效: %v", f.Filename, err)
+			for _, saved := range uploadPaths {
+				os.Remove(saved)
+			}
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("%s: %v", f.Filename, err),
+			})
+			return
+		}
+		mimeTypes = append(mimeTypes, mimeType)
+	}
+	job.uploadPath, job.mimeType = uploadPaths[0], mimeTypes[0]
+
+	// 多张图片按分组整理为每个学生的图片列表
+	for _, group := range imageGroups {
+		images := make([]string, 0, len(group))
+		for _, i := range group {
+			images = append(images, uploadPaths[i])
+		}
+		job.studentImages = append(job.studentImages, images)
+	}
+
+	// 创建异步任务，客户端通过该任务ID跟踪拆分、批改进度和最终结果
 	taskID := h.taskQueue.CreateTask("homework_processing", "正在处理文件...")
+	h.taskQueue.SetTaskOwner(taskID, c.GetString("userId"), job.classID)
+	h.taskQueue.SetTaskInfo(taskID, job.uploadPath, job.opts.homeworkType, job.pagesPerStudent, job.layout)
+	h.taskQueue.AddTaskFiles(taskID, uploadPaths...)
+	if job.answerKeyPDF != "" {
+		h.taskQueue.AddTaskFiles(taskID, job.answerKeyPDF)
+	}
+	taskCreated = true
+	if job.assignmentID != "" {
+		h.taskQueue.SetTaskAssignment(taskID, job.assignmentID)
+	}
+
+	// 任务的上下文，取消任务时用于停止拆分后的批改和大模型调用
+	ctx, cancel := context.WithCancel(context.Background())
+	h.taskQueue.RegisterCancelFunc(taskID, cancel)
 
 	// 立即返回任务ID
 	c.JSON(http.StatusOK, models.APIResponse{
@@ -153,80 +343,368 @@ func (h *HomeworkHandler) UploadHomework(c *gin.Context) {
 
 	// 异步处理文件
 	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("[ERROR] 处理文件时发生异常: %v", r)
-				h.taskQueue.UpdateTaskStatus(taskID, "error", fmt.Sprintf("处理文件时发生异常: %v", r))
+		defer h.taskQueue.UnregisterCancelFunc(taskID)
+		h.runHomeworkTask(ctx, taskID, job)
+	}()
+}
+
+// homeworkJob 一次上传需要批改的文件和批改参数
+type homeworkJob struct {
+	opts            gradingOptions
+	answerKeyPDF    string     // 教师版标准答案PDF，批改前由大模型提取
+	uploadPath      string     // 上传的第一个文件
+	mimeType        string     // 按内容识别的文件类型
+	studentImages   [][]string // 多张图片按学生分组，为空时按uploadPath的类型处理
+	pagesPerStudent int
+	layout          string
+	splitMode       string
+	classID         string // 作业所属的班级（可选）
+	assignmentID    string // 引用的作业（可选）
+}
+
+// discardAnswerKey 删除不再使用的教师版标准答案PDF，例如上传引用了作业或没有创建任务时
+func (job *homeworkJob) discardAnswerKey() {
+	if job.answerKeyPDF != "" {
+		os.Remove(job.answerKeyPDF)
+		job.answerKeyPDF = ""
+	}
+}
+
+// readHomeworkJob 读取上传请求中的批改参数：作业类型、提示词、班级、每个学生的页数、布局、计分方式、评分标准和标准答案
+func (h *HomeworkHandler) readHomeworkJob(c *gin.Context) (homeworkJob, error) {
+	job := homeworkJob{
+		opts: gradingOptions{
+			// 获取作业类型
+			homeworkType: c.DefaultPostForm("type", "general"),
+			// 获取自定义提示词
+			customPrompt: c.DefaultPostForm("prompt", ""),
+		},
+		pagesPerStudent: 1,
+		classID:         strings.TrimSpace(c.PostForm("classId")),
+	}
+
+	// 管理员维护的提示词模板覆盖内置的系统指令
+	if template, ok := h.prompts.SystemInstruction(job.opts.homeworkType); ok {
+		job.opts.basePrompt = template
+	}
+
+	// 获取每个学生的页数
+	if pagesPerStudentStr := c.DefaultPostForm("pagesPerStudent", "1"); pagesPerStudentStr != "" {
+		if pages, err := strconv.Atoi(pagesPerStudentStr); err == nil && pages > 0 {
+			job.pagesPerStudent = pages
+		}
+	}
+
+	// 获取布局方式：single为每页一张A4，double为A3横向扫描的双栏页面
+	job.layout = c.DefaultPostForm("layout", services.LayoutSingle)
+	if job.layout != services.LayoutSingle && job.layout != services.LayoutDouble {
+		return job, fmt.Errorf("布局方式只支持single和double")
+	}
+
+	// 获取总得分的满分和取整方式
+	var err error
+	if job.opts.scoring, err = services.ParseScoringOptions(c.PostForm("fullMarks"), c.PostForm("rounding"), c.PostForm("precision")); err != nil {
+		return job, err
+	}
+
+	// 作文的评分标准，未提供时使用默认评分标准
+	if job.opts.homeworkType == services.HomeworkTypeEssay {
+		job.opts.rubric = services.DefaultEssayRubric()
+		if rubricText := strings.TrimSpace(c.PostForm("rubric")); rubricText != "" {
+			if job.opts.rubric, err = services.ParseRubricJSON([]byte(rubricText)); err != nil {
+				return job, err
 			}
-		}()
+		}
+	}
 
-		// 更新任务状态
-		h.taskQueue.UpdateTaskStatus(taskID, "processing", "正在分析文件内容...")
+	// 获取标准答案（可选）：JSON/CSV在上传时解析，教师版PDF在批改前由大模型提取
+	if job.opts.answerKey, job.answerKeyPDF, err = readAnswerKey(c); err != nil {
+		log.Printf("[ERROR] 读取标准答案失败: %v", err)
+		return job, err
+	}
+	return job, nil
+}
 
-		// 处理文件，根据文件类型选择不同的处理方式
-		// var result string
-		var err error
+// bindAssignment 上传请求指定了assignmentId时，以作业的设置替换请求中的作业类型、提示词、标准答案、评分标准、计分方式、多次批改、页数和布局
+// 只能引用自己创建的作业；作业布置给了班级时，只能上传这些班级的作业
+func (h *HomeworkHandler) bindAssignment(c *gin.Context, job *homeworkJob) (int, error) {
+	assignmentID := strings.TrimSpace(c.PostForm("assignmentId"))
+	if assignmentID == "" {
+		return 0, nil
+	}
+	if h.assignments == nil {
+		return http.StatusBadRequest, services.ErrAssignmentNotFound
+	}
 
-		if extension == ".pdf" {
+	assignment, err := h.assignments.Get(assignmentID)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if !middleware.CurrentPrincipal(c).CanManageAssignment(assignment) {
+		return http.StatusForbidden, fmt.Errorf("没有权限使用该作业")
+	}
+	if len(assignment.ClassIDs) > 0 && !slices.Contains(assignment.ClassIDs, job.classID) {
+		return http.StatusBadRequest, fmt.Errorf("请指定布置了该作业的班级")
+	}
+	if assignment.Ensemble != nil && assignment.Ensemble.CrossModel && h.secondaryLLM == nil {
+		return http.StatusBadRequest, fmt.Errorf("服务没有配置第二个大模型，不能使用多模型批改")
+	}
+
+	job.assignmentID = assignment.ID
+	job.opts = gradingOptions{
+		homeworkType:   assignment.Subject,
+		customPrompt:   assignment.Prompt,
+		answerKey:      services.AssignmentAnswerKey(assignment.AssignmentSettings),
+		questionPoints: assignment.QuestionPoints,
+		scoring:        services.AssignmentScoringOptions(assignment.AssignmentSettings),
+		ensemble:       assignment.Ensemble,
+		secondaryLLM:   h.secondaryLLM,
+	}
+	if template, ok := h.prompts.SystemInstruction(job.opts.homeworkType); ok {
+		job.opts.basePrompt = template
+	}
+	if job.opts.homeworkType == services.HomeworkTypeEssay {
+		job.opts.rubric = assignment.Rubric
+		if job.opts.rubric == nil {
+			job.opts.rubric = services.DefaultEssayRubric()
+		}
+	}
+	job.discardAnswerKey()
+	job.pagesPerStudent = assignment.PagesPerStudent
+	job.layout = assignment.Layout
+	return 0, nil
+}
+
+// bindRoster 校验上传的班级，并按班级名单准备批改结果的学生匹配
+// 指定了班级时只能上传自己管理的班级，只匹配该班级的学生；未指定时匹配上传者管理的所有班级
+func (h *HomeworkHandler) bindRoster(c *gin.Context, job *homeworkJob) (int, error) {
+	principal := middleware.CurrentPrincipal(c)
+	// 限制了班级的API密钥只能上传这些班级的作业
+	if !principal.CanUseClass(job.classID) {
+		return http.StatusForbidden, fmt.Errorf("API密钥不能访问该班级")
+	}
+	if h.rosters == nil {
+		return 0, nil
+	}
+
+	var classes []models.Class
+	if job.classID != "" {
+		class, err := h.rosters.GetClass(job.classID)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		if !principal.CanManageClass(class) {
+			return http.StatusForbidden, fmt.Errorf("没有权限上传该班级的作业")
+		}
+		classes = []models.Class{class}
+	} else {
+		classes = h.rosters.ListClasses(principal.CanManageClass)
+	}
+	job.opts.roster = h.rosters.Matcher(classes)
+	return 0, nil
+}
+
+// runHomeworkTask 处理一次上传的作业，进度、结果和错误都记录在taskID对应的任务上
+func (h *HomeworkHandler) runHomeworkTask(ctx context.Context, taskID string, job homeworkJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ERROR] 处理文件时发生异常: %v", r)
+			h.taskQueue.UpdateTaskStatus(taskID, "error", fmt.Sprintf("处理文件时发生异常: %v", r))
+		}
+	}()
+
+	// 更新任务状态
+	h.taskQueue.UpdateTaskStatus(taskID, "processing", "正在分析文件内容...")
+
+	// 处理文件，根据文件类型选择不同的处理方式
+	opts := job.opts
+	var results []models.HomeworkResult
+	var err error
+
+	if job.answerKeyPDF != "" {
+		h.taskQueue.UpdateTaskMessage(taskID, "正在识别标准答案...")
+		opts.answerKey, err = services.ExtractAnswerKeyFromPDF(ctx, h.llm, job.answerKeyPDF)
+	}
+
+	// 未指定满分时按标准答案或评分标准的总分计算，教师版PDF的标准答案识别后才能确定；复核时沿用同样的计分方式
+	opts.scoring = opts.scoring.ResolveFullMarks(opts.answerKey, opts.rubric)
+	h.taskQueue.SetTaskScoring(taskID, opts.scoring)
+
+	// 标准答案识别失败时不再批改
+	if err == nil {
+		if len(job.studentImages) > 0 {
+			// 多张图片按学生合并后批改
+			results, err = h.processImageSubmissions(ctx, taskID, job.studentImages, opts)
+		} else if job.mimeType == "application/pdf" {
 			// PDF处理逻辑
-			_, err = h.processPDFHomework(uploadPath, homeworkType, customPrompt, pagesPerStudent, layout)
+			results, err = h.processPDFHomework(ctx, taskID, job.uploadPath, opts, job.pagesPerStudent, job.layout, job.splitMode)
 		} else {
 			// 图片处理逻辑
-			_, err = h.processImageHomework(uploadPath, homeworkType, customPrompt)
+			results, err = h.processImageHomework(ctx, taskID, job.uploadPath, opts)
 		}
+	}
 
-		if err != nil {
-			log.Printf("[ERROR] 处理文件失败: %v", err)
-			h.taskQueue.UpdateTaskStatus(taskID, "error", fmt.Sprintf("处理文件失败: %v", err))
-			return
+	// 任务已被取消，状态和已完成的结果由CancelTask保留
+	if ctx.Err() != nil {
+		log.Printf("[INFO] 任务 %s 已取消，停止处理", taskID)
+		return
+	}
+
+	if err != nil {
+		log.Printf("[ERROR] 处理文件失败: %v", err)
+		h.taskQueue.UpdateTaskStatus(taskID, "error", fmt.Sprintf("处理文件失败: %v", err))
+		return
+	}
+
+	// 更新任务状态为完成
+	h.taskQueue.CompleteTask(taskID, results)
+}
+
+// splitPDFByDetectedBoundaries 识别每个学生的起始页作为建议分页，等待教师通过任务接口确认或修改后按分页拆分PDF
+// originalPages不为空时pdfPath是双栏拆分后的PDF，建议和确认的分页都使用原扫描件的页码
+func (h *HomeworkHandler) splitPDFByDetectedBoundaries(ctx context.Context, taskID, pdfPath string, originalPages []int) ([]string, []models.StudentPageRange, error) {
+	h.taskQueue.UpdateTaskMessage(taskID, "正在识别每个学生的起始页...")
+	ranges, pageCount, err := services.DetectStudentBoundaries(ctx, h.llm, pdfPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 双栏扫描件按原扫描件的页码请教师确认，拆分时再换算回裁剪后的页码
+	if len(originalPages) > 0 {
+		ranges = services.MapRangesToOriginalPages(ranges, originalPages)
+		pageCount = originalPages[len(originalPages)-1]
+	}
+	h.taskQueue.ProposeSplit(taskID, pageCount, ranges)
+	ranges, err = h.taskQueue.WaitForSplitConfirmation(ctx, taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	splitRanges := ranges
+	if len(originalPages) > 0 {
+		splitRanges = services.MapRangesToSplitPages(ranges, originalPages)
+	}
+	h.taskQueue.UpdateTaskMessage(taskID, "正在拆分PDF文件...")
+	studentPDFs, err := services.SplitPDFByRanges(pdfPath, splitRanges)
+	return studentPDFs, ranges, err
+}
+
+// splitPDFByQRCodes 识别每页的封面二维码，按二维码拆分学生并记录学生身份，二维码中的作业与任务引用的作业不一致时不批改
+func (h *HomeworkHandler) splitPDFByQRCodes(taskID, pdfPath string, originalPages []int) ([]string, []models.StudentPageRange, error) {
+	h.taskQueue.UpdateTaskMessage(taskID, "正在识别封面二维码...")
+	codes, err := services.DecodePageQRCodes(pdfPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ranges := services.SplitByQRCodes(codes)
+	identified := 0
+	for _, r := range ranges {
+		if r.StudentID != "" {
+			identified++
 		}
+	}
+	if identified == 0 {
+		return nil, nil, fmt.Errorf("未识别到学生二维码")
+	}
+	log.Printf("[INFO] 通过二维码识别出%d个学生，共%d份作业", identified, len(ranges))
 
-		// 更新任务状态为完成
-		//h.taskQueue.UpdateTaskStatus(taskID, "completed", result)
-	}()
+	// 二维码中的作业必须是上传时引用的作业
+	if task, exists := h.taskQueue.GetTask(taskID); exists {
+		if err := services.CheckCoverAssignment(ranges, task.AssignmentID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// 记录的学生分页使用原扫描件的页码
+	if len(originalPages) > 0 {
+		h.taskQueue.SetStudentSplit(taskID, originalPages[len(originalPages)-1], services.MapRangesToOriginalPages(ranges, originalPages))
+	} else {
+		h.taskQueue.SetStudentSplit(taskID, len(codes), ranges)
+	}
+	h.taskQueue.UpdateTaskMessage(taskID, "正在拆分PDF文件...")
+	studentPDFs, err := services.SplitPDFByRanges(pdfPath, ranges)
+	return studentPDFs, ranges, err
 }
 
-// 处理PDF作业
-func (h *HomeworkHandler) processPDFHomework(pdfPath, homeworkType, customPrompt string, pagesPerStudent int, layout string) (string, error) {
-	// 实现PDF处理逻辑
-	log.Printf("[INFO] 处理PDF作业: %s, 类型: %s", pdfPath, homeworkType)
+// retryBackoff 调用大模型失败后重试前的等待时间（指数退避）
+var retryBackoff = func(attempt int) time.Duration {
+	return time.Duration(attempt*attempt) * time.Second
+}
 
-	// 创建任务记录
-	taskID := h.taskQueue.CreateTask("pdf_processing", "正在处理PDF文件...")
+// 处理PDF作业，进度和每个学生的结果都记录在taskID对应的任务上，返回按学生顺序排列的结果
+// splitMode为auto时先识别每个学生的起始页，等待教师确认学生分页后再批改；为qr时按封面二维码拆分学生并确定身份
+func (h *HomeworkHandler) processPDFHomework(ctx context.Context, taskID, pdfPath string, opts gradingOptions, pagesPerStudent int, layout, splitMode string) ([]models.HomeworkResult, error) {
+	// 实现PDF处理逻辑
+	log.Printf("[INFO] 处理PDF作业: %s, 类型: %s, 任务: %s", pdfPath, opts.homeworkType, taskID)
 
 	// 检查文件是否存在
 	if _, err := os.Stat(pdfPath); os.IsNotExist(err) {
 		errMsg := fmt.Sprintf("PDF文件不存在: %s", pdfPath)
 		log.Printf("[ERROR] %s", errMsg)
-		h.taskQueue.FailTask(taskID, errMsg)
-		return "", fmt.Errorf(errMsg)
+		return nil, fmt.Errorf("%s", errMsg)
 	}
 
-	// 创建AI客户端
-	client := services.NewVertexAIClient()
-
-	// 获取系统指令
-	systemInstruction := getSystemInstructionByType(homeworkType)
+	// 获取系统指令，按二维码确定学生身份时不需要识别手写姓名
+	systemInstruction := opts.systemInstruction()
+	if splitMode == services.SplitModeQR {
+		systemInstruction += "\n\n学生身份已由封面二维码确定，不需要识别手写的姓名和班级，name和class返回空字符串。"
+	}
 
 	// 创建临时目录用于分割的PDF文件
 	splitDir := filepath.Join("uploads", "split")
 
-	// 按照学生页数拆分PDF
-	studentPDFs, err := services.SplitPDF(pdfPath, pagesPerStudent, splitDir)
+	// 双栏扫描件先把每页裁剪为左右两页，之后按裁剪后的页数拆分学生
+	// originalPages为裁剪后每页在原扫描件中的页码，给教师看的学生分页使用原扫描件的页码
+	var originalPages []int
+	if layout == services.LayoutDouble {
+		h.taskQueue.UpdateTaskMessage(taskID, "正在拆分双栏页面...")
+		halvesPath := strings.TrimSuffix(pdfPath, filepath.Ext(pdfPath)) + "_halves.pdf"
+		var err error
+		if originalPages, err = services.SplitDoubleLayoutPDF(pdfPath, halvesPath); err != nil {
+			errMsg := fmt.Sprintf("拆分双栏页面失败: %v", err)
+			log.Printf("[ERROR] %s", errMsg)
+			return nil, fmt.Errorf("%s", errMsg)
+		}
+		defer os.Remove(halvesPath)
+		pdfPath = halvesPath
+	}
+
+	// 按照学生页数或确认后的学生分页拆分PDF
+	// identities为每个学生已确定的身份（二维码或教师确认的分页），按固定页数拆分时为空
+	var studentPDFs []string
+	var identities []models.StudentPageRange
+	var err error
+	switch splitMode {
+	case services.SplitModeAuto:
+		studentPDFs, identities, err = h.splitPDFByDetectedBoundaries(ctx, taskID, pdfPath, originalPages)
+	case services.SplitModeQR:
+		studentPDFs, identities, err = h.splitPDFByQRCodes(taskID, pdfPath, originalPages)
+	default:
+		h.taskQueue.UpdateTaskMessage(taskID, "正在拆分PDF文件...")
+		studentPDFs, err = services.SplitPDF(pdfPath, pagesPerStudent, splitDir)
+	}
 	if err != nil {
 		errMsg := fmt.Sprintf("拆分PDF失败: %v", err)
 		log.Printf("[ERROR] %s", errMsg)
-		h.taskQueue.FailTask(taskID, errMsg)
-		return "", fmt.Errorf(errMsg)
+		return nil, fmt.Errorf("%s", errMsg)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
+	return h.gradeStudentPDFs(ctx, taskID, studentPDFs, identities, opts, systemInstruction)
+}
+
+// gradeStudentPDFs 并发批改每个学生的PDF，identities不为空时用其中已确定的身份覆盖识别的姓名
+func (h *HomeworkHandler) gradeStudentPDFs(ctx context.Context, taskID string, studentPDFs []string, identities []models.StudentPageRange, opts gradingOptions, systemInstruction string) ([]models.HomeworkResult, error) {
 	// 更新任务状态
 	totalStudents := len(studentPDFs)
-	h.taskQueue.UpdateTaskStatus(taskID, "processing", fmt.Sprintf("正在处理，总共%d个学生", totalStudents))
 	h.taskQueue.UpdateTaskTotalStudents(taskID, totalStudents)
+	h.taskQueue.UpdateTaskMessage(taskID, fmt.Sprintf("正在批改，总共%d个学生", totalStudents))
 
-	// 用于保存每个学生的处理结果
-	results := make([]string, totalStudents)
+	// 用于保存每个学生的处理结果，批改失败的学生保存为错误条目
+	results := make([]models.HomeworkResult, totalStudents)
 
 	// 创建一个等待组来同步所有goroutine
 	var wg sync.WaitGroup
@@ -242,6 +720,11 @@ func (h *HomeworkHandler) processPDFHomework(pdfPath, homeworkType, customPrompt
 			defer func() {
 				if r := recover(); r != nil {
 					log.Printf("[ERROR] 处理学生作业时发生panic: %v", r)
+					errMsg := fmt.Sprintf("处理时发生异常: %v", r)
+					resultsMutex.Lock()
+					results[studentIdx] = services.NewErrorResult(studentIdx, errMsg)
+					resultsMutex.Unlock()
+					h.taskQueue.RecordStudentFailure(taskID, studentIdx, errMsg)
 				}
 			}()
 
@@ -249,14 +732,14 @@ func (h *HomeworkHandler) processPDFHomework(pdfPath, homeworkType, customPrompt
 			log.Printf("[INFO] 开始处理学生 %d 的作业: %s", studentIdx+1, pdfPath)
 
 			// 设置提示词
-			textPrompt := customPrompt
+			textPrompt := opts.customPrompt
 			if textPrompt == "" {
 				textPrompt = fmt.Sprintf("这是一份%s作业，请分析PDF中的内容。这是学生%d的作业。请从上到下处理，整理所有答案。",
-					homeworkType, studentIdx+1)
+					opts.homeworkType, studentIdx+1)
 			}
 
-			// 调用AI模型分析PDF（添加重试机制）
-			var response string
+			// 调用AI模型分析PDF（添加重试机制），无法解析为批改结果的响应也会重试
+			var result models.HomeworkResult
 			var err error
 			maxRetries := 3
 
@@ -265,33 +748,17 @@ func (h *HomeworkHandler) processPDFHomework(pdfPath, homeworkType, customPrompt
 					log.Printf("[INFO] 第%d次重试调用大模型处理学生%d的PDF...",
 						attempt, studentIdx+1)
 					// 指数退避策略
-					backoffTime := time.Duration(attempt*attempt) * time.Second
-					time.Sleep(backoffTime)
+					if err = services.SleepWithContext(ctx, retryBackoff(attempt)); err != nil {
+						break
+					}
 				}
-
-				// 模拟模式下返回测试数据
-				if services.UseMockMode {
-					log.Printf("[INFO] 模拟模式: 生成模拟结果代替调用大模型")
-					mockData := fmt.Sprintf(`{
-  "answers": [
-    {
-								"questionNumber": "1",
-								"studentAnswer": "模拟答案 - 学生%d PDF作业",
-								"isCorrect": true,
-								"correctAnswer": "标准答案",
-								"explanation": "这是一个测试解释"
-							}
-						],
-						"overallScore": "90",
-						"feedback": "模拟反馈: 学生%d PDF作业表现良好"
-					}`, studentIdx+1, studentIdx+1)
-					response = mockData
+				if err = ctx.Err(); err != nil {
 					break
-				} else {
-					// 调用大模型API处理PDF文件
-					response, err = services.GenerateContentWithPDF(client, systemInstruction, pdfPath, textPrompt)
 				}
 
+				// 调用大模型API处理PDF文件，输出按schema校验
+				result, err = opts.grade(ctx, h.llm, systemInstruction, pdfPath, "application/pdf", textPrompt)
+
 				if err == nil {
 					log.Printf("[INFO] 成功获取学生%d的大模型分析结果", studentIdx+1)
 					break // 成功获取响应，退出重试循环
@@ -306,101 +773,62 @@ func (h *HomeworkHandler) processPDFHomework(pdfPath, homeworkType, customPrompt
 				}
 			}
 
-			// 如果获取到响应
-			if err == nil && response != "" {
+			if err == nil {
 				log.Printf("[INFO] 成功处理学生 %d 的作业", studentIdx+1)
 
-				// 解析JSON字符串为对象
-				var responseObj map[string]interface{}
-				err := json.Unmarshal([]byte(response), &responseObj)
-				if err == nil {
-					// 移除 "uploads/split/" 路径前缀
-					cleanPath := pdfPath
-					if strings.HasPrefix(cleanPath, "uploads/split/") {
-						cleanPath = strings.TrimPrefix(cleanPath, "uploads/split/")
-					}
-
-					// 添加PDF文件路径到响应对象
-					responseObj["pdfUrl"] = cleanPath
-
-					// 将对象转换回JSON字符串
-					updatedResponse, jsonErr := json.Marshal(responseObj)
-					if jsonErr == nil {
-						response = string(updatedResponse)
-					} else {
-						log.Printf("[ERROR] 将更新后的响应转换为JSON失败: %v", jsonErr)
-					}
-				} else {
-					log.Printf("[ERROR] 解析学生 %d 的响应JSON失败: %v", studentIdx+1, err)
+				// 按标准答案判分并计算总得分
+				opts.score(&result)
+				if studentIdx < len(identities) {
+					services.ApplyStudentIdentity(&result, identities[studentIdx])
 				}
+				opts.roster.Match(&result)
 
-				// 更新处理计数
-				h.taskQueue.IncrementProcessedCount(taskID)
+				// 添加PDF文件路径（移除 "uploads/split/" 路径前缀）
+				result.PdfURL = strings.TrimPrefix(filepath.ToSlash(pdfPath), "uploads/split/")
+				result.StudentIndex = studentIdx
 
-				// 锁定添加结果
-				resultsMutex.Lock()
 				// 保存结果到正确的索引位置
-				results[studentIdx] = response
+				resultsMutex.Lock()
+				results[studentIdx] = result
 				resultsMutex.Unlock()
+
+				// 更新任务上的部分结果和处理计数
+				h.taskQueue.RecordStudentResult(taskID, studentIdx, result)
+			} else if ctx.Err() != nil {
+				// 任务被取消，未完成的学生不计为失败
+				log.Printf("[INFO] 任务已取消，停止处理学生 %d 的作业", studentIdx+1)
 			} else {
 				log.Printf("[ERROR] 处理学生 %d 作业失败: %v", studentIdx+1, err)
-				// 即使处理失败，也在结果数组中保留位置
+				// 处理失败的学生以错误条目保留在结果中
+				errorResult := services.NewErrorResult(studentIdx, err.Error())
+				errorResult.PdfURL = strings.TrimPrefix(filepath.ToSlash(pdfPath), "uploads/split/")
 				resultsMutex.Lock()
-				results[studentIdx] = ""
+				results[studentIdx] = errorResult
 				resultsMutex.Unlock()
+
+				h.taskQueue.RecordStudentFailure(taskID, studentIdx, err.Error())
 			}
 		}(studentIdx, studentPDF)
 	}
 
 	// 等待所有处理完成
 	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	// 合并结果 - 按原始索引顺序合并
-	combinedResults := "["
-	validResultCount := 0
-
-	for i, result := range results {
-		// 如果某个位置为空（处理失败），跳过它
-		if result == "" {
-			continue
-		}
-
-		validResultCount++
-
-		// 去除首尾可能存在的中括号和多余空格
-		result = strings.TrimSpace(result)
-		if strings.HasPrefix(result, "[") {
-			result = strings.TrimPrefix(result, "[")
-		}
-		if strings.HasSuffix(result, "]") {
-			result = strings.TrimSuffix(result, "]")
-		}
-
-		// 添加到合并结果中
-		combinedResults += result
-
-		// 如果不是最后一个结果，添加逗号分隔
-		if i < len(results)-1 {
-			// 检查后面是否还有非空结果
-			hasMoreResults := false
-			for j := i + 1; j < len(results); j++ {
-				if results[j] != "" {
-					hasMoreResults = true
-					break
-				}
-			}
-			if hasMoreResults {
-				combinedResults += ","
-			}
+	gradedCount := 0
+	for _, result := range results {
+		if result.Status == models.ResultStatusGraded {
+			gradedCount++
 		}
 	}
-	combinedResults += "]"
-
-	// 完成任务
-	h.taskQueue.CompleteTask(taskID, combinedResults)
+	if gradedCount == 0 {
+		return nil, fmt.Errorf("所有%d个学生的作业都处理失败", totalStudents)
+	}
 
-	// 返回任务ID，前端可以轮询任务状态
-	return taskID, nil
+	log.Printf("[INFO] PDF作业处理完成，成功 %d/%d 个学生", gradedCount, totalStudents)
+	return results, nil
 }
 
 // 根据作业类型获取系统指令
@@ -426,14 +854,18 @@ func getSystemInstructionByType(homeworkType string) string {
       "studentAnswer": "学生的手写答案",
       "isCorrect": true/false,
       "correctAnswer": "正确答案（可双栏布局）",
-      "explanation": "简短答案解释"
+      "explanation": "简短答案解释",
+      "maxPoints": 该题满分（数字）,
+      "awardedPoints": 该题得分（数字，0到满分之间，部分正确时可给部分分）,
+      "rationale": "给分理由，部分给分时说明扣分原因",
+      "confidence": 对识别和判定的把握（0到1之间的数字，字迹难以辨认或无法确定对错时给较低的值）,
+      "illegible": 学生的答案字迹无法辨认时为true，否则为false
     }
   ],
-  "overallScore": "总得分（必须使用百分制，0-100之间的数字，不要带百分号）",
   "feedback": "整体评价和建议"
 }
 
-		请只返回标准JSON格式数据，不要使用Markdown代码块；不要分析右半部分的内容，从上到下处理。`
+		请只返回标准JSON格式数据，不要使用Markdown代码块；按从上到下、从左到右的顺序处理。`
 	case "math":
 		systemInstruction = `
 		你是一位专业的数学老师。
@@ -454,10 +886,14 @@ func getSystemInstructionByType(homeworkType string) string {
       "studentAnswer": "学生的手写答案",
       "isCorrect": true/false,
       "correctAnswer": "正确答案",
-      "explanation": "简短答案解释"
+      "explanation": "简短答案解释",
+      "maxPoints": 该题满分（数字）,
+      "awardedPoints": 该题得分（数字，0到满分之间，部分正确时可给部分分）,
+      "rationale": "给分理由，部分给分时说明扣分原因",
+      "confidence": 对识别和判定的把握（0到1之间的数字，字迹难以辨认或无法确定对错时给较低的值）,
+      "illegible": 学生的答案字迹无法辨认时为true，否则为false
     }
   ],
-  "overallScore": "总得分（必须使用百分制，0-100之间的数字，不要带百分号）",
   "feedback": "整体评价和建议"
 		}`
 	case "chinese":
@@ -479,12 +915,40 @@ func getSystemInstructionByType(homeworkType string) string {
       "studentAnswer": "学生的手写答案",
       "isCorrect": true/false,
       "correctAnswer": "正确答案",
-      "explanation": "简短答案解释"
+      "explanation": "简短答案解释",
+      "maxPoints": 该题满分（数字）,
+      "awardedPoints": 该题得分（数字，0到满分之间，部分正确时可给部分分）,
+      "rationale": "给分理由，部分给分时说明扣分原因",
+      "confidence": 对识别和判定的把握（0到1之间的数字，字迹难以辨认或无法确定对错时给较低的值）,
+      "illegible": 学生的答案字迹无法辨认时为true，否则为false
     }
   ],
-  "overallScore": "总得分（必须使用百分制，0-100之间的数字，不要带百分号）",
   "feedback": "整体评价和建议"
 		}`
+	case services.HomeworkTypeEssay:
+		systemInstruction = `
+		你是一位经验丰富的作文阅卷老师，能够批改语文作文和英语作文。
+		特别注意：
+		1. 完整识别学生的手写作文，区分印刷的题目要求和学生的作文内容
+		2. 严格按照评分标准逐项评分，每一项都要从作文原文中摘录支持该得分的句子作为依据
+		3. 英语作文的评语使用中文，引用保留英文原文
+		4. 从作业中提取学生姓名和班级信息（通常在作业右上角或左上角）
+
+		请以下面的JSON格式回答：
+{
+  "name": "学生姓名（如果能识别）",
+  "class": "班级（如果能识别）",
+  "criteria": [
+    {
+      "name": "评分项名称（与评分标准一致）",
+      "score": 该项得分（数字）,
+      "band": "所属档次",
+      "evidence": "支持该得分的作文原文引用",
+      "comment": "该项的评语"
+    }
+  ],
+  "feedback": "整体评价和修改建议"
+}`
 	default:
 		systemInstruction = `
 		请分析学生的作业图片，提取其中的内容。
@@ -503,34 +967,20 @@ func getSystemInstructionByType(homeworkType string) string {
       "studentAnswer": "学生的手写答案",
       "isCorrect": true/false,
       "correctAnswer": "正确答案",
-      "explanation": "简短答案解释"
+      "explanation": "简短答案解释",
+      "maxPoints": 该题满分（数字）,
+      "awardedPoints": 该题得分（数字，0到满分之间，部分正确时可给部分分）,
+      "rationale": "给分理由，部分给分时说明扣分原因",
+      "confidence": 对识别和判定的把握（0到1之间的数字，字迹难以辨认或无法确定对错时给较低的值）,
+      "illegible": 学生的答案字迹无法辨认时为true，否则为false
     }
   ],
-  "overallScore": "总得分（必须使用百分制，0-100之间的数字，不要带百分号）",
   "feedback": "整体评价和建议"
 		}`
 	}
 	return systemInstruction
 }
 
-// 计算总分
-func calculateOverallScore(answers []map[string]interface{}) string {
-	if len(answers) == 0 {
-		return "0"
-	}
-
-	correctCount := 0
-	for _, answer := range answers {
-		if isCorrect, ok := answer["isCorrect"].(bool); ok && isCorrect {
-			correctCount++
-		}
-	}
-
-	// 计算百分比
-	percentage := float64(correctCount) / float64(len(answers)) * 100
-	return fmt.Sprintf("%.1f", percentage)
-}
-
 // 生成反馈
 func generateFeedback(answers []map[string]interface{}) string {
 	if len(answers) == 0 {
@@ -607,53 +1057,112 @@ func splitPDFToImages(pdfPath, outputDir string) ([]string, error) {
 	return imageFiles, nil
 }
 
-// 处理图片作业
-func (h *HomeworkHandler) processImageHomework(imagePath, homeworkType, customPrompt string) (string, error) {
-	log.Printf("[DEBUG] 开始处理作业图片: %s, 类型: %s", imagePath, homeworkType)
+// 处理图片作业，图片作为一个学生处理，与PDF作业以相同方式报告进度，返回批改结果
+func (h *HomeworkHandler) processImageHomework(ctx context.Context, taskID, imagePath string, opts gradingOptions) ([]models.HomeworkResult, error) {
+	log.Printf("[DEBUG] 开始处理作业图片: %s, 类型: %s, 任务: %s", imagePath, opts.homeworkType, taskID)
+
+	h.taskQueue.UpdateTaskTotalStudents(taskID, 1)
+	h.taskQueue.UpdateTaskMessage(taskID, "正在批改，总共1个学生")
+
+	result, err := h.gradeImage(ctx, taskID, imagePath, opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		h.taskQueue.RecordStudentFailure(taskID, 0, err.Error())
+		return nil, err
+	}
+
+	h.taskQueue.RecordStudentResult(taskID, 0, result)
+	return []models.HomeworkResult{result}, nil
+}
+
+// processImageSubmissions 将每个学生的多张作业图片按顺序合并为一份PDF，每份作为一个学生批改
+func (h *HomeworkHandler) processImageSubmissions(ctx context.Context, taskID string, studentImages [][]string, opts gradingOptions) ([]models.HomeworkResult, error) {
+	log.Printf("[INFO] 处理多图片作业: %d 个学生, 类型: %s, 任务: %s", len(studentImages), opts.homeworkType, taskID)
+
+	// 合并前先规范化每张图片（摆正方向、缩小尺寸、HEIC/WebP转换为JPEG）
+	h.taskQueue.UpdateTaskMessage(taskID, "正在合并作业图片...")
+	normalized := make([][]string, len(studentImages))
+	for i, images := range studentImages {
+		for _, imagePath := range images {
+			normalizedPath, _, err := h.normalizeTaskImage(taskID, imagePath)
+			if err != nil {
+				return nil, err
+			}
+			normalized[i] = append(normalized[i], normalizedPath)
+		}
+	}
+	studentPDFs, err := services.AssembleImageSubmissions(normalized)
+	if err != nil {
+		return nil, err
+	}
+	return h.gradeStudentPDFs(ctx, taskID, studentPDFs, nil, opts, opts.systemInstruction())
+}
+
+// normalizeTaskImage 规范化作业图片，生成的新文件登记到任务中，清理任务时与上传的文件一并删除
+func (h *HomeworkHandler) normalizeTaskImage(taskID, imagePath string) (string, string, error) {
+	normalizedPath, mimeType, err := services.NormalizeImage(imagePath)
+	if err != nil {
+		return "", "", err
+	}
+	if normalizedPath != imagePath {
+		h.taskQueue.AddTaskFiles(taskID, normalizedPath)
+	}
+	return normalizedPath, mimeType, nil
+}
+
+// gradeImage 调用大模型批改单张作业图片，返回该学生的批改结果
+func (h *HomeworkHandler) gradeImage(ctx context.Context, taskID, imagePath string, opts gradingOptions) (models.HomeworkResult, error) {
+	var result models.HomeworkResult
 
 	// 检查图片文件是否存在
 	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
 		log.Printf("[ERROR] 图片文件不存在: %s", imagePath)
-		return "", fmt.Errorf("图片文件不存在: %s", imagePath)
+		return result, fmt.Errorf("图片文件不存在: %s", imagePath)
 	}
 
 	// 检查图片文件是否可读
 	if _, err := os.Open(imagePath); err != nil {
 		log.Printf("[ERROR] 无法打开图片文件: %v", err)
-		return "", fmt.Errorf("无法打开图片文件: %v", err)
+		return result, fmt.Errorf("无法打开图片文件: %v", err)
 	}
 
-	// 创建AI客户端
-	client := services.NewVertexAIClient()
-	log.Printf("[DEBUG] 创建AI客户端成功")
+	// 规范化图片：按EXIF方向摆正、缩小过大的照片，HEIC/WebP转换为JPEG，并得到实际的MIME类型
+	imagePath, mimeType, err := h.normalizeTaskImage(taskID, imagePath)
+	if err != nil {
+		log.Printf("[ERROR] 规范化图片失败: %v", err)
+		return result, err
+	}
 
-	// 根据作业类型设置系统指令
-	systemInstruction := getSystemInstructionByType(homeworkType)
+	log.Printf("[DEBUG] 使用AI服务: %s", h.llm.Name())
+
+	// 根据作业类型和标准答案设置系统指令
+	systemInstruction := opts.systemInstruction()
 	log.Printf("[DEBUG] 系统指令长度: %d 字符", len(systemInstruction))
 
 	// 设置提示词 - 使用传入的自定义提示词或者创建一个基本提示词
-	textPrompt := customPrompt
+	textPrompt := opts.customPrompt
 	if textPrompt == "" {
-		textPrompt = fmt.Sprintf("这是一份%s作业，请分析图片中的内容，从上到下处理。", homeworkType)
+		textPrompt = fmt.Sprintf("这是一份%s作业，请分析图片中的内容，从上到下处理。", opts.homeworkType)
 	}
 
 	log.Printf("[DEBUG] 提示词长度: %d 字符", len(textPrompt))
 
 	// 调用Gemini模型分析图片（添加重试机制）
-	var response string
-	var err error
 	maxRetries := 3
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		if attempt > 0 {
 			log.Printf("[INFO] 第%d次重试调用大模型处理图片...", attempt)
 			// 指数退避策略
-			backoffTime := time.Duration(attempt*attempt) * time.Second
-			time.Sleep(backoffTime)
+			if err := services.SleepWithContext(ctx, retryBackoff(attempt)); err != nil {
+				return result, err
+			}
 		}
 
-		// 调用大模型API
-		response, err = client.GenerateContentWithFile(systemInstruction, imagePath, "image/jpeg", textPrompt)
+		// 调用大模型API，输出按schema校验
+		result, err = opts.grade(ctx, h.llm, systemInstruction, imagePath, mimeType, textPrompt)
 
 		if err == nil {
 			log.Printf("[INFO] 成功获取大模型分析结果")
@@ -662,31 +1171,65 @@ func (h *HomeworkHandler) processImageHomework(imagePath, homeworkType, customPr
 
 		log.Printf("[ERROR] 调用大模型处理图片失败 (尝试%d/%d): %v",
 			attempt+1, maxRetries+1, err)
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
 
 		if attempt == maxRetries {
 			log.Printf("[ERROR] 达到最大重试次数，处理图片失败")
-			return "", fmt.Errorf("AI服务处理失败: %v", err)
+			return result, fmt.Errorf("AI服务处理失败: %v", err)
 		}
 	}
 
-	// 验证返回的JSON格式
-	var jsonResult interface{}
-	if err := json.Unmarshal([]byte(response), &jsonResult); err != nil {
-		log.Printf("[ERROR] 解析AI返回的JSON失败: %v", err)
-		// 记录部分原始响应以便调试
-		if len(response) > 200 {
-			log.Printf("[DEBUG] 原始响应前200字符: %s", response[:200])
-		} else {
-			log.Printf("[DEBUG] 原始响应: %s", response)
+	// 按标准答案判分并计算总得分，并匹配班级名单中的学生
+	opts.score(&result)
+	opts.roster.Match(&result)
+
+	log.Printf("[DEBUG] 成功处理作业图片: %s", result.Name)
+	return result, nil
+}
+
+// readAnswerKey 读取上传请求中的标准答案，可以是answerKey文件字段（JSON、CSV或教师版PDF），
+// 也可以是answerKey文本字段（JSON或CSV）。教师版PDF保存后返回其路径，由调用方在批改前提取，并登记到任务或在不使用时删除
+func readAnswerKey(c *gin.Context) (*models.AnswerKey, string, error) {
+	if file, err := c.FormFile("answerKey"); err == nil {
+		extension := strings.ToLower(filepath.Ext(file.Filename))
+		if extension != ".json" && extension != ".csv" && extension != ".pdf" {
+			return nil, "", fmt.Errorf("标准答案只支持JSON、CSV和PDF格式的文件")
 		}
-		return "", fmt.Errorf("解析AI返回的JSON失败: %v", err)
+
+		keyDir := filepath.Join("uploads", "keys")
+		if err := os.MkdirAll(keyDir, 0755); err != nil {
+			return nil, "", fmt.Errorf("保存标准答案失败: %v", err)
+		}
+		keyPath := filepath.Join(keyDir, uuid.New().String()+extension)
+		if err := c.SaveUploadedFile(file, keyPath); err != nil {
+			return nil, "", fmt.Errorf("保存标准答案失败: %v", err)
+		}
+
+		if extension == ".pdf" {
+			return nil, keyPath, nil
+		}
+		// JSON和CSV在上传时解析，不再保留文件
+		defer os.Remove(keyPath)
+		key, err := services.ParseAnswerKeyFile(keyPath)
+		return key, "", err
 	}
 
-	log.Printf("[DEBUG] 成功处理作业图片，返回结果长度: %d 字符", len(response))
-	return response, nil
+	text := strings.TrimSpace(c.PostForm("answerKey"))
+	if text == "" {
+		return nil, "", nil
+	}
+	if strings.HasPrefix(text, "{") || strings.HasPrefix(text, "[") {
+		key, err := services.ParseAnswerKeyJSON([]byte(text))
+		return key, "", err
+	}
+	key, err := services.ParseAnswerKeyCSV([]byte(text))
+	return key, "", err
 }
 
-// MarkHomework handles homework marking requests
+// MarkHomework 同步批改单张图片或页数较少的PDF，在请求内直接返回结构化的批改结果
+// 超过同步页数上限的PDF直接转为异步任务；批改未能在超时时间内完成时返回202和任务ID，客户端改为通过任务接口查询结果
 func (h *HomeworkHandler) MarkHomework(c *gin.Context) {
 	// 获取文件
 	file, err := c.FormFile("homework")
@@ -698,69 +1241,156 @@ func (h *HomeworkHandler) MarkHomework(c *gin.Context) {
 		return
 	}
 
-	// 获取布局参数（记录但本例中未使用）
-	_ = c.DefaultPostForm("layout", "single")
+	// 获取批改参数，同步接口只按固定页数拆分学生
+	job, err := h.readHomeworkJob(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	job.splitMode = services.SplitModeFixed
 
-	// 获取作业类型（记录但本例中未使用）
-	_ = c.DefaultPostForm("type", "general")
+	// 没有创建任务就返回时删除已保存的教师版标准答案
+	taskCreated := false
+	defer func() {
+		if !taskCreated {
+			job.discardAnswerKey()
+		}
+	}()
 
-	// 打开文件
-	src, err := file.Open()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
+	// 引用了作业时沿用作业的批改设置
+	if status, err := h.bindAssignment(c, &job); err != nil {
+		c.JSON(status, models.APIResponse{
 			Success: false,
-			Error:   "无法打开文件",
+			Error:   err.Error(),
 		})
 		return
 	}
-	defer src.Close()
 
-	// 创建临时文件
-	dst, err := os.CreateTemp("", "homework-*.jpg")
-	if err != nil {
+	// 校验班级并准备按班级名单匹配学生
+	if status, err := h.bindRoster(c, &job); err != nil {
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// 保存文件，按文件内容识别类型，拒绝不支持或损坏的文件
+	job.uploadPath = filepath.Join("uploads", uuid.New().String()+strings.ToLower(filepath.Ext(file.Filename)))
+	if err := c.SaveUploadedFile(file, job.uploadPath); err != nil {
+		log.Printf("[ERROR] 保存文件失败: %v", err)
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
-			Error:   "无法创建临时文件",
+			Error:   "保存文件失败",
 		})
 		return
 	}
-	defer os.Remove(dst.Name())
+	if job.mimeType, err = services.InspectUpload(job.uploadPath); err != nil {
+		log.Printf("[ERROR] 上传文件 %s 无效: %v", file.Filename, err)
+		os.Remove(job.uploadPath)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("%s: %v", file.Filename, err),
+		})
+		return
+	}
+
+	// 页数超过同步上限的PDF不等待结果
+	tooLarge := false
+	if job.mimeType == "application/pdf" {
+		if pageCount, err := api.PageCountFile(job.uploadPath); err == nil && pageCount > h.syncMaxPages {
+			log.Printf("[INFO] PDF共 %d 页，超过同步批改上限 %d 页，转为异步任务", pageCount, h.syncMaxPages)
+			tooLarge = true
+		}
+	}
+
+	// 同步批改同样创建任务，转为异步时客户端使用任务ID查询结果
+	taskID := h.taskQueue.CreateTask("homework_processing", "正在处理文件...")
+	h.taskQueue.SetTaskOwner(taskID, c.GetString("userId"), job.classID)
+	h.taskQueue.SetTaskInfo(taskID, job.uploadPath, job.opts.homeworkType, job.pagesPerStudent, job.layout)
+	h.taskQueue.AddTaskFiles(taskID, job.uploadPath)
+	if job.answerKeyPDF != "" {
+		h.taskQueue.AddTaskFiles(taskID, job.answerKeyPDF)
+	}
+	taskCreated = true
+	if job.assignmentID != "" {
+		h.taskQueue.SetTaskAssignment(taskID, job.assignmentID)
+	}
+
+	// 任务的上下文不随请求结束，超时或客户端断开后批改在后台继续
+	ctx, cancel := context.WithCancel(context.Background())
+	h.taskQueue.RegisterCancelFunc(taskID, cancel)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer h.taskQueue.UnregisterCancelFunc(taskID)
+		h.runHomeworkTask(ctx, taskID, job)
+	}()
+
+	if tooLarge {
+		h.respondMarkingAccepted(c, taskID, fmt.Sprintf("文件超过%d页，已转为异步批改", h.syncMaxPages))
+		return
+	}
+
+	timer := time.NewTimer(h.syncTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Printf("[INFO] 任务 %s 未能在 %v 内完成，转为异步任务", taskID, h.syncTimeout)
+		h.respondMarkingAccepted(c, taskID, fmt.Sprintf("批改未能在%v内完成，已转为异步批改", h.syncTimeout))
+		return
+	case <-c.Request.Context().Done():
+		log.Printf("[INFO] 客户端已断开，任务 %s 在后台继续处理", taskID)
+		return
+	}
 
-	// 复制文件内容
-	if _, err := io.Copy(dst, src); err != nil {
+	task, exists := h.taskQueue.GetTask(taskID)
+	if !exists {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
-			Error:   "无法保存文件",
+			Error:   "任务不存在",
+		})
+		return
+	}
+	if task.Status != services.TaskStatusCompleted {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Error:   task.Error,
+			Data: gin.H{
+				"taskId": taskID,
+				"status": string(task.Status),
+			},
 		})
 		return
 	}
 
-	// 这里简化实现，直接返回成功响应
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Result:  "作业已接收，正在处理中",
+		Data: gin.H{
+			"taskId":  taskID,
+			"status":  string(task.Status),
+			"results": task.Results,
+		},
 	})
 }
 
-// 检查同一学生的文件是否已在处理中
-func (h *HomeworkHandler) isProcessing(studentName string) bool {
-	// 通过获取所有任务来检查是否存在处理中的相同学生名称的任务
-	allTasks := h.getAllTasks()
-	for _, task := range allTasks {
-		// 只检查处理中的任务
-		if task.Status == services.TaskStatusProcessing || task.Status == services.TaskStatusPending {
-			// 检查任务参数中是否包含相同的学生名称
-			if params, ok := task.Params["studentName"]; ok {
-				if studentNameVal, ok := params.(string); ok && studentNameVal == studentName {
-					return true
-				}
-			}
-		}
-	}
-	return false
-}
-
-// 辅助方法：获取所有任务
-func (h *HomeworkHandler) getAllTasks() []*services.HomeworkTask {
-	return h.taskQueue.GetAllTasks()
+// respondMarkingAccepted 同步批改转为异步任务时返回202，Location指向任务状态接口
+func (h *HomeworkHandler) respondMarkingAccepted(c *gin.Context, taskID, message string) {
+	statusURL := "/api/tasks/" + taskID
+	c.Header("Location", statusURL)
+	c.JSON(http.StatusAccepted, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"taskId":    taskID,
+			"status":    string(services.TaskStatusProcessing),
+			"message":   message,
+			"statusUrl": statusURL,
+		},
+	})
 }
@@ -0,0 +1,617 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/GiantClam/homework_marking/services"
+	"github.com/gin-gonic/gin"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// writeTestPDF 生成指定页数的测试PDF，每页一张空白图片
+func writeTestPDF(t *testing.T, path string, pages int) {
+	t.Helper()
+
+	var imageFiles []string
+	for i := 0; i < pages; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, 40, 60))
+		for x := 0; x < 40; x++ {
+			for y := 0; y < 60; y++ {
+				img.Set(x, y, color.White)
+			}
+		}
+
+		imagePath := filepath.Join(t.TempDir(), "page.png")
+		file, err := os.Create(imagePath)
+		if err != nil {
+			t.Fatalf("创建测试图片失败: %v", err)
+		}
+		if err := png.Encode(file, img); err != nil {
+			t.Fatalf("写入测试图片失败: %v", err)
+		}
+		file.Close()
+		imageFiles = append(imageFiles, imagePath)
+	}
+
+	if err := api.ImportImagesFile(imageFiles, path, nil, nil); err != nil {
+		t.Fatalf("生成测试PDF失败: %v", err)
+	}
+}
+
+// chdirTemp 切换到临时目录（上传和拆分都使用相对路径），测试结束后恢复
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	oldDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("获取当前目录失败: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("切换目录失败: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(oldDir) })
+
+	if err := os.MkdirAll("uploads", 0755); err != nil {
+		t.Fatalf("创建上传目录失败: %v", err)
+	}
+	return dir
+}
+
+// newTestRouter 创建使用假大模型的路由，重试等待设为0
+func newTestRouter(t *testing.T, llm services.LLMProvider) (*gin.Engine, *services.TaskQueue) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	oldBackoff := retryBackoff
+	retryBackoff = func(int) time.Duration { return 0 }
+	t.Cleanup(func() { retryBackoff = oldBackoff })
+
+	taskQueue := services.NewTaskQueue(1)
+	homeworkHandler := NewHomeworkHandler(taskQueue, llm, nil, nil, nil, nil)
+	taskHandler := NewTaskHandler(taskQueue, nil)
+
+	router := gin.New()
+	router.POST("/api/homework/upload", homeworkHandler.UploadHomework)
+	router.POST("/api/marking/homework", homeworkHandler.MarkHomework)
+	router.GET("/api/tasks/:taskId", taskHandler.GetTaskStatus)
+	router.DELETE("/api/tasks/:taskId", taskHandler.CancelTask)
+	router.PUT("/api/tasks/:taskId/split", taskHandler.ConfirmStudentSplit)
+	return router, taskQueue
+}
+
+// uploadTestPDF 上传一个指定页数的PDF（每个学生1页），返回任务ID
+func uploadTestPDF(t *testing.T, router *gin.Engine, dir string, pages int) string {
+	t.Helper()
+	return uploadTestPDFWithFields(t, router, dir, pages, nil)
+}
+
+// uploadTestPDFWithFields 上传测试PDF并附带额外的表单字段，返回任务ID
+func uploadTestPDFWithFields(t *testing.T, router *gin.Engine, dir string, pages int, fields map[string]string) string {
+	t.Helper()
+
+	pdfPath := filepath.Join(dir, "homework.pdf")
+	writeTestPDF(t, pdfPath, pages)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("homework", "homework.pdf")
+	content, _ := os.ReadFile(pdfPath)
+	part.Write(content)
+	writer.WriteField("pagesPerStudent", "1")
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/homework/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	var uploadResp struct {
+		Success bool `json:"success"`
+		Data    struct {
+			TaskID string `json:"taskId"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &uploadResp); err != nil || !uploadResp.Success {
+		t.Fatalf("上传失败: %s", resp.Body.String())
+	}
+	return uploadResp.Data.TaskID
+}
+
+// taskStatusResponse 任务状态接口的响应
+type taskStatusResponse struct {
+	Status         string                    `json:"status"`
+	TotalStudents  int                       `json:"total_students"`
+	Processed      int                       `json:"processed"`
+	Results        []models.HomeworkResult   `json:"results"`
+	PartialResults []models.HomeworkResult   `json:"partial_results"`
+	Error          string                    `json:"error"`
+	ProposedSplit  []models.StudentPageRange `json:"proposed_split"`
+}
+
+// getTaskStatus 查询一次任务状态
+func getTaskStatus(t *testing.T, router *gin.Engine, taskID string) taskStatusResponse {
+	t.Helper()
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/tasks/"+taskID, nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("查询任务状态失败: %d %s", resp.Code, resp.Body.String())
+	}
+
+	var status taskStatusResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &status); err != nil {
+		t.Fatalf("解析任务状态失败: %v", err)
+	}
+	return status
+}
+
+// waitForTask 轮询任务状态直到满足条件
+func waitForTask(t *testing.T, router *gin.Engine, taskID string, done func(taskStatusResponse) bool) taskStatusResponse {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		status := getTaskStatus(t, router, taskID)
+		if done(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待任务状态超时，当前状态: %+v", status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestUploadHomeworkReturnsGradingResults 测试上传返回的任务ID能查询到结构化的批改结果，批改失败的学生以错误条目出现
+func TestUploadHomeworkReturnsGradingResults(t *testing.T) {
+	dir := chdirTemp(t)
+
+	llm := services.NewFakeLLMProvider(
+		services.FakeLLMResponse{Match: "student_1.pdf", Text: `{"name":"张三","class":"一班","answers":[{"questionNumber":1,"studentAnswer":"B","isCorrect":"true","correctAnswer":"B","maxPoints":"4","awardedPoints":3}],"overallScore":90,"feedback":"很好"}`},
+		services.FakeLLMResponse{Match: "student_2.pdf", Error: "服务不可用"},
+		// 第一次返回缺少字段的结果，带纠正提示词重试后返回完整结果
+		services.FakeLLMResponse{Match: "student_3.pdf", Text: `{"name":"李四","overallScore":"80"}`, Times: 1},
+		services.FakeLLMResponse{Match: "student_3.pdf", Text: `{"name":"李四","class":"一班","answers":[],"overallScore":"80","feedback":"继续努力"}`},
+	)
+	router, _ := newTestRouter(t, llm)
+	taskID := uploadTestPDF(t, router, dir, 3)
+
+	// 轮询任务状态直到完成
+	status := waitForTask(t, router, taskID, func(s taskStatusResponse) bool {
+		return s.Status != "pending" && s.Status != "processing"
+	})
+
+	if status.Status != "completed" {
+		t.Fatalf("预期任务完成，实际状态: %s, 错误: %s", status.Status, status.Error)
+	}
+	if status.TotalStudents != 3 || status.Processed != 3 {
+		t.Errorf("学生数量错误: total=%d processed=%d", status.TotalStudents, status.Processed)
+	}
+	if len(status.Results) != 3 {
+		t.Fatalf("预期3个学生结果，实际: %+v", status.Results)
+	}
+
+	first := status.Results[0]
+	// 总得分由每题得分计算，不采用模型给出的90分
+	if first.Name != "张三" || first.OverallScore != "75" || first.FullMarks != 100 || first.Status != models.ResultStatusGraded || first.StudentIndex != 0 {
+		t.Errorf("第1个学生结果错误: %+v", first)
+	}
+	if len(first.Answers) != 1 || first.Answers[0].QuestionNumber != "1" || first.Answers[0].IsCorrect == nil || !*first.Answers[0].IsCorrect {
+		t.Errorf("第1个学生的答案解析错误: %+v", first.Answers)
+	}
+	if !strings.HasSuffix(first.PdfURL, "student_1.pdf") {
+		t.Errorf("缺少学生PDF地址: %s", first.PdfURL)
+	}
+
+	if failed := status.Results[1]; failed.Status != models.ResultStatusError || failed.StudentIndex != 1 || !strings.Contains(failed.Error, "服务不可用") {
+		t.Errorf("批改失败的学生应以错误条目出现: %+v", failed)
+	}
+	if third := status.Results[2]; third.Name != "李四" || third.StudentIndex != 2 || third.Status != models.ResultStatusGraded {
+		t.Errorf("第3个学生结果错误或顺序不对: %+v", third)
+	}
+
+	var corrected bool
+	for _, call := range llm.Calls() {
+		if call.FileName == "student_3.pdf" && strings.Contains(call.Prompt, "缺少必填字段") {
+			corrected = true
+		}
+	}
+	if !corrected {
+		t.Error("不符合格式的结果应带校验错误重新请求")
+	}
+}
+
+// TestUploadHomeworkWithAnswerKey 测试上传标准答案后，标准答案写入系统指令，客观题按标准答案判分并按指定满分和取整方式计算总分
+func TestUploadHomeworkWithAnswerKey(t *testing.T) {
+	dir := chdirTemp(t)
+
+	// 模型把第2题判为正确、总分判为100，应以标准答案为准
+	llm := services.NewFakeLLMProvider(services.FakeLLMResponse{
+		Text: `{"name":"张三","class":"一班","answers":[{"questionNumber":"1","studentAnswer":"b","isCorrect":true,"correctAnswer":"B","maxPoints":1,"awardedPoints":1},{"questionNumber":"2","studentAnswer":"C","isCorrect":true,"correctAnswer":"C","maxPoints":1,"awardedPoints":1}],"overallScore":"100","feedback":"很好"}`,
+	})
+	router, _ := newTestRouter(t, llm)
+	taskID := uploadTestPDFWithFields(t, router, dir, 1, map[string]string{
+		"answerKey": "题号,答案,分值\n1,B,3\n2,D,1\n",
+		"fullMarks": "150",
+		"rounding":  "floor",
+		"precision": "0",
+	})
+
+	status := waitForTask(t, router, taskID, func(s taskStatusResponse) bool {
+		return s.Status != "pending" && s.Status != "processing"
+	})
+	if status.Status != "completed" || len(status.Results) != 1 {
+		t.Fatalf("预期任务完成，实际: %+v", status)
+	}
+
+	result := status.Results[0]
+	// 3/4*150=112.5，向下取整
+	if result.OverallScore != "112" || result.FullMarks != 150 {
+		t.Errorf("总得分应按标准答案和满分计算，实际: %s/%v", result.OverallScore, result.FullMarks)
+	}
+	if answer := result.Answers[1]; answer.IsCorrect == nil || *answer.IsCorrect || answer.CorrectAnswer != "D" {
+		t.Errorf("第2题应按标准答案判为错误: %+v", answer)
+	}
+
+	calls := llm.Calls()
+	if len(calls) != 1 || !strings.Contains(calls[0].SystemInstruction, "2: D（1分）") {
+		t.Errorf("系统指令中缺少标准答案: %+v", calls)
+	}
+
+	// 无效的标准答案在上传时拒绝
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("homework", "homework.pdf")
+	part.Write([]byte("%PDF-1.4"))
+	writer.WriteField("answerKey", `[{"questionNumber":"1","answer":""}]`)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/homework/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("无效的标准答案预期400，实际: %d %s", resp.Code, resp.Body.String())
+	}
+}
+
+// uploadWithAnswerKeyPDF 上传作业和教师版标准答案PDF，返回响应
+func uploadWithAnswerKeyPDF(t *testing.T, router *gin.Engine, dir string, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	pdfPath := filepath.Join(dir, "homework.pdf")
+	writeTestPDF(t, pdfPath, 1)
+	content, _ := os.ReadFile(pdfPath)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("homework", "homework.pdf")
+	part.Write(content)
+	part, _ = writer.CreateFormFile("answerKey", "key.pdf")
+	part.Write(content)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/homework/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+// TestAnswerKeyPDFBelongsToTask 测试教师版标准答案PDF登记到任务，上传失败时删除
+func TestAnswerKeyPDFBelongsToTask(t *testing.T) {
+	dir := chdirTemp(t)
+	llm := services.NewFakeLLMProvider(services.FakeLLMResponse{
+		Text: `{"answers":[{"questionNumber":"1","answer":"B"}],"name":"张三","overallScore":"100","feedback":"很好"}`,
+	})
+	router, taskQueue := newTestRouter(t, llm)
+
+	// 引用的作业不存在，没有创建任务
+	if resp := uploadWithAnswerKeyPDF(t, router, dir, map[string]string{"assignmentId": "missing"}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("作业不存在预期400，实际: %d %s", resp.Code, resp.Body.String())
+	}
+	if entries, _ := os.ReadDir(filepath.Join("uploads", "keys")); len(entries) != 0 {
+		t.Errorf("上传失败后不应保留标准答案文件，实际: %d 个", len(entries))
+	}
+
+	resp := uploadWithAnswerKeyPDF(t, router, dir, nil)
+	var uploadResp struct {
+		Data struct {
+			TaskID string `json:"taskId"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &uploadResp); err != nil || uploadResp.Data.TaskID == "" {
+		t.Fatalf("上传失败: %d %s", resp.Code, resp.Body.String())
+	}
+	waitForTask(t, router, uploadResp.Data.TaskID, func(s taskStatusResponse) bool {
+		return s.Status != "pending" && s.Status != "processing"
+	})
+
+	task, _ := taskQueue.GetTask(uploadResp.Data.TaskID)
+	registered := false
+	for _, file := range task.Files {
+		if filepath.Dir(file) == filepath.Join("uploads", "keys") {
+			registered = true
+		}
+	}
+	if !registered {
+		t.Errorf("标准答案文件应登记到任务，实际: %v", task.Files)
+	}
+}
+
+// TestUploadEssayWithRubric 测试作文类型按教师提供的评分标准批改并返回每项得分
+func TestUploadEssayWithRubric(t *testing.T) {
+	dir := chdirTemp(t)
+
+	llm := services.NewFakeLLMProvider(services.FakeLLMResponse{
+		Text: `{"name":"王五","class":"二班","criteria":[{"name":"内容","score":8,"evidence":"我的家乡"},{"name":"书写","score":4,"evidence":"字迹工整"}],"feedback":"很好"}`,
+	})
+	router, _ := newTestRouter(t, llm)
+	taskID := uploadTestPDFWithFields(t, router, dir, 1, map[string]string{
+		"type":   "essay",
+		"rubric": `{"criteria":[{"name":"内容","weight":80,"maxScore":10},{"name":"书写","weight":20,"maxScore":5}]}`,
+	})
+
+	status := waitForTask(t, router, taskID, func(s taskStatusResponse) bool {
+		return s.Status != "pending" && s.Status != "processing"
+	})
+	if status.Status != "completed" || len(status.Results) != 1 {
+		t.Fatalf("预期任务完成，实际: %+v", status)
+	}
+
+	// 8/10×80 + 4/5×20 = 80
+	result := status.Results[0]
+	if result.OverallScore != "80" || len(result.Criteria) != 2 || result.Criteria[1].Evidence != "字迹工整" {
+		t.Errorf("作文批改结果错误: %+v", result)
+	}
+	if calls := llm.Calls(); len(calls) != 1 || !strings.Contains(calls[0].SystemInstruction, "书写（满分5分）") {
+		t.Errorf("系统指令中缺少评分标准: %+v", calls)
+	}
+}
+
+// TestUploadHomeworkWithDetectedStudentSplit 测试自动识别学生分页后等待教师确认，按修改后的分页批改
+func TestUploadHomeworkWithDetectedStudentSplit(t *testing.T) {
+	dir := chdirTemp(t)
+
+	llm := services.NewFakeLLMProvider(
+		services.FakeLLMResponse{Match: "逐页判断", Text: `{"pages":[{"page":1,"kind":"header","name":"张三"},{"page":2,"kind":"continuation"},{"page":3,"kind":"blank"},{"page":4,"kind":"header","name":"李四"}]}`},
+		services.FakeLLMResponse{Text: `{"name":"","class":"","answers":[],"feedback":"很好"}`},
+	)
+	router, _ := newTestRouter(t, llm)
+	taskID := uploadTestPDFWithFields(t, router, dir, 4, map[string]string{"splitMode": "auto"})
+
+	status := waitForTask(t, router, taskID, func(s taskStatusResponse) bool {
+		return s.Status != "pending" && s.Status != "processing"
+	})
+	if status.Status != "awaiting_confirmation" {
+		t.Fatalf("预期等待确认学生分页，实际: %+v", status)
+	}
+	if len(status.ProposedSplit) != 2 || status.ProposedSplit[0].EndPage != 2 || status.ProposedSplit[1].StartPage != 4 || status.ProposedSplit[1].Name != "李四" {
+		t.Fatalf("建议的学生分页错误: %+v", status.ProposedSplit)
+	}
+
+	confirm := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/tasks/"+taskID+"/split", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// 页码重叠的分页被拒绝，任务仍等待确认
+	if resp := confirm(`{"students":[{"startPage":1,"endPage":2},{"startPage":2,"endPage":4}]}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("页码重叠预期400，实际: %d %s", resp.Code, resp.Body.String())
+	}
+
+	// 教师把第1、2页拆成两个学生
+	if resp := confirm(`{"students":[{"startPage":1,"endPage":1},{"startPage":2,"endPage":2},{"startPage":4,"endPage":4}]}`); resp.Code != http.StatusOK {
+		t.Fatalf("确认学生分页失败: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := confirm(""); resp.Code != http.StatusConflict {
+		t.Errorf("重复确认预期409，实际: %d %s", resp.Code, resp.Body.String())
+	}
+
+	status = waitForTask(t, router, taskID, func(s taskStatusResponse) bool {
+		return s.Status == "completed" || s.Status == "failed"
+	})
+	if status.Status != "completed" || len(status.Results) != 3 {
+		t.Fatalf("预期按确认的分页批改3个学生，实际: %+v", status)
+	}
+}
+
+// TestUploadMultipleImagesAsSubmissions 测试一次上传多张图片，按分组合并为每个学生一份PDF后批改
+func TestUploadMultipleImagesAsSubmissions(t *testing.T) {
+	chdirTemp(t)
+
+	llm := services.NewFakeLLMProvider(services.FakeLLMResponse{
+		Text: `{"name":"张三","class":"一班","answers":[],"feedback":"很好"}`,
+	})
+	router, _ := newTestRouter(t, llm)
+
+	var imageData bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 40, 60))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 60; y++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	if err := png.Encode(&imageData, img); err != nil {
+		t.Fatalf("生成测试图片失败: %v", err)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, name := range []string{"p1.png", "p2.png", "p3.png"} {
+		part, _ := writer.CreateFormFile("homework", name)
+		part.Write(imageData.Bytes())
+	}
+	writer.WriteField("group", "a,a,b")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/homework/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	var uploadResp struct {
+		Data struct {
+			TaskID string `json:"taskId"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &uploadResp); err != nil || uploadResp.Data.TaskID == "" {
+		t.Fatalf("上传失败: %s", resp.Body.String())
+	}
+
+	status := waitForTask(t, router, uploadResp.Data.TaskID, func(s taskStatusResponse) bool {
+		return s.Status != "pending" && s.Status != "processing"
+	})
+	if status.Status != "completed" || len(status.Results) != 2 {
+		t.Fatalf("预期2个学生的结果，实际: %+v", status)
+	}
+
+	// 第1个学生的两张图片合并为一份2页的PDF
+	first, err := api.PageCountFile(filepath.Join("uploads", "split", status.Results[0].PdfURL))
+	if err != nil || first != 2 {
+		t.Errorf("第1个学生的PDF应有2页，实际: %d, %v", first, err)
+	}
+	if calls := llm.Calls(); len(calls) != 2 {
+		t.Errorf("预期批改2次，实际: %d", len(calls))
+	}
+}
+
+// markTestPDF 调用同步批改接口批改一个指定页数的PDF（每个学生1页）
+func markTestPDF(t *testing.T, router *gin.Engine, pages int) (int, models.APIResponse) {
+	t.Helper()
+
+	// ImportImagesFile会追加到已存在的PDF，每次使用新的目录
+	pdfPath := filepath.Join(t.TempDir(), "homework.pdf")
+	writeTestPDF(t, pdfPath, pages)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("homework", "homework.pdf")
+	content, _ := os.ReadFile(pdfPath)
+	part.Write(content)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/marking/homework", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	var markResp models.APIResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &markResp); err != nil {
+		t.Fatalf("解析批改结果失败: %s", resp.Body.String())
+	}
+	return resp.Code, markResp
+}
+
+// TestMarkHomeworkReturnsResultInline 测试同步批改接口直接返回批改结果
+func TestMarkHomeworkReturnsResultInline(t *testing.T) {
+	chdirTemp(t)
+
+	llm := services.NewFakeLLMProvider(
+		services.FakeLLMResponse{Text: `{"name":"张三","class":"一班","answers":[],"overallScore":"95","feedback":"很好"}`},
+	)
+	router, _ := newTestRouter(t, llm)
+
+	code, resp := markTestPDF(t, router, 1)
+	if code != http.StatusOK || !resp.Success {
+		t.Fatalf("预期同步返回结果，实际: %d %+v", code, resp)
+	}
+	data := resp.Data.(map[string]interface{})
+	results, _ := data["results"].([]interface{})
+	if data["status"] != "completed" || data["taskId"] == "" || len(results) != 1 || results[0].(map[string]interface{})["name"] != "张三" {
+		t.Errorf("批改结果错误: %+v", data)
+	}
+}
+
+// TestMarkHomeworkFallsBackToTask 测试超时或页数过多时返回202和任务ID，批改在后台继续完成
+func TestMarkHomeworkFallsBackToTask(t *testing.T) {
+	chdirTemp(t)
+	t.Setenv("MARKING_SYNC_TIMEOUT", "50ms")
+	t.Setenv("MARKING_SYNC_MAX_PAGES", "2")
+
+	llm := services.NewFakeLLMProvider(
+		services.FakeLLMResponse{Text: `{"name":"张三","class":"","answers":[],"overallScore":"100","feedback":""}`, DelayMs: 300},
+	)
+	router, _ := newTestRouter(t, llm)
+
+	for _, pages := range []int{1, 3} {
+		code, resp := markTestPDF(t, router, pages)
+		if code != http.StatusAccepted || !resp.Success {
+			t.Fatalf("%d页预期返回202，实际: %d %+v", pages, code, resp)
+		}
+		taskID, _ := resp.Data.(map[string]interface{})["taskId"].(string)
+		if taskID == "" {
+			t.Fatalf("202响应缺少任务ID: %+v", resp)
+		}
+
+		status := waitForTask(t, router, taskID, func(s taskStatusResponse) bool { return s.Status == "completed" })
+		if len(status.Results) != pages {
+			t.Errorf("%d页预期%d个学生结果，实际: %d", pages, pages, len(status.Results))
+		}
+	}
+}
+
+// TestCancelTaskKeepsCompletedResults 测试取消任务会停止未完成的批改并保留已完成的结果
+func TestCancelTaskKeepsCompletedResults(t *testing.T) {
+	dir := chdirTemp(t)
+
+	llm := services.NewFakeLLMProvider(
+		services.FakeLLMResponse{Match: "student_1.pdf", Text: `{"name":"张三","class":"","answers":[],"overallScore":"100","feedback":""}`},
+		services.FakeLLMResponse{Match: "student_2.pdf", Text: `{"name":"李四","class":"","answers":[],"overallScore":"100","feedback":""}`, DelayMs: 60000},
+	)
+	router, taskQueue := newTestRouter(t, llm)
+	taskID := uploadTestPDF(t, router, dir, 2)
+
+	// 等待第一个学生批改完成
+	waitForTask(t, router, taskID, func(s taskStatusResponse) bool { return s.Processed >= 1 })
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, "/api/tasks/"+taskID, nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("取消任务失败: %d %s", resp.Code, resp.Body.String())
+	}
+
+	status := getTaskStatus(t, router, taskID)
+	if status.Status != "cancelled" {
+		t.Fatalf("预期任务已取消，实际状态: %s", status.Status)
+	}
+	if len(status.PartialResults) != 1 || status.PartialResults[0].Name != "张三" {
+		t.Errorf("已完成的结果未保留: %v", status.PartialResults)
+	}
+
+	// 再次取消已结束的任务返回冲突
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, "/api/tasks/"+taskID, nil))
+	if resp.Code != http.StatusConflict {
+		t.Errorf("重复取消预期409，实际: %d", resp.Code)
+	}
+
+	// 正在进行的大模型调用应随上下文停止，且不会把任务改回其他状态
+	time.Sleep(100 * time.Millisecond)
+	if task, _ := taskQueue.GetTask(taskID); task.Status != services.TaskStatusCancelled || task.FailedCount != 0 {
+		t.Errorf("取消后任务状态被修改: %s, 失败数: %d", task.Status, task.FailedCount)
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/GiantClam/homework_marking/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TestReviewResultRecomputesScore 测试教师复核后按上传时的计分方式重新计算总得分，任务结果返回复核后的版本
+func TestReviewResultRecomputesScore(t *testing.T) {
+	dir := chdirTemp(t)
+
+	llm := services.NewFakeLLMProvider(services.FakeLLMResponse{
+		Text: `{"name":"张三","class":"一班","answers":[{"questionNumber":"1","studentAnswer":"B","isCorrect":true,"correctAnswer":"B","maxPoints":1,"awardedPoints":1},{"questionNumber":"2","studentAnswer":"C","isCorrect":false,"correctAnswer":"D","maxPoints":1,"awardedPoints":0}],"overallScore":"50","feedback":"很好"}`,
+	})
+	router, taskQueue := newTestRouter(t, llm)
+	router.PUT("/api/tasks/:taskId/results/:studentIndex/review", NewTaskHandler(taskQueue, nil).ReviewResult)
+
+	// 第2题按标准答案判为错误：3/4*150=112.5，向下取整
+	taskID := uploadTestPDFWithFields(t, router, dir, 1, map[string]string{
+		"answerKey": "1,B,3\n2,D,1\n",
+		"fullMarks": "150",
+		"rounding":  "floor",
+		"precision": "0",
+	})
+	status := waitForTask(t, router, taskID, func(s taskStatusResponse) bool {
+		return s.Status != "pending" && s.Status != "processing"
+	})
+	if status.Status != "completed" || status.Results[0].OverallScore != "112" {
+		t.Fatalf("任务未按预期完成: %+v", status)
+	}
+
+	path := "/api/tasks/" + taskID + "/results/0/review"
+	if resp := sendJSON(router, http.MethodPut, path, "", gin.H{"answers": []gin.H{{"questionNumber": "9", "action": "reject"}}}); resp.Code != http.StatusBadRequest {
+		t.Errorf("不存在的题号预期400，实际: %d", resp.Code)
+	}
+	resp := sendJSON(router, http.MethodPut, path, "", gin.H{
+		"answers":  []gin.H{{"questionNumber": "2", "action": "reject"}},
+		"feedback": "第2题的题目印刷有误，按正确处理",
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("复核失败: %d %s", resp.Code, resp.Body.String())
+	}
+
+	result := getTaskStatus(t, router, taskID).Results[0]
+	if result.Status != models.ResultStatusReviewed || result.OverallScore != "150" || result.Review == nil || result.Review.OriginalScore != "112" {
+		t.Errorf("任务结果应为复核后的版本: %+v", result)
+	}
+	if answer := result.Answers[1]; answer.Review == nil || answer.Review.Action != models.ReviewActionReject || *answer.Review.Original.IsCorrect {
+		t.Errorf("第2题应记录复核和原始的判定: %+v", answer)
+	}
+	if resp := sendJSON(router, http.MethodPut, "/api/tasks/"+taskID+"/results/5/review", "", gin.H{}); resp.Code != http.StatusNotFound {
+		t.Errorf("不存在的学生结果预期404，实际: %d", resp.Code)
+	}
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/GiantClam/homework_marking/middleware"
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/GiantClam/homework_marking/services"
+	"github.com/GiantClam/homework_marking/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// maxRosterFileSize CSV名单文件的大小上限
+const maxRosterFileSize = 1 << 20
+
+// RosterHandler 处理班级和学生名单的请求
+type RosterHandler struct {
+	rosters *services.Rosters
+}
+
+// NewRosterHandler 创建班级名单处理器
+func NewRosterHandler(rosters *services.Rosters) *RosterHandler {
+	return &RosterHandler{
+		rosters: rosters,
+	}
+}
+
+// classSummary 班级列表中的班级及其学生人数
+type classSummary struct {
+	models.Class
+	StudentCount int `json:"studentCount"`
+}
+
+// ListClasses 列出当前用户可以查看的班级
+func (h *RosterHandler) ListClasses(c *gin.Context) {
+	classes := h.rosters.ListClasses(middleware.CurrentPrincipal(c).CanReadClass)
+
+	summaries := make([]classSummary, 0, len(classes))
+	for _, class := range classes {
+		summaries = append(summaries, classSummary{
+			Class:        class,
+			StudentCount: len(h.rosters.ListStudents(class.ID)),
+		})
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    summaries,
+	})
+}
+
+// CreateClass 创建班级，由当前教师管理；管理员可以通过teacherId指定管理的教师
+// 请求体为 {"name":"三年级二班","grade":"三年级"}
+func (h *RosterHandler) CreateClass(c *gin.Context) {
+	var req struct {
+		Name      string `json:"name" binding:"required"`
+		Grade     string `json:"grade"`
+		TeacherID string `json:"teacherId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请提供班级名称")
+		return
+	}
+
+	teacherID := c.GetString("userId")
+	if req.TeacherID != "" && req.TeacherID != teacherID {
+		if c.GetString("role") != models.RoleAdmin {
+			utils.RespondWithError(c, http.StatusForbidden, "只有管理员可以为其他教师创建班级")
+			return
+		}
+		teacherID = req.TeacherID
+	}
+
+	class, err := h.rosters.CreateClass(req.Name, req.Grade, teacherID)
+	if err != nil {
+		respondRosterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    class,
+	})
+}
+
+// GetClass 返回班级及其学生名单
+func (h *RosterHandler) GetClass(c *gin.Context) {
+	class, ok := h.authorizedClass(c, false)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"class":    class,
+			"students": h.rosters.ListStudents(class.ID),
+		},
+	})
+}
+
+// UpdateClass 修改班级的名称和年级，管理员还可以通过teacherId转交给其他教师
+func (h *RosterHandler) UpdateClass(c *gin.Context) {
+	class, ok := h.authorizedClass(c, true)
+	if !ok {
+		return
+	}
+
+	var update services.ClassUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请求格式错误: "+err.Error())
+		return
+	}
+	if update.TeacherID != nil && *update.TeacherID != class.TeacherID && c.GetString("role") != models.RoleAdmin {
+		utils.RespondWithError(c, http.StatusForbidden, "只有管理员可以转交班级")
+		return
+	}
+
+	class, err := h.rosters.UpdateClass(class.ID, update)
+	if err != nil {
+		respondRosterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    class,
+	})
+}
+
+// DeleteClass 删除班级及其学生名单，已完成的批改结果不受影响
+func (h *RosterHandler) DeleteClass(c *gin.Context) {
+	class, ok := h.authorizedClass(c, true)
+	if !ok {
+		return
+	}
+
+	if err := h.rosters.DeleteClass(class.ID); err != nil {
+		respondRosterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Result:  "班级已删除",
+	})
+}
+
+// ListStudents 列出班级名单中的学生
+func (h *RosterHandler) ListStudents(c *gin.Context) {
+	class, ok := h.authorizedClass(c, false)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    h.rosters.ListStudents(class.ID),
+	})
+}
+
+// AddStudent 向班级名单添加学生，请求体为 {"name":"张三","studentNumber":"S001"}
+func (h *RosterHandler) AddStudent(c *gin.Context) {
+	class, ok := h.authorizedClass(c, true)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name          string `json:"name" binding:"required"`
+		StudentNumber string `json:"studentNumber"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请提供学生姓名")
+		return
+	}
+
+	student, err := h.rosters.AddStudent(class.ID, req.Name, req.StudentNumber)
+	if err != nil {
+		respondRosterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Success: true,
+		Data:    student,
+	})
+}
+
+// UpdateStudent 修改学生的姓名或学号
+func (h *RosterHandler) UpdateStudent(c *gin.Context) {
+	class, ok := h.authorizedClass(c, true)
+	if !ok {
+		return
+	}
+
+	var update services.StudentUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请求格式错误: "+err.Error())
+		return
+	}
+
+	student, err := h.rosters.UpdateStudent(class.ID, c.Param("studentId"), update)
+	if err != nil {
+		respondRosterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    student,
+	})
+}
+
+// DeleteStudent 从班级名单中删除学生
+func (h *RosterHandler) DeleteStudent(c *gin.Context) {
+	class, ok := h.authorizedClass(c, true)
+	if !ok {
+		return
+	}
+
+	if err := h.rosters.DeleteStudent(class.ID, c.Param("studentId")); err != nil {
+		respondRosterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Result:  "学生已删除",
+	})
+}
+
+// ImportStudents 从CSV导入班级名单，可以上传file文件字段，也可以直接以text/csv作为请求体
+// CSV的列为姓名和学号，已有学生按学号（没有学号时按姓名）更新，其他学生新增
+func (h *RosterHandler) ImportStudents(c *gin.Context) {
+	class, ok := h.authorizedClass(c, true)
+	if !ok {
+		return
+	}
+
+	var reader io.Reader = io.LimitReader(c.Request.Body, maxRosterFileSize)
+	if file, err := c.FormFile("file"); err == nil {
+		if file.Size > maxRosterFileSize {
+			utils.RespondWithError(c, http.StatusBadRequest, "名单文件不能超过1MB")
+			return
+		}
+		opened, err := file.Open()
+		if err != nil {
+			utils.RespondWithError(c, http.StatusBadRequest, "读取名单文件失败")
+			return
+		}
+		defer opened.Close()
+		reader = opened
+	}
+
+	result, err := h.rosters.ImportStudents(class.ID, reader)
+	if err != nil {
+		respondRosterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    result,
+	})
+}
+
+// authorizedClass 读取路径中的班级并检查权限，无权查看时返回404，可以查看但无权修改时返回403
+func (h *RosterHandler) authorizedClass(c *gin.Context, manage bool) (models.Class, bool) {
+	principal := middleware.CurrentPrincipal(c)
+	class, err := h.rosters.GetClass(c.Param("classId"))
+	if err != nil || !principal.CanReadClass(class) {
+		utils.RespondWithError(c, http.StatusNotFound, services.ErrClassNotFound.Error())
+		return class, false
+	}
+	if manage && !principal.CanManageClass(class) {
+		utils.RespondWithError(c, http.StatusForbidden, "没有权限修改该班级")
+		return class, false
+	}
+	return class, true
+}
+
+// respondRosterError 根据名单操作的错误类型返回对应的状态码
+func respondRosterError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrClassNotFound), errors.Is(err, services.ErrStudentNotFound):
+		utils.RespondWithError(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrStudentNumberTaken):
+		utils.RespondWithError(c, http.StatusConflict, err.Error())
+	default:
+		utils.RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/GiantClam/homework_marking/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TestUploadMatchesRoster 测试批改结果按班级名单匹配学生，未匹配的结果由教师手动指定
+func TestUploadMatchesRoster(t *testing.T) {
+	dir := chdirTemp(t)
+
+	rosters, _ := services.NewRosters(nil)
+	class, _ := rosters.CreateClass("三年级二班", "三年级", "teacher-1")
+	otherClass, _ := rosters.CreateClass("三年级一班", "三年级", "teacher-2")
+	rosters.AddStudent(class.ID, "张三", "S001")
+	rosters.AddStudent(class.ID, "李思思", "S002")
+	wang, _ := rosters.AddStudent(class.ID, "王五", "S003")
+
+	llm := services.NewFakeLLMProvider(
+		services.FakeLLMResponse{Match: "student_1.pdf", Text: `{"name":"张 三","class":"3年级2班","answers":[],"feedback":"很好"}`},
+		services.FakeLLMResponse{Match: "student_2.pdf", Text: `{"name":"李思","class":"三年级二班","answers":[],"feedback":"很好"}`},
+		services.FakeLLMResponse{Match: "student_3.pdf", Text: `{"name":"","class":"","answers":[],"feedback":"姓名无法辨认"}`},
+	)
+	// newTestRouter 将重试等待设为0，这里另建带班级名单和登录用户的路由
+	newTestRouter(t, llm)
+	taskQueue := services.NewTaskQueue(1)
+	homeworkHandler := NewHomeworkHandler(taskQueue, llm, nil, nil, rosters, nil)
+	taskHandler := NewTaskHandler(taskQueue, rosters)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("userId", "teacher-1")
+		c.Set("role", models.RoleTeacher)
+		c.Next()
+	})
+	router.POST("/api/homework/upload", homeworkHandler.UploadHomework)
+	router.GET("/api/tasks/:taskId", taskHandler.GetTaskStatus)
+	router.PUT("/api/tasks/:taskId/results/:studentIndex/student", taskHandler.AssignResultStudent)
+
+	// 只能上传自己管理的已有班级
+	if resp := uploadTestImage(t, router, "missing"); resp.Code != http.StatusBadRequest {
+		t.Errorf("不存在的班级预期400，实际: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := uploadTestImage(t, router, otherClass.ID); resp.Code != http.StatusForbidden {
+		t.Errorf("其他教师的班级预期403，实际: %d %s", resp.Code, resp.Body.String())
+	}
+
+	taskID := uploadTestPDFWithFields(t, router, dir, 3, map[string]string{"classId": class.ID})
+	status := waitForTask(t, router, taskID, func(s taskStatusResponse) bool {
+		return s.Status != "pending" && s.Status != "processing"
+	})
+	if status.Status != "completed" || len(status.Results) != 3 {
+		t.Fatalf("任务未完成: %+v", status)
+	}
+
+	expected := []struct {
+		status string
+		name   string
+	}{
+		{models.RosterMatchExact, "张三"},
+		{models.RosterMatchFuzzy, "李思思"},
+		{models.RosterMatchUnmatched, ""},
+	}
+	for i, want := range expected {
+		match := status.Results[i].RosterMatch
+		if match == nil || match.Status != want.status || match.StudentName != want.name {
+			t.Errorf("第%d个学生的匹配结果错误: %+v", i+1, match)
+		}
+	}
+
+	// 教师为未匹配的结果指定学生
+	path := "/api/tasks/" + taskID + "/results/2/student"
+	if resp := sendJSON(router, http.MethodPut, path, "", gin.H{"studentId": "missing"}); resp.Code != http.StatusBadRequest {
+		t.Errorf("不存在的学生预期400，实际: %d", resp.Code)
+	}
+	if resp := sendJSON(router, http.MethodPut, path, "", gin.H{"studentId": wang.ID}); resp.Code != http.StatusOK {
+		t.Fatalf("指定学生失败: %d %s", resp.Code, resp.Body.String())
+	}
+	result := getTaskStatus(t, router, taskID).Results[2]
+	if result.Name != "王五" || result.RosterMatch == nil || result.RosterMatch.Status != models.RosterMatchManual || result.RosterMatch.StudentID != wang.ID {
+		t.Errorf("指定学生后的结果错误: %+v", result)
+	}
+	if resp := sendJSON(router, http.MethodPut, "/api/tasks/"+taskID+"/results/9/student", "", gin.H{"studentId": wang.ID}); resp.Code != http.StatusNotFound {
+		t.Errorf("不存在的学生结果预期404，实际: %d", resp.Code)
+	}
+}
+
+// uploadTestImage 上传一张作业图片并指定班级，返回响应
+func uploadTestImage(t *testing.T, router *gin.Engine, classID string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("homework", "homework.png")
+	if err := png.Encode(part, image.NewGray(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatalf("生成测试图片失败: %v", err)
+	}
+	writer.WriteField("classId", classID)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/homework/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
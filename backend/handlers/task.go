@@ -1,24 +1,45 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"io"
 	"math"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/GiantClam/homework_marking/middleware"
+	"github.com/GiantClam/homework_marking/models"
 	"github.com/GiantClam/homework_marking/services"
 	"github.com/GiantClam/homework_marking/utils"
 )
 
 // TaskHandler 处理任务相关请求
 type TaskHandler struct {
-	taskQueue *services.TaskQueue
+	taskQueue       *services.TaskQueue
+	rosters         *services.Rosters // 班级名单，用于教师为批改结果指定学生
+	reviewThreshold float64           // 把握低于该值的判定进入复核队列
 }
 
 // NewTaskHandler 创建任务处理器
-func NewTaskHandler(taskQueue *services.TaskQueue) *TaskHandler {
+func NewTaskHandler(taskQueue *services.TaskQueue, rosters *services.Rosters) *TaskHandler {
+	reviewThreshold := services.DefaultReviewConfidenceThreshold
+	if value := os.Getenv("REVIEW_CONFIDENCE_THRESHOLD"); value != "" {
+		if threshold, err := strconv.ParseFloat(value, 64); err == nil && threshold >= 0 && threshold <= 1 {
+			reviewThreshold = threshold
+		} else {
+			log.Printf("[WARN] REVIEW_CONFIDENCE_THRESHOLD 格式错误: %s，使用默认值 %v", value, services.DefaultReviewConfidenceThreshold)
+		}
+	}
+
 	return &TaskHandler{
-		taskQueue: taskQueue,
+		taskQueue:       taskQueue,
+		rosters:         rosters,
+		reviewThreshold: reviewThreshold,
 	}
 }
 
@@ -32,9 +53,8 @@ func (h *TaskHandler) GetTaskStatus(c *gin.Context) {
 
 	log.Printf("查询任务状态: %s", taskID)
 
-	task, exists := h.taskQueue.GetTask(taskID)
-	if !exists {
-		utils.RespondWithError(c, http.StatusNotFound, "任务不存在")
+	task, ok := h.authorizedTask(c, taskID, false)
+	if !ok {
 		return
 	}
 
@@ -57,14 +77,25 @@ func (h *TaskHandler) GetTaskStatus(c *gin.Context) {
 		// 返回任务进度
 		c.JSON(http.StatusOK, gin.H{
 			"status":         string(task.Status),
-			"message":        "任务正在处理中",
+			"message":        taskMessage(task, "任务正在处理中"),
 			"task_id":        task.ID,
 			"total_students": task.TotalStudents,
 			"processed":      task.ProcessedCount,
+			"failed":         task.FailedCount,
 			"progress":       progress, // 使用安全计算的进度值
 			"start_time":     task.StartTime,
 			// 如果有部分结果，可以返回已处理的结果
-			"partial_results": task.Results,
+			"partial_results": partialResults(task),
+		})
+	case services.TaskStatusAwaitingConfirmation:
+		// 返回建议的学生分页，等待教师确认
+		c.JSON(http.StatusOK, gin.H{
+			"status":         string(task.Status),
+			"message":        taskMessage(task, "请确认学生分页"),
+			"task_id":        task.ID,
+			"page_count":     task.PageCount,
+			"proposed_split": task.ProposedSplit,
+			"start_time":     task.StartTime,
 		})
 	case services.TaskStatusCompleted:
 		// 返回完整结果
@@ -76,6 +107,7 @@ func (h *TaskHandler) GetTaskStatus(c *gin.Context) {
 			"end_time": task.EndTime,
 			"total_students": task.TotalStudents,
 			"processed":      task.ProcessedCount,
+			"failed":         task.FailedCount,
 			"progress":       1.0, // 已完成的任务进度始终为100%
 		})
 	case services.TaskStatusFailed:
@@ -89,19 +121,441 @@ func (h *TaskHandler) GetTaskStatus(c *gin.Context) {
 			"progress": 0.0, // 失败的任务进度设为0
 			"is_error": true,
 		})
+	case services.TaskStatusInterrupted:
+		// 服务重启导致任务中断
+		c.JSON(http.StatusOK, gin.H{
+			"status":   "interrupted",
+			"message":  "任务因服务重启被中断",
+			"task_id":  task.ID,
+			"error":    task.Error,
+			"end_time": task.EndTime,
+			"total_students": task.TotalStudents,
+			"processed":      task.ProcessedCount,
+			"failed":         task.FailedCount,
+			"partial_results": partialResults(task),
+			"is_error": true,
+		})
+	case services.TaskStatusCancelled:
+		// 用户取消的任务，返回取消前已完成的结果
+		c.JSON(http.StatusOK, gin.H{
+			"status":   "cancelled",
+			"message":  "任务已取消",
+			"task_id":  task.ID,
+			"end_time": task.EndTime,
+			"total_students": task.TotalStudents,
+			"processed":      task.ProcessedCount,
+			"failed":         task.FailedCount,
+			"partial_results": partialResults(task),
+		})
 	default:
 		utils.RespondWithError(c, http.StatusInternalServerError, "未知任务状态")
 	}
 }
 
-// GetAllTasks 获取所有任务
+// CancelTask 取消待处理或处理中的任务
+func (h *TaskHandler) CancelTask(c *gin.Context) {
+	taskID := c.Param("taskId")
+	if taskID == "" {
+		utils.RespondWithError(c, http.StatusBadRequest, "任务ID不能为空")
+		return
+	}
+
+	log.Printf("取消任务: %s", taskID)
+
+	if _, ok := h.authorizedTask(c, taskID, true); !ok {
+		return
+	}
+	if err := h.taskQueue.CancelTask(taskID); err != nil {
+		switch {
+		case errors.Is(err, services.ErrTaskNotFound):
+			utils.RespondWithError(c, http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrTaskNotCancellable):
+			utils.RespondWithError(c, http.StatusConflict, err.Error())
+		default:
+			utils.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "cancelled",
+		"message": "任务已取消",
+		"task_id": taskID,
+	})
+}
+
+// GetStudentSplit 获取建议或已确认的学生分页
+func (h *TaskHandler) GetStudentSplit(c *gin.Context) {
+	task, ok := h.authorizedTask(c, c.Param("taskId"), false)
+	if !ok {
+		return
+	}
+	if task.ProposedSplit == nil {
+		utils.RespondWithError(c, http.StatusNotFound, "任务没有学生分页")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     string(task.Status),
+		"task_id":    task.ID,
+		"page_count": task.PageCount,
+		"students":   task.ProposedSplit,
+	})
+}
+
+// ConfirmStudentSplit 确认或修改建议的学生分页，确认后开始批改
+// 请求体为 {"students":[{"startPage":1,"endPage":2,"name":"张三"}]}，students为空时采用建议的分页
+func (h *TaskHandler) ConfirmStudentSplit(c *gin.Context) {
+	taskID := c.Param("taskId")
+	if _, ok := h.authorizedTask(c, taskID, true); !ok {
+		return
+	}
+
+	var req struct {
+		Students []models.StudentPageRange `json:"students"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.RespondWithError(c, http.StatusBadRequest, "学生分页格式错误: "+err.Error())
+			return
+		}
+	}
+
+	ranges, err := h.taskQueue.ConfirmSplit(taskID, req.Students)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTaskNotFound):
+			utils.RespondWithError(c, http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrTaskNotAwaitingConfirmation):
+			utils.RespondWithError(c, http.StatusConflict, err.Error())
+		default:
+			utils.RespondWithError(c, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   string(services.TaskStatusProcessing),
+		"message":  "学生分页已确认，开始批改",
+		"task_id":  taskID,
+		"students": ranges,
+	})
+}
+
+// sseHeartbeatInterval SSE连接的心跳间隔，避免代理因空闲断开连接
+var sseHeartbeatInterval = 15 * time.Second
+
+// StreamTaskEvents 以服务端事件流(SSE)推送任务进度
+// 连接后先推送当前状态和已完成的学生结果，之后推送状态变化、进度和每个学生的结果，任务结束时推送done事件并关闭连接
+func (h *TaskHandler) StreamTaskEvents(c *gin.Context) {
+	taskID := c.Param("taskId")
+	if taskID == "" {
+		utils.RespondWithError(c, http.StatusBadRequest, "任务ID不能为空")
+		return
+	}
+
+	// 先校验权限，无权查看的请求不登记订阅
+	if _, ok := h.authorizedTask(c, taskID, false); !ok {
+		return
+	}
+
+	// 先订阅再读取快照，避免遗漏两者之间产生的事件
+	events, unsubscribe, err := h.taskQueue.Subscribe(taskID)
+	if err != nil {
+		utils.RespondWithError(c, http.StatusNotFound, err.Error())
+		return
+	}
+	defer unsubscribe()
+
+	task, exists := h.taskQueue.GetTask(taskID)
+	if !exists {
+		utils.RespondWithError(c, http.StatusNotFound, "任务不存在")
+		return
+	}
+
+	log.Printf("订阅任务事件: %s", taskID)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	// 推送当前状态和已经完成的学生结果
+	c.SSEvent(services.TaskEventStatus, gin.H{
+		"type":           services.TaskEventStatus,
+		"task_id":        task.ID,
+		"status":         task.Status,
+		"message":        task.Message,
+		"total_students": task.TotalStudents,
+		"processed":      task.ProcessedCount,
+		"failed":         task.FailedCount,
+	})
+	for i, result := range task.StudentResults {
+		if result != nil {
+			c.SSEvent(services.TaskEventStudentResult, gin.H{
+				"type":          services.TaskEventStudentResult,
+				"task_id":       task.ID,
+				"student_index": i,
+				"result":        result,
+			})
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// 任务已结束，推送最终状态
+				h.sendDoneEvent(c, taskID)
+				return false
+			}
+			c.SSEvent(event.Type, event)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("ping", gin.H{"time": time.Now().Unix()})
+			return true
+		case <-c.Request.Context().Done():
+			log.Printf("任务事件订阅已断开: %s", taskID)
+			return false
+		}
+	})
+}
+
+// sendDoneEvent 推送任务结束事件，包含最终状态和结果
+func (h *TaskHandler) sendDoneEvent(c *gin.Context, taskID string) {
+	task, exists := h.taskQueue.GetTask(taskID)
+	if !exists {
+		return
+	}
+
+	c.SSEvent(services.TaskEventDone, gin.H{
+		"type":            services.TaskEventDone,
+		"task_id":         task.ID,
+		"status":          task.Status,
+		"message":         task.Message,
+		"error":           task.Error,
+		"total_students":  task.TotalStudents,
+		"processed":       task.ProcessedCount,
+		"failed":          task.FailedCount,
+		"results":         task.Results,
+		"partial_results": partialResults(task),
+		"end_time":        task.EndTime,
+	})
+}
+
+// AssignResultStudent 教师为批改结果指定班级名单中的学生，用于处理未匹配或匹配错误的结果
+// 请求体为 {"studentId":"..."}，studentId为空时清除匹配，结果标记为未匹配；没有启用班级名单时指定学生返回503
+func (h *TaskHandler) AssignResultStudent(c *gin.Context) {
+	task, ok := h.authorizedTask(c, c.Param("taskId"), true)
+	if !ok {
+		return
+	}
+	studentIndex, err := strconv.Atoi(c.Param("studentIndex"))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "学生序号无效")
+		return
+	}
+
+	var req struct {
+		StudentID string `json:"studentId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请求格式错误: "+err.Error())
+		return
+	}
+
+	match := &models.RosterMatch{Status: models.RosterMatchUnmatched}
+	if req.StudentID != "" {
+		// 没有配置班级名单存储时无法查找学生，只能清除匹配
+		if h.rosters == nil {
+			utils.RespondWithError(c, http.StatusServiceUnavailable, "服务没有启用班级名单，不能指定学生")
+			return
+		}
+		student, err := h.rosters.GetStudent(req.StudentID)
+		if err != nil {
+			utils.RespondWithError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		class, err := h.rosters.GetClass(student.ClassID)
+		if err != nil || !middleware.CurrentPrincipal(c).CanManageClass(class) {
+			utils.RespondWithError(c, http.StatusBadRequest, services.ErrStudentNotFound.Error())
+			return
+		}
+		if task.ClassID != "" && student.ClassID != task.ClassID {
+			utils.RespondWithError(c, http.StatusBadRequest, "学生不在该任务的班级中")
+			return
+		}
+		match = services.NewManualRosterMatch(student)
+	}
+
+	result, err := h.taskQueue.UpdateStudentResult(task.ID, studentIndex, func(result *models.HomeworkResult) error {
+		if result.Status == models.ResultStatusError {
+			return fmt.Errorf("批改失败的结果不能指定学生")
+		}
+		result.RosterMatch = match
+		if match.StudentName != "" {
+			result.Name = match.StudentName
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrStudentResultNotFound) {
+			utils.RespondWithError(c, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(c, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    result,
+	})
+}
+
+// ReviewResult 教师复核学生的批改结果：认可、否定或修改单题的判定、得分和评语，也可以修改整体评价
+// 请求体为 {"answers":[{"questionNumber":"2","action":"reject"},{"questionNumber":"3","action":"edit","awardedPoints":1.5}],"feedback":"..."}
+// 复核后按上传时的计分方式重新计算总得分，结果标记为reviewed，任务结果和成绩对比都使用复核后的版本
+func (h *TaskHandler) ReviewResult(c *gin.Context) {
+	task, ok := h.authorizedTask(c, c.Param("taskId"), true)
+	if !ok {
+		return
+	}
+	studentIndex, err := strconv.Atoi(c.Param("studentIndex"))
+	if err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "学生序号无效")
+		return
+	}
+
+	var override services.ResultOverride
+	if err := c.ShouldBindJSON(&override); err != nil {
+		utils.RespondWithError(c, http.StatusBadRequest, "请求格式错误: "+err.Error())
+		return
+	}
+
+	result, err := h.taskQueue.UpdateStudentResult(task.ID, studentIndex, func(result *models.HomeworkResult) error {
+		// 计分方式未记录时（较早的任务）按结果的满分和默认取整方式计算
+		scoring := services.DefaultScoringOptions()
+		if task.Scoring != nil {
+			scoring = *task.Scoring
+		} else if result.FullMarks > 0 {
+			scoring.FullMarks = result.FullMarks
+		}
+		return services.ReviewResult(result, override, c.GetString("userId"), scoring)
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrStudentResultNotFound) {
+			utils.RespondWithError(c, http.StatusNotFound, err.Error())
+		} else {
+			utils.RespondWithError(c, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	log.Printf("[INFO] 用户 %s 复核了任务 %s 中学生 %d 的批改结果，总得分: %s", c.GetString("userId"), task.ID, studentIndex, result.OverallScore)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    result,
+	})
+}
+
+// GetReviewQueue 获取当前用户可以查看的任务中需要复核的学生结果和题目，按处理的优先级排列
+// 查询参数：taskId、assignmentId、classId 筛选任务，threshold 为0到1之间的复核阈值，默认使用服务的配置
+func (h *TaskHandler) GetReviewQueue(c *gin.Context) {
+	threshold := h.reviewThreshold
+	if value := c.Query("threshold"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			utils.RespondWithError(c, http.StatusBadRequest, "threshold必须是0到1之间的数字")
+			return
+		}
+		threshold = parsed
+	}
+
+	principal := middleware.CurrentPrincipal(c)
+	taskID, assignmentID, classID := c.Query("taskId"), c.Query("assignmentId"), c.Query("classId")
+	tasks := h.taskQueue.ListTasks(func(task *services.HomeworkTask) bool {
+		return principal.CanReadTask(task) &&
+			(taskID == "" || task.ID == taskID) &&
+			(assignmentID == "" || task.AssignmentID == assignmentID) &&
+			(classID == "" || task.ClassID == classID)
+	})
+
+	items := services.BuildReviewQueue(tasks, threshold)
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"threshold": threshold,
+			"total":     len(items),
+			"items":     items,
+		},
+	})
+}
+
+// authorizedTask 获取当前用户有权访问的任务，manage为true时还需要有修改任务的权限
+// 无权查看的任务与不存在的任务一样返回404，可以查看但无权修改时返回403
+func (h *TaskHandler) authorizedTask(c *gin.Context, taskID string, manage bool) (*services.HomeworkTask, bool) {
+	principal := middleware.CurrentPrincipal(c)
+	task, exists := h.taskQueue.GetTask(taskID)
+	if !exists || !principal.CanReadTask(task) {
+		utils.RespondWithError(c, http.StatusNotFound, "任务不存在")
+		return nil, false
+	}
+	if manage && !principal.CanManageTask(task) {
+		utils.RespondWithError(c, http.StatusForbidden, "没有权限修改该任务")
+		return nil, false
+	}
+	return task, true
+}
+
+// taskMessage 返回任务当前的进度描述，没有时使用默认描述
+func taskMessage(task *services.HomeworkTask, defaultMessage string) string {
+	if task.Message != "" {
+		return task.Message
+	}
+	return defaultMessage
+}
+
+// partialResults 按学生顺序返回已经处理完成的学生结果（包括批改失败的错误条目）
+func partialResults(task *services.HomeworkTask) []models.HomeworkResult {
+	results := []models.HomeworkResult{}
+	for _, result := range task.StudentResults {
+		if result != nil {
+			results = append(results, *result)
+		}
+	}
+	return results
+}
+
+// GetAllTasks 获取当前用户可以查看的任务列表和各状态的任务数量
 func (h *TaskHandler) GetAllTasks(c *gin.Context) {
-	// 获取任务计数
-	counts := h.taskQueue.GetTasksCount()
-	
+	tasks := h.taskQueue.ListTasks(middleware.CurrentPrincipal(c).CanReadTask)
+
+	counts := map[services.TaskStatus]int{}
+	summaries := make([]gin.H, 0, len(tasks))
+	for _, task := range tasks {
+		counts[task.Status]++
+		summaries = append(summaries, gin.H{
+			"task_id":        task.ID,
+			"status":         string(task.Status),
+			"homework_type":  task.HomeworkType,
+			"class_id":       task.ClassID,
+			"assignment_id":  task.AssignmentID,
+			"total_students": task.TotalStudents,
+			"processed":      task.ProcessedCount,
+			"failed":         task.FailedCount,
+			"start_time":     task.StartTime,
+			"end_time":       task.EndTime,
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
 		"message": "任务统计",
 		"counts":  counts,
+		"tasks":   summaries,
 	})
-} 
\ No newline at end of file
+}
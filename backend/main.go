@@ -7,9 +7,11 @@ import (
 	"unicode/utf16"
 	"bytes"
 	"io/ioutil"
+	"time"
 
 	"github.com/GiantClam/homework_marking/routes"
 	"github.com/GiantClam/homework_marking/services"
+	"github.com/GiantClam/homework_marking/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
@@ -161,6 +163,9 @@ func main() {
 	log.Printf("- GOOGLE_CLOUD_PROJECT: %s", os.Getenv("GOOGLE_CLOUD_PROJECT"))
 	log.Printf("- GOOGLE_CLOUD_LOCATION: %s", os.Getenv("GOOGLE_CLOUD_LOCATION"))
 	log.Printf("- PORT: %s", os.Getenv("PORT"))
+	log.Printf("- LLM_PROVIDER: %s", os.Getenv("LLM_PROVIDER"))
+	log.Printf("- SECONDARY_LLM_PROVIDER: %s", os.Getenv("SECONDARY_LLM_PROVIDER"))
+	log.Printf("- STORE_PATH: %s", os.Getenv("STORE_PATH"))
 
 	// 检查凭证文件是否存在
 	credFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
@@ -204,16 +209,94 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// 登录令牌使用JWT_SECRET签名，生产环境不能使用未设置或公开的密钥
+	if err := utils.CheckJWTSecret(); err != nil {
+		if ginMode == "release" {
+			log.Fatalf("JWT密钥不安全: %v，请设置随机生成的JWT_SECRET", err)
+		}
+		log.Printf("[WARN] JWT密钥不安全: %v，只能用于开发环境", err)
+	}
+
 	// 初始化Gemini服务
 	log.Println("初始化Gemini服务...")
 	geminiService, err := services.NewGeminiService()
 	if err != nil {
 		log.Fatalf("创建Gemini服务失败: %v", err)
 	}
-	log.Println("Gemini服务初始化成功")
+	log.Printf("Gemini服务初始化成功，使用大模型服务: %s", geminiService.Name())
+
+	// 作业设置了多模型批改时与主模型交替使用的第二个大模型（可选）
+	secondaryLLM, err := services.NewSecondaryLLMProviderFromEnv()
+	if err != nil {
+		log.Fatalf("创建第二个大模型服务失败: %v", err)
+	}
+
+	// 打开数据库，用于持久化任务
+	storePath := os.Getenv("STORE_PATH")
+	if storePath == "" {
+		storePath = "data/homework_marking.db"
+	}
+	db, err := services.OpenBoltDB(storePath)
+	if err != nil {
+		log.Fatalf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+
+	taskStore, err := services.NewBoltTaskStore(db)
+	if err != nil {
+		log.Fatalf("创建任务存储失败: %v", err)
+	}
+
+	// 创建任务队列，并恢复重启前保存的任务
+	taskQueue, err := services.NewTaskQueueWithStore(5, taskStore) // 5个工作协程
+	if err != nil {
+		log.Fatalf("恢复任务失败: %v", err)
+	}
+	// 自动识别学生分页后等待教师确认的最长时间，超时的任务标记为失败
+	if value := os.Getenv("SPLIT_CONFIRMATION_TIMEOUT"); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			taskQueue.SetSplitConfirmationTimeout(timeout)
+		} else {
+			log.Printf("[WARN] SPLIT_CONFIRMATION_TIMEOUT 格式错误: %s，使用默认值 %v", value, services.DefaultSplitConfirmationTimeout)
+		}
+	}
+
+	// 创建用户存储和账号服务，第一个注册的账号为管理员；ALLOW_REGISTRATION为true时开放教师注册，否则由管理员创建账号
+	userStore, err := services.NewBoltUserStore(db)
+	if err != nil {
+		log.Fatalf("创建用户存储失败: %v", err)
+	}
+	allowRegistration := os.Getenv("ALLOW_REGISTRATION") == "true"
+	log.Printf("开放注册: %v", allowRegistration)
+	authService := services.NewAuthService(userStore, allowRegistration)
+
+	// 加载管理员维护的全局提示词模板
+	prompts, err := services.NewPromptTemplates(db)
+	if err != nil {
+		log.Fatalf("加载提示词模板失败: %v", err)
+	}
+
+	// 创建API密钥服务，供教务系统等脚本调用接口
+	apiKeyStore, err := services.NewBoltAPIKeyStore(db)
+	if err != nil {
+		log.Fatalf("创建API密钥存储失败: %v", err)
+	}
+	apiKeys := services.NewAPIKeyService(apiKeyStore, authService)
+
+	// 加载班级和学生名单，批改结果按名单匹配学生
+	rosters, err := services.NewRosters(db)
+	if err != nil {
+		log.Fatalf("加载班级名单失败: %v", err)
+	}
+
+	// 加载作业，上传时引用作业即沿用作业的批改设置
+	assignments, err := services.NewAssignments(db)
+	if err != nil {
+		log.Fatalf("加载作业失败: %v", err)
+	}
 
 	// 使用路由模块配置路由
-	r := routes.SetupRouter(geminiService)
+	r := routes.SetupRouter(geminiService, taskQueue, authService, prompts, apiKeys, rosters, assignments, secondaryLLM)
 
 	// 确定端口
 	port := os.Getenv("PORT")
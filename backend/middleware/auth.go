@@ -1,34 +1,71 @@
 package middleware
 
 import (
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/GiantClam/homework_marking/services"
 	"github.com/GiantClam/homework_marking/utils"
 	"github.com/gin-gonic/gin"
 )
 
 // AuthMiddleware 认证中间件
-func AuthMiddleware() gin.HandlerFunc {
+// 令牌从Authorization请求头读取，角色和可以查看的班级读取用户的最新设置，令牌签发后账号被删除时返回401
+// apiKeys不为nil时还接受X-API-Key请求头或以hmk_开头的Bearer令牌作为API密钥
+func AuthMiddleware(authService *services.AuthService, apiKeys *services.APIKeyService) gin.HandlerFunc {
+	return authenticate(authService, apiKeys, false)
+}
+
+// QueryTokenAuthMiddleware 与AuthMiddleware相同，但在没有Authorization请求头时还接受token查询参数
+// 只用于浏览器的EventSource和文件链接这类无法设置请求头的接口，访问日志会隐去该参数；API密钥不能通过查询参数传递
+func QueryTokenAuthMiddleware(authService *services.AuthService, apiKeys *services.APIKeyService) gin.HandlerFunc {
+	return authenticate(authService, apiKeys, true)
+}
+
+// authenticate 校验请求携带的令牌或API密钥，allowQuery为true时接受token查询参数
+func authenticate(authService *services.AuthService, apiKeys *services.APIKeyService, allowQuery bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 从请求头获取Authorization
 		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		tokenString := ""
+		if allowQuery {
+			tokenString = c.Query("token")
+			// API密钥长期有效，只能通过请求头传递，避免出现在地址栏、浏览器历史和代理日志中
+			if strings.HasPrefix(tokenString, services.APIKeyPrefix) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "API密钥只能通过X-API-Key或Authorization请求头传递"})
+				c.Abort()
+				return
+			}
+		}
+		if authHeader != "" {
+			// 提取令牌
+			parts := strings.SplitN(authHeader, " ", 2)
+			if !(len(parts) == 2 && parts[0] == "Bearer") {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization格式无效"})
+				c.Abort()
+				return
+			}
+			tokenString = parts[1]
+		}
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			tokenString = apiKey
+		}
+		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "缺少Authorization请求头"})
 			c.Abort()
 			return
 		}
 
-		// 提取令牌
-		parts := strings.SplitN(authHeader, " ", 2)
-		if !(len(parts) == 2 && parts[0] == "Bearer") {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization格式无效"})
-			c.Abort()
+		if apiKeys != nil && strings.HasPrefix(tokenString, services.APIKeyPrefix) {
+			authenticateAPIKey(c, apiKeys, tokenString)
 			return
 		}
 
-		tokenString := parts[1]
-
 		// 解析JWT
 		claims, err := utils.ParseJWT(tokenString)
 		if err != nil {
@@ -37,8 +74,97 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 将用户ID存入上下文
-		c.Set("userId", claims.UserID)
+		// 管理员修改角色、班级或删除账号后立即生效，不等令牌过期
+		user, err := authService.GetUser(claims.UserID)
+		if err != nil {
+			if !errors.Is(err, services.ErrUserNotFound) {
+				log.Printf("[ERROR] 读取用户 %s 失败: %v", claims.UserID, err)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "账号不存在或已被删除"})
+			c.Abort()
+			return
+		}
+
+		// 将用户ID、角色和可以查看的班级存入上下文
+		c.Set("userId", user.ID)
+		c.Set("role", user.Role)
+		c.Set("classIds", user.ClassIDs)
+		c.Next()
+	}
+}
+
+// authenticateAPIKey 校验API密钥并按密钥限流，以密钥所属用户的身份继续处理请求
+func authenticateAPIKey(c *gin.Context, apiKeys *services.APIKeyService, plaintext string) {
+	principal, key, err := apiKeys.Authenticate(plaintext)
+	if err != nil {
+		if !errors.Is(err, services.ErrInvalidAPIKey) {
+			log.Printf("[ERROR] 校验API密钥失败: %v", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": services.ErrInvalidAPIKey.Error()})
+		c.Abort()
+		return
+	}
+
+	if allowed, retryAfter := apiKeys.Allow(key); !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "请求过于频繁，请稍后重试"})
+		c.Abort()
+		return
+	}
+
+	c.Set("userId", principal.UserID)
+	c.Set("role", principal.Role)
+	c.Set("classIds", principal.ClassIDs)
+	c.Set("apiKeyId", principal.APIKeyID)
+	c.Set("scopes", principal.Scopes)
+	c.Set("scopeClassIds", principal.ScopeClassIDs)
+	c.Next()
+}
+
+// RequireRole 只允许指定角色访问，需要在AuthMiddleware之后使用
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !slices.Contains(roles, c.GetString("role")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "没有权限执行该操作"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope 要求API密钥拥有指定的权限范围，登录会话不受限制
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !CurrentPrincipal(c).HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API密钥没有" + scope + "权限"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession 只允许登录会话访问，用于账号管理和API密钥管理等不开放给API密钥的接口
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("apiKeyId") != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "该接口不能使用API密钥访问，请登录后操作"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
+
+// CurrentPrincipal 返回AuthMiddleware认证的用户
+func CurrentPrincipal(c *gin.Context) services.Principal {
+	return services.Principal{
+		UserID:        c.GetString("userId"),
+		Role:          c.GetString("role"),
+		ClassIDs:      c.GetStringSlice("classIds"),
+		APIKeyID:      c.GetString("apiKeyId"),
+		Scopes:        c.GetStringSlice("scopes"),
+		ScopeClassIDs: c.GetStringSlice("scopeClassIds"),
+	}
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// 标准答案中的题目类型
+const (
+	QuestionTypeObjective  = "objective"  // 客观题，按标准答案自动判分
+	QuestionTypeSubjective = "subjective" // 主观题，由大模型参照标准答案判分
+)
+
+// AnswerKeyItem 标准答案中的一道题
+type AnswerKeyItem struct {
+	QuestionNumber string  `json:"questionNumber"`
+	Answer         string  `json:"answer"`
+	Points         float64 `json:"points"`
+	Type           string  `json:"type,omitempty"` // objective 或 subjective，为空时按客观题处理
+}
+
+// AnswerKey 教师提供的标准答案
+type AnswerKey struct {
+	Items []AnswerKeyItem `json:"items"`
+}
+
+// IsObjective 是否为按标准答案自动判分的客观题
+func (i AnswerKeyItem) IsObjective() bool {
+	return i.Type != QuestionTypeSubjective
+}
+
+// UnmarshalJSON 解析标准答案条目，兼容题号为数字、分值为字符串的情况，未给出分值时记为1分
+func (i *AnswerKeyItem) UnmarshalJSON(data []byte) error {
+	type answerKeyItemAlias AnswerKeyItem
+	aux := struct {
+		*answerKeyItemAlias
+		QuestionNumber json.RawMessage `json:"questionNumber"`
+		Answer         json.RawMessage `json:"answer"`
+		Points         json.RawMessage `json:"points"`
+	}{answerKeyItemAlias: (*answerKeyItemAlias)(i)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	i.QuestionNumber = strings.TrimSpace(rawToString(aux.QuestionNumber))
+	i.Answer = strings.TrimSpace(rawToString(aux.Answer))
+	i.Type = strings.ToLower(strings.TrimSpace(i.Type))
+
+	i.Points = 1
+	if points := strings.TrimSpace(rawToString(aux.Points)); points != "" {
+		value, err := strconv.ParseFloat(points, 64)
+		if err != nil {
+			return err
+		}
+		i.Points = value
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// API密钥的权限范围
+const (
+	ScopeGrade       = "grade"        // 上传作业和同步批改
+	ScopeTasksRead   = "tasks:read"   // 查看任务进度、批改结果和学生PDF
+	ScopeTasksManage = "tasks:manage" // 取消任务、确认学生分页
+	ScopeRoster      = "roster"       // 查看和维护班级名单
+	ScopeAssignments = "assignments"  // 查看和维护作业，查看各班级的成绩对比
+)
+
+// ValidScope 是否为有效的权限范围
+func ValidScope(scope string) bool {
+	return scope == ScopeGrade || scope == ScopeTasksRead || scope == ScopeTasksManage || scope == ScopeRoster || scope == ScopeAssignments
+}
+
+// APIKey 供教务系统等脚本调用接口的API密钥，以所属用户的身份访问，并受权限范围和班级的限制
+// 密钥明文只在创建时返回一次，存储中只保存SHA-256哈希
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"userId"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`             // 密钥的前几位，用于识别是哪一个密钥
+	Scopes     []string   `json:"scopes"`             // 允许的权限范围
+	ClassIDs   []string   `json:"classIds,omitempty"` // 只能访问这些班级的任务，为空时不限制班级
+	RateLimit  int        `json:"rateLimit"`          // 每分钟最多请求次数
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
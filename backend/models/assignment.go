@@ -0,0 +1,69 @@
+package models
+
+import "time"
+
+// Assignment 作业，保存批改一份练习卷需要的全部设置
+// 上传时引用作业ID即可沿用这些设置，同一份练习卷可以分多个班级上传并比较各班的成绩
+type Assignment struct {
+	ID        string `json:"id"`
+	TeacherID string `json:"teacherId"` // 创建作业的教师
+	AssignmentSettings
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// AssignmentSettings 作业中由教师填写的设置
+type AssignmentSettings struct {
+	Title           string             `json:"title"`
+	Subject         string             `json:"subject"`                  // 科目，即批改时的作业类型：general、english、math、chinese、essay等
+	DueDate         *time.Time         `json:"dueDate,omitempty"`        // 截止日期
+	Prompt          string             `json:"prompt,omitempty"`         // 附加的批改要求
+	AnswerKey       *AnswerKey         `json:"answerKey,omitempty"`      // 标准答案
+	Rubric          *Rubric            `json:"rubric,omitempty"`         // 作文的评分标准，为空时使用默认评分标准
+	QuestionPoints  map[string]float64 `json:"questionPoints,omitempty"` // 每题的分值，按题号设置，优先于标准答案中的分值
+	FullMarks       float64            `json:"fullMarks,omitempty"`      // 总得分的满分，为0时按标准答案或评分标准的总分，都没有时按百分制
+	Rounding        string             `json:"rounding,omitempty"`       // 取整方式：round、floor、ceil
+	Precision       *int               `json:"precision,omitempty"`      // 保留的小数位数
+	PagesPerStudent int                `json:"pagesPerStudent"`          // 每个学生的页数
+	Layout          string             `json:"layout"`                   // 布局方式：single或double
+	ClassIDs        []string           `json:"classIds,omitempty"`       // 布置该作业的班级，为空时不限制上传的班级
+	Ensemble        *EnsembleSettings  `json:"ensemble,omitempty"`       // 多次批改取多数，为nil时每份作业只批改一次
+}
+
+// EnsembleSettings 每份作业批改多次、逐题按多数表决的设置，大模型的调用次数会成倍增加
+type EnsembleSettings struct {
+	Runs       int  `json:"runs"`                 // 每份作业的批改次数
+	CrossModel bool `json:"crossModel,omitempty"` // 交替使用主模型和第二个大模型批改
+}
+
+// AssignmentReport 同一份作业在各班级的成绩对比
+type AssignmentReport struct {
+	AssignmentID string        `json:"assignmentId"`
+	Title        string        `json:"title"`
+	FullMarks    float64       `json:"fullMarks"`
+	Classes      []ClassReport `json:"classes"` // 每个班级的成绩，未指定班级上传的作业单独列为一组
+	Overall      ClassReport   `json:"overall"` // 所有班级合计
+}
+
+// ClassReport 一个班级的成绩统计
+type ClassReport struct {
+	ClassID          string           `json:"classId,omitempty"`
+	ClassName        string           `json:"className,omitempty"`
+	Students         int              `json:"students"` // 已批改的学生数
+	Failed           int              `json:"failed"`   // 批改失败的学生数
+	Average          float64          `json:"average"`
+	Median           float64          `json:"median"`
+	Highest          float64          `json:"highest"`
+	Lowest           float64          `json:"lowest"`
+	PassRate         float64          `json:"passRate"` // 及格率，得分不低于满分的60%
+	Questions        []QuestionReport `json:"questions,omitempty"`
+	DisagreementRate *float64         `json:"disagreementRate,omitempty"` // 多次批改时各次判定不一致的题目比例，没有多次批改的结果时为nil
+}
+
+// QuestionReport 一道题在班级中的得分情况
+type QuestionReport struct {
+	QuestionNumber string  `json:"questionNumber"`
+	MaxPoints      float64 `json:"maxPoints"`
+	AveragePoints  float64 `json:"averagePoints"`
+	ScoreRate      float64 `json:"scoreRate"` // 得分率，平均得分占满分的比例
+}
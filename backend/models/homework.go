@@ -1,24 +1,195 @@
 package models
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // HomeworkAnswer 代表单个作业题目的答案
 type HomeworkAnswer struct {
-	QuestionNumber string `json:"questionNumber"`
-	StudentAnswer  string `json:"studentAnswer"`
-	IsCorrect      *bool  `json:"isCorrect,omitempty"`
-	CorrectAnswer  string `json:"correctAnswer,omitempty"`
-	CorrectSteps   string `json:"correctSteps,omitempty"`
-	Explanation    string `json:"explanation,omitempty"`
-	Evaluation     string `json:"evaluation,omitempty"`
-	Suggestion     string `json:"suggestion,omitempty"`
+	QuestionNumber string         `json:"questionNumber"`
+	StudentAnswer  string         `json:"studentAnswer"`
+	IsCorrect      *bool          `json:"isCorrect,omitempty"`
+	CorrectAnswer  string         `json:"correctAnswer,omitempty"`
+	CorrectSteps   string         `json:"correctSteps,omitempty"`
+	Explanation    string         `json:"explanation,omitempty"`
+	Evaluation     string         `json:"evaluation,omitempty"`
+	Suggestion     string         `json:"suggestion,omitempty"`
+	MaxPoints      *float64       `json:"maxPoints,omitempty"`     // 该题满分
+	AwardedPoints  *float64       `json:"awardedPoints,omitempty"` // 该题得分，可以是部分分
+	Rationale      string         `json:"rationale,omitempty"`     // 给分（特别是部分给分）的理由
+	Confidence     *float64       `json:"confidence,omitempty"`    // 对判定的把握，0到1之间，为nil时大模型没有给出
+	Illegible      bool           `json:"illegible,omitempty"`     // 学生的答案字迹无法辨认
+	Votes          []EnsembleVote `json:"votes,omitempty"`         // 多次批改的判定不一致时每次批改的判定
+	Review         *AnswerReview  `json:"review,omitempty"`        // 教师的复核，为nil时未复核
+}
+
+// EnsembleVote 多次批改中一次批改对单题的判定
+type EnsembleVote struct {
+	Source        string   `json:"source"` // 批改使用的大模型和批改序号，如 vertex#1
+	StudentAnswer string   `json:"studentAnswer,omitempty"`
+	IsCorrect     *bool    `json:"isCorrect,omitempty"` // 为nil时该次批改没有给出这道题的判定
+	AwardedPoints *float64 `json:"awardedPoints,omitempty"`
 }
 
-// HomeworkResult 代表作业批改结果
+// EnsembleSummary 多次批改后逐题表决的情况，判定不一致的比例可作为批改质量的参考
+type EnsembleSummary struct {
+	Sources          []string `json:"sources"`              // 成功的各次批改
+	FailedRuns       int      `json:"failedRuns,omitempty"` // 失败的批改次数
+	Questions        int      `json:"questions"`
+	Disagreements    int      `json:"disagreements"`    // 各次批改判定不一致的题数
+	DisagreementRate float64  `json:"disagreementRate"` // 判定不一致的题数占总题数的比例
+}
+
+// 学生批改结果的状态
+const (
+	ResultStatusGraded   = "graded"   // 批改成功
+	ResultStatusReviewed = "reviewed" // 教师已复核，结果为教师确认的版本
+	ResultStatusError    = "error"    // 批改失败
+)
+
+// 教师复核单题的方式
+const (
+	ReviewActionAccept = "accept" // 认可大模型的判定
+	ReviewActionReject = "reject" // 大模型判断错误，对错取反
+	ReviewActionEdit   = "edit"   // 修改对错、得分或评语
+)
+
+// AnswerVerdict 单题的判定、得分和评语
+type AnswerVerdict struct {
+	IsCorrect     *bool    `json:"isCorrect,omitempty"`
+	AwardedPoints *float64 `json:"awardedPoints,omitempty"`
+	Explanation   string   `json:"explanation,omitempty"`
+	Suggestion    string   `json:"suggestion,omitempty"`
+}
+
+// AnswerReview 教师对单题的复核，答案中的判定和得分为复核后的版本，Original保存大模型原始的判定
+type AnswerReview struct {
+	Action     string        `json:"action"`
+	ReviewerID string        `json:"reviewerId"`
+	ReviewedAt time.Time     `json:"reviewedAt"`
+	Original   AnswerVerdict `json:"original"`
+}
+
+// ResultReview 教师对学生批改结果的复核，保存第一次复核前的总得分和整体评价
+type ResultReview struct {
+	ReviewerID       string    `json:"reviewerId"`
+	ReviewedAt       time.Time `json:"reviewedAt"`
+	OriginalScore    string    `json:"originalScore,omitempty"`
+	OriginalFeedback string    `json:"originalFeedback,omitempty"`
+}
+
+// HomeworkResult 代表一个学生的作业批改结果
 type HomeworkResult struct {
-	Answers      []HomeworkAnswer `json:"answers"`
-	OverallScore string           `json:"overallScore,omitempty"`
-	Feedback     string           `json:"feedback,omitempty"`
+	StudentIndex int              `json:"studentIndex"`           // 学生在上传文件中的序号，从0开始
+	Name         string           `json:"name"`                   // 学生姓名
+	Class        string           `json:"class"`                  // 班级
+	StudentID    string           `json:"studentId,omitempty"`    // 封面二维码中的学号
+	AssignmentID string           `json:"assignmentId,omitempty"` // 封面二维码中的作业编号
+	RosterMatch  *RosterMatch     `json:"rosterMatch,omitempty"`  // 与班级名单的匹配结果
+	Answers      []HomeworkAnswer `json:"answers"`                // 每道题的答案
+	Criteria     []CriterionScore `json:"criteria,omitempty"`     // 作文按评分标准每一项的得分
+	OverallScore string           `json:"overallScore,omitempty"` // 总得分，由服务端按每题得分计算
+	FullMarks    float64          `json:"fullMarks,omitempty"`    // 总得分对应的满分
+	Feedback     string           `json:"feedback,omitempty"`     // 整体评价和建议
+	PdfURL       string           `json:"pdfUrl,omitempty"`       // 该学生拆分后的PDF地址
+	Status       string           `json:"status"`                 // 批改状态：graded、reviewed 或 error
+	Error        string           `json:"error,omitempty"`        // 批改失败的原因
+	Ensemble     *EnsembleSummary `json:"ensemble,omitempty"`     // 多次批改的表决情况，只批改一次时为nil
+	Review       *ResultReview    `json:"review,omitempty"`       // 教师的复核，为nil时未复核
+}
+
+// UnmarshalJSON 解析大模型返回的批改结果，兼容总得分为数字的情况
+func (r *HomeworkResult) UnmarshalJSON(data []byte) error {
+	type homeworkResultAlias HomeworkResult
+	aux := struct {
+		*homeworkResultAlias
+		OverallScore json.RawMessage `json:"overallScore,omitempty"`
+	}{homeworkResultAlias: (*homeworkResultAlias)(r)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.OverallScore = rawToString(aux.OverallScore)
+	return nil
+}
+
+// UnmarshalJSON 解析大模型返回的单题答案，兼容isCorrect为字符串"true"/"false"、分值为字符串的情况
+// 把握程度按百分数给出时（如85）换算为0到1之间
+func (a *HomeworkAnswer) UnmarshalJSON(data []byte) error {
+	type homeworkAnswerAlias HomeworkAnswer
+	aux := struct {
+		*homeworkAnswerAlias
+		QuestionNumber json.RawMessage `json:"questionNumber"`
+		IsCorrect      json.RawMessage `json:"isCorrect,omitempty"`
+		MaxPoints      json.RawMessage `json:"maxPoints,omitempty"`
+		AwardedPoints  json.RawMessage `json:"awardedPoints,omitempty"`
+		Confidence     json.RawMessage `json:"confidence,omitempty"`
+		Illegible      json.RawMessage `json:"illegible,omitempty"`
+	}{homeworkAnswerAlias: (*homeworkAnswerAlias)(a)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	a.QuestionNumber = rawToString(aux.QuestionNumber)
+	a.MaxPoints = rawToFloat(aux.MaxPoints)
+	a.AwardedPoints = rawToFloat(aux.AwardedPoints)
+	a.Confidence = rawToConfidence(aux.Confidence)
+	illegible := rawToBool(aux.Illegible)
+	a.Illegible = illegible != nil && *illegible
+	a.IsCorrect = rawToBool(aux.IsCorrect)
+	return nil
+}
+
+// rawToBool 将JSON中的布尔值或"true"/"false"字符串转换为布尔值，无法转换时返回nil
+func rawToBool(raw json.RawMessage) *bool {
+	var value bool
+	switch rawToString(raw) {
+	case "true", "True", "TRUE":
+		value = true
+	case "false", "False", "FALSE":
+		value = false
+	default:
+		return nil
+	}
+	return &value
+}
+
+// rawToConfidence 将JSON中的把握程度转换为0到1之间的数字，大于1时按百分数换算，无法转换时返回nil
+func rawToConfidence(raw json.RawMessage) *float64 {
+	value := rawToFloat(raw)
+	if value == nil || *value < 0 || *value > 100 {
+		return nil
+	}
+	if *value > 1 {
+		percent := *value / 100
+		value = &percent
+	}
+	return value
+}
+
+// rawToString 将JSON中的字符串、数字或布尔值转换为字符串，null或空时返回空字符串
+func rawToString(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// rawToFloat 将JSON中的数字或数字字符串转换为浮点数，无法转换时返回nil
+func rawToFloat(raw json.RawMessage) *float64 {
+	value, err := strconv.ParseFloat(strings.TrimSpace(rawToString(raw)), 64)
+	if err != nil {
+		return nil
+	}
+	return &value
 }
 
 // APIResponse 表示API的通用响应格式
@@ -0,0 +1,27 @@
+package models
+
+// 进入复核队列的原因，按处理的优先级从高到低排列
+const (
+	ReviewReasonNameMissing   = "name_missing"   // 没有识别出学生姓名
+	ReviewReasonNameUnmatched = "name_unmatched" // 姓名没有匹配到班级名单中的学生
+	ReviewReasonIllegible     = "illegible"      // 学生的答案字迹无法辨认
+	ReviewReasonLowConfidence = "low_confidence" // 大模型对判定的把握低于阈值
+	ReviewReasonNameFuzzy     = "name_fuzzy"     // 姓名近似匹配到名单中的学生，建议核对
+)
+
+// ReviewQueueItem 复核队列中的一项：一个需要核对姓名的学生结果，或一道需要复核判定的题目
+type ReviewQueueItem struct {
+	Reason         string   `json:"reason"`
+	TaskID         string   `json:"taskId"`
+	AssignmentID   string   `json:"assignmentId,omitempty"`
+	ClassID        string   `json:"classId,omitempty"`
+	StudentIndex   int      `json:"studentIndex"`
+	Name           string   `json:"name"`
+	PdfURL         string   `json:"pdfUrl,omitempty"`
+	QuestionNumber string   `json:"questionNumber,omitempty"` // 姓名相关的原因没有题号
+	StudentAnswer  string   `json:"studentAnswer,omitempty"`
+	IsCorrect      *bool    `json:"isCorrect,omitempty"`
+	MaxPoints      *float64 `json:"maxPoints,omitempty"`
+	AwardedPoints  *float64 `json:"awardedPoints,omitempty"`
+	Confidence     *float64 `json:"confidence,omitempty"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Class 班级，由创建它的教师管理，分配了该班级的只读用户可以查看
+type Class struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`            // 班级名称，如“三年级二班”
+	Grade     string    `json:"grade,omitempty"` // 年级
+	TeacherID string    `json:"teacherId"`       // 管理该班级的教师
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Student 班级名单中的学生
+type Student struct {
+	ID            string    `json:"id"`
+	ClassID       string    `json:"classId"`
+	Name          string    `json:"name"`
+	StudentNumber string    `json:"studentNumber,omitempty"` // 学号，与封面二维码中的学号对应
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// 批改结果与班级名单的匹配状态
+const (
+	RosterMatchExact     = "exact"     // 学号或姓名与名单完全一致
+	RosterMatchFuzzy     = "fuzzy"     // 姓名近似匹配，建议教师核对
+	RosterMatchManual    = "manual"    // 教师手动指定
+	RosterMatchUnmatched = "unmatched" // 没有匹配到名单中的学生，需要教师处理
+)
+
+// RosterMatch 批改结果匹配到的名单学生
+type RosterMatch struct {
+	Status        string            `json:"status"`                  // 匹配状态
+	StudentID     string            `json:"studentId,omitempty"`     // 匹配到的学生
+	StudentName   string            `json:"studentName,omitempty"`   // 名单中的姓名
+	StudentNumber string            `json:"studentNumber,omitempty"` // 名单中的学号
+	ClassID       string            `json:"classId,omitempty"`       // 学生所在的班级
+	Score         float64           `json:"score,omitempty"`         // 姓名的相似度，0到1
+	Candidates    []RosterCandidate `json:"candidates,omitempty"`    // 未匹配或近似匹配时最接近的几个学生，供教师选择
+}
+
+// RosterCandidate 名单中与识别的姓名相近的学生
+type RosterCandidate struct {
+	StudentID   string  `json:"studentId"`
+	StudentName string  `json:"studentName"`
+	ClassID     string  `json:"classId"`
+	Score       float64 `json:"score"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import "encoding/json"
+
+// RubricBand 评分标准中某一项的一个分数段
+type RubricBand struct {
+	Label       string  `json:"label,omitempty"`       // 档次名称，如"一类"
+	Min         float64 `json:"min"`                   // 该档最低分
+	Max         float64 `json:"max"`                   // 该档最高分
+	Description string  `json:"description,omitempty"` // 该档的评分描述
+}
+
+// RubricCriterion 评分标准中的一项，如内容、结构、语言、书写
+type RubricCriterion struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Weight      float64      `json:"weight"`   // 该项在总分中的权重
+	MaxScore    float64      `json:"maxScore"` // 该项的满分，未给出时取分数段的最高分
+	Bands       []RubricBand `json:"bands,omitempty"`
+}
+
+// Rubric 作文批改的评分标准
+type Rubric struct {
+	Criteria []RubricCriterion `json:"criteria"`
+}
+
+// CriterionScore 作文在某一评分项上的得分
+type CriterionScore struct {
+	Name     string   `json:"name"`
+	Score    *float64 `json:"score"`              // 该项得分，0到该项满分之间
+	MaxScore float64  `json:"maxScore,omitempty"` // 该项满分，由服务端按评分标准填写
+	Weight   float64  `json:"weight,omitempty"`   // 该项权重，由服务端按评分标准填写
+	Band     string   `json:"band,omitempty"`     // 所属档次
+	Evidence string   `json:"evidence,omitempty"` // 支持该得分的原文引用
+	Comment  string   `json:"comment,omitempty"`  // 该项的评语
+}
+
+// UnmarshalJSON 解析大模型返回的评分项，兼容得分为字符串的情况
+func (c *CriterionScore) UnmarshalJSON(data []byte) error {
+	type criterionScoreAlias CriterionScore
+	aux := struct {
+		*criterionScoreAlias
+		Score json.RawMessage `json:"score"`
+	}{criterionScoreAlias: (*criterionScoreAlias)(c)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	c.Score = rawToFloat(aux.Score)
+	return nil
+}
@@ -0,0 +1,36 @@
+package models
+
+// 批量扫描件中每页的类型，用于识别每个学生作业的起始页
+const (
+	PageKindHeader       = "header"       // 带姓名/班级栏的首页，开始一个新学生
+	PageKindCover        = "cover"        // 封面，开始一个新学生
+	PageKindBlank        = "blank"        // 空白分隔页，不属于任何学生
+	PageKindContinuation = "continuation" // 上一个学生的后续页
+)
+
+// PageClassification 大模型对扫描件中某一页的识别结果
+type PageClassification struct {
+	Page  int    `json:"page"`            // 页码，从1开始
+	Kind  string `json:"kind"`            // header、cover、blank或continuation
+	Name  string `json:"name,omitempty"`  // 首页或封面上的学生姓名
+	Class string `json:"class,omitempty"` // 首页或封面上的班级
+}
+
+// StudentPageRange 一个学生的作业在扫描件中的页码范围（包含起止页）
+type StudentPageRange struct {
+	StartPage int    `json:"startPage"`
+	EndPage   int    `json:"endPage"`
+	Name      string `json:"name,omitempty"`
+	Class     string `json:"class,omitempty"`
+	// 由封面二维码确定的学生身份，为空时由大模型识别手写姓名
+	StudentID    string `json:"studentId,omitempty"`
+	AssignmentID string `json:"assignmentId,omitempty"`
+}
+
+// CoverCode 封面或贴纸二维码中编码的学生和作业信息
+type CoverCode struct {
+	StudentID    string `json:"studentId"`
+	AssignmentID string `json:"assignmentId"`
+	Name         string `json:"name,omitempty"`
+	Class        string `json:"class,omitempty"`
+}
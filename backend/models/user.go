@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// 用户角色
+const (
+	RoleAdmin   = "admin"   // 管理员，管理用户和全局提示词模板，可以查看所有任务
+	RoleTeacher = "teacher" // 教师，只能查看和操作自己的任务
+	RoleViewer  = "viewer"  // 只读用户（如年级组长），只能查看分配给他的班级的批改结果
+)
+
+// ValidRole 是否为有效的用户角色
+func ValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleTeacher || role == RoleViewer
+}
+
+// User 系统用户，密码哈希只保存在用户存储中，不返回给客户端
+type User struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Name      string    `json:"name,omitempty"`
+	Role      string    `json:"role"`
+	ClassIDs  []string  `json:"classIds,omitempty"` // 只读用户可以查看的班级
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// PromptTemplate 管理员维护的全局提示词模板，覆盖某种作业类型内置的系统指令
+type PromptTemplate struct {
+	HomeworkType      string    `json:"homeworkType"`
+	SystemInstruction string    `json:"systemInstruction"`
+	UpdatedBy         string    `json:"updatedBy,omitempty"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
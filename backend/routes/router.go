@@ -4,28 +4,35 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/GiantClam/homework_marking/handlers"
+	"github.com/GiantClam/homework_marking/middleware"
+	"github.com/GiantClam/homework_marking/models"
 	"github.com/GiantClam/homework_marking/services"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 // SetupRouter 设置API路由
-func SetupRouter(geminiService *services.GeminiService) *gin.Engine {
-	// 创建任务队列
-	taskQueue := services.NewTaskQueue(5) // 5个工作协程
-
-	r := gin.Default()
+// 除注册、登录和测试接口外，API都需要登录；上传和修改任务需要教师或管理员角色，
+// 教师只能访问自己创建的任务和文件，只读用户只能查看分配给他的班级的任务，用户管理和提示词模板只允许管理员访问
+// 使用API密钥访问时还需要密钥拥有对应的权限范围，账号和密钥管理只能登录后操作
+// 班级名单由管理它的教师维护，上传作业时指定的班级必须是上传者管理的班级
+// 作业由创建它的教师维护，上传时引用作业即沿用作业的批改设置；secondaryLLM为nil时作业不能使用多模型批改
+func SetupRouter(geminiService *services.GeminiService, taskQueue *services.TaskQueue, authService *services.AuthService, prompts *services.PromptTemplates, apiKeys *services.APIKeyService, rosters *services.Rosters, assignments *services.Assignments, secondaryLLM services.LLMProvider) *gin.Engine {
+	// 不使用gin.Default的访问日志，它会记录完整的查询参数
+	r := gin.New()
+	r.Use(gin.Recovery())
 
 	// 配置CORS
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"https://aiexammark.o3-tools.com", "https://exammark.o3-tools.com", "http://localhost:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "Accept", "X-Requested-With"},
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-API-Key", "Accept", "X-Requested-With"},
 		ExposeHeaders:    []string{"Content-Length", "Content-Type", "Access-Control-Allow-Origin", "Access-Control-Allow-Headers"},
 		AllowCredentials: true,
 		AllowWildcard:    true,
@@ -49,8 +56,8 @@ func SetupRouter(geminiService *services.GeminiService) *gin.Engine {
 		// 请求方式
 		reqMethod := c.Request.Method
 
-		// 请求路由
-		reqURI := c.Request.RequestURI
+		// 请求路由，隐去token查询参数
+		reqURI := redactedRequestURI(c.Request.URL)
 
 		// 状态码
 		statusCode := c.Writer.Status()
@@ -65,32 +72,112 @@ func SetupRouter(geminiService *services.GeminiService) *gin.Engine {
 	})
 
 	// 创建处理器
-	homeworkHandler := handlers.NewHomeworkHandler(taskQueue)
-	taskHandler := handlers.NewTaskHandler(taskQueue)
+	homeworkHandler := handlers.NewHomeworkHandler(taskQueue, geminiService, secondaryLLM, prompts, rosters, assignments)
+	taskHandler := handlers.NewTaskHandler(taskQueue, rosters)
+	rosterHandler := handlers.NewRosterHandler(rosters)
+	assignmentHandler := handlers.NewAssignmentHandler(assignments, taskQueue, rosters, geminiService)
+	authHandler := handlers.NewAuthHandler(authService)
+	adminHandler := handlers.NewAdminHandler(authService, prompts)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeys)
+	requireAuth := middleware.AuthMiddleware(authService, apiKeys)
+	// EventSource和文件链接无法设置请求头，只有这两类接口接受token查询参数
+	requireQueryAuth := middleware.QueryTokenAuthMiddleware(authService, apiKeys)
+	requireSession := middleware.RequireSession()
+	requireGrader := middleware.RequireRole(models.RoleAdmin, models.RoleTeacher)
+	requireRead := middleware.RequireScope(models.ScopeTasksRead)
+	requireManage := middleware.RequireScope(models.ScopeTasksManage)
 
 	// 上传文件API
 	api := r.Group("/api")
 	{
-		upload := api.Group("/upload")
+		// 账号API
+		auth := api.Group("/auth")
+		{
+			auth.POST("/register", authHandler.Register)
+			auth.POST("/login", authHandler.Login)
+			auth.GET("/me", requireAuth, authHandler.Me)
+		}
+
+		// 管理员API：用户管理和全局提示词模板
+		admin := api.Group("/admin", requireAuth, requireSession, middleware.RequireRole(models.RoleAdmin))
+		{
+			admin.GET("/users", adminHandler.ListUsers)
+			admin.POST("/users", adminHandler.CreateUser)
+			admin.PUT("/users/:userId", adminHandler.UpdateUser)
+			admin.DELETE("/users/:userId", adminHandler.DeleteUser)
+			admin.GET("/prompts", adminHandler.ListPromptTemplates)
+			admin.GET("/prompts/:homeworkType", adminHandler.GetPromptTemplate)
+			admin.PUT("/prompts/:homeworkType", adminHandler.SavePromptTemplate)
+			admin.DELETE("/prompts/:homeworkType", adminHandler.DeletePromptTemplate)
+		}
+
+		// API密钥管理：教师和管理员为自己的脚本创建密钥，管理员可以查看和吊销所有密钥
+		keys := api.Group("/keys", requireAuth, requireSession, requireGrader)
+		{
+			keys.GET("", apiKeyHandler.ListAPIKeys)
+			keys.POST("", apiKeyHandler.CreateAPIKey)
+			keys.DELETE("/:keyId", apiKeyHandler.RevokeAPIKey)
+		}
+
+		// 班级和学生名单API：教师维护自己班级的名单，只读用户可以查看分配给他的班级
+		classes := api.Group("/classes", requireAuth, middleware.RequireScope(models.ScopeRoster))
+		{
+			classes.GET("", rosterHandler.ListClasses)
+			classes.POST("", requireGrader, rosterHandler.CreateClass)
+			classes.GET("/:classId", rosterHandler.GetClass)
+			classes.PUT("/:classId", requireGrader, rosterHandler.UpdateClass)
+			classes.DELETE("/:classId", requireGrader, rosterHandler.DeleteClass)
+			classes.GET("/:classId/students", rosterHandler.ListStudents)
+			classes.POST("/:classId/students", requireGrader, rosterHandler.AddStudent)
+			classes.POST("/:classId/students/import", requireGrader, rosterHandler.ImportStudents)
+			classes.PUT("/:classId/students/:studentId", requireGrader, rosterHandler.UpdateStudent)
+			classes.DELETE("/:classId/students/:studentId", requireGrader, rosterHandler.DeleteStudent)
+		}
+
+		// 作业API：教师维护自己的作业，并按班级对比同一份作业的成绩
+		assignmentsAPI := api.Group("/assignments", requireAuth, middleware.RequireScope(models.ScopeAssignments))
+		{
+			assignmentsAPI.GET("", assignmentHandler.ListAssignments)
+			assignmentsAPI.POST("", requireGrader, assignmentHandler.CreateAssignment)
+			assignmentsAPI.GET("/:assignmentId", assignmentHandler.GetAssignment)
+			assignmentsAPI.PUT("/:assignmentId", requireGrader, assignmentHandler.UpdateAssignment)
+			assignmentsAPI.DELETE("/:assignmentId", requireGrader, assignmentHandler.DeleteAssignment)
+			assignmentsAPI.PUT("/:assignmentId/answer-key", requireGrader, assignmentHandler.UploadAnswerKey)
+			assignmentsAPI.GET("/:assignmentId/report", assignmentHandler.GetAssignmentReport)
+		}
+
+		upload := api.Group("/upload", requireAuth, requireGrader, middleware.RequireScope(models.ScopeGrade))
 		{
 			upload.POST("/homework", homeworkHandler.UploadHomework)
 		}
 
 		// 作业批改API
-		marking := api.Group("/marking")
+		marking := api.Group("/marking", requireAuth, requireGrader, middleware.RequireScope(models.ScopeGrade))
 		{
 			marking.POST("/homework", homeworkHandler.MarkHomework)
 		}
 
 		// 任务API
-		tasks := api.Group("/tasks")
+		tasks := api.Group("/tasks", requireAuth)
 		{
-			tasks.GET("/:taskId", taskHandler.GetTaskStatus)
-			tasks.GET("", taskHandler.GetAllTasks)
+			tasks.GET("/:taskId", requireRead, taskHandler.GetTaskStatus)
+			tasks.DELETE("/:taskId", requireGrader, requireManage, taskHandler.CancelTask)
+			tasks.POST("/:taskId/cancel", requireGrader, requireManage, taskHandler.CancelTask)
+			tasks.GET("/:taskId/split", requireRead, taskHandler.GetStudentSplit)
+			tasks.PUT("/:taskId/split", requireGrader, requireManage, taskHandler.ConfirmStudentSplit)
+			tasks.PUT("/:taskId/results/:studentIndex/student", requireGrader, requireManage, taskHandler.AssignResultStudent)
+			tasks.PUT("/:taskId/results/:studentIndex/review", requireGrader, requireManage, taskHandler.ReviewResult)
+			tasks.GET("", requireRead, taskHandler.GetAllTasks)
 		}
 
+		// 任务进度推送API
+		api.GET("/tasks/:taskId/events", requireQueryAuth, requireRead, taskHandler.StreamTaskEvents)
+
+		// 复核队列API：需要教师核对的姓名和判定
+		api.GET("/review-queue", requireAuth, requireRead, taskHandler.GetReviewQueue)
+
 		// 添加文件服务API
-		files := api.Group("/files")
+		files := api.Group("/files", requireQueryAuth, requireRead)
 		{
 			// 用于获取分割后的PDF文件
 			files.GET("/:path/:filename", func(c *gin.Context) {
@@ -106,6 +193,15 @@ func SetupRouter(geminiService *services.GeminiService) *gin.Engine {
 					return
 				}
 
+				// 只能获取有权查看的任务中的文件
+				if task, exists := taskQueue.FindTaskByResultFile(path + "/" + filename); !exists || !middleware.CurrentPrincipal(c).CanReadTask(task) {
+					c.JSON(http.StatusNotFound, gin.H{
+						"status":  "error",
+						"message": "文件不存在",
+					})
+					return
+				}
+
 				// 构建文件路径
 				filePath := filepath.Join("uploads/split", path, filename)
 
@@ -146,6 +242,15 @@ func SetupRouter(geminiService *services.GeminiService) *gin.Engine {
 					return
 				}
 
+				// 只能获取有权查看的任务中的文件
+				if task, exists := taskQueue.FindTaskByResultFile(filename); !exists || !middleware.CurrentPrincipal(c).CanReadTask(task) {
+					c.JSON(http.StatusNotFound, gin.H{
+						"status":  "error",
+						"message": "文件不存在",
+					})
+					return
+				}
+
 				// 构建文件路径
 				filePath := filepath.Join("uploads/split", filename)
 
@@ -201,3 +306,15 @@ func SetupRouter(geminiService *services.GeminiService) *gin.Engine {
 
 	return r
 }
+
+// redactedRequestURI 返回用于访问日志的请求地址，token查询参数替换为REDACTED
+func redactedRequestURI(u *url.URL) string {
+	query := u.Query()
+	if !query.Has("token") {
+		return u.RequestURI()
+	}
+	query.Set("token", "REDACTED")
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.RequestURI()
+}
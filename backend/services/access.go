@@ -0,0 +1,122 @@
+package services
+
+import (
+	"slices"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// Principal 发起请求的用户，来自登录令牌或API密钥
+type Principal struct {
+	UserID   string
+	Role     string
+	ClassIDs []string // 只读用户可以查看的班级
+
+	// 使用API密钥访问时，权限还受密钥的权限范围和班级限制
+	APIKeyID      string
+	Scopes        []string
+	ScopeClassIDs []string
+}
+
+// HasScope 是否拥有权限范围，登录会话不受权限范围限制
+func (p Principal) HasScope(scope string) bool {
+	return p.APIKeyID == "" || slices.Contains(p.Scopes, scope)
+}
+
+// CanUseClass 是否可以访问班级，API密钥限制了班级时只能访问这些班级
+func (p Principal) CanUseClass(classID string) bool {
+	return len(p.ScopeClassIDs) == 0 || slices.Contains(p.ScopeClassIDs, classID)
+}
+
+// CanReadTask 是否可以查看任务的进度和批改结果
+// 管理员可以查看所有任务，教师只能查看自己的任务，只读用户只能查看分配给他的班级的任务
+// 限制了班级的API密钥还只能查看这些班级的任务
+func (p Principal) CanReadTask(task *HomeworkTask) bool {
+	if !p.CanUseClass(task.ClassID) {
+		return false
+	}
+	switch p.Role {
+	case models.RoleAdmin:
+		return true
+	case models.RoleViewer:
+		return task.ClassID != "" && slices.Contains(p.ClassIDs, task.ClassID)
+	default:
+		return task.UserID == p.UserID
+	}
+}
+
+// CanManageTask 是否可以取消任务或确认学生分页，只读用户不能修改任务
+func (p Principal) CanManageTask(task *HomeworkTask) bool {
+	if !p.CanUseClass(task.ClassID) {
+		return false
+	}
+	switch p.Role {
+	case models.RoleAdmin:
+		return true
+	case models.RoleViewer:
+		return false
+	default:
+		return task.UserID == p.UserID
+	}
+}
+
+// CanReadClass 是否可以查看班级和名单
+// 管理员可以查看所有班级，教师只能查看自己管理的班级，只读用户只能查看分配给他的班级
+func (p Principal) CanReadClass(class models.Class) bool {
+	if !p.CanUseClass(class.ID) {
+		return false
+	}
+	switch p.Role {
+	case models.RoleAdmin:
+		return true
+	case models.RoleViewer:
+		return slices.Contains(p.ClassIDs, class.ID)
+	default:
+		return class.TeacherID == p.UserID
+	}
+}
+
+// CanManageClass 是否可以修改班级和名单，以及上传该班级的作业
+func (p Principal) CanManageClass(class models.Class) bool {
+	if !p.CanUseClass(class.ID) {
+		return false
+	}
+	switch p.Role {
+	case models.RoleAdmin:
+		return true
+	case models.RoleViewer:
+		return false
+	default:
+		return class.TeacherID == p.UserID
+	}
+}
+
+// CanReadAssignment 是否可以查看作业的设置和成绩对比
+// 管理员可以查看所有作业，教师只能查看自己创建的作业，只读用户只能查看布置给分配给他的班级的作业
+func (p Principal) CanReadAssignment(assignment models.Assignment) bool {
+	if len(p.ScopeClassIDs) > 0 && !slices.ContainsFunc(assignment.ClassIDs, p.CanUseClass) {
+		return false
+	}
+	switch p.Role {
+	case models.RoleAdmin:
+		return true
+	case models.RoleViewer:
+		return slices.ContainsFunc(assignment.ClassIDs, func(classID string) bool {
+			return slices.Contains(p.ClassIDs, classID)
+		})
+	default:
+		return assignment.TeacherID == p.UserID
+	}
+}
+
+// CanManageAssignment 是否可以修改、删除作业，以及上传时引用该作业
+func (p Principal) CanManageAssignment(assignment models.Assignment) bool {
+	switch p.Role {
+	case models.RoleAdmin:
+		return true
+	case models.RoleViewer:
+		return false
+	default:
+		return assignment.TeacherID == p.UserID
+	}
+}
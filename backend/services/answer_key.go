@@ -0,0 +1,314 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// AnswerKeySchema 从教师版PDF中提取标准答案时的输出结构
+var AnswerKeySchema = &ResponseSchema{
+	Name: "answer_key",
+	Type: "object",
+	Properties: map[string]*ResponseSchema{
+		"items": {
+			Type:        "array",
+			Description: "按题号顺序排列的每道题的标准答案",
+			Items: &ResponseSchema{
+				Type: "object",
+				Properties: map[string]*ResponseSchema{
+					"questionNumber": {Type: "string", Description: "题号"},
+					"answer":         {Type: "string", Description: "标准答案，主观题为参考答案或评分要点"},
+					"points":         {Type: "number", Description: "该题分值，未标注时为1"},
+					"type":           {Type: "string", Description: "objective（选择、判断、填空等有唯一答案的题）或 subjective（简答、作文等）"},
+				},
+				Required: []string{"questionNumber", "answer", "points", "type"},
+			},
+		},
+	},
+	Required: []string{"items"},
+}
+
+// ParseAnswerKeyJSON 解析JSON格式的标准答案，支持 {"items":[...]} 或直接的数组
+func ParseAnswerKeyJSON(data []byte) (*models.AnswerKey, error) {
+	data = bytes.TrimSpace(data)
+
+	key := &models.AnswerKey{}
+	var err error
+	if bytes.HasPrefix(data, []byte("[")) {
+		err = json.Unmarshal(data, &key.Items)
+	} else {
+		err = json.Unmarshal(data, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析标准答案失败: %v", err)
+	}
+
+	if err := validateAnswerKey(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ParseAnswerKeyCSV 解析CSV格式的标准答案，列依次为：题号、答案、分值（可选）、题目类型（可选）
+// 第一行的题号不像题号或分值不是数字时视为表头
+func ParseAnswerKeyCSV(data []byte) (*models.AnswerKey, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	key := &models.AnswerKey{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析标准答案失败: %v", err)
+		}
+		if len(record) == 0 || (len(record) == 1 && strings.TrimSpace(record[0]) == "") {
+			continue
+		}
+		if line == 1 && !looksLikeQuestionNumber(record[0]) {
+			continue // 表头
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("标准答案第%d行至少需要题号和答案两列", line)
+		}
+
+		item := models.AnswerKeyItem{
+			QuestionNumber: strings.TrimSpace(record[0]),
+			Answer:         strings.TrimSpace(record[1]),
+			Points:         1,
+		}
+		if len(record) > 2 && strings.TrimSpace(record[2]) != "" {
+			points, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+			if err != nil {
+				if line == 1 {
+					continue // 表头
+				}
+				return nil, fmt.Errorf("标准答案第%d行的分值无效: %s", line, record[2])
+			}
+			item.Points = points
+		}
+		if len(record) > 3 {
+			item.Type = strings.ToLower(strings.TrimSpace(record[3]))
+		}
+		key.Items = append(key.Items, item)
+	}
+
+	if err := validateAnswerKey(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ParseAnswerKeyFile 按扩展名读取JSON或CSV格式的标准答案文件
+func ParseAnswerKeyFile(path string) (*models.AnswerKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取标准答案文件失败: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ParseAnswerKeyJSON(data)
+	case ".csv":
+		return ParseAnswerKeyCSV(data)
+	default:
+		return nil, fmt.Errorf("不支持的标准答案文件格式: %s", filepath.Ext(path))
+	}
+}
+
+// ExtractAnswerKeyFromPDF 调用大模型从教师版PDF中提取标准答案
+func ExtractAnswerKeyFromPDF(ctx context.Context, llm LLMProvider, pdfPath string) (*models.AnswerKey, error) {
+	log.Printf("[INFO] 从教师版PDF提取标准答案: %s", pdfPath)
+
+	systemInstruction := "你是一位老师，请从这份教师版试卷（含答案）中提取每道题的题号、标准答案和分值。" +
+		"选择、判断、填空等有唯一答案的题目类型为objective，简答、作文等为subjective，主观题的answer填写参考答案或评分要点。"
+	response, err := llm.GenerateJSONWithFile(ctx, systemInstruction, pdfPath, "application/pdf", "请按题号顺序提取全部标准答案。", AnswerKeySchema)
+	if err != nil {
+		return nil, fmt.Errorf("提取标准答案失败: %v", err)
+	}
+
+	key, err := ParseAnswerKeyJSON([]byte(response))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] 从教师版PDF提取到 %d 道题的标准答案", len(key.Items))
+	return key, nil
+}
+
+// validateAnswerKey 检查标准答案的题号、答案和分值
+func validateAnswerKey(key *models.AnswerKey) error {
+	if len(key.Items) == 0 {
+		return fmt.Errorf("标准答案为空")
+	}
+
+	seen := make(map[string]bool, len(key.Items))
+	for i, item := range key.Items {
+		number := normalizeQuestionNumber(item.QuestionNumber)
+		if number == "" {
+			return fmt.Errorf("第%d条标准答案缺少题号", i+1)
+		}
+		if seen[number] {
+			return fmt.Errorf("标准答案的题号重复: %s", item.QuestionNumber)
+		}
+		seen[number] = true
+
+		if item.Type != "" && item.Type != models.QuestionTypeObjective && item.Type != models.QuestionTypeSubjective {
+			return fmt.Errorf("题号%s的题目类型无效: %s", item.QuestionNumber, item.Type)
+		}
+		if item.IsObjective() && item.Answer == "" {
+			return fmt.Errorf("客观题%s缺少标准答案", item.QuestionNumber)
+		}
+		if item.Points < 0 {
+			return fmt.Errorf("题号%s的分值不能为负数", item.QuestionNumber)
+		}
+	}
+	return nil
+}
+
+// AnswerKeyInstruction 生成附加到系统指令中的标准答案说明，key为空时返回空字符串
+func AnswerKeyInstruction(key *models.AnswerKey) string {
+	if key == nil || len(key.Items) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n\n以下是本次作业的标准答案（题号: 答案，分值），请以标准答案为准判断学生的答案是否正确，correctAnswer填写标准答案，题号与标准答案保持一致：\n")
+	for _, item := range key.Items {
+		if item.IsObjective() {
+			fmt.Fprintf(&sb, "%s: %s（%s分）\n", item.QuestionNumber, item.Answer, formatScore(item.Points))
+		} else {
+			fmt.Fprintf(&sb, "%s: 主观题，参考答案：%s（%s分）\n", item.QuestionNumber, item.Answer, formatScore(item.Points))
+		}
+	}
+	return sb.String()
+}
+
+// ApplyAnswerKey 按标准答案设置每题的满分和得分：客观题直接比对学生答案给满分或0分，
+// 主观题采用大模型给出的（部分）得分；标准答案中学生未作答的题目记为0分，标准答案以外的题目不计分。
+// 总得分由ScoreResult计算
+func ApplyAnswerKey(result *models.HomeworkResult, key *models.AnswerKey) {
+	if key == nil || len(key.Items) == 0 {
+		return
+	}
+
+	answerIndex := make(map[string]int, len(result.Answers))
+	for i, answer := range result.Answers {
+		answerIndex[normalizeQuestionNumber(answer.QuestionNumber)] = i
+	}
+
+	matched := make(map[int]bool, len(key.Items))
+	for _, item := range key.Items {
+		idx, ok := answerIndex[normalizeQuestionNumber(item.QuestionNumber)]
+		if !ok {
+			result.Answers = append(result.Answers, models.HomeworkAnswer{
+				QuestionNumber: item.QuestionNumber,
+				Explanation:    "未作答",
+			})
+			idx = len(result.Answers) - 1
+		}
+
+		matched[idx] = true
+		answer := &result.Answers[idx]
+		maxPoints := item.Points
+		answer.MaxPoints = &maxPoints
+
+		if item.IsObjective() || !ok {
+			correct := ok && item.IsObjective() && answer.StudentAnswer != "" &&
+				normalizeAnswer(answer.StudentAnswer) == normalizeAnswer(item.Answer)
+			awarded := 0.0
+			if correct {
+				awarded = maxPoints
+			}
+			answer.IsCorrect = &correct
+			answer.AwardedPoints = &awarded
+			answer.CorrectAnswer = item.Answer
+		} else if answer.CorrectAnswer == "" {
+			answer.CorrectAnswer = item.Answer
+		}
+	}
+
+	for i := range result.Answers {
+		if !matched[i] {
+			maxPoints, awarded := 0.0, 0.0
+			result.Answers[i].MaxPoints = &maxPoints
+			result.Answers[i].AwardedPoints = &awarded
+		}
+	}
+}
+
+// normalizeQuestionNumber 统一题号格式，如"第1题"、"1."、"（1）"都视为"1"
+func normalizeQuestionNumber(number string) string {
+	number = strings.Join(strings.Fields(toHalfWidth(number)), "")
+	number = strings.TrimPrefix(number, "第")
+	number = strings.TrimSuffix(number, "题")
+	number = strings.Trim(number, ".、:()")
+	return strings.ToLower(number)
+}
+
+// looksLikeQuestionNumber 判断文本是否像题号，如"1"、"第2题"、"(3)"、"一"，用于识别CSV的表头
+func looksLikeQuestionNumber(text string) bool {
+	for _, r := range normalizeQuestionNumber(text) {
+		if unicode.IsDigit(r) || strings.ContainsRune("一二三四五六七八九十", r) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeAnswer 统一客观题答案格式：忽略空白、大小写、全半角和末尾标点，多选题忽略选项顺序
+func normalizeAnswer(answer string) string {
+	answer = strings.Join(strings.Fields(toHalfWidth(answer)), "")
+	answer = strings.TrimRight(answer, ".。;；,，")
+	answer = strings.ToUpper(answer)
+
+	// 选择题答案，如"A,C"、"C、A"
+	choice := strings.NewReplacer(",", "", "、", "", "，", "").Replace(answer)
+	if choice == "" || len(choice) > 7 {
+		return answer
+	}
+	for _, r := range choice {
+		if r < 'A' || r > 'G' {
+			return answer
+		}
+	}
+	letters := strings.Split(choice, "")
+	sort.Strings(letters)
+	return strings.Join(letters, "")
+}
+
+// toHalfWidth 将全角字母、数字和标点转换为半角
+func toHalfWidth(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '　':
+			return ' '
+		case r >= '！' && r <= '～':
+			return r - 0xFEE0
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// formatScore 将分数格式化为最多一位小数
+func formatScore(score float64) string {
+	return strconv.FormatFloat(math.Round(score*10)/10, 'f', -1, 64)
+}
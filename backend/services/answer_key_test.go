@@ -0,0 +1,99 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// TestParseAnswerKey 测试CSV和JSON格式的标准答案解析
+func TestParseAnswerKey(t *testing.T) {
+	key, err := ParseAnswerKeyCSV([]byte("\xef\xbb\xbf题号,答案,分值,类型\n1,B,2\n2,\"A,C\",3,objective\n3,光合作用的条件,5,subjective\n"))
+	if err != nil {
+		t.Fatalf("解析CSV失败: %v", err)
+	}
+	if len(key.Items) != 3 || key.Items[1].Answer != "A,C" || key.Items[1].Points != 3 || key.Items[2].IsObjective() {
+		t.Errorf("CSV解析结果错误: %+v", key.Items)
+	}
+
+	// 只有题号和答案两列的表头
+	key, err = ParseAnswerKeyCSV([]byte("题号,答案\n第1题,B\n(2),C\n"))
+	if err != nil {
+		t.Fatalf("解析两列的CSV失败: %v", err)
+	}
+	if len(key.Items) != 2 || key.Items[0].QuestionNumber != "第1题" || key.Items[1].Answer != "C" {
+		t.Errorf("两列CSV的表头应被跳过: %+v", key.Items)
+	}
+
+	key, err = ParseAnswerKeyJSON([]byte(`[{"questionNumber":1,"answer":"true"},{"questionNumber":"2","answer":"x=3","points":"4"}]`))
+	if err != nil {
+		t.Fatalf("解析JSON失败: %v", err)
+	}
+	if key.Items[0].QuestionNumber != "1" || key.Items[0].Points != 1 || key.Items[1].Points != 4 {
+		t.Errorf("JSON解析结果错误: %+v", key.Items)
+	}
+
+	invalid := []string{
+		`[]`,
+		`[{"questionNumber":"1","answer":""}]`,
+		`[{"questionNumber":"1","answer":"A"},{"questionNumber":"第1题","answer":"B"}]`,
+	}
+	for _, text := range invalid {
+		if _, err := ParseAnswerKeyJSON([]byte(text)); err == nil {
+			t.Errorf("预期解析失败: %s", text)
+		}
+	}
+}
+
+// TestApplyAnswerKey 测试客观题按标准答案判分，主观题采用模型给出的部分分，未作答的题目记为错误
+func TestApplyAnswerKey(t *testing.T) {
+	key := &models.AnswerKey{Items: []models.AnswerKeyItem{
+		{QuestionNumber: "1", Answer: "B", Points: 2},
+		{QuestionNumber: "2", Answer: "A,C", Points: 2},
+		{QuestionNumber: "3", Answer: "x=3", Points: 2},
+		{QuestionNumber: "4", Answer: "要点：条件和产物", Points: 4, Type: models.QuestionTypeSubjective},
+		{QuestionNumber: "5", Answer: "D", Points: 10},
+	}}
+
+	correct, wrong := true, false
+	partial := 2.5
+	result := models.HomeworkResult{
+		OverallScore: "100",
+		Answers: []models.HomeworkAnswer{
+			{QuestionNumber: "1.", StudentAnswer: "ｂ", IsCorrect: &wrong},
+			{QuestionNumber: "第2题", StudentAnswer: "C A"},
+			{QuestionNumber: "3", StudentAnswer: "x=4", IsCorrect: &correct},
+			{QuestionNumber: "4", StudentAnswer: "需要光照", IsCorrect: &correct, AwardedPoints: &partial},
+			{QuestionNumber: "附加题", StudentAnswer: "A", IsCorrect: &correct},
+		},
+	}
+	ApplyAnswerKey(&result, key)
+	ScoreResult(&result, DefaultScoringOptions())
+
+	expected := []bool{true, true, false, true, true, false}
+	if len(result.Answers) != len(expected) {
+		t.Fatalf("预期%d个答案，实际: %+v", len(expected), result.Answers)
+	}
+	for i, want := range expected {
+		if got := result.Answers[i].IsCorrect; got == nil || *got != want {
+			t.Errorf("第%d题判分错误: %+v", i+1, result.Answers[i])
+		}
+	}
+	if extra := result.Answers[4]; *extra.MaxPoints != 0 || *extra.AwardedPoints != 0 {
+		t.Errorf("标准答案以外的题目不应计分: %+v", extra)
+	}
+	if missing := result.Answers[5]; missing.QuestionNumber != "5" || missing.CorrectAnswer != "D" || *missing.MaxPoints != 10 || *missing.AwardedPoints != 0 {
+		t.Errorf("未作答的题目应补充为错误条目: %+v", missing)
+	}
+
+	// 得分 (2+2+0+2.5+0)/20
+	if result.OverallScore != "32.5" {
+		t.Errorf("总得分错误: %s", result.OverallScore)
+	}
+
+	instruction := AnswerKeyInstruction(key)
+	if !strings.Contains(instruction, "1: B（2分）") || !strings.Contains(instruction, "4: 主观题，参考答案：要点：条件和产物（4分）") {
+		t.Errorf("标准答案说明错误: %s", instruction)
+	}
+}
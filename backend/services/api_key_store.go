@@ -0,0 +1,273 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GiantClam/homework_marking/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// apiKeysBucket API密钥在BoltDB中的存储桶名称，按密钥ID保存
+	apiKeysBucket = []byte("api_keys")
+	// apiKeyHashesBucket 密钥哈希到密钥ID的索引
+	apiKeyHashesBucket = []byte("api_key_hashes")
+)
+
+// ErrAPIKeyNotFound API密钥不存在
+var ErrAPIKeyNotFound = errors.New("API密钥不存在")
+
+// APIKeyRecord 保存在存储中的API密钥及其哈希
+type APIKeyRecord struct {
+	models.APIKey
+	Hash string `json:"hash"`
+}
+
+// APIKeyStore API密钥存储接口
+type APIKeyStore interface {
+	// Create 保存新密钥
+	Create(record *APIKeyRecord) error
+	// Get 按密钥ID查找，不存在时返回ErrAPIKeyNotFound
+	Get(id string) (*APIKeyRecord, error)
+	// GetByHash 按密钥哈希查找，不存在时返回ErrAPIKeyNotFound
+	GetByHash(hash string) (*APIKeyRecord, error)
+	// List 返回所有密钥，按创建时间排序
+	List() ([]*APIKeyRecord, error)
+	// Update 更新已存在的密钥（哈希不能修改）
+	Update(record *APIKeyRecord) error
+	// Touch 只更新密钥的最后使用时间，已吊销的密钥保持不变，不存在时返回ErrAPIKeyNotFound
+	Touch(id string, usedAt time.Time) error
+}
+
+// memoryAPIKeyStore 保存在内存中的API密钥存储，用于测试
+type memoryAPIKeyStore struct {
+	mutex  sync.RWMutex
+	keys   map[string]APIKeyRecord
+	hashes map[string]string
+}
+
+// NewMemoryAPIKeyStore 创建保存在内存中的API密钥存储，服务重启后密钥丢失
+func NewMemoryAPIKeyStore() APIKeyStore {
+	return &memoryAPIKeyStore{
+		keys:   make(map[string]APIKeyRecord),
+		hashes: make(map[string]string),
+	}
+}
+
+func (s *memoryAPIKeyStore) Create(record *APIKeyRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.keys[record.ID] = *record
+	s.hashes[record.Hash] = record.ID
+	return nil
+}
+
+func (s *memoryAPIKeyStore) Get(id string) (*APIKeyRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	record, exists := s.keys[id]
+	if !exists {
+		return nil, ErrAPIKeyNotFound
+	}
+	return &record, nil
+}
+
+func (s *memoryAPIKeyStore) GetByHash(hash string) (*APIKeyRecord, error) {
+	s.mutex.RLock()
+	id, exists := s.hashes[hash]
+	s.mutex.RUnlock()
+	if !exists {
+		return nil, ErrAPIKeyNotFound
+	}
+	return s.Get(id)
+}
+
+func (s *memoryAPIKeyStore) List() ([]*APIKeyRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	records := make([]*APIKeyRecord, 0, len(s.keys))
+	for _, record := range s.keys {
+		record := record
+		records = append(records, &record)
+	}
+	sortAPIKeyRecords(records)
+	return records, nil
+}
+
+func (s *memoryAPIKeyStore) Update(record *APIKeyRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	existing, exists := s.keys[record.ID]
+	if !exists {
+		return ErrAPIKeyNotFound
+	}
+	record.Hash = existing.Hash
+	s.keys[record.ID] = *record
+	return nil
+}
+
+func (s *memoryAPIKeyStore) Touch(id string, usedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, exists := s.keys[id]
+	if !exists {
+		return ErrAPIKeyNotFound
+	}
+	if record.RevokedAt == nil {
+		record.LastUsedAt = &usedAt
+		s.keys[id] = record
+	}
+	return nil
+}
+
+// BoltAPIKeyStore 基于BoltDB的API密钥存储
+type BoltAPIKeyStore struct {
+	db *bolt.DB
+}
+
+// NewBoltAPIKeyStore 创建基于BoltDB的API密钥存储
+func NewBoltAPIKeyStore(db *bolt.DB) (*BoltAPIKeyStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(apiKeysBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(apiKeyHashesBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建API密钥存储桶失败: %v", err)
+	}
+
+	return &BoltAPIKeyStore{db: db}, nil
+}
+
+// Create 保存新密钥和哈希索引
+func (s *BoltAPIKeyStore) Create(record *APIKeyRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("序列化API密钥失败: %v", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(apiKeyHashesBucket).Put([]byte(record.Hash), []byte(record.ID)); err != nil {
+			return err
+		}
+		return tx.Bucket(apiKeysBucket).Put([]byte(record.ID), data)
+	})
+}
+
+// Get 按密钥ID查找
+func (s *BoltAPIKeyStore) Get(id string) (*APIKeyRecord, error) {
+	var record *APIKeyRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		record, err = getAPIKeyRecord(tx, []byte(id))
+		return err
+	})
+	return record, err
+}
+
+// GetByHash 按密钥哈希查找
+func (s *BoltAPIKeyStore) GetByHash(hash string) (*APIKeyRecord, error) {
+	var record *APIKeyRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(apiKeyHashesBucket).Get([]byte(hash))
+		if id == nil {
+			return ErrAPIKeyNotFound
+		}
+		var err error
+		record, err = getAPIKeyRecord(tx, id)
+		return err
+	})
+	return record, err
+}
+
+// List 返回所有密钥
+func (s *BoltAPIKeyStore) List() ([]*APIKeyRecord, error) {
+	var records []*APIKeyRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).ForEach(func(k, v []byte) error {
+			var record APIKeyRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				log.Printf("[ERROR] 解析API密钥 %s 失败: %v", string(k), err)
+				return nil
+			}
+			records = append(records, &record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("加载API密钥失败: %v", err)
+	}
+	sortAPIKeyRecords(records)
+	return records, nil
+}
+
+// Update 更新已存在的密钥，哈希保持不变
+func (s *BoltAPIKeyStore) Update(record *APIKeyRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		existing, err := getAPIKeyRecord(tx, []byte(record.ID))
+		if err != nil {
+			return err
+		}
+		record.Hash = existing.Hash
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("序列化API密钥失败: %v", err)
+		}
+		return tx.Bucket(apiKeysBucket).Put([]byte(record.ID), data)
+	})
+}
+
+// Touch 在同一个事务中读取密钥并只修改最后使用时间，不会覆盖同时进行的吊销
+func (s *BoltAPIKeyStore) Touch(id string, usedAt time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		record, err := getAPIKeyRecord(tx, []byte(id))
+		if err != nil {
+			return err
+		}
+		if record.RevokedAt != nil {
+			return nil
+		}
+		record.LastUsedAt = &usedAt
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("序列化API密钥失败: %v", err)
+		}
+		return tx.Bucket(apiKeysBucket).Put([]byte(id), data)
+	})
+}
+
+// sortAPIKeyRecords 按创建时间排序密钥
+func sortAPIKeyRecords(records []*APIKeyRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+}
+
+// getAPIKeyRecord 在事务中读取并解析密钥
+func getAPIKeyRecord(tx *bolt.Tx, id []byte) (*APIKeyRecord, error) {
+	data := tx.Bucket(apiKeysBucket).Get(id)
+	if data == nil {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	var record APIKeyRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("解析API密钥 %s 失败: %v", string(id), err)
+	}
+	return &record, nil
+}
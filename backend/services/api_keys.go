@@ -0,0 +1,215 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+// APIKeyPrefix API密钥明文的固定前缀，用于区分API密钥和登录令牌
+const APIKeyPrefix = "hmk_"
+
+// API密钥的限制
+const (
+	defaultAPIKeyRateLimit = 60
+	maxAPIKeyRateLimit     = 6000
+	maxAPIKeyNameLength    = 64
+	// apiKeyDisplayLength 列表中显示的密钥前缀长度（包含hmk_）
+	apiKeyDisplayLength = 12
+	// lastUsedInterval 记录最近使用时间的最小间隔，避免每个请求都写存储
+	lastUsedInterval = time.Minute
+)
+
+// ErrInvalidAPIKey API密钥无效或已吊销
+var ErrInvalidAPIKey = errors.New("API密钥无效或已吊销")
+
+// APIKeyService API密钥的创建、吊销、认证和限流
+type APIKeyService struct {
+	store    APIKeyStore
+	auth     *AuthService
+	mutex    sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewAPIKeyService 创建API密钥服务，auth用于读取密钥所属用户的最新角色
+func NewAPIKeyService(store APIKeyStore, auth *AuthService) *APIKeyService {
+	return &APIKeyService{
+		store:    store,
+		auth:     auth,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// Create 为用户创建API密钥，返回密钥信息和只显示一次的密钥明文
+// scopes为允许的权限范围，classIDs不为空时只能访问这些班级，rateLimit为每分钟最多请求次数（0使用默认值）
+func (s *APIKeyService) Create(userID, name string, scopes, classIDs []string, rateLimit int) (*models.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > maxAPIKeyNameLength {
+		return nil, "", fmt.Errorf("密钥名称不能为空且不超过%d个字符", maxAPIKeyNameLength)
+	}
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("请至少指定一个权限范围")
+	}
+	for _, scope := range scopes {
+		if !models.ValidScope(scope) {
+			return nil, "", fmt.Errorf("无效的权限范围: %s，只支持grade、tasks:read、tasks:manage、roster和assignments", scope)
+		}
+	}
+	if rateLimit == 0 {
+		rateLimit = defaultAPIKeyRateLimit
+	}
+	if rateLimit < 0 || rateLimit > maxAPIKeyRateLimit {
+		return nil, "", fmt.Errorf("每分钟请求次数应为1到%d", maxAPIKeyRateLimit)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("生成API密钥失败: %v", err)
+	}
+	plaintext := APIKeyPrefix + hex.EncodeToString(secret)
+
+	record := &APIKeyRecord{
+		APIKey: models.APIKey{
+			ID:        uuid.New().String(),
+			UserID:    userID,
+			Name:      name,
+			Prefix:    plaintext[:apiKeyDisplayLength],
+			Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+			ClassIDs:  classIDs,
+			RateLimit: rateLimit,
+			CreatedAt: time.Now(),
+		},
+		Hash: hashAPIKey(plaintext),
+	}
+	if err := s.store.Create(record); err != nil {
+		return nil, "", fmt.Errorf("保存API密钥失败: %v", err)
+	}
+
+	log.Printf("[INFO] 用户 %s 创建了API密钥 %s (%s)", userID, record.ID, name)
+	return &record.APIKey, plaintext, nil
+}
+
+// List 列出调用者可以管理的密钥，管理员可以看到所有用户的密钥
+func (s *APIKeyService) List(principal Principal) ([]models.APIKey, error) {
+	records, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]models.APIKey, 0, len(records))
+	for _, record := range records {
+		if principal.Role == models.RoleAdmin || record.UserID == principal.UserID {
+			keys = append(keys, record.APIKey)
+		}
+	}
+	return keys, nil
+}
+
+// Revoke 吊销密钥，吊销后立即不能再使用；只能吊销自己的密钥，管理员可以吊销任何密钥
+func (s *APIKeyService) Revoke(principal Principal, id string) (*models.APIKey, error) {
+	record, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if principal.Role != models.RoleAdmin && record.UserID != principal.UserID {
+		return nil, ErrAPIKeyNotFound
+	}
+	if record.RevokedAt != nil {
+		return &record.APIKey, nil
+	}
+
+	now := time.Now()
+	record.RevokedAt = &now
+	if err := s.store.Update(record); err != nil {
+		return nil, fmt.Errorf("吊销API密钥失败: %v", err)
+	}
+
+	s.mutex.Lock()
+	delete(s.limiters, id)
+	s.mutex.Unlock()
+
+	log.Printf("[INFO] 用户 %s 吊销了API密钥 %s", principal.UserID, id)
+	return &record.APIKey, nil
+}
+
+// Authenticate 校验密钥明文，返回以密钥所属用户身份访问的Principal
+// 角色和可以查看的班级读取用户的最新设置；密钥已吊销或用户已删除时返回ErrInvalidAPIKey
+func (s *APIKeyService) Authenticate(plaintext string) (Principal, *models.APIKey, error) {
+	if !strings.HasPrefix(plaintext, APIKeyPrefix) {
+		return Principal{}, nil, ErrInvalidAPIKey
+	}
+
+	record, err := s.store.GetByHash(hashAPIKey(plaintext))
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return Principal{}, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return Principal{}, nil, fmt.Errorf("读取API密钥失败: %v", err)
+	}
+	if record.RevokedAt != nil {
+		return Principal{}, nil, ErrInvalidAPIKey
+	}
+
+	user, err := s.auth.GetUser(record.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return Principal{}, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return Principal{}, nil, fmt.Errorf("读取用户失败: %v", err)
+	}
+
+	// 只更新最后使用时间，读取密钥之后被吊销时不会恢复吊销前的状态
+	now := time.Now()
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) >= lastUsedInterval {
+		record.LastUsedAt = &now
+		if err := s.store.Touch(record.ID, now); err != nil {
+			log.Printf("[WARN] 更新API密钥 %s 的使用时间失败: %v", record.ID, err)
+		}
+	}
+
+	principal := Principal{
+		UserID:        user.ID,
+		Role:          user.Role,
+		ClassIDs:      user.ClassIDs,
+		APIKeyID:      record.ID,
+		Scopes:        record.Scopes,
+		ScopeClassIDs: record.ClassIDs,
+	}
+	return principal, &record.APIKey, nil
+}
+
+// Allow 按密钥的每分钟请求次数限流，超出时返回false和建议的重试等待时间
+func (s *APIKeyService) Allow(key *models.APIKey) (bool, time.Duration) {
+	s.mutex.Lock()
+	limiter, exists := s.limiters[key.ID]
+	if !exists {
+		limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(key.RateLimit)), key.RateLimit)
+		s.limiters[key.ID] = limiter
+	}
+	s.mutex.Unlock()
+
+	reservation := limiter.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return true, 0
+	}
+	reservation.Cancel()
+	return false, delay
+}
+
+// hashAPIKey 计算密钥明文的SHA-256哈希，密钥是随机生成的高熵字符串，不需要加盐
+func hashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// TestAPIKeyService 测试创建、认证、吊销API密钥，存储中只保存哈希
+func TestAPIKeyService(t *testing.T) {
+	db, err := OpenBoltDB(filepath.Join(t.TempDir(), "keys.db"))
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+	store, err := NewBoltAPIKeyStore(db)
+	if err != nil {
+		t.Fatalf("创建API密钥存储失败: %v", err)
+	}
+	auth := NewAuthService(NewMemoryUserStore(), true)
+	apiKeys := NewAPIKeyService(store, auth)
+
+	admin, _ := auth.Register("admin", "test-password", "")
+	teacher, _ := auth.Register("teacher", "test-password", "")
+
+	if _, _, err := apiKeys.Create(teacher.ID, "脚本", nil, nil, 0); err == nil {
+		t.Error("没有权限范围应返回错误")
+	}
+	key, plaintext, err := apiKeys.Create(teacher.ID, "脚本", []string{models.ScopeGrade, models.ScopeTasksRead}, []string{"class-1"}, 0)
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+	if !strings.HasPrefix(plaintext, APIKeyPrefix) || !strings.HasPrefix(plaintext, key.Prefix) || key.RateLimit != defaultAPIKeyRateLimit {
+		t.Errorf("密钥信息错误: %s %+v", plaintext, key)
+	}
+
+	record, err := store.Get(key.ID)
+	if err != nil || record.Hash == plaintext || record.Hash != hashAPIKey(plaintext) {
+		t.Errorf("存储中应只保存密钥哈希: %+v %v", record, err)
+	}
+
+	principal, _, err := apiKeys.Authenticate(plaintext)
+	if err != nil {
+		t.Fatalf("认证API密钥失败: %v", err)
+	}
+	if principal.UserID != teacher.ID || principal.Role != models.RoleTeacher || principal.HasScope(models.ScopeTasksManage) || !principal.HasScope(models.ScopeGrade) {
+		t.Errorf("认证结果错误: %+v", principal)
+	}
+	if principal.CanUseClass("class-2") || !principal.CanUseClass("class-1") {
+		t.Error("密钥应只能访问class-1")
+	}
+	if _, _, err := apiKeys.Authenticate(APIKeyPrefix + "unknown"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("未知密钥应返回ErrInvalidAPIKey: %v", err)
+	}
+
+	// 只能吊销自己的密钥，管理员可以吊销任何密钥
+	other := Principal{UserID: "other", Role: models.RoleTeacher}
+	if _, err := apiKeys.Revoke(other, key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("吊销其他教师的密钥应返回ErrAPIKeyNotFound: %v", err)
+	}
+	if keys, _ := apiKeys.List(other); len(keys) != 0 {
+		t.Errorf("其他教师不应看到该密钥: %+v", keys)
+	}
+	if _, err := apiKeys.Revoke(Principal{UserID: admin.ID, Role: models.RoleAdmin}, key.ID); err != nil {
+		t.Fatalf("管理员吊销密钥失败: %v", err)
+	}
+	if _, _, err := apiKeys.Authenticate(plaintext); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("吊销后应返回ErrInvalidAPIKey: %v", err)
+	}
+
+	// 用户删除后密钥失效
+	_, plaintext, _ = apiKeys.Create(teacher.ID, "脚本2", []string{models.ScopeGrade}, nil, 0)
+	if err := auth.DeleteUser(teacher.ID); err != nil {
+		t.Fatalf("删除用户失败: %v", err)
+	}
+	if _, _, err := apiKeys.Authenticate(plaintext); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("用户删除后应返回ErrInvalidAPIKey: %v", err)
+	}
+}
+
+// TestAPIKeyRateLimit 测试按每分钟请求次数限流
+func TestAPIKeyRateLimit(t *testing.T) {
+	apiKeys := NewAPIKeyService(NewMemoryAPIKeyStore(), NewAuthService(NewMemoryUserStore(), true))
+	key := &models.APIKey{ID: "key-1", RateLimit: 2}
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := apiKeys.Allow(key); !allowed {
+			t.Fatalf("第%d次请求不应被限流", i+1)
+		}
+	}
+	allowed, retryAfter := apiKeys.Allow(key)
+	if allowed || retryAfter <= 0 {
+		t.Errorf("超过次数应被限流: %v %v", allowed, retryAfter)
+	}
+}
+
+// revokingAPIKeyStore 读取密钥后立即执行revoke，模拟认证过程中密钥被吊销
+type revokingAPIKeyStore struct {
+	APIKeyStore
+	revoke func()
+}
+
+func (s *revokingAPIKeyStore) GetByHash(hash string) (*APIKeyRecord, error) {
+	record, err := s.APIKeyStore.GetByHash(hash)
+	if s.revoke != nil {
+		revoke := s.revoke
+		s.revoke = nil
+		revoke()
+	}
+	return record, err
+}
+
+// TestAPIKeyRevokeDuringAuthenticate 测试认证读取密钥后、更新使用时间前被吊销时，吊销不会被覆盖
+func TestAPIKeyRevokeDuringAuthenticate(t *testing.T) {
+	db, err := OpenBoltDB(filepath.Join(t.TempDir(), "keys.db"))
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+	boltStore, err := NewBoltAPIKeyStore(db)
+	if err != nil {
+		t.Fatalf("创建API密钥存储失败: %v", err)
+	}
+	store := &revokingAPIKeyStore{APIKeyStore: boltStore}
+	auth := NewAuthService(NewMemoryUserStore(), true)
+	apiKeys := NewAPIKeyService(store, auth)
+
+	teacher, _ := auth.Register("teacher", "test-password", "")
+	owner := Principal{UserID: teacher.ID, Role: models.RoleTeacher}
+	key, plaintext, err := apiKeys.Create(teacher.ID, "脚本", []string{models.ScopeGrade}, nil, 0)
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+
+	store.revoke = func() {
+		if _, err := apiKeys.Revoke(owner, key.ID); err != nil {
+			t.Errorf("吊销密钥失败: %v", err)
+		}
+	}
+	apiKeys.Authenticate(plaintext)
+
+	record, err := boltStore.Get(key.ID)
+	if err != nil {
+		t.Fatalf("读取密钥失败: %v", err)
+	}
+	if record.RevokedAt == nil {
+		t.Fatal("更新使用时间覆盖了吊销")
+	}
+	if _, _, err := apiKeys.Authenticate(plaintext); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("吊销后应返回ErrInvalidAPIKey: %v", err)
+	}
+}
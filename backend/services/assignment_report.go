@@ -0,0 +1,192 @@
+package services
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// passRatio 及格线占满分的比例
+const passRatio = 0.6
+
+// classScores 汇总一个班级成绩时的中间数据
+type classScores struct {
+	report    models.ClassReport
+	scores    []float64
+	questions map[string]*questionScores
+	// 多次批改的结果中表决的题数和判定不一致的题数
+	ensembleQuestions int
+	disagreements     int
+}
+
+// questionScores 一道题在班级中的累计得分
+type questionScores struct {
+	number    string
+	maxPoints float64
+	awarded   float64
+	count     int
+}
+
+// BuildAssignmentReport 按班级汇总引用了同一份作业的任务的成绩，tasks按开始时间从新到旧排列
+// 同一名学生（按名单匹配结果识别）被多次批改时只统计最新一次；各次批改的满分不同时按作业的满分换算
+// className用于显示班级名称，可以为nil
+func BuildAssignmentReport(assignment models.Assignment, tasks []*HomeworkTask, className func(classID string) string) models.AssignmentReport {
+	fullMarks := AssignmentScoringOptions(assignment.AssignmentSettings).FullMarks
+	report := models.AssignmentReport{
+		AssignmentID: assignment.ID,
+		Title:        assignment.Title,
+		FullMarks:    fullMarks,
+	}
+
+	// 题目按标准答案中的顺序排列，标准答案以外的题目按出现顺序排在后面
+	var questionOrder []string
+	seenQuestions := make(map[string]bool)
+	addQuestion := func(number string) string {
+		normalized := normalizeQuestionNumber(number)
+		if !seenQuestions[normalized] {
+			seenQuestions[normalized] = true
+			questionOrder = append(questionOrder, normalized)
+		}
+		return normalized
+	}
+	if key := AssignmentAnswerKey(assignment.AssignmentSettings); key != nil {
+		for _, item := range key.Items {
+			addQuestion(item.QuestionNumber)
+		}
+	}
+
+	var classOrder []string
+	classes := make(map[string]*classScores)
+	overall := &classScores{questions: make(map[string]*questionScores)}
+	seenStudents := make(map[string]bool)
+
+	for _, task := range tasks {
+		group, exists := classes[task.ClassID]
+		if !exists {
+			group = &classScores{
+				report:    models.ClassReport{ClassID: task.ClassID},
+				questions: make(map[string]*questionScores),
+			}
+			if className != nil && task.ClassID != "" {
+				group.report.ClassName = className(task.ClassID)
+			}
+			classes[task.ClassID] = group
+			classOrder = append(classOrder, task.ClassID)
+		}
+
+		for _, result := range task.StudentResults {
+			if result == nil {
+				continue
+			}
+			if result.RosterMatch != nil && result.RosterMatch.StudentID != "" {
+				if seenStudents[result.RosterMatch.StudentID] {
+					continue
+				}
+				seenStudents[result.RosterMatch.StudentID] = true
+			}
+			if result.Status == models.ResultStatusError {
+				group.report.Failed++
+				overall.report.Failed++
+				continue
+			}
+
+			score, err := strconv.ParseFloat(result.OverallScore, 64)
+			if err != nil {
+				continue
+			}
+			if result.FullMarks > 0 {
+				score = score / result.FullMarks * fullMarks
+			}
+			group.scores = append(group.scores, score)
+			overall.scores = append(overall.scores, score)
+			if result.Ensemble != nil {
+				for _, scores := range []*classScores{group, overall} {
+					scores.ensembleQuestions += result.Ensemble.Questions
+					scores.disagreements += result.Ensemble.Disagreements
+				}
+			}
+
+			for _, answer := range result.Answers {
+				if answer.MaxPoints == nil || *answer.MaxPoints <= 0 {
+					continue
+				}
+				number := addQuestion(answer.QuestionNumber)
+				awarded := 0.0
+				if answer.AwardedPoints != nil {
+					awarded = *answer.AwardedPoints
+				}
+				for _, scores := range []*classScores{group, overall} {
+					question, exists := scores.questions[number]
+					if !exists {
+						question = &questionScores{number: answer.QuestionNumber}
+						scores.questions[number] = question
+					}
+					question.maxPoints += *answer.MaxPoints
+					question.awarded += awarded
+					question.count++
+				}
+			}
+		}
+	}
+
+	// 班级按名称排列，未指定班级的一组排在最后
+	sort.SliceStable(classOrder, func(i, j int) bool {
+		a, b := classes[classOrder[i]], classes[classOrder[j]]
+		if (a.report.ClassID == "") != (b.report.ClassID == "") {
+			return b.report.ClassID == ""
+		}
+		return a.report.ClassName < b.report.ClassName
+	})
+
+	report.Classes = make([]models.ClassReport, 0, len(classOrder))
+	for _, classID := range classOrder {
+		report.Classes = append(report.Classes, classes[classID].summarize(questionOrder, fullMarks))
+	}
+	report.Overall = overall.summarize(questionOrder, fullMarks)
+	return report
+}
+
+// summarize 计算班级的平均分、中位数、最高分、最低分、及格率、每题得分率和多次批改判定不一致的比例
+func (s *classScores) summarize(questionOrder []string, fullMarks float64) models.ClassReport {
+	report := s.report
+	report.Students = len(s.scores)
+	if len(s.scores) > 0 {
+		sort.Float64s(s.scores)
+		total, passed := 0.0, 0
+		for _, score := range s.scores {
+			total += score
+			if score >= fullMarks*passRatio {
+				passed++
+			}
+		}
+		report.Average = roundScore(total / float64(len(s.scores)))
+		report.Lowest = roundScore(s.scores[0])
+		report.Highest = roundScore(s.scores[len(s.scores)-1])
+		middle := len(s.scores) / 2
+		if len(s.scores)%2 == 0 {
+			report.Median = roundScore((s.scores[middle-1] + s.scores[middle]) / 2)
+		} else {
+			report.Median = roundScore(s.scores[middle])
+		}
+		report.PassRate = roundScore(float64(passed) / float64(len(s.scores)))
+	}
+	if s.ensembleQuestions > 0 {
+		rate := roundScore(float64(s.disagreements) / float64(s.ensembleQuestions))
+		report.DisagreementRate = &rate
+	}
+
+	for _, number := range questionOrder {
+		question, exists := s.questions[number]
+		if !exists {
+			continue
+		}
+		report.Questions = append(report.Questions, models.QuestionReport{
+			QuestionNumber: question.number,
+			MaxPoints:      roundScore(question.maxPoints / float64(question.count)),
+			AveragePoints:  roundScore(question.awarded / float64(question.count)),
+			ScoreRate:      roundScore(question.awarded / question.maxPoints),
+		})
+	}
+	return report
+}
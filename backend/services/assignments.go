@@ -0,0 +1,363 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// assignmentsBucket 作业在BoltDB中的存储桶名称，按作业ID保存
+var assignmentsBucket = []byte("assignments")
+
+// ErrAssignmentNotFound 作业不存在
+var ErrAssignmentNotFound = errors.New("作业不存在")
+
+// maxAssignmentTitleLength 作业标题的长度限制
+const maxAssignmentTitleLength = 100
+
+// Assignments 教师创建的作业，数据保存在内存中，db不为nil时同时写入BoltDB
+type Assignments struct {
+	mutex       sync.RWMutex
+	db          *bolt.DB
+	assignments map[string]models.Assignment
+}
+
+// NewAssignments 创建作业存储，并加载已保存的作业；db为nil时不做持久化
+func NewAssignments(db *bolt.DB) (*Assignments, error) {
+	a := &Assignments{
+		db:          db,
+		assignments: make(map[string]models.Assignment),
+	}
+	if db == nil {
+		return a, nil
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(assignmentsBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var assignment models.Assignment
+			if err := json.Unmarshal(v, &assignment); err != nil {
+				log.Printf("[ERROR] 解析已保存的作业 %s 失败: %v", string(k), err)
+				return nil
+			}
+			a.assignments[assignment.ID] = assignment
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("加载作业失败: %v", err)
+	}
+
+	log.Printf("[INFO] 已加载 %d 份作业", len(a.assignments))
+	return a, nil
+}
+
+// List 返回match为true的作业，按创建时间从新到旧排列
+func (a *Assignments) List(match func(assignment models.Assignment) bool) []models.Assignment {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	assignments := make([]models.Assignment, 0, len(a.assignments))
+	for _, assignment := range a.assignments {
+		if match == nil || match(assignment) {
+			assignments = append(assignments, assignment)
+		}
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		return assignments[i].CreatedAt.After(assignments[j].CreatedAt)
+	})
+	return assignments
+}
+
+// Get 按作业ID获取作业，不存在时返回ErrAssignmentNotFound
+func (a *Assignments) Get(id string) (models.Assignment, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	assignment, exists := a.assignments[id]
+	if !exists {
+		return models.Assignment{}, ErrAssignmentNotFound
+	}
+	return assignment, nil
+}
+
+// Create 创建由teacherID管理的作业
+func (a *Assignments) Create(teacherID string, settings models.AssignmentSettings) (models.Assignment, error) {
+	if err := normalizeAssignmentSettings(&settings); err != nil {
+		return models.Assignment{}, err
+	}
+
+	now := time.Now()
+	assignment := models.Assignment{
+		ID:                 uuid.New().String(),
+		TeacherID:          teacherID,
+		AssignmentSettings: settings,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if err := a.put(assignment); err != nil {
+		return assignment, err
+	}
+	a.assignments[assignment.ID] = assignment
+
+	log.Printf("[INFO] 用户 %s 创建了作业 %s (%s)", teacherID, assignment.ID, assignment.Title)
+	return assignment, nil
+}
+
+// Update 以settings替换作业的全部设置，之后引用该作业的上传使用新的设置，已完成的批改结果不受影响
+func (a *Assignments) Update(id string, settings models.AssignmentSettings) (models.Assignment, error) {
+	if err := normalizeAssignmentSettings(&settings); err != nil {
+		return models.Assignment{}, err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	assignment, exists := a.assignments[id]
+	if !exists {
+		return assignment, ErrAssignmentNotFound
+	}
+	assignment.AssignmentSettings = settings
+	assignment.UpdatedAt = time.Now()
+
+	if err := a.put(assignment); err != nil {
+		return assignment, err
+	}
+	a.assignments[assignment.ID] = assignment
+	return assignment, nil
+}
+
+// SetAnswerKey 只替换作业的标准答案，用于从教师版PDF中提取标准答案
+func (a *Assignments) SetAnswerKey(id string, key *models.AnswerKey) (models.Assignment, error) {
+	if key != nil {
+		if err := validateAnswerKey(key); err != nil {
+			return models.Assignment{}, err
+		}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	assignment, exists := a.assignments[id]
+	if !exists {
+		return assignment, ErrAssignmentNotFound
+	}
+	assignment.AnswerKey = key
+	assignment.UpdatedAt = time.Now()
+
+	if err := a.put(assignment); err != nil {
+		return assignment, err
+	}
+	a.assignments[assignment.ID] = assignment
+	return assignment, nil
+}
+
+// Delete 删除作业，引用过该作业的任务和批改结果不受影响
+func (a *Assignments) Delete(id string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, exists := a.assignments[id]; !exists {
+		return ErrAssignmentNotFound
+	}
+	if a.db != nil {
+		err := a.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(assignmentsBucket).Delete([]byte(id))
+		})
+		if err != nil {
+			return fmt.Errorf("删除作业失败: %v", err)
+		}
+	}
+	delete(a.assignments, id)
+
+	log.Printf("[INFO] 已删除作业 %s", id)
+	return nil
+}
+
+// put 将作业写入BoltDB，调用方需要持有锁
+func (a *Assignments) put(assignment models.Assignment) error {
+	if a.db == nil {
+		return nil
+	}
+	data, err := json.Marshal(assignment)
+	if err != nil {
+		return fmt.Errorf("序列化作业失败: %v", err)
+	}
+	err = a.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(assignmentsBucket).Put([]byte(assignment.ID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("保存作业失败: %v", err)
+	}
+	return nil
+}
+
+// normalizeAssignmentSettings 检查作业设置，并为未填写的科目、页数和布局设置默认值
+func normalizeAssignmentSettings(settings *models.AssignmentSettings) error {
+	settings.Title = strings.TrimSpace(settings.Title)
+	if settings.Title == "" {
+		return fmt.Errorf("作业标题不能为空")
+	}
+	if utf8.RuneCountInString(settings.Title) > maxAssignmentTitleLength {
+		return fmt.Errorf("作业标题不能超过%d个字符", maxAssignmentTitleLength)
+	}
+
+	settings.Subject = strings.TrimSpace(settings.Subject)
+	if settings.Subject == "" {
+		settings.Subject = "general"
+	}
+	settings.Prompt = strings.TrimSpace(settings.Prompt)
+
+	if settings.PagesPerStudent < 0 {
+		return fmt.Errorf("每个学生的页数不能为负数")
+	}
+	if settings.PagesPerStudent == 0 {
+		settings.PagesPerStudent = 1
+	}
+	if settings.Layout == "" {
+		settings.Layout = LayoutSingle
+	}
+	if settings.Layout != LayoutSingle && settings.Layout != LayoutDouble {
+		return fmt.Errorf("布局方式只支持single和double")
+	}
+
+	if settings.FullMarks < 0 {
+		return fmt.Errorf("满分必须是大于0的数字: %v", settings.FullMarks)
+	}
+	settings.Rounding = strings.ToLower(strings.TrimSpace(settings.Rounding))
+	if settings.Rounding != "" && settings.Rounding != RoundingRound && settings.Rounding != RoundingFloor && settings.Rounding != RoundingCeil {
+		return fmt.Errorf("取整方式只支持round、floor和ceil: %s", settings.Rounding)
+	}
+	if settings.Precision != nil && (*settings.Precision < 0 || *settings.Precision > 4) {
+		return fmt.Errorf("小数位数必须是0-4之间的整数: %d", *settings.Precision)
+	}
+
+	if settings.AnswerKey != nil {
+		if err := validateAnswerKey(settings.AnswerKey); err != nil {
+			return err
+		}
+	}
+	if settings.Rubric != nil {
+		if err := validateRubric(settings.Rubric); err != nil {
+			return err
+		}
+	}
+	ensemble, err := normalizeEnsembleSettings(settings.Ensemble, settings.Subject)
+	if err != nil {
+		return err
+	}
+	settings.Ensemble = ensemble
+
+	seen := make(map[string]bool, len(settings.QuestionPoints))
+	for number, points := range settings.QuestionPoints {
+		normalized := normalizeQuestionNumber(number)
+		if normalized == "" {
+			return fmt.Errorf("每题分值中存在空的题号")
+		}
+		if seen[normalized] {
+			return fmt.Errorf("每题分值中的题号重复: %s", number)
+		}
+		seen[normalized] = true
+		if points < 0 || math.IsNaN(points) {
+			return fmt.Errorf("题号%s的分值不能为负数", number)
+		}
+	}
+
+	var classIDs []string
+	for _, classID := range settings.ClassIDs {
+		if classID = strings.TrimSpace(classID); classID != "" && !slices.Contains(classIDs, classID) {
+			classIDs = append(classIDs, classID)
+		}
+	}
+	settings.ClassIDs = classIDs
+	return nil
+}
+
+// AssignmentScoringOptions 返回作业的计分方式，未设置的项使用默认值
+// 未设置满分时作文取评分标准的权重之和，其他科目取标准答案的总分
+func AssignmentScoringOptions(settings models.AssignmentSettings) ScoringOptions {
+	opts := DefaultScoringOptions()
+	opts.FullMarks = settings.FullMarks
+	if settings.Subject == HomeworkTypeEssay {
+		rubric := settings.Rubric
+		if rubric == nil {
+			rubric = DefaultEssayRubric()
+		}
+		opts = opts.ResolveFullMarks(nil, rubric)
+	} else {
+		opts = opts.ResolveFullMarks(AssignmentAnswerKey(settings), nil)
+	}
+	if settings.Rounding != "" {
+		opts.Rounding = settings.Rounding
+	}
+	if settings.Precision != nil {
+		opts.Precision = *settings.Precision
+	}
+	return opts
+}
+
+// AssignmentAnswerKey 返回批改时使用的标准答案，每题分值中设置的题目覆盖标准答案中的分值
+func AssignmentAnswerKey(settings models.AssignmentSettings) *models.AnswerKey {
+	if settings.AnswerKey == nil {
+		return nil
+	}
+	points := questionPointsIndex(settings.QuestionPoints)
+	key := &models.AnswerKey{Items: slices.Clone(settings.AnswerKey.Items)}
+	for i := range key.Items {
+		if value, ok := points[normalizeQuestionNumber(key.Items[i].QuestionNumber)]; ok {
+			key.Items[i].Points = value
+		}
+	}
+	return key
+}
+
+// ApplyQuestionPoints 没有标准答案时按每题分值设置题目的满分，大模型给出的得分按满分等比换算
+// 标准答案已经判为不计分（满分为0）的题目不做修改，总得分由ScoreResult计算
+func ApplyQuestionPoints(result *models.HomeworkResult, questionPoints map[string]float64) {
+	points := questionPointsIndex(questionPoints)
+	if len(points) == 0 {
+		return
+	}
+
+	for i := range result.Answers {
+		answer := &result.Answers[i]
+		value, ok := points[normalizeQuestionNumber(answer.QuestionNumber)]
+		if !ok || (answer.MaxPoints != nil && *answer.MaxPoints == 0) {
+			continue
+		}
+		if answer.AwardedPoints != nil && answer.MaxPoints != nil && *answer.MaxPoints > 0 {
+			awarded := *answer.AwardedPoints / *answer.MaxPoints * value
+			answer.AwardedPoints = &awarded
+		}
+		maxPoints := value
+		answer.MaxPoints = &maxPoints
+	}
+}
+
+// questionPointsIndex 按规范化的题号索引每题分值
+func questionPointsIndex(questionPoints map[string]float64) map[string]float64 {
+	index := make(map[string]float64, len(questionPoints))
+	for number, points := range questionPoints {
+		index[normalizeQuestionNumber(number)] = points
+	}
+	return index
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// TestAssignmentSettings 测试作业设置的默认值和校验
+func TestAssignmentSettings(t *testing.T) {
+	assignments, _ := NewAssignments(nil)
+
+	assignment, err := assignments.Create("teacher-1", models.AssignmentSettings{
+		Title:    " 第三单元练习 ",
+		ClassIDs: []string{"class-1", "class-1", " "},
+	})
+	if err != nil {
+		t.Fatalf("创建作业失败: %v", err)
+	}
+	if assignment.Title != "第三单元练习" || assignment.Subject != "general" || assignment.PagesPerStudent != 1 ||
+		assignment.Layout != LayoutSingle || len(assignment.ClassIDs) != 1 {
+		t.Errorf("作业设置的默认值错误: %+v", assignment)
+	}
+
+	precision := 5
+	invalid := []models.AssignmentSettings{
+		{},
+		{Title: "练习", Layout: "triple"},
+		{Title: "练习", Rounding: "half"},
+		{Title: "练习", Precision: &precision},
+		{Title: "练习", QuestionPoints: map[string]float64{"1": -1}},
+		{Title: "练习", QuestionPoints: map[string]float64{"1": 1, "第1题": 2}},
+		{Title: "练习", AnswerKey: &models.AnswerKey{}},
+		{Title: "练习", Ensemble: &models.EnsembleSettings{Runs: 6}},
+		{Title: "作文", Subject: HomeworkTypeEssay, Ensemble: &models.EnsembleSettings{Runs: 3}},
+	}
+	for i, settings := range invalid {
+		if _, err := assignments.Update(assignment.ID, settings); err == nil {
+			t.Errorf("第%d组无效的设置应返回错误", i+1)
+		}
+	}
+	updated, err := assignments.Update(assignment.ID, models.AssignmentSettings{Title: "练习", Ensemble: &models.EnsembleSettings{CrossModel: true}})
+	if err != nil || updated.Ensemble.Runs != 2 {
+		t.Errorf("多模型批改默认批改2次: %+v %v", updated.Ensemble, err)
+	}
+	if _, err := assignments.Update("missing", models.AssignmentSettings{Title: "练习"}); err != ErrAssignmentNotFound {
+		t.Errorf("不存在的作业应返回ErrAssignmentNotFound，实际: %v", err)
+	}
+}
+
+// TestApplyQuestionPoints 测试没有标准答案时按每题分值计算总得分
+func TestApplyQuestionPoints(t *testing.T) {
+	correct, wrong := true, false
+	half, two := 0.5, 2.0
+	result := models.HomeworkResult{Answers: []models.HomeworkAnswer{
+		{QuestionNumber: "1", IsCorrect: &correct},
+		{QuestionNumber: "第2题", IsCorrect: &wrong, MaxPoints: &two, AwardedPoints: &half},
+		{QuestionNumber: "3", IsCorrect: &wrong},
+	}}
+
+	ApplyQuestionPoints(&result, map[string]float64{"1": 4, "2": 4})
+	ScoreResult(&result, DefaultScoringOptions())
+
+	// 第1题4分，第2题按2分制的0.5分换算为1分，第3题没有设置分值按1分计算：5/9
+	if result.OverallScore != "55.6" {
+		t.Errorf("总得分应按每题分值计算，实际: %s", result.OverallScore)
+	}
+}
+
+// TestBuildAssignmentReport 测试按班级汇总成绩，同一名学生被重复批改时只统计最新一次
+func TestBuildAssignmentReport(t *testing.T) {
+	assignment := models.Assignment{ID: "assignment-1", AssignmentSettings: models.AssignmentSettings{Title: "练习"}}
+	student := func(score, fullMarks float64, studentID string) *models.HomeworkResult {
+		result := &models.HomeworkResult{Status: models.ResultStatusGraded, FullMarks: fullMarks}
+		result.OverallScore = formatScore(score)
+		if studentID != "" {
+			result.RosterMatch = &models.RosterMatch{Status: models.RosterMatchExact, StudentID: studentID}
+		}
+		return result
+	}
+
+	// 任务按从新到旧排列，第二个任务中的学生s1已在第一个任务中重新批改
+	tasks := []*HomeworkTask{
+		{ClassID: "class-1", StudentResults: []*models.HomeworkResult{student(90, 100, "s1")}},
+		{ClassID: "class-1", StudentResults: []*models.HomeworkResult{student(50, 100, "s1"), student(30, 50, "s2"), nil}},
+		{ClassID: "", StudentResults: []*models.HomeworkResult{{Status: models.ResultStatusError}}},
+	}
+	tasks[1].StudentResults[1].Ensemble = &models.EnsembleSummary{Questions: 4, Disagreements: 1}
+	report := BuildAssignmentReport(assignment, tasks, func(classID string) string { return "一班" })
+
+	if len(report.Classes) != 2 || report.Classes[0].ClassName != "一班" || report.Classes[1].ClassID != "" {
+		t.Fatalf("班级分组错误: %+v", report.Classes)
+	}
+	// s1取90分，s2按50分制的30分换算为60分
+	class := report.Classes[0]
+	if class.Students != 2 || class.Average != 75 || class.Highest != 90 || class.Lowest != 60 || class.PassRate != 1 {
+		t.Errorf("班级成绩统计错误: %+v", class)
+	}
+	if class.DisagreementRate == nil || *class.DisagreementRate != 0.25 || report.Classes[1].DisagreementRate != nil {
+		t.Errorf("多次批改判定不一致的比例错误: %v", class.DisagreementRate)
+	}
+	if report.Overall.Students != 2 || report.Overall.Failed != 1 || report.Classes[1].Failed != 1 {
+		t.Errorf("合计成绩统计错误: %+v", report.Overall)
+	}
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidCredentials 用户名或密码错误
+	ErrInvalidCredentials = errors.New("用户名或密码错误")
+	// ErrRegistrationClosed 未开放注册
+	ErrRegistrationClosed = errors.New("未开放注册，请联系管理员创建账号")
+	// ErrLastAdmin 不能删除或降级最后一个管理员
+	ErrLastAdmin = errors.New("不能删除或降级最后一个管理员")
+)
+
+// 用户名和密码的长度限制
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+	minPasswordLength = 8
+	// bcrypt只使用密码的前72个字节
+	maxPasswordBytes = 72
+)
+
+// AuthService 账号的注册、登录和管理，密码以bcrypt哈希保存
+type AuthService struct {
+	store             UserStore
+	allowRegistration bool
+}
+
+// NewAuthService 创建账号服务
+// allowRegistration为false时只允许注册第一个账号（初始化系统的管理员），之后由管理员创建账号
+func NewAuthService(store UserStore, allowRegistration bool) *AuthService {
+	return &AuthService{
+		store:             store,
+		allowRegistration: allowRegistration,
+	}
+}
+
+// Register 注册账号，第一个账号为管理员，之后注册的账号为教师
+// 是否为第一个账号由存储在写入时判断，同时注册的两个账号只有一个成为管理员
+func (s *AuthService) Register(username, password, name string) (*models.User, error) {
+	// 未开放注册时先拒绝，避免为注册不了的账号计算密码哈希
+	if !s.allowRegistration {
+		count, err := s.store.Count()
+		if err != nil {
+			return nil, fmt.Errorf("读取用户失败: %v", err)
+		}
+		if count > 0 {
+			return nil, ErrRegistrationClosed
+		}
+	}
+
+	record, err := newUserRecord(username, password, name, models.RoleTeacher, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Register(record, !s.allowRegistration); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[INFO] 注册用户: %s (%s), 角色: %s", record.Username, record.ID, record.Role)
+	return &record.User, nil
+}
+
+// CreateUser 创建指定角色的账号，classIDs为只读用户可以查看的班级
+func (s *AuthService) CreateUser(username, password, name, role string, classIDs []string) (*models.User, error) {
+	record, err := newUserRecord(username, password, name, role, classIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Create(record); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[INFO] 创建用户: %s (%s), 角色: %s", record.Username, record.ID, record.Role)
+	return &record.User, nil
+}
+
+// newUserRecord 校验用户名、角色和密码，生成待保存的用户
+func newUserRecord(username, password, name, role string, classIDs []string) (*UserRecord, error) {
+	username = strings.TrimSpace(username)
+	if n := utf8.RuneCountInString(username); n < minUsernameLength || n > maxUsernameLength {
+		return nil, fmt.Errorf("用户名长度应为%d到%d个字符", minUsernameLength, maxUsernameLength)
+	}
+	if !models.ValidRole(role) {
+		return nil, fmt.Errorf("角色只支持admin、teacher和viewer")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &UserRecord{
+		User: models.User{
+			ID:        uuid.New().String(),
+			Username:  username,
+			Name:      strings.TrimSpace(name),
+			Role:      role,
+			ClassIDs:  classIDs,
+			CreatedAt: time.Now(),
+		},
+		PasswordHash: hash,
+	}
+	return record, nil
+}
+
+// hashPassword 校验密码长度并生成bcrypt哈希
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength || len(password) > maxPasswordBytes {
+		return "", fmt.Errorf("密码长度应为%d到%d个字符", minPasswordLength, maxPasswordBytes)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("生成密码哈希失败: %v", err)
+	}
+	return string(hash), nil
+}
+
+// UserUpdate 管理员修改账号的内容，为nil的字段保持不变
+type UserUpdate struct {
+	Name     *string   `json:"name"`
+	Role     *string   `json:"role"`
+	ClassIDs *[]string `json:"classIds"`
+	Password *string   `json:"password"`
+}
+
+// UpdateUser 修改账号的姓名、角色、可查看的班级或重置密码，不能取消最后一个管理员
+func (s *AuthService) UpdateUser(id string, update UserUpdate) (*models.User, error) {
+	record, err := s.store.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Role != nil && *update.Role != record.Role {
+		if !models.ValidRole(*update.Role) {
+			return nil, fmt.Errorf("角色只支持admin、teacher和viewer")
+		}
+		record.Role = *update.Role
+	}
+	if update.Name != nil {
+		record.Name = strings.TrimSpace(*update.Name)
+	}
+	if update.ClassIDs != nil {
+		record.ClassIDs = *update.ClassIDs
+	}
+	if update.Password != nil {
+		if record.PasswordHash, err = hashPassword(*update.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	// 是否还有其他管理员由存储在写入时检查
+	if err := s.store.Update(record); err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] 更新用户: %s (%s), 角色: %s", record.Username, record.ID, record.Role)
+	return &record.User, nil
+}
+
+// DeleteUser 删除账号，不能删除最后一个管理员
+func (s *AuthService) DeleteUser(id string) error {
+	record, err := s.store.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Delete(id); err != nil {
+		return err
+	}
+	log.Printf("[INFO] 删除用户: %s (%s)", record.Username, record.ID)
+	return nil
+}
+
+// ListUsers 返回所有账号
+func (s *AuthService) ListUsers() ([]models.User, error) {
+	records, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+	users := make([]models.User, 0, len(records))
+	for _, record := range records {
+		users = append(users, record.User)
+	}
+	return users, nil
+}
+
+// Login 校验用户名和密码，成功时返回用户
+func (s *AuthService) Login(username, password string) (*models.User, error) {
+	record, err := s.store.GetByUsername(username)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取用户失败: %v", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &record.User, nil
+}
+
+// GetUser 按用户ID获取用户
+func (s *AuthService) GetUser(id string) (*models.User, error) {
+	record, err := s.store.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return &record.User, nil
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestAuthServiceRegisterAndLogin 测试注册、登录和关闭注册时只允许注册第一个账号
+func TestAuthServiceRegisterAndLogin(t *testing.T) {
+	db, err := OpenBoltDB(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+	store, err := NewBoltUserStore(db)
+	if err != nil {
+		t.Fatalf("创建用户存储失败: %v", err)
+	}
+	auth := NewAuthService(store, false)
+
+	if _, err := auth.Register("zhang", "short", ""); err == nil {
+		t.Error("密码过短应返回错误")
+	}
+
+	user, err := auth.Register("Zhang", "correct-password", "张老师")
+	if err != nil {
+		t.Fatalf("注册第一个账号失败: %v", err)
+	}
+	// 第一个账号为管理员
+	if user.Role != "admin" || user.ID == "" {
+		t.Errorf("用户信息错误: %+v", user)
+	}
+	if _, err := auth.Register("lisi", "correct-password", ""); !errors.Is(err, ErrRegistrationClosed) {
+		t.Errorf("未开放注册时预期ErrRegistrationClosed，实际: %v", err)
+	}
+
+	// 开放注册后用户名仍不能重复（不区分大小写）
+	auth = NewAuthService(store, true)
+	if _, err := auth.Register("zhang", "another-password", ""); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("重复的用户名预期ErrUsernameTaken，实际: %v", err)
+	}
+
+	// 不能降级或删除最后一个管理员
+	role := "teacher"
+	if _, err := auth.UpdateUser(user.ID, UserUpdate{Role: &role}); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("降级最后一个管理员预期ErrLastAdmin，实际: %v", err)
+	}
+	if err := auth.DeleteUser(user.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("删除最后一个管理员预期ErrLastAdmin，实际: %v", err)
+	}
+
+	if _, err := auth.Login("zhang", "wrong-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("密码错误预期ErrInvalidCredentials，实际: %v", err)
+	}
+	if _, err := auth.Login("nobody", "correct-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("用户不存在预期ErrInvalidCredentials，实际: %v", err)
+	}
+	loggedIn, err := auth.Login("zhang", "correct-password")
+	if err != nil || loggedIn.ID != user.ID {
+		t.Errorf("登录失败: %+v %v", loggedIn, err)
+	}
+
+	// 存储中只保存密码哈希
+	record, err := store.GetByID(user.ID)
+	if err != nil || record.PasswordHash == "" || record.PasswordHash == "correct-password" {
+		t.Errorf("密码未以哈希保存: %+v %v", record, err)
+	}
+}
+
+// TestAuthServiceConcurrentFirstRegistration 测试同时注册第一个账号时只有一个成为管理员
+func TestAuthServiceConcurrentFirstRegistration(t *testing.T) {
+	for _, allowRegistration := range []bool{true, false} {
+		db, err := OpenBoltDB(filepath.Join(t.TempDir(), "users.db"))
+		if err != nil {
+			t.Fatalf("打开数据库失败: %v", err)
+		}
+		defer db.Close()
+		store, err := NewBoltUserStore(db)
+		if err != nil {
+			t.Fatalf("创建用户存储失败: %v", err)
+		}
+		auth := NewAuthService(store, allowRegistration)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				auth.Register(fmt.Sprintf("user%d", i), "correct-password", "")
+			}(i)
+		}
+		wg.Wait()
+
+		users, err := auth.ListUsers()
+		if err != nil {
+			t.Fatalf("加载用户失败: %v", err)
+		}
+		admins := 0
+		for _, user := range users {
+			if user.Role == "admin" {
+				admins++
+			}
+		}
+		// 未开放注册时只有第一个账号注册成功
+		expected := 5
+		if !allowRegistration {
+			expected = 1
+		}
+		if len(users) != expected || admins != 1 {
+			t.Errorf("开放注册=%v: 预期%d个账号且只有1个管理员，实际%d个账号、%d个管理员", allowRegistration, expected, len(users), admins)
+		}
+	}
+}
+
+// TestAuthServiceConcurrentAdminDemotion 测试两个管理员同时被降级和删除时至少保留一个管理员
+func TestAuthServiceConcurrentAdminDemotion(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		db, err := OpenBoltDB(filepath.Join(t.TempDir(), "users.db"))
+		if err != nil {
+			t.Fatalf("打开数据库失败: %v", err)
+		}
+		defer db.Close()
+		store, err := NewBoltUserStore(db)
+		if err != nil {
+			t.Fatalf("创建用户存储失败: %v", err)
+		}
+		auth := NewAuthService(store, true)
+
+		first, err := auth.Register("first", "correct-password", "")
+		if err != nil {
+			t.Fatalf("注册账号失败: %v", err)
+		}
+		second, err := auth.CreateUser("second", "correct-password", "", "admin", nil)
+		if err != nil {
+			t.Fatalf("创建管理员失败: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			role := "teacher"
+			auth.UpdateUser(first.ID, UserUpdate{Role: &role})
+		}()
+		go func() {
+			defer wg.Done()
+			auth.DeleteUser(second.ID)
+		}()
+		wg.Wait()
+
+		users, err := auth.ListUsers()
+		if err != nil {
+			t.Fatalf("加载用户失败: %v", err)
+		}
+		admins := 0
+		for _, user := range users {
+			if user.Role == "admin" {
+				admins++
+			}
+		}
+		if admins != 1 {
+			t.Fatalf("第%d轮: 预期保留1个管理员，实际%d个", i, admins)
+		}
+	}
+}
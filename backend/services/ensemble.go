@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// 每份作业的批改次数范围，未设置时单模型批改3次、多模型交替批改2次
+const (
+	minEnsembleRuns = 2
+	maxEnsembleRuns = 5
+)
+
+// normalizeEnsembleSettings 设置多次批改的默认次数并校验，返回新的设置而不修改传入的值
+func normalizeEnsembleSettings(ensemble *models.EnsembleSettings, subject string) (*models.EnsembleSettings, error) {
+	if ensemble == nil {
+		return nil, nil
+	}
+	if subject == HomeworkTypeEssay {
+		return nil, fmt.Errorf("作文按评分项计分，不支持多次批改")
+	}
+
+	normalized := *ensemble
+	if normalized.Runs == 0 {
+		normalized.Runs = 3
+		if normalized.CrossModel {
+			normalized.Runs = 2
+		}
+	}
+	if normalized.Runs < minEnsembleRuns || normalized.Runs > maxEnsembleRuns {
+		return nil, fmt.Errorf("批改次数必须是%d-%d之间的整数: %d", minEnsembleRuns, maxEnsembleRuns, normalized.Runs)
+	}
+	return &normalized, nil
+}
+
+// GradeEnsemble 由providers轮流批改runs次，再逐题按多数表决合并为一个批改结果
+// 部分批改失败时用成功的批改表决，全部失败时返回最后一次的错误；ctx取消时立即返回
+func GradeEnsemble(ctx context.Context, providers []LLMProvider, runs int, grade func(ctx context.Context, llm LLMProvider) (models.HomeworkResult, error)) (models.HomeworkResult, error) {
+	var results []models.HomeworkResult
+	var sources []string
+	var lastErr error
+
+	for run := 0; run < runs; run++ {
+		llm := providers[run%len(providers)]
+		result, err := grade(ctx, llm)
+		if err != nil {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			log.Printf("[WARN] 第%d/%d次批改失败 (%s): %v", run+1, runs, llm.Name(), err)
+			lastErr = err
+			continue
+		}
+		results = append(results, result)
+		sources = append(sources, fmt.Sprintf("%s#%d", llm.Name(), run+1))
+	}
+	if len(results) == 0 {
+		return models.HomeworkResult{}, lastErr
+	}
+
+	merged := ReconcileResults(results, sources)
+	merged.Ensemble.FailedRuns = runs - len(results)
+	log.Printf("[INFO] %d次批改的表决完成，判定不一致的题目: %d/%d", len(results), merged.Ensemble.Disagreements, merged.Ensemble.Questions)
+	return merged, nil
+}
+
+// ReconcileResults 合并同一份作业的多次批改结果：逐题按对错多数表决，票数相同时以较早的批改为准，
+// 采用多数一方中第一次批改的答案、得分和评语；某次批改缺少这道题时视为不同意见。
+// 判定不一致的题目记录每次的判定，题目的把握取各次批改的一致比例（大模型给出的把握更低时保留较低的值）。
+// 姓名、班级和整体评价取第一次批改的结果，姓名没有识别出时使用其他批改识别出的姓名
+func ReconcileResults(results []models.HomeworkResult, sources []string) models.HomeworkResult {
+	merged := results[0]
+	for _, result := range results[1:] {
+		if strings.TrimSpace(merged.Name) == "" {
+			merged.Name = result.Name
+		}
+		if strings.TrimSpace(merged.Class) == "" {
+			merged.Class = result.Class
+		}
+	}
+
+	// 每道题在各次批改中的答案，按题号首次出现的顺序排列
+	var order []string
+	votes := make(map[string][]*models.HomeworkAnswer)
+	for run := range results {
+		for i := range results[run].Answers {
+			answer := &results[run].Answers[i]
+			number := normalizeQuestionNumber(answer.QuestionNumber)
+			if votes[number] == nil {
+				votes[number] = make([]*models.HomeworkAnswer, len(results))
+				order = append(order, number)
+			}
+			if votes[number][run] == nil {
+				votes[number][run] = answer
+			}
+		}
+	}
+
+	summary := &models.EnsembleSummary{Sources: sources, Questions: len(order)}
+	merged.Answers = make([]models.HomeworkAnswer, 0, len(order))
+	for _, number := range order {
+		answers := votes[number]
+		counts := make(map[string]int)
+		for _, answer := range answers {
+			if answer != nil {
+				counts[verdictKey(answer.IsCorrect)]++
+			}
+		}
+
+		// 票数最多的判定，票数相同时取较早的批改
+		var winner *models.HomeworkAnswer
+		illegible := false
+		for _, answer := range answers {
+			if answer == nil {
+				continue
+			}
+			illegible = illegible || answer.Illegible
+			if winner == nil || counts[verdictKey(answer.IsCorrect)] > counts[verdictKey(winner.IsCorrect)] {
+				winner = answer
+			}
+		}
+
+		answer := *winner
+		answer.Illegible = illegible
+		agreement := float64(counts[verdictKey(winner.IsCorrect)]) / float64(len(answers))
+		if answer.Confidence == nil || *answer.Confidence > agreement {
+			answer.Confidence = &agreement
+		}
+		if agreement < 1 {
+			summary.Disagreements++
+			answer.Votes = make([]models.EnsembleVote, len(answers))
+			for run, vote := range answers {
+				answer.Votes[run].Source = sources[run]
+				if vote != nil {
+					answer.Votes[run].StudentAnswer = vote.StudentAnswer
+					answer.Votes[run].IsCorrect = vote.IsCorrect
+					answer.Votes[run].AwardedPoints = vote.AwardedPoints
+				}
+			}
+		}
+		merged.Answers = append(merged.Answers, answer)
+	}
+
+	if summary.Questions > 0 {
+		summary.DisagreementRate = roundScore(float64(summary.Disagreements) / float64(summary.Questions))
+	}
+	merged.Ensemble = summary
+	return merged
+}
+
+// verdictKey 表决时比较的判定，没有判定对错的答案单独作为一种意见
+func verdictKey(isCorrect *bool) string {
+	if isCorrect == nil {
+		return ""
+	}
+	return fmt.Sprint(*isCorrect)
+}
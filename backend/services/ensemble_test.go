@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// TestReconcileResults 测试逐题多数表决、票数相同时以较早的批改为准，并记录判定不一致的题目
+func TestReconcileResults(t *testing.T) {
+	correct, wrong := true, false
+	one, zero := 1.0, 0.0
+	answer := func(number string, isCorrect *bool) models.HomeworkAnswer {
+		awarded := &zero
+		if *isCorrect {
+			awarded = &one
+		}
+		return models.HomeworkAnswer{QuestionNumber: number, IsCorrect: isCorrect, MaxPoints: &one, AwardedPoints: awarded}
+	}
+
+	results := []models.HomeworkResult{
+		{Name: "", Answers: []models.HomeworkAnswer{answer("1", &correct), answer("2", &wrong), answer("3", &correct)}},
+		{Name: "张三", Answers: []models.HomeworkAnswer{answer("1", &correct), answer("第2题", &correct), answer("3", &wrong)}},
+		{Name: "张三", Answers: []models.HomeworkAnswer{answer("1", &correct), answer("2", &correct)}},
+	}
+	merged := ReconcileResults(results, []string{"fake#1", "fake#2", "fake#3"})
+
+	if merged.Name != "张三" || len(merged.Answers) != 3 {
+		t.Fatalf("合并结果错误: %+v", merged)
+	}
+	if first := merged.Answers[0]; !*first.IsCorrect || first.Votes != nil || *first.Confidence != 1 {
+		t.Errorf("第1题三次一致，不应记录判定: %+v", first)
+	}
+	if second := merged.Answers[1]; !*second.IsCorrect || len(second.Votes) != 3 || *second.Votes[0].IsCorrect || second.Confidence == nil || roundScore(*second.Confidence) != 0.67 {
+		t.Errorf("第2题应按多数判为正确并记录每次的判定: %+v", second)
+	}
+	// 第3题第三次批改缺少，两次意见各一票，以第一次批改为准
+	if third := merged.Answers[2]; !*third.IsCorrect || third.Votes[2].IsCorrect != nil {
+		t.Errorf("第3题票数相同时应以第一次批改为准: %+v", third)
+	}
+	if summary := merged.Ensemble; summary.Questions != 3 || summary.Disagreements != 2 || summary.DisagreementRate != 0.67 {
+		t.Errorf("表决情况错误: %+v", summary)
+	}
+}
+
+// TestGradeEnsemble 测试多模型交替批改，部分批改失败时用成功的批改表决
+func TestGradeEnsemble(t *testing.T) {
+	primary := NewFakeLLMProvider(FakeLLMResponse{Text: "primary"})
+	secondary := NewFakeLLMProvider(FakeLLMResponse{Error: "服务不可用"})
+
+	var graded []string
+	grade := func(ctx context.Context, llm LLMProvider) (models.HomeworkResult, error) {
+		text, err := llm.GenerateContent(ctx, "", "")
+		if err != nil {
+			return models.HomeworkResult{}, err
+		}
+		graded = append(graded, text)
+		return models.HomeworkResult{Name: text, Answers: []models.HomeworkAnswer{}}, nil
+	}
+
+	result, err := GradeEnsemble(context.Background(), []LLMProvider{primary, secondary}, 3, grade)
+	if err != nil {
+		t.Fatalf("批改失败: %v", err)
+	}
+	if len(graded) != 2 || len(secondary.Calls()) != 1 {
+		t.Errorf("应交替调用两个大模型: %v", graded)
+	}
+	if summary := result.Ensemble; summary.FailedRuns != 1 || fmt.Sprint(summary.Sources) != "[fake#1 fake#3]" {
+		t.Errorf("表决情况错误: %+v", summary)
+	}
+
+	if _, err := GradeEnsemble(context.Background(), []LLMProvider{secondary}, 2, grade); err == nil {
+		t.Error("全部批改失败时应返回错误")
+	}
+}
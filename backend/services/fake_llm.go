@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProviderFake 回放预设响应的假大模型，用于离线的端到端测试
+const ProviderFake = "fake"
+
+// FakeLLMResponse 一条预设的模型响应
+type FakeLLMResponse struct {
+	// Match 命中条件：请求的文件名、提示词或系统指令包含该字符串时命中，为空时匹配任意请求
+	Match string `json:"match"`
+	// Text 返回的文本，可以是故意损坏的JSON
+	Text string `json:"text"`
+	// Error 不为空时返回该错误
+	Error string `json:"error,omitempty"`
+	// Blocked 模拟内容被安全策略拦截
+	Blocked bool `json:"blocked,omitempty"`
+	// Timeout 模拟请求超时（在DelayMs之后返回超时错误）
+	Timeout bool `json:"timeout,omitempty"`
+	// DelayMs 返回前等待的毫秒数
+	DelayMs int `json:"delayMs,omitempty"`
+	// Times 最多命中的次数，0表示不限；可用于模拟"前几次失败、之后成功"
+	Times int `json:"times,omitempty"`
+}
+
+// FakeLLMScript 假大模型的响应脚本，按顺序匹配
+type FakeLLMScript struct {
+	Responses []FakeLLMResponse `json:"responses"`
+}
+
+// FakeLLMCall 记录一次对假大模型的调用
+type FakeLLMCall struct {
+	SystemInstruction string
+	Prompt            string
+	FileName          string
+	MimeType          string
+}
+
+// FakeLLMProvider 按脚本回放响应的大模型服务提供方
+type FakeLLMProvider struct {
+	mutex     sync.Mutex
+	responses []FakeLLMResponse
+	hits      []int
+	calls     []FakeLLMCall
+}
+
+// NewFakeLLMProvider 使用预设响应创建假大模型
+func NewFakeLLMProvider(responses ...FakeLLMResponse) *FakeLLMProvider {
+	return &FakeLLMProvider{
+		responses: responses,
+		hits:      make([]int, len(responses)),
+	}
+}
+
+// LoadFakeLLMScript 从JSON文件加载响应脚本
+func LoadFakeLLMScript(path string) (*FakeLLMScript, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取假大模型脚本失败: %v", err)
+	}
+
+	var script FakeLLMScript
+	if err := json.Unmarshal(content, &script); err != nil {
+		return nil, fmt.Errorf("解析假大模型脚本失败: %v", err)
+	}
+	return &script, nil
+}
+
+// NewFakeLLMProviderFromEnv 使用FAKE_LLM_SCRIPT环境变量指定的脚本创建假大模型
+func NewFakeLLMProviderFromEnv() (*FakeLLMProvider, error) {
+	scriptPath := os.Getenv("FAKE_LLM_SCRIPT")
+	if scriptPath == "" {
+		return nil, fmt.Errorf("未设置FAKE_LLM_SCRIPT环境变量")
+	}
+
+	script, err := LoadFakeLLMScript(scriptPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewFakeLLMProvider(script.Responses...), nil
+}
+
+// Name 返回提供方名称
+func (p *FakeLLMProvider) Name() string {
+	return ProviderFake
+}
+
+// Calls 返回已记录的调用
+func (p *FakeLLMProvider) Calls() []FakeLLMCall {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	calls := make([]FakeLLMCall, len(p.calls))
+	copy(calls, p.calls)
+	return calls
+}
+
+// Respond 记录调用并返回命中的原始响应文本（不做JSON修复），等待期间ctx被取消时返回ctx的错误
+func (p *FakeLLMProvider) Respond(ctx context.Context, call FakeLLMCall) (string, error) {
+	p.mutex.Lock()
+	p.calls = append(p.calls, call)
+
+	key := strings.Join([]string{call.FileName, call.Prompt, call.SystemInstruction}, "\n")
+	var matched *FakeLLMResponse
+	for i := range p.responses {
+		resp := &p.responses[i]
+		if resp.Times > 0 && p.hits[i] >= resp.Times {
+			continue
+		}
+		if resp.Match == "" || strings.Contains(key, resp.Match) {
+			p.hits[i]++
+			matched = resp
+			break
+		}
+	}
+	p.mutex.Unlock()
+
+	if matched == nil {
+		log.Printf("[ERROR] 假大模型没有匹配的响应, 文件: %s", call.FileName)
+		return "", fmt.Errorf("假大模型没有匹配的响应")
+	}
+
+	if err := SleepWithContext(ctx, time.Duration(matched.DelayMs)*time.Millisecond); err != nil {
+		return "", fmt.Errorf("AI服务请求失败: %w", err)
+	}
+
+	switch {
+	case matched.Timeout:
+		return "", fmt.Errorf("AI服务请求失败: %w", context.DeadlineExceeded)
+	case matched.Blocked:
+		return "", fmt.Errorf("内容被安全策略限制")
+	case matched.Error != "":
+		return "", fmt.Errorf("AI服务请求失败: %s", matched.Error)
+	}
+	return matched.Text, nil
+}
+
+// GenerateContent 使用文本内容生成回复
+func (p *FakeLLMProvider) GenerateContent(ctx context.Context, systemInstruction, textPrompt string) (string, error) {
+	text, err := p.Respond(ctx, FakeLLMCall{SystemInstruction: systemInstruction, Prompt: textPrompt})
+	if err != nil {
+		return "", err
+	}
+	return normalizeModelResponse(text), nil
+}
+
+// GenerateContentWithFile 使用文件内容生成回复
+func (p *FakeLLMProvider) GenerateContentWithFile(ctx context.Context, systemInstruction, filePath, mimeType, textPrompt string) (string, error) {
+	text, err := p.respondWithFile(ctx, systemInstruction, filePath, mimeType, textPrompt)
+	if err != nil {
+		return "", err
+	}
+	return normalizeModelResponse(text), nil
+}
+
+// GenerateJSONWithFile 使用文件内容生成JSON回复，原样返回脚本文本，便于测试校验失败的情况
+func (p *FakeLLMProvider) GenerateJSONWithFile(ctx context.Context, systemInstruction, filePath, mimeType, textPrompt string, schema *ResponseSchema) (string, error) {
+	text, err := p.respondWithFile(ctx, systemInstruction, filePath, mimeType, textPrompt)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// respondWithFile 检查文件后按脚本回放响应
+func (p *FakeLLMProvider) respondWithFile(ctx context.Context, systemInstruction, filePath, mimeType, textPrompt string) (string, error) {
+	if _, err := os.Stat(filePath); err != nil {
+		return "", fmt.Errorf("文件检查失败: %v", err)
+	}
+
+	return p.Respond(ctx, FakeLLMCall{
+		SystemInstruction: systemInstruction,
+		Prompt:            textPrompt,
+		FileName:          filepath.Base(filePath),
+		MimeType:          mimeType,
+	})
+}
+
+// GenerateContentStream 将命中的响应作为单个片段返回
+func (p *FakeLLMProvider) GenerateContentStream(ctx context.Context, systemInstruction, prompt string, onChunk func(chunk string) error) error {
+	text, err := p.Respond(ctx, FakeLLMCall{SystemInstruction: systemInstruction, Prompt: prompt})
+	if err != nil {
+		return err
+	}
+	return onChunk(text)
+}
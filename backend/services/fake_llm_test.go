@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFakeLLMProvider 测试假大模型按脚本回放响应
+func TestFakeLLMProvider(t *testing.T) {
+	dir := t.TempDir()
+	newFile := func(name string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("%PDF-1.4"), 0644); err != nil {
+			t.Fatalf("创建测试文件失败: %v", err)
+		}
+		return path
+	}
+
+	provider := NewFakeLLMProvider(
+		// 损坏的JSON：前后多余的说明文字 + 尾部多余逗号
+		FakeLLMResponse{Match: "student_1.pdf", Text: "以下是批改结果：\n{\"name\": \"张三\", \"answers\": [{\"questionNumber\": \"1\"},]}\n请查收"},
+		// 第一次超时，之后走默认响应
+		FakeLLMResponse{Match: "student_2.pdf", Timeout: true, Times: 1},
+		FakeLLMResponse{Match: "student_3.pdf", Blocked: true},
+		FakeLLMResponse{Text: `{"name": "默认"}`},
+	)
+
+	result, err := provider.GenerateContentWithFile(context.Background(), "系统指令", newFile("student_1.pdf"), "application/pdf", "请批改")
+	if err != nil {
+		t.Fatalf("预期成功，但得到错误: %v", err)
+	}
+	if !strings.Contains(result, `"name": "张三"`) || strings.Contains(result, "请查收") || strings.Contains(result, "},]") {
+		t.Errorf("损坏的JSON没有被修复: %s", result)
+	}
+
+	student2 := newFile("student_2.pdf")
+	if _, err := provider.GenerateContentWithFile(context.Background(), "", student2, "application/pdf", ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("预期超时错误，实际: %v", err)
+	}
+	if result, err := provider.GenerateContentWithFile(context.Background(), "", student2, "application/pdf", ""); err != nil || result != `{"name": "默认"}` {
+		t.Errorf("重试时预期返回默认响应，实际: %s, %v", result, err)
+	}
+
+	if _, err := provider.GenerateContentWithFile(context.Background(), "", newFile("student_3.pdf"), "application/pdf", ""); err == nil || !strings.Contains(err.Error(), "安全策略") {
+		t.Errorf("预期安全策略错误，实际: %v", err)
+	}
+
+	calls := provider.Calls()
+	if len(calls) != 4 || calls[0].FileName != "student_1.pdf" || calls[0].SystemInstruction != "系统指令" {
+		t.Errorf("调用记录错误: %+v", calls)
+	}
+}
+
+// TestFakeLLMProviderNoMatch 测试没有匹配的响应时返回错误
+func TestFakeLLMProviderNoMatch(t *testing.T) {
+	provider := NewFakeLLMProvider(FakeLLMResponse{Match: "数学", Text: "{}"})
+
+	if _, err := provider.GenerateContent(context.Background(), "", "语文作业"); err == nil {
+		t.Error("预期没有匹配的响应时返回错误")
+	}
+}
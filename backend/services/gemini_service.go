@@ -1,41 +1,66 @@
 package services
 
 import (
+	"context"
 	"log"
 )
 
-// GeminiService 是对Vertex AI的封装
+// GeminiService 是对大模型服务提供方的封装
+// 具体使用Vertex AI还是OpenAI兼容接口由LLM_PROVIDER环境变量决定
 type GeminiService struct {
-	vertexClient *VertexAIClient
+	provider LLMProvider
 }
 
 // NewGeminiService 创建新的Gemini服务
 func NewGeminiService() (*GeminiService, error) {
 	log.Println("初始化Gemini服务...")
-	
-	// 创建Vertex AI客户端
-	vertexClient := NewVertexAIClient()
-	
-	service := &GeminiService{
-		vertexClient: vertexClient,
+
+	// 根据配置创建大模型服务提供方
+	provider, err := NewLLMProviderFromEnv()
+	if err != nil {
+		return nil, err
 	}
-	
+
+	service := NewGeminiServiceWithProvider(provider)
+
 	log.Println("Gemini服务初始化完成")
-	
+
 	return service, nil
 }
 
+// NewGeminiServiceWithProvider 使用指定的大模型服务提供方创建服务
+func NewGeminiServiceWithProvider(provider LLMProvider) *GeminiService {
+	return &GeminiService{
+		provider: provider,
+	}
+}
+
+// Name 返回当前使用的提供方名称
+func (s *GeminiService) Name() string {
+	return s.provider.Name()
+}
+
 // GenerateContent 生成内容
-func (s *GeminiService) GenerateContent(systemInstruction, prompt string) (string, error) {
-	return s.vertexClient.GenerateContent(systemInstruction, prompt)
+func (s *GeminiService) GenerateContent(ctx context.Context, systemInstruction, prompt string) (string, error) {
+	return s.provider.GenerateContent(ctx, systemInstruction, prompt)
 }
 
 // GenerateContentWithFile 使用文件生成内容
-func (s *GeminiService) GenerateContentWithFile(systemInstruction, filePath, mimeType, prompt string) (string, error) {
-	return s.vertexClient.GenerateContentWithFile(systemInstruction, filePath, mimeType, prompt)
+func (s *GeminiService) GenerateContentWithFile(ctx context.Context, systemInstruction, filePath, mimeType, prompt string) (string, error) {
+	return s.provider.GenerateContentWithFile(ctx, systemInstruction, filePath, mimeType, prompt)
+}
+
+// GenerateJSONWithFile 使用文件生成受schema约束的JSON内容
+func (s *GeminiService) GenerateJSONWithFile(ctx context.Context, systemInstruction, filePath, mimeType, prompt string, schema *ResponseSchema) (string, error) {
+	return s.provider.GenerateJSONWithFile(ctx, systemInstruction, filePath, mimeType, prompt, schema)
+}
+
+// GenerateContentStream 流式生成内容
+func (s *GeminiService) GenerateContentStream(ctx context.Context, systemInstruction, prompt string, onChunk func(chunk string) error) error {
+	return s.provider.GenerateContentStream(ctx, systemInstruction, prompt, onChunk)
 }
 
 // BuildHomeworkAnalysisPrompt 构建作业分析提示词
 func (s *GeminiService) BuildHomeworkAnalysisPrompt(homeworkType string, imageContent string) string {
 	return BuildHomeworkAnalysisPrompt(homeworkType, imageContent)
-} 
\ No newline at end of file
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// ParseHomeworkResult 将大模型返回的文本解析为单个学生的批改结果
+// 模型偶尔会把单个学生的结果包在数组里，此时取第一个元素
+func ParseHomeworkResult(text string) (models.HomeworkResult, error) {
+	var result models.HomeworkResult
+
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "[") {
+		var results []models.HomeworkResult
+		if err := json.Unmarshal([]byte(text), &results); err != nil {
+			return result, fmt.Errorf("解析批改结果失败: %v", err)
+		}
+		if len(results) == 0 {
+			return result, fmt.Errorf("批改结果为空数组")
+		}
+		result = results[0]
+	} else {
+		if !strings.HasPrefix(text, "{") {
+			return result, fmt.Errorf("批改结果不是JSON对象")
+		}
+		if err := json.Unmarshal([]byte(text), &result); err != nil {
+			return result, fmt.Errorf("解析批改结果失败: %v", err)
+		}
+	}
+
+	if result.Answers == nil {
+		result.Answers = []models.HomeworkAnswer{}
+	}
+	result.Status = models.ResultStatusGraded
+	result.Error = ""
+	return result, nil
+}
+
+// NewErrorResult 创建一个批改失败的学生结果，使失败的学生在结果中显式出现
+func NewErrorResult(studentIndex int, errMsg string) models.HomeworkResult {
+	return models.HomeworkResult{
+		StudentIndex: studentIndex,
+		Name:         fmt.Sprintf("学生%d", studentIndex+1),
+		Answers:      []models.HomeworkAnswer{},
+		Feedback:     "批改失败: " + errMsg,
+		Status:       models.ResultStatusError,
+		Error:        errMsg,
+	}
+}
+
+// maxSchemaRetries 批改结果不符合schema时，带纠正提示词重新请求的最大次数
+const maxSchemaRetries = 2
+
+// GradeHomeworkFile 以schema约束的JSON模式批改单个学生的作业文件
+// 返回结果不符合HomeworkResultSchema时，把校验错误附加到提示词中重新请求；
+// 调用大模型本身失败时直接返回错误，由调用方决定是否重试
+func GradeHomeworkFile(ctx context.Context, llm LLMProvider, systemInstruction, filePath, mimeType, textPrompt string) (models.HomeworkResult, error) {
+	return gradeWithSchema(ctx, llm, systemInstruction, filePath, mimeType, textPrompt, HomeworkResultSchema, ValidateHomeworkResult)
+}
+
+// gradeWithSchema 按schema请求大模型并用validate校验输出，校验失败时带纠正提示词重新请求
+func gradeWithSchema(ctx context.Context, llm LLMProvider, systemInstruction, filePath, mimeType, textPrompt string, schema *ResponseSchema, validate func(text string) (models.HomeworkResult, error)) (models.HomeworkResult, error) {
+	var result models.HomeworkResult
+	prompt := textPrompt
+
+	for attempt := 0; attempt <= maxSchemaRetries; attempt++ {
+		response, err := llm.GenerateJSONWithFile(ctx, systemInstruction, filePath, mimeType, prompt, schema)
+		if err != nil {
+			return result, err
+		}
+
+		result, err = validate(response)
+		if err == nil {
+			return result, nil
+		}
+
+		log.Printf("[WARN] 批改结果不符合格式要求 (尝试%d/%d): %v", attempt+1, maxSchemaRetries+1, err)
+		if len(response) > 200 {
+			log.Printf("[DEBUG] 原始响应前200字符: %s", response[:200])
+		} else {
+			log.Printf("[DEBUG] 原始响应: %s", response)
+		}
+
+		if attempt == maxSchemaRetries {
+			return result, fmt.Errorf("批改结果不符合格式要求: %v", err)
+		}
+		prompt = fmt.Sprintf("%s\n\n上一次返回的结果不符合要求：%v。请严格按照要求的JSON结构重新返回完整结果。", textPrompt, err)
+	}
+
+	return result, fmt.Errorf("批改结果不符合格式要求")
+}
@@ -0,0 +1,454 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	xdraw "golang.org/x/image/draw"
+
+	// 注册手机照片常见的HEIC和WebP格式的解码器
+	_ "github.com/gen2brain/heic"
+	_ "golang.org/x/image/webp"
+)
+
+// maxImageDimension 发送给大模型的图片最长边的像素数，超过时按比例缩小
+const maxImageDimension = 2048
+
+// normalizedJPEGQuality 重新编码为JPEG时的质量
+const normalizedJPEGQuality = 90
+
+// supportedUploadTypes 支持上传的文件类型（按内容识别的MIME类型）
+var supportedUploadTypes = map[string]bool{
+	"application/pdf": true,
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/webp":      true,
+	"image/heic":      true,
+	"image/heif":      true,
+}
+
+// InspectUpload 按文件内容识别上传文件的类型，并检查文件能否正常读取
+// 不支持的类型或损坏的文件返回错误，调用方应在上传时拒绝
+func InspectUpload(path string) (string, error) {
+	detected, err := mimetype.DetectFile(path)
+	if err != nil {
+		return "", fmt.Errorf("读取上传文件失败: %v", err)
+	}
+
+	mimeType := detected.String()
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	if mimeType == "image/heic-sequence" || mimeType == "image/heif-sequence" {
+		mimeType = strings.TrimSuffix(mimeType, "-sequence")
+	}
+	if !supportedUploadTypes[mimeType] {
+		return "", fmt.Errorf("不支持的文件类型: %s，只支持PDF、JPG、PNG、WebP和HEIC", mimeType)
+	}
+
+	if mimeType == "application/pdf" {
+		if _, err := api.PageCountFile(path); err != nil {
+			return "", fmt.Errorf("PDF文件已损坏或无法读取: %v", err)
+		}
+		return mimeType, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("读取上传文件失败: %v", err)
+	}
+	defer file.Close()
+	if _, _, err := image.Decode(file); err != nil {
+		return "", fmt.Errorf("图片已损坏或无法解码: %v", err)
+	}
+	return mimeType, nil
+}
+
+// IsImageType 是否为图片类型
+func IsImageType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "image/")
+}
+
+// NormalizeImage 在发送给大模型前规范化作业照片：按EXIF方向（HEIC还包括irot/imir属性）摆正、缩小过大的照片，HEIC和WebP转换为JPEG
+// 不需要处理的JPEG和PNG原样返回；处理后的图片写入原文件旁的_normalized文件，返回新文件路径和MIME类型
+func NormalizeImage(path string) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("读取图片失败: %v", err)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", "", fmt.Errorf("图片已损坏或无法解码: %v", err)
+	}
+
+	orientation := 1
+	switch format {
+	case "jpeg":
+		orientation = jpegOrientation(data)
+	case "heic":
+		orientation = heicOrientation(data)
+	}
+	bounds := img.Bounds()
+	oversized := bounds.Dx() > maxImageDimension || bounds.Dy() > maxImageDimension
+
+	if (format == "jpeg" || format == "png") && orientation == 1 && !oversized {
+		return path, "image/" + format, nil
+	}
+
+	img = applyOrientation(img, orientation)
+	if oversized {
+		img = downscale(img, maxImageDimension)
+	}
+
+	// PNG多为截图或扫描件，保持无损格式；照片统一转换为JPEG
+	outputFormat, outputExt := "jpeg", ".jpg"
+	if format == "png" {
+		outputFormat, outputExt = "png", ".png"
+	}
+	outputPath := strings.TrimSuffix(path, filepath.Ext(path)) + "_normalized" + outputExt
+
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return "", "", fmt.Errorf("保存规范化图片失败: %v", err)
+	}
+	defer output.Close()
+
+	if outputFormat == "png" {
+		err = png.Encode(output, img)
+	} else {
+		err = jpeg.Encode(output, img, &jpeg.Options{Quality: normalizedJPEGQuality})
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("编码规范化图片失败: %v", err)
+	}
+
+	log.Printf("[INFO] 图片已规范化: %s (%s, 方向%d, %dx%d) -> %s (%dx%d)",
+		path, format, orientation, bounds.Dx(), bounds.Dy(), outputPath, img.Bounds().Dx(), img.Bounds().Dy())
+	return outputPath, "image/" + outputFormat, nil
+}
+
+// downscale 按比例缩小图片，使最长边不超过maxSide
+func downscale(img image.Image, maxSide int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width >= height {
+		height = height * maxSide / width
+		width = maxSide
+	} else {
+		width = width * maxSide / height
+		height = maxSide
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, max(width, 1), max(height, 1)))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// applyOrientation 按EXIF方向（1-8）旋转或翻转图片，使其正向显示
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	dstWidth, dstHeight := w, h
+	if orientation >= 5 {
+		dstWidth, dstHeight = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // 水平翻转
+				dx, dy = w-1-x, y
+			case 3: // 旋转180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // 垂直翻转
+				dx, dy = x, h-1-y
+			case 5: // 沿主对角线翻转
+				dx, dy = y, x
+			case 6: // 顺时针旋转90°
+				dx, dy = h-1-y, x
+			case 7: // 沿副对角线翻转
+				dx, dy = h-1-y, w-1-x
+			case 8: // 逆时针旋转90°
+				dx, dy = y, w-1-x
+			}
+			dst.SetRGBA(dx, dy, src.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation 读取JPEG中EXIF的方向标记（0x0112），没有时返回1
+func jpegOrientation(data []byte) int {
+	r := bytes.NewReader(data)
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || marker != [2]byte{0xFF, 0xD8} {
+		return 1
+	}
+
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return 1
+		}
+		// 图像数据开始后不会再有EXIF
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return 1
+		}
+
+		var size uint16
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil || size < 2 {
+			return 1
+		}
+		segment := make([]byte, size-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return 1
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+	}
+}
+
+// exifOrientation 从EXIF的TIFF结构中读取IFD0的方向标记
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8:]))
+			if value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// heicOrientation 读取HEIC中EXIF的方向标记，没有时返回1
+// 带有irot/imir属性时解码器已经旋转或翻转了图片，EXIF中的方向不再应用，避免重复旋转
+func heicOrientation(data []byte) int {
+	meta, ok := findBox(data, "meta")
+	if !ok || len(meta) < 4 {
+		return 1
+	}
+	meta = meta[4:] // meta是FullBox，跳过版本和标志
+
+	if iprp, ok := findBox(meta, "iprp"); ok {
+		if ipco, ok := findBox(iprp, "ipco"); ok {
+			_, rotated := findBox(ipco, "irot")
+			_, mirrored := findBox(ipco, "imir")
+			if rotated || mirrored {
+				return 1
+			}
+		}
+	}
+
+	itemID, ok := heicExifItemID(meta)
+	if !ok {
+		return 1
+	}
+	item, ok := heicItemData(data, meta, itemID)
+	if !ok || len(item) < 4 {
+		return 1
+	}
+	// EXIF数据项以TIFF头的偏移开始
+	offset := 4 + int(binary.BigEndian.Uint32(item))
+	if offset > len(item) {
+		return 1
+	}
+	return exifOrientation(item[offset:])
+}
+
+// isoBox ISOBMFF（HEIC的容器格式）中的一个box
+type isoBox struct {
+	boxType string
+	body    []byte
+}
+
+// readBoxes 读取同一层级的box，遇到格式错误时返回已读取的部分
+func readBoxes(data []byte) []isoBox {
+	var boxes []isoBox
+	for len(data) >= 8 {
+		size, header := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+		switch size {
+		case 0: // 延续到末尾
+			size = uint64(len(data))
+		case 1: // 64位长度
+			if len(data) < 16 {
+				return boxes
+			}
+			size, header = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return boxes
+		}
+		boxes = append(boxes, isoBox{boxType: string(data[4:8]), body: data[header:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+// findBox 查找同一层级中第一个指定类型的box，返回其内容
+func findBox(data []byte, boxType string) ([]byte, bool) {
+	for _, box := range readBoxes(data) {
+		if box.boxType == boxType {
+			return box.body, true
+		}
+	}
+	return nil, false
+}
+
+// heicExifItemID 从iinf中查找类型为Exif的数据项
+func heicExifItemID(meta []byte) (uint64, bool) {
+	iinf, ok := findBox(meta, "iinf")
+	if !ok || len(iinf) < 6 {
+		return 0, false
+	}
+	entries := iinf[6:] // 版本0的数据项个数为16位
+	if iinf[0] != 0 {
+		if len(iinf) < 8 {
+			return 0, false
+		}
+		entries = iinf[8:]
+	}
+
+	for _, box := range readBoxes(entries) {
+		if box.boxType != "infe" || len(box.body) < 4 {
+			continue
+		}
+		r := &byteReader{data: box.body, pos: 4}
+		var itemID uint64
+		switch box.body[0] {
+		case 2:
+			itemID = r.uint(2)
+		case 3:
+			itemID = r.uint(4)
+		default:
+			continue
+		}
+		r.uint(2) // item_protection_index
+		itemType := r.uint(4)
+		if !r.failed && itemType == uint64(binary.BigEndian.Uint32([]byte("Exif"))) {
+			return itemID, true
+		}
+	}
+	return 0, false
+}
+
+// heicItemData 按iloc中记录的位置读取数据项的内容，只支持以文件偏移存储的数据项
+func heicItemData(data, meta []byte, itemID uint64) ([]byte, bool) {
+	iloc, ok := findBox(meta, "iloc")
+	if !ok || len(iloc) < 6 {
+		return nil, false
+	}
+	version := iloc[0]
+	offsetSize, lengthSize := int(iloc[4]>>4), int(iloc[4]&0x0F)
+	baseOffsetSize, indexSize := int(iloc[5]>>4), int(iloc[5]&0x0F)
+	if version == 0 {
+		indexSize = 0
+	}
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+
+	r := &byteReader{data: iloc, pos: 6}
+	count := r.uint(idSize)
+	for i := uint64(0); i < count && !r.failed; i++ {
+		id := r.uint(idSize)
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = r.uint(2) & 0x0F
+		}
+		r.uint(2) // data_reference_index
+		baseOffset := r.uint(baseOffsetSize)
+		extentCount := r.uint(2)
+
+		var offset, length uint64
+		for extent := uint64(0); extent < extentCount && !r.failed; extent++ {
+			r.uint(indexSize)
+			extentOffset, extentLength := r.uint(offsetSize), r.uint(lengthSize)
+			if extent == 0 {
+				offset, length = baseOffset+extentOffset, extentLength
+			}
+		}
+		if r.failed || id != itemID {
+			continue
+		}
+		if method != 0 || extentCount == 0 || offset > uint64(len(data)) {
+			return nil, false
+		}
+		if length == 0 { // 延续到文件末尾
+			length = uint64(len(data)) - offset
+		}
+		if offset+length > uint64(len(data)) {
+			return nil, false
+		}
+		return data[offset : offset+length], true
+	}
+	return nil, false
+}
+
+// byteReader 按大端序读取变长整数，越界后failed为true并返回0
+type byteReader struct {
+	data   []byte
+	pos    int
+	failed bool
+}
+
+// uint 读取size字节（0到8）的无符号整数
+func (r *byteReader) uint(size int) uint64 {
+	if r.failed || r.pos+size > len(r.data) {
+		r.failed = true
+		return 0
+	}
+	var value uint64
+	for _, b := range r.data[r.pos : r.pos+size] {
+		value = value<<8 | uint64(b)
+	}
+	r.pos += size
+	return value
+}
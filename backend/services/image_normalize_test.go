@@ -0,0 +1,202 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// exifTIFF 生成只包含方向标记的EXIF TIFF结构
+func exifTIFF(orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	binary.Write(&tiff, binary.BigEndian, uint16(42))
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, uint16(0x0112))
+	binary.Write(&tiff, binary.BigEndian, uint16(3))
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, orientation)
+	binary.Write(&tiff, binary.BigEndian, uint16(0))
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+	return tiff.Bytes()
+}
+
+// withEXIFOrientation 在JPEG的SOI之后插入只包含方向标记的EXIF段
+func withEXIFOrientation(data []byte, orientation uint16) []byte {
+	segment := append([]byte("Exif\x00\x00"), exifTIFF(orientation)...)
+	var out bytes.Buffer
+	out.Write(data[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(data[2:])
+	return out.Bytes()
+}
+
+// TestNormalizeImageOrientation 测试按EXIF方向摆正手机照片
+func TestNormalizeImageOrientation(t *testing.T) {
+	// 横向存储的照片，左半边为黑色，方向6表示需要顺时针旋转90°显示
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 20; y++ {
+			c := color.RGBA{255, 255, 255, 255}
+			if x < 20 {
+				c = color.RGBA{0, 0, 0, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("生成测试图片失败: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(path, withEXIFOrientation(buf.Bytes(), 6), 0644); err != nil {
+		t.Fatalf("写入测试图片失败: %v", err)
+	}
+
+	normalizedPath, mimeType, err := NormalizeImage(path)
+	if err != nil {
+		t.Fatalf("规范化图片失败: %v", err)
+	}
+	if normalizedPath == path || mimeType != "image/jpeg" {
+		t.Fatalf("规范化结果错误: %s %s", normalizedPath, mimeType)
+	}
+
+	file, err := os.Open(normalizedPath)
+	if err != nil {
+		t.Fatalf("打开规范化图片失败: %v", err)
+	}
+	defer file.Close()
+	result, _, err := image.Decode(file)
+	if err != nil {
+		t.Fatalf("解码规范化图片失败: %v", err)
+	}
+	if result.Bounds().Dx() != 20 || result.Bounds().Dy() != 40 {
+		t.Fatalf("旋转后尺寸错误: %v", result.Bounds())
+	}
+	// 顺时针旋转后原来的左半边在上方
+	if r, _, _, _ := result.At(10, 5).RGBA(); r > 0x4000 {
+		t.Error("旋转方向错误：上方应为黑色")
+	}
+	if r, _, _, _ := result.At(10, 35).RGBA(); r < 0xC000 {
+		t.Error("旋转方向错误：下方应为白色")
+	}
+}
+
+// heicContainer 生成只有元数据的HEIC容器：EXIF数据项保存在mdat中，rotated为true时带有irot属性
+func heicContainer(orientation uint16, rotated bool) []byte {
+	box := func(boxType string, body ...[]byte) []byte {
+		content := bytes.Join(body, nil)
+		out := binary.BigEndian.AppendUint32(nil, uint32(len(content)+8))
+		return append(append(out, boxType...), content...)
+	}
+	u16 := func(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+	u32 := func(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+	ftyp := box("ftyp", []byte("heic"), u32(0), []byte("mif1heic"))
+	infe := box("infe", []byte{2, 0, 0, 0}, u16(1), u16(0), []byte("Exif"), []byte{0})
+	iinf := box("iinf", []byte{0, 0, 0, 0}, u16(1), infe)
+	exif := append(u32(6), append([]byte("Exif\x00\x00"), exifTIFF(orientation)...)...)
+
+	var ipco []byte
+	if rotated {
+		ipco = box("iprp", box("ipco", box("irot", []byte{1})))
+	}
+	// iloc版本0：偏移和长度各4字节，没有基准偏移；mdat的位置在确定meta的长度后计算
+	iloc := func(offset uint32) []byte {
+		return box("iloc", []byte{0, 0, 0, 0, 0x44, 0x00}, u16(1), u16(1), u16(0), u16(1), u32(offset), u32(uint32(len(exif))))
+	}
+	meta := box("meta", []byte{0, 0, 0, 0}, iinf, ipco, iloc(0))
+	offset := uint32(len(ftyp) + len(meta) + 8)
+	meta = box("meta", []byte{0, 0, 0, 0}, iinf, ipco, iloc(offset))
+	return bytes.Join([][]byte{ftyp, meta, box("mdat", exif)}, nil)
+}
+
+// TestHEICOrientation 测试读取HEIC中EXIF的方向，带有irot属性时解码器已摆正图片，不再应用EXIF的方向
+func TestHEICOrientation(t *testing.T) {
+	if orientation := heicOrientation(heicContainer(6, false)); orientation != 6 {
+		t.Errorf("应读取EXIF中的方向6，实际: %d", orientation)
+	}
+	if orientation := heicOrientation(heicContainer(6, true)); orientation != 1 {
+		t.Errorf("带有irot属性时不应再应用EXIF的方向，实际: %d", orientation)
+	}
+	if orientation := heicOrientation([]byte("not a heic file")); orientation != 1 {
+		t.Errorf("无法解析时方向应为1，实际: %d", orientation)
+	}
+}
+
+// TestNormalizeImageDownscale 测试缩小过大的图片，正常尺寸的图片原样返回
+func TestNormalizeImageDownscale(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, width, height int) string {
+		path := filepath.Join(dir, name)
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatalf("创建测试图片失败: %v", err)
+		}
+		defer file.Close()
+		if err := png.Encode(file, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+			t.Fatalf("写入测试图片失败: %v", err)
+		}
+		return path
+	}
+
+	small := write("small.png", 100, 50)
+	if path, mimeType, err := NormalizeImage(small); err != nil || path != small || mimeType != "image/png" {
+		t.Errorf("正常尺寸的图片应原样返回: %s %s %v", path, mimeType, err)
+	}
+
+	large := write("large.png", maxImageDimension*2, maxImageDimension)
+	path, mimeType, err := NormalizeImage(large)
+	if err != nil || mimeType != "image/png" {
+		t.Fatalf("规范化图片失败: %s %v", mimeType, err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("打开规范化图片失败: %v", err)
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		t.Fatalf("解码规范化图片失败: %v", err)
+	}
+	if config.Width != maxImageDimension || config.Height != maxImageDimension/2 {
+		t.Errorf("缩小后尺寸错误: %dx%d", config.Width, config.Height)
+	}
+}
+
+// TestInspectUpload 测试按内容识别上传文件类型，拒绝不支持和损坏的文件
+func TestInspectUpload(t *testing.T) {
+	dir := t.TempDir()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatalf("生成测试图片失败: %v", err)
+	}
+	// 扩展名与内容不一致时以内容为准
+	disguised := filepath.Join(dir, "photo.jpg")
+	os.WriteFile(disguised, buf.Bytes(), 0644)
+	if mimeType, err := InspectUpload(disguised); err != nil || mimeType != "image/png" {
+		t.Errorf("应识别为PNG: %s %v", mimeType, err)
+	}
+
+	text := filepath.Join(dir, "notes.pdf")
+	os.WriteFile(text, []byte("这不是作业文件"), 0644)
+	if _, err := InspectUpload(text); err == nil {
+		t.Error("文本文件应返回错误")
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.png")
+	os.WriteFile(corrupt, buf.Bytes()[:40], 0644)
+	if _, err := InspectUpload(corrupt); err == nil {
+		t.Error("损坏的图片应返回错误")
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// GroupSubmissionFiles 按每个文件的分组标识把上传的文件分配给学生，返回每个学生的文件序号（按上传顺序）
+// groups为空时所有文件属于同一个学生；只有一个值时按逗号拆分；分组按第一次出现的顺序排列
+func GroupSubmissionFiles(fileCount int, groups []string) ([][]int, error) {
+	if len(groups) == 1 && strings.Contains(groups[0], ",") {
+		groups = strings.Split(groups[0], ",")
+	}
+
+	if len(groups) == 0 {
+		all := make([]int, fileCount)
+		for i := range all {
+			all[i] = i
+		}
+		return [][]int{all}, nil
+	}
+	if len(groups) != fileCount {
+		return nil, fmt.Errorf("分组数量(%d)与文件数量(%d)不一致", len(groups), fileCount)
+	}
+
+	var students [][]int
+	index := make(map[string]int)
+	for i, group := range groups {
+		group = strings.TrimSpace(group)
+		if group == "" {
+			return nil, fmt.Errorf("第%d个文件缺少分组", i+1)
+		}
+		n, exists := index[group]
+		if !exists {
+			n = len(students)
+			index[group] = n
+			students = append(students, nil)
+		}
+		students[n] = append(students[n], i)
+	}
+	return students, nil
+}
+
+// AssembleImageSubmissions 将每个学生的多张作业图片按顺序合并为一份PDF，每个学生生成一个文件
+func AssembleImageSubmissions(students [][]string) ([]string, error) {
+	log.Printf("[INFO] 合并作业图片，共 %d 个学生", len(students))
+
+	sessionDir := filepath.Join("uploads", "split", "images_"+time.Now().Format("20060102_150405")+"_"+RandStringRunes(6))
+	if err := os.MkdirAll(sessionDir, 0755); err != nil {
+		return nil, fmt.Errorf("创建合并文件目录失败: %v", err)
+	}
+
+	outputFiles := make([]string, 0, len(students))
+	for i, images := range students {
+		studentPDFFile := filepath.Join(sessionDir, fmt.Sprintf("student_%d.pdf", i+1))
+		if err := api.ImportImagesFile(images, studentPDFFile, nil, nil); err != nil {
+			return nil, fmt.Errorf("合并学生 %d 的作业图片失败: %v", i+1, err)
+		}
+		log.Printf("[INFO] 学生 %d 的 %d 张图片已合并为: %s", i+1, len(images), studentPDFFile)
+		outputFiles = append(outputFiles, studentPDFFile)
+	}
+	return outputFiles, nil
+}
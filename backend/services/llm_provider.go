@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// 支持的大模型服务提供方
+const (
+	ProviderVertex = "vertex" // Google Vertex AI (Gemini)
+	ProviderOpenAI = "openai" // OpenAI 兼容接口（可指向自建服务）
+)
+
+// LLMProvider 大模型服务提供方的统一接口
+// 批改流程只依赖该接口，具体使用哪个服务由配置决定
+type LLMProvider interface {
+	// Name 返回提供方名称，用于日志
+	Name() string
+
+	// GenerateContent 使用文本内容生成回复，ctx取消时中止请求
+	GenerateContent(ctx context.Context, systemInstruction, textPrompt string) (string, error)
+
+	// GenerateContentWithFile 使用文件（图片或PDF）和文本生成回复，ctx取消时中止请求
+	GenerateContentWithFile(ctx context.Context, systemInstruction, filePath, mimeType, textPrompt string) (string, error)
+
+	// GenerateJSONWithFile 使用文件和文本生成受schema约束的JSON回复（JSON模式）
+	// 返回模型的原始JSON文本，不做修复，由调用方校验
+	GenerateJSONWithFile(ctx context.Context, systemInstruction, filePath, mimeType, textPrompt string, schema *ResponseSchema) (string, error)
+
+	// GenerateContentStream 流式生成内容，每收到一段文本调用一次onChunk
+	// onChunk返回错误时停止接收
+	GenerateContentStream(ctx context.Context, systemInstruction, prompt string, onChunk func(chunk string) error) error
+}
+
+// NewLLMProviderFromEnv 根据环境变量LLM_PROVIDER创建大模型服务提供方
+// 未设置时默认使用Vertex AI
+func NewLLMProviderFromEnv() (LLMProvider, error) {
+	providerName := strings.ToLower(strings.TrimSpace(os.Getenv("LLM_PROVIDER")))
+
+	switch providerName {
+	case "", ProviderVertex:
+		log.Printf("[INFO] 使用大模型服务: %s", ProviderVertex)
+		return NewVertexAIClient(), nil
+	case ProviderOpenAI:
+		provider, err := NewOpenAIProviderFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] 使用大模型服务: %s (%s, 模型: %s)", ProviderOpenAI, provider.baseURL, provider.model)
+		return provider, nil
+	case ProviderFake:
+		provider, err := NewFakeLLMProviderFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] 使用大模型服务: %s (脚本: %s)", ProviderFake, os.Getenv("FAKE_LLM_SCRIPT"))
+		return provider, nil
+	default:
+		return nil, fmt.Errorf("不支持的大模型服务提供方: %s", providerName)
+	}
+}
+
+// NewSecondaryLLMProviderFromEnv 根据环境变量SECONDARY_LLM_PROVIDER创建多模型批改使用的第二个大模型，未设置时返回nil
+// 使用OpenAI兼容接口时，SECONDARY_OPENAI_BASE_URL、SECONDARY_OPENAI_API_KEY和SECONDARY_OPENAI_MODEL未设置的部分沿用OPENAI_*的配置
+func NewSecondaryLLMProviderFromEnv() (LLMProvider, error) {
+	providerName := strings.ToLower(strings.TrimSpace(os.Getenv("SECONDARY_LLM_PROVIDER")))
+
+	switch providerName {
+	case "":
+		return nil, nil
+	case ProviderVertex:
+		log.Printf("[INFO] 第二个大模型服务: %s", ProviderVertex)
+		return NewVertexAIClient(), nil
+	case ProviderOpenAI:
+		baseURL := envOrDefault("SECONDARY_OPENAI_BASE_URL", envOrDefault("OPENAI_BASE_URL", "https://api.openai.com/v1"))
+		model := envOrDefault("SECONDARY_OPENAI_MODEL", os.Getenv("OPENAI_MODEL"))
+		if model == "" {
+			return nil, fmt.Errorf("未设置SECONDARY_OPENAI_MODEL环境变量")
+		}
+		log.Printf("[INFO] 第二个大模型服务: %s (%s, 模型: %s)", ProviderOpenAI, baseURL, model)
+		return NewOpenAIProvider(baseURL, envOrDefault("SECONDARY_OPENAI_API_KEY", os.Getenv("OPENAI_API_KEY")), model), nil
+	case ProviderFake:
+		provider, err := NewFakeLLMProviderFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] 第二个大模型服务: %s (脚本: %s)", ProviderFake, os.Getenv("FAKE_LLM_SCRIPT"))
+		return provider, nil
+	default:
+		return nil, fmt.Errorf("不支持的大模型服务提供方: %s", providerName)
+	}
+}
+
+// envOrDefault 返回环境变量的值，未设置时返回defaultValue
+func envOrDefault(name, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// SleepWithContext 等待指定时间，ctx被取消时提前返回ctx的错误
+func SleepWithContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// normalizeModelResponse 清理模型返回的文本，看起来是JSON时尝试修复格式
+func normalizeModelResponse(responseText string) string {
+	sanitized := sanitizeUTF8(responseText)
+	if strings.Contains(sanitized, "{") || strings.Contains(sanitized, "[") {
+		log.Printf("[INFO] 响应看起来包含JSON，尝试处理和验证")
+		return EnsureValidJSON(sanitized)
+	}
+
+	log.Printf("[INFO] 响应不包含JSON结构，返回原始文本")
+	return sanitized
+}
@@ -0,0 +1,351 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OpenAIProvider 通过OpenAI兼容的HTTP接口(/chat/completions)调用大模型
+// 可以指向OpenAI官方服务，也可以指向自建的兼容服务（如vLLM、Ollama等）
+type OpenAIProvider struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	httpClient *http.Client
+}
+
+// NewOpenAIProvider 创建新的OpenAI兼容接口客户端
+func NewOpenAIProvider(baseURL, apiKey, model string) *OpenAIProvider {
+	return &OpenAIProvider{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		model:      model,
+		httpClient: &http.Client{Timeout: 300 * time.Second},
+	}
+}
+
+// NewOpenAIProviderFromEnv 从环境变量创建OpenAI兼容接口客户端
+// OPENAI_BASE_URL 服务地址，默认为OpenAI官方地址
+// OPENAI_API_KEY  访问密钥，自建服务可以为空
+// OPENAI_MODEL    模型名称，必须设置
+func NewOpenAIProviderFromEnv() (*OpenAIProvider, error) {
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+
+	model := os.Getenv("OPENAI_MODEL")
+	if model == "" {
+		return nil, fmt.Errorf("未设置OPENAI_MODEL环境变量")
+	}
+
+	return NewOpenAIProvider(baseURL, os.Getenv("OPENAI_API_KEY"), model), nil
+}
+
+// openAIChatRequest 聊天补全请求
+type openAIChatRequest struct {
+	Model       string          `json:"model"`
+	Messages    []openAIMessage `json:"messages"`
+	Temperature float32         `json:"temperature"`
+	TopP        float32         `json:"top_p"`
+	MaxTokens   int             `json:"max_tokens"`
+	Stream      bool            `json:"stream,omitempty"`
+	// ResponseFormat 不为空时要求模型按JSON Schema输出
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+// openAIResponseFormat 结构化输出格式
+type openAIResponseFormat struct {
+	Type       string                 `json:"type"`
+	JSONSchema map[string]interface{} `json:"json_schema,omitempty"`
+}
+
+// openAIMessage 聊天消息，Content为字符串或内容片段数组
+type openAIMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+// openAIContentPart 多模态消息中的一个内容片段
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+	File     *openAIFile     `json:"file,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type openAIFile struct {
+	Filename string `json:"filename"`
+	FileData string `json:"file_data"`
+}
+
+// openAIChatResponse 聊天补全响应（同时兼容流式响应的delta）
+type openAIChatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Name 返回提供方名称
+func (p *OpenAIProvider) Name() string {
+	return ProviderOpenAI
+}
+
+// GenerateContent 使用文本内容生成AI回复
+func (p *OpenAIProvider) GenerateContent(ctx context.Context, systemInstruction, textPrompt string) (string, error) {
+	log.Printf("[INFO] 开始通过OpenAI兼容接口生成内容...")
+
+	messages := p.buildMessages(systemInstruction, textPrompt)
+	responseText, err := p.complete(ctx, p.newRequest(messages))
+	if err != nil {
+		return "", err
+	}
+	return normalizeModelResponse(responseText), nil
+}
+
+// GenerateContentWithFile 使用文件内容生成AI回复
+func (p *OpenAIProvider) GenerateContentWithFile(ctx context.Context, systemInstruction, filePath, mimeType, textPrompt string) (string, error) {
+	log.Printf("[INFO] 开始通过OpenAI兼容接口生成带文件的内容...")
+	log.Printf("[DEBUG] 文件路径: %s, MIME类型: %s", filePath, mimeType)
+
+	messages, err := p.buildFileMessages(systemInstruction, filePath, mimeType, textPrompt)
+	if err != nil {
+		return "", err
+	}
+
+	responseText, err := p.complete(ctx, p.newRequest(messages))
+	if err != nil {
+		return "", err
+	}
+	return normalizeModelResponse(responseText), nil
+}
+
+// GenerateJSONWithFile 使用文件内容生成受schema约束的JSON回复
+// 通过response_format的json_schema启用结构化输出，返回原始文本不做修复
+func (p *OpenAIProvider) GenerateJSONWithFile(ctx context.Context, systemInstruction, filePath, mimeType, textPrompt string, schema *ResponseSchema) (string, error) {
+	log.Printf("[INFO] 开始通过OpenAI兼容接口生成结构化内容...")
+	log.Printf("[DEBUG] 文件路径: %s, MIME类型: %s", filePath, mimeType)
+
+	messages, err := p.buildFileMessages(systemInstruction, filePath, mimeType, textPrompt)
+	if err != nil {
+		return "", err
+	}
+
+	reqBody := p.newRequest(messages)
+	reqBody.ResponseFormat = &openAIResponseFormat{
+		Type: "json_schema",
+		JSONSchema: map[string]interface{}{
+			"name":   schema.Name,
+			"schema": schema.toJSONSchema(),
+		},
+	}
+
+	responseText, err := p.complete(ctx, reqBody)
+	if err != nil {
+		return "", err
+	}
+	return sanitizeUTF8(strings.TrimSpace(responseText)), nil
+}
+
+// buildFileMessages 构建带文件的消息
+// 图片以data URL形式发送，其他文件（如PDF）以file片段发送
+func (p *OpenAIProvider) buildFileMessages(systemInstruction, filePath, mimeType, textPrompt string) ([]openAIMessage, error) {
+	fileContent, err := os.ReadFile(filePath)
+	if err != nil {
+		log.Printf("[ERROR] 无法读取文件内容: %v", err)
+		return nil, fmt.Errorf("无法读取文件内容: %v", err)
+	}
+
+	dataURL := fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(fileContent))
+
+	var filePart openAIContentPart
+	if strings.HasPrefix(mimeType, "image/") {
+		filePart = openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: dataURL}}
+	} else {
+		filePart = openAIContentPart{Type: "file", File: &openAIFile{Filename: filepath.Base(filePath), FileData: dataURL}}
+	}
+
+	messages := p.buildMessages(systemInstruction, "")
+	messages = append(messages, openAIMessage{
+		Role: "user",
+		Content: []openAIContentPart{
+			{Type: "text", Text: textPrompt},
+			filePart,
+		},
+	})
+
+	return messages, nil
+}
+
+// GenerateContentStream 使用服务端事件流(SSE)流式生成内容
+func (p *OpenAIProvider) GenerateContentStream(ctx context.Context, systemInstruction, prompt string, onChunk func(chunk string) error) error {
+	reqBody := p.newRequest(p.buildMessages(systemInstruction, sanitizeUTF8(prompt)))
+	reqBody.Stream = true
+
+	resp, err := p.post(ctx, reqBody)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var chunk openAIChatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			log.Printf("[WARN] 无法解析流式响应片段: %v", err)
+			continue
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason == "content_filter" {
+				return fmt.Errorf("内容被安全策略限制")
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			if err := onChunk(choice.Delta.Content); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取流式响应失败: %v", err)
+	}
+	return nil
+}
+
+// buildMessages 构建系统指令和文本提示词消息
+func (p *OpenAIProvider) buildMessages(systemInstruction, textPrompt string) []openAIMessage {
+	var messages []openAIMessage
+	if systemInstruction != "" {
+		messages = append(messages, openAIMessage{Role: "system", Content: systemInstruction})
+	}
+	if textPrompt != "" {
+		messages = append(messages, openAIMessage{Role: "user", Content: textPrompt})
+	}
+	return messages
+}
+
+// newRequest 使用与Vertex AI一致的生成参数构建请求
+func (p *OpenAIProvider) newRequest(messages []openAIMessage) *openAIChatRequest {
+	return &openAIChatRequest{
+		Model:       p.model,
+		Messages:    messages,
+		Temperature: 0.2,
+		TopP:        0.8,
+		MaxTokens:   8192,
+	}
+}
+
+// post 发送聊天补全请求，非200状态码时返回错误
+func (p *OpenAIProvider) post(ctx context.Context, reqBody *openAIChatRequest) (*http.Response, error) {
+	payload, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		log.Printf("[ERROR] AI请求失败: %v", err)
+		return nil, fmt.Errorf("AI服务请求失败: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		log.Printf("[ERROR] AI服务返回错误状态码 %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("AI服务返回错误状态码 %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return resp, nil
+}
+
+// complete 发送非流式请求并提取回复的原始文本
+func (p *OpenAIProvider) complete(ctx context.Context, reqBody *openAIChatRequest) (string, error) {
+	log.Printf("[INFO] 发送请求到 %s, 模型: %s", p.baseURL, p.model)
+
+	resp, err := p.post(ctx, reqBody)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var chatResp openAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		log.Printf("[ERROR] 解析AI响应失败: %v", err)
+		return "", fmt.Errorf("解析AI响应失败: %v", err)
+	}
+
+	if chatResp.Error != nil {
+		return "", fmt.Errorf("AI服务返回错误: %s", chatResp.Error.Message)
+	}
+
+	if len(chatResp.Choices) == 0 {
+		log.Printf("[ERROR] AI未返回任何候选结果")
+		return "", fmt.Errorf("AI未返回任何候选结果")
+	}
+
+	if chatResp.Choices[0].FinishReason == "content_filter" {
+		log.Printf("[ERROR] 内容被安全策略限制")
+		return "", fmt.Errorf("内容被安全策略限制")
+	}
+
+	responseText := chatResp.Choices[0].Message.Content
+	if responseText == "" {
+		log.Printf("[ERROR] AI未返回文本内容")
+		return "", fmt.Errorf("AI未返回文本内容")
+	}
+
+	if len(responseText) > 100 {
+		log.Printf("[DEBUG] AI响应文本前100个字符: %s", responseText[:100])
+	} else {
+		log.Printf("[DEBUG] AI响应文本: %s", responseText)
+	}
+
+	return responseText, nil
+}
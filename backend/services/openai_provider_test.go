@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestOpenAIProviderGenerateContentWithFile 测试OpenAI兼容接口的请求格式和响应解析
+func TestOpenAIProviderGenerateContentWithFile(t *testing.T) {
+	var received openAIChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("请求路径错误: %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization请求头错误: %s", got)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("解析请求失败: %v", err)
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"`+"```json\\n"+`{\"overallScore\":\"90\"}`+"\\n```"+`"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	imagePath := filepath.Join(t.TempDir(), "homework.png")
+	if err := os.WriteFile(imagePath, []byte("fake image"), 0644); err != nil {
+		t.Fatalf("创建测试文件失败: %v", err)
+	}
+
+	provider := NewOpenAIProvider(server.URL+"/v1/", "test-key", "test-model")
+	result, err := provider.GenerateContentWithFile(context.Background(), "系统指令", imagePath, "image/png", "请批改")
+	if err != nil {
+		t.Fatalf("预期成功，但得到错误: %v", err)
+	}
+
+	// Markdown代码块应被清理为纯JSON
+	if result != `{"overallScore":"90"}` {
+		t.Errorf("响应清理结果错误: %s", result)
+	}
+
+	if received.Model != "test-model" || len(received.Messages) != 2 {
+		t.Fatalf("请求内容错误: %+v", received)
+	}
+	if received.Messages[0].Role != "system" || received.Messages[0].Content != "系统指令" {
+		t.Errorf("系统消息错误: %+v", received.Messages[0])
+	}
+
+	parts, _ := json.Marshal(received.Messages[1].Content)
+	if !strings.Contains(string(parts), `"image_url":{"url":"data:image/png;base64,`) {
+		t.Errorf("图片未以data URL形式发送: %s", parts)
+	}
+}
+
+// TestOpenAIProviderGenerateJSONWithFile 测试JSON模式会发送response_format且不修复返回内容
+func TestOpenAIProviderGenerateJSONWithFile(t *testing.T) {
+	var received openAIChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("解析请求失败: %v", err)
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"{\"name\":\"张三\""},"finish_reason":"length"}]}`)
+	}))
+	defer server.Close()
+
+	pdfPath := filepath.Join(t.TempDir(), "homework.pdf")
+	if err := os.WriteFile(pdfPath, []byte("%PDF-1.4"), 0644); err != nil {
+		t.Fatalf("创建测试文件失败: %v", err)
+	}
+
+	provider := NewOpenAIProvider(server.URL+"/v1", "", "test-model")
+	result, err := provider.GenerateJSONWithFile(context.Background(), "", pdfPath, "application/pdf", "请批改", HomeworkResultSchema)
+	if err != nil {
+		t.Fatalf("预期成功，但得到错误: %v", err)
+	}
+	if result != `{"name":"张三"` {
+		t.Errorf("JSON模式不应修复返回内容: %s", result)
+	}
+
+	format := received.ResponseFormat
+	if format == nil || format.Type != "json_schema" || format.JSONSchema["name"] != "homework_result" {
+		t.Fatalf("缺少response_format: %+v", format)
+	}
+	schema, _ := json.Marshal(format.JSONSchema["schema"])
+	if !strings.Contains(string(schema), `"isCorrect":{"description":"答案是否正确","type":"boolean"}`) {
+		t.Errorf("schema内容错误: %s", schema)
+	}
+}
+
+// TestOpenAIProviderErrors 测试错误状态码和安全策略限制
+func TestOpenAIProviderErrors(t *testing.T) {
+	testCases := []struct {
+		name        string
+		status      int
+		body        string
+		expectedErr string
+	}{
+		{"服务端错误", http.StatusInternalServerError, `{"error":{"message":"boom"}}`, "错误状态码 500"},
+		{"安全策略限制", http.StatusOK, `{"choices":[{"message":{"content":""},"finish_reason":"content_filter"}]}`, "安全策略"},
+		{"没有候选结果", http.StatusOK, `{"choices":[]}`, "未返回任何候选结果"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			}))
+			defer server.Close()
+
+			provider := NewOpenAIProvider(server.URL, "", "test-model")
+			_, err := provider.GenerateContent(context.Background(), "", "你好")
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("预期错误包含'%s'，实际: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+// TestOpenAIProviderStream 测试流式响应的解析
+func TestOpenAIProviderStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"连接\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"成功\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	provider := NewOpenAIProvider(server.URL, "", "test-model")
+
+	var builder strings.Builder
+	err := provider.GenerateContentStream(context.Background(), "", "你好", func(chunk string) error {
+		builder.WriteString(chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("预期成功，但得到错误: %v", err)
+	}
+	if builder.String() != "连接成功" {
+		t.Errorf("流式内容错误: %s", builder.String())
+	}
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// 作业扫描件的版面
+const (
+	LayoutSingle = "single" // 每页一张A4
+	LayoutDouble = "double" // A3横向扫描，每页左右两张A4
+)
+
+// SplitDoubleLayoutPDF 将双栏（A3横向）扫描件的每一页裁剪为左右两页，按阅读顺序写入outputFile
+// 每页先复制一份，再分别把页面框设置为左半部分和右半部分；竖向页面不是双栏，保持原样
+// 返回输出的每一页在原扫描件中的页码，用于在两种页码之间换算学生分页
+func SplitDoubleLayoutPDF(inputFile, outputFile string) ([]int, error) {
+	log.Printf("[INFO] 开始拆分双栏PDF: %s", inputFile)
+
+	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
+		return nil, fmt.Errorf("输入PDF文件不存在: %s", inputFile)
+	}
+
+	ctx, err := api.ReadContextFile(inputFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取PDF失败: %v", err)
+	}
+	boundaries, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		return nil, fmt.Errorf("读取PDF页面尺寸失败: %v", err)
+	}
+
+	// 双栏页面出现两次，分别保留左半部分和右半部分
+	var selection []string
+	var halves []*types.Rectangle
+	var pages []int
+	for i, pb := range boundaries {
+		page := strconv.Itoa(i + 1)
+		left, right, ok := splitPageBox(pb)
+		if !ok {
+			log.Printf("[WARN] 第%d页不是横向页面，不拆分", i+1)
+			selection = append(selection, page)
+			halves = append(halves, nil)
+			pages = append(pages, i+1)
+			continue
+		}
+		selection = append(selection, page, page)
+		halves = append(halves, left, right)
+		pages = append(pages, i+1, i+1)
+	}
+
+	tempFile := outputFile + ".collect"
+	defer os.Remove(tempFile)
+	if err := api.CollectFile(inputFile, tempFile, selection, nil); err != nil {
+		return nil, fmt.Errorf("复制双栏页面失败: %v", err)
+	}
+
+	ctx, err = api.ReadContextFile(tempFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取PDF失败: %v", err)
+	}
+	for i, half := range halves {
+		if half == nil {
+			continue
+		}
+		box := &model.PageBoundaries{
+			Media: &model.Box{Rect: half},
+			Crop:  &model.Box{Rect: half},
+		}
+		if err := ctx.AddPageBoundaries(types.IntSet{i + 1: true}, box); err != nil {
+			return nil, fmt.Errorf("裁剪第%d页失败: %v", i+1, err)
+		}
+	}
+
+	if err := api.WriteContextFile(ctx, outputFile); err != nil {
+		return nil, fmt.Errorf("写入拆分后的PDF失败: %v", err)
+	}
+
+	log.Printf("[INFO] 双栏PDF拆分完成: %d页 -> %d页, 输出: %s", len(boundaries), len(halves), outputFile)
+	return pages, nil
+}
+
+// splitPageBox 按页面的可见区域和旋转角度计算阅读顺序上的左半部分和右半部分
+// 页面旋转90°时显示的左侧对应页面坐标的下方，旋转270°时对应上方
+func splitPageBox(pb model.PageBoundaries) (left, right *types.Rectangle, ok bool) {
+	r := pb.CropBox()
+	if r == nil {
+		r = pb.MediaBox()
+	}
+	if r == nil {
+		return nil, nil, false
+	}
+
+	rot := (pb.Rot%360 + 360) % 360
+	width, height := r.Width(), r.Height()
+	if rot%180 != 0 {
+		width, height = height, width
+	}
+	if width <= height {
+		return nil, nil, false
+	}
+
+	midX := (r.LL.X + r.UR.X) / 2
+	midY := (r.LL.Y + r.UR.Y) / 2
+	switch rot {
+	case 90:
+		left = types.NewRectangle(r.LL.X, r.LL.Y, r.UR.X, midY)
+		right = types.NewRectangle(r.LL.X, midY, r.UR.X, r.UR.Y)
+	case 180:
+		left = types.NewRectangle(midX, r.LL.Y, r.UR.X, r.UR.Y)
+		right = types.NewRectangle(r.LL.X, r.LL.Y, midX, r.UR.Y)
+	case 270:
+		left = types.NewRectangle(r.LL.X, midY, r.UR.X, r.UR.Y)
+		right = types.NewRectangle(r.LL.X, r.LL.Y, r.UR.X, midY)
+	default:
+		left = types.NewRectangle(r.LL.X, r.LL.Y, midX, r.UR.Y)
+		right = types.NewRectangle(midX, r.LL.Y, r.UR.X, r.UR.Y)
+	}
+	return left, right, true
+}
+
+// MapRangesToOriginalPages 把按双栏拆分后的页码识别的学生分页换算为原扫描件的页码，pages为SplitDoubleLayoutPDF的返回值
+// 一页的左右两部分属于不同学生时整页归入后一个学生，教师可以在确认分页时调整
+func MapRangesToOriginalPages(ranges []models.StudentPageRange, pages []int) []models.StudentPageRange {
+	mapped := make([]models.StudentPageRange, 0, len(ranges))
+	for _, r := range ranges {
+		if r.StartPage < 1 || r.EndPage > len(pages) || r.EndPage < r.StartPage {
+			log.Printf("[WARN] 学生分页 %d-%d 超出拆分后的页数%d，忽略", r.StartPage, r.EndPage, len(pages))
+			continue
+		}
+		r.StartPage, r.EndPage = pages[r.StartPage-1], pages[r.EndPage-1]
+
+		if last := len(mapped) - 1; last >= 0 && mapped[last].EndPage >= r.StartPage {
+			log.Printf("[WARN] 第%d页的左右两部分属于不同学生，整页归入后一个学生", r.StartPage)
+			mapped[last].EndPage = r.StartPage - 1
+			if mapped[last].EndPage < mapped[last].StartPage {
+				mapped = mapped[:last]
+			}
+		}
+		mapped = append(mapped, r)
+	}
+	return mapped
+}
+
+// MapRangesToSplitPages 把原扫描件页码的学生分页换算为双栏拆分后的页码，每页的左右两部分都归入该学生
+func MapRangesToSplitPages(ranges []models.StudentPageRange, pages []int) []models.StudentPageRange {
+	mapped := make([]models.StudentPageRange, len(ranges))
+	for i, r := range ranges {
+		// pages按原页码递增，第一个不小于起始页的位置即该页的第一部分
+		r.StartPage = sort.SearchInts(pages, r.StartPage) + 1
+		r.EndPage = sort.SearchInts(pages, r.EndPage+1)
+		mapped[i] = r
+	}
+	return mapped
+}
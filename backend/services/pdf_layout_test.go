@@ -0,0 +1,126 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// TestSplitDoubleLayoutPDF 测试横向页面被裁剪为左右两页，竖向页面保持不变
+func TestSplitDoubleLayoutPDF(t *testing.T) {
+	dir := t.TempDir()
+
+	var imageFiles []string
+	for i, size := range [][2]int{{80, 40}, {40, 60}} {
+		img := image.NewRGBA(image.Rect(0, 0, size[0], size[1]))
+		for x := 0; x < size[0]; x++ {
+			for y := 0; y < size[1]; y++ {
+				img.Set(x, y, color.White)
+			}
+		}
+		imagePath := filepath.Join(dir, string(rune('a'+i))+".png")
+		file, err := os.Create(imagePath)
+		if err != nil {
+			t.Fatalf("创建测试图片失败: %v", err)
+		}
+		if err := png.Encode(file, img); err != nil {
+			t.Fatalf("写入测试图片失败: %v", err)
+		}
+		file.Close()
+		imageFiles = append(imageFiles, imagePath)
+	}
+
+	input := filepath.Join(dir, "a3.pdf")
+	if err := api.ImportImagesFile(imageFiles, input, nil, nil); err != nil {
+		t.Fatalf("生成测试PDF失败: %v", err)
+	}
+
+	output := filepath.Join(dir, "halves.pdf")
+	pages, err := SplitDoubleLayoutPDF(input, output)
+	if err != nil {
+		t.Fatalf("拆分双栏PDF失败: %v", err)
+	}
+	if !slices.Equal(pages, []int{1, 1, 2}) {
+		t.Fatalf("拆分后的页应依次来自原扫描件的第1、1、2页，实际为%v", pages)
+	}
+
+	ctx, err := api.ReadContextFile(output)
+	if err != nil {
+		t.Fatalf("读取拆分后的PDF失败: %v", err)
+	}
+	boundaries, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		t.Fatalf("读取页面尺寸失败: %v", err)
+	}
+	if len(boundaries) != 3 {
+		t.Fatalf("拆分后的PDF应有3页，实际为%d页", len(boundaries))
+	}
+
+	left, right, portrait := boundaries[0].CropBox(), boundaries[1].CropBox(), boundaries[2].CropBox()
+	if left.Width() != 40 || left.Height() != 40 || right.Width() != 40 || right.Height() != 40 {
+		t.Errorf("左右两页应为40x40，实际为%v和%v", left, right)
+	}
+	if left.LL.X >= right.LL.X {
+		t.Errorf("左半页应排在右半页之前，实际为%v和%v", left, right)
+	}
+	if portrait.Width() != 40 || portrait.Height() != 60 {
+		t.Errorf("竖向页面不应被裁剪，实际为%v", portrait)
+	}
+}
+
+// TestSplitPageBoxRotated 测试旋转页面按显示方向拆分左右两半
+func TestSplitPageBoxRotated(t *testing.T) {
+	// 页面坐标为竖向，旋转90°后显示为横向，显示的左半部分对应页面坐标的下半部分
+	media := types.NewRectangle(0, 0, 40, 80)
+	left, right, ok := splitPageBox(model.PageBoundaries{Media: &model.Box{Rect: media}, Rot: 90})
+	if !ok {
+		t.Fatal("旋转90°的竖向页面应按横向页面拆分")
+	}
+	if left.LL.Y != 0 || left.UR.Y != 40 || right.LL.Y != 40 || right.UR.Y != 80 {
+		t.Errorf("旋转90°的拆分结果错误: %v, %v", left, right)
+	}
+
+	if _, _, ok := splitPageBox(model.PageBoundaries{Media: &model.Box{Rect: media}}); ok {
+		t.Error("未旋转的竖向页面不应拆分")
+	}
+}
+
+// TestMapRangesBetweenSplitAndOriginalPages 测试双栏拆分后的学生分页与原扫描件页码之间的换算
+func TestMapRangesBetweenSplitAndOriginalPages(t *testing.T) {
+	// 第1、2页为双栏，第3页为竖向，第4页为双栏
+	pages := []int{1, 1, 2, 2, 3, 4, 4}
+
+	detected := []models.StudentPageRange{
+		{StartPage: 1, EndPage: 4, Name: "张三"},
+		{StartPage: 5, EndPage: 7, Name: "李四"},
+	}
+	original := MapRangesToOriginalPages(detected, pages)
+	expected := []models.StudentPageRange{
+		{StartPage: 1, EndPage: 2, Name: "张三"},
+		{StartPage: 3, EndPage: 4, Name: "李四"},
+	}
+	if !slices.Equal(original, expected) {
+		t.Errorf("换算为原页码错误: %+v", original)
+	}
+	if split := MapRangesToSplitPages(original, pages); !slices.Equal(split, detected) {
+		t.Errorf("换算为拆分后的页码错误: %+v", split)
+	}
+
+	// 学生从第2页的右半部分开始时，整页归入后一个学生
+	shared := MapRangesToOriginalPages([]models.StudentPageRange{
+		{StartPage: 1, EndPage: 3, Name: "张三"},
+		{StartPage: 4, EndPage: 7, Name: "李四"},
+	}, pages)
+	if len(shared) != 2 || shared[0].EndPage != 1 || shared[1].StartPage != 2 || shared[1].EndPage != 4 {
+		t.Errorf("跨页的学生分页错误: %+v", shared)
+	}
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GiantClam/homework_marking/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// promptTemplatesBucket 提示词模板在BoltDB中的存储桶名称，按作业类型保存
+var promptTemplatesBucket = []byte("prompt_templates")
+
+// PromptTemplates 管理员维护的全局提示词模板，模板保存在内存中，db不为nil时同时写入BoltDB
+type PromptTemplates struct {
+	mutex     sync.RWMutex
+	db        *bolt.DB
+	templates map[string]models.PromptTemplate
+}
+
+// NewPromptTemplates 创建提示词模板存储，并加载已保存的模板；db为nil时不做持久化
+func NewPromptTemplates(db *bolt.DB) (*PromptTemplates, error) {
+	p := &PromptTemplates{
+		db:        db,
+		templates: make(map[string]models.PromptTemplate),
+	}
+	if db == nil {
+		return p, nil
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(promptTemplatesBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var template models.PromptTemplate
+			if err := json.Unmarshal(v, &template); err != nil {
+				log.Printf("[ERROR] 解析已保存的提示词模板 %s 失败: %v", string(k), err)
+				return nil
+			}
+			p.templates[template.HomeworkType] = template
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("加载提示词模板失败: %v", err)
+	}
+
+	log.Printf("[INFO] 已加载 %d 个提示词模板", len(p.templates))
+	return p, nil
+}
+
+// SystemInstruction 返回作业类型的系统指令模板，没有模板时返回false，使用内置的系统指令
+func (p *PromptTemplates) SystemInstruction(homeworkType string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	template, exists := p.templates[homeworkType]
+	return template.SystemInstruction, exists
+}
+
+// List 返回所有提示词模板，按作业类型排序
+func (p *PromptTemplates) List() []models.PromptTemplate {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	templates := make([]models.PromptTemplate, 0, len(p.templates))
+	for _, template := range p.templates {
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].HomeworkType < templates[j].HomeworkType
+	})
+	return templates
+}
+
+// Save 新增或覆盖作业类型的提示词模板
+func (p *PromptTemplates) Save(homeworkType, systemInstruction, updatedBy string) (models.PromptTemplate, error) {
+	homeworkType = strings.TrimSpace(homeworkType)
+	if homeworkType == "" {
+		return models.PromptTemplate{}, fmt.Errorf("作业类型不能为空")
+	}
+	if strings.TrimSpace(systemInstruction) == "" {
+		return models.PromptTemplate{}, fmt.Errorf("系统指令不能为空")
+	}
+
+	template := models.PromptTemplate{
+		HomeworkType:      homeworkType,
+		SystemInstruction: systemInstruction,
+		UpdatedBy:         updatedBy,
+		UpdatedAt:         time.Now(),
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.db != nil {
+		data, err := json.Marshal(template)
+		if err != nil {
+			return template, fmt.Errorf("序列化提示词模板失败: %v", err)
+		}
+		err = p.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(promptTemplatesBucket).Put([]byte(homeworkType), data)
+		})
+		if err != nil {
+			return template, fmt.Errorf("保存提示词模板失败: %v", err)
+		}
+	}
+	p.templates[homeworkType] = template
+
+	log.Printf("[INFO] 用户 %s 更新了作业类型 %s 的提示词模板", updatedBy, homeworkType)
+	return template, nil
+}
+
+// Delete 删除作业类型的提示词模板，恢复使用内置的系统指令，模板不存在时返回false
+func (p *PromptTemplates) Delete(homeworkType string) (bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, exists := p.templates[homeworkType]; !exists {
+		return false, nil
+	}
+	if p.db != nil {
+		err := p.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(promptTemplatesBucket).Delete([]byte(homeworkType))
+		})
+		if err != nil {
+			return false, fmt.Errorf("删除提示词模板失败: %v", err)
+		}
+	}
+	delete(p.templates, homeworkType)
+	return true, nil
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestPromptTemplatesPersist 测试提示词模板保存后重启仍然有效，删除后恢复内置的系统指令
+func TestPromptTemplatesPersist(t *testing.T) {
+	db, err := OpenBoltDB(filepath.Join(t.TempDir(), "prompts.db"))
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	defer db.Close()
+
+	prompts, err := NewPromptTemplates(db)
+	if err != nil {
+		t.Fatalf("创建提示词模板存储失败: %v", err)
+	}
+	if _, err := prompts.Save("math", "  ", "admin"); err == nil {
+		t.Error("空的系统指令应返回错误")
+	}
+	if _, err := prompts.Save("math", "你是一位数学老师", "admin"); err != nil {
+		t.Fatalf("保存提示词模板失败: %v", err)
+	}
+
+	// 重新加载
+	prompts, err = NewPromptTemplates(db)
+	if err != nil {
+		t.Fatalf("加载提示词模板失败: %v", err)
+	}
+	if instruction, ok := prompts.SystemInstruction("math"); !ok || instruction != "你是一位数学老师" {
+		t.Errorf("提示词模板错误: %q %v", instruction, ok)
+	}
+
+	if deleted, err := prompts.Delete("math"); err != nil || !deleted {
+		t.Fatalf("删除提示词模板失败: %v", err)
+	}
+	if _, ok := prompts.SystemInstruction("math"); ok {
+		t.Error("删除后应使用内置的系统指令")
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// SplitModeQR 按封面或贴纸上的二维码拆分学生并确定学生身份
+const SplitModeQR = "qr"
+
+// decodeQRCode 识别图片中的二维码，没有二维码时返回false
+func decodeQRCode(img image.Image) (string, bool) {
+	bitmap, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return "", false
+	}
+
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_TRY_HARDER: true}
+	result, err := qrcode.NewQRCodeReader().Decode(bitmap, hints)
+	if err != nil {
+		return "", false
+	}
+	return result.GetText(), true
+}
+
+// ParseCoverCode 解析二维码内容，支持JSON（{"studentId":"S001","assignmentId":"HW3"}）
+// 和查询字符串（student=S001&assignment=HW3），二维码中必须有学号
+func ParseCoverCode(text string) (*models.CoverCode, error) {
+	text = strings.TrimSpace(text)
+	code := &models.CoverCode{}
+
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), code); err != nil {
+			return nil, fmt.Errorf("解析二维码内容失败: %v", err)
+		}
+	} else {
+		values, err := url.ParseQuery(strings.ReplaceAll(text, ";", "&"))
+		if err != nil {
+			return nil, fmt.Errorf("解析二维码内容失败: %v", err)
+		}
+		code.StudentID = firstValue(values, "studentId", "student")
+		code.AssignmentID = firstValue(values, "assignmentId", "assignment")
+		code.Name = values.Get("name")
+		code.Class = values.Get("class")
+	}
+
+	code.StudentID = strings.TrimSpace(code.StudentID)
+	code.AssignmentID = strings.TrimSpace(code.AssignmentID)
+	if code.StudentID == "" {
+		return nil, fmt.Errorf("二维码中缺少学号: %s", text)
+	}
+	return code, nil
+}
+
+// firstValue 返回第一个有值的参数
+func firstValue(values url.Values, keys ...string) string {
+	for _, key := range keys {
+		if value := values.Get(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// SplitByQRCodes 根据每页识别到的二维码生成学生分页
+// 带二维码的页开始一个新学生（与上一页学号相同时视为同一学生的贴纸），第一个二维码之前的页归为身份未知的学生
+func SplitByQRCodes(codes []*models.CoverCode) []models.StudentPageRange {
+	var ranges []models.StudentPageRange
+	for i, code := range codes {
+		page := i + 1
+		last := len(ranges) - 1
+
+		if code == nil || (last >= 0 && ranges[last].StudentID == code.StudentID && ranges[last].AssignmentID == code.AssignmentID) {
+			if last < 0 {
+				log.Printf("[WARN] 第%d页之前没有二维码，无法确定学生身份", page)
+				ranges = append(ranges, models.StudentPageRange{StartPage: page, EndPage: page})
+			} else {
+				ranges[last].EndPage = page
+			}
+			continue
+		}
+
+		ranges = append(ranges, models.StudentPageRange{
+			StartPage:    page,
+			EndPage:      page,
+			Name:         code.Name,
+			Class:        code.Class,
+			StudentID:    code.StudentID,
+			AssignmentID: code.AssignmentID,
+		})
+	}
+	return ranges
+}
+
+// CheckCoverAssignment 确认二维码中的作业与上传时引用的作业一致，避免把其他作业的答卷按本次作业批改
+// 没有引用作业或二维码中没有作业编号时不检查
+func CheckCoverAssignment(ranges []models.StudentPageRange, assignmentID string) error {
+	if assignmentID == "" {
+		return nil
+	}
+	for _, r := range ranges {
+		if r.AssignmentID != "" && r.AssignmentID != assignmentID {
+			return fmt.Errorf("学号%s的二维码属于作业%s，与上传时指定的作业%s不一致", r.StudentID, r.AssignmentID, assignmentID)
+		}
+	}
+	return nil
+}
+
+// ApplyStudentIdentity 用学生分页中已确定的身份（二维码或教师确认的姓名）覆盖大模型识别的姓名和班级
+func ApplyStudentIdentity(result *models.HomeworkResult, identity models.StudentPageRange) {
+	if identity.StudentID != "" {
+		result.StudentID = identity.StudentID
+		result.AssignmentID = identity.AssignmentID
+		if identity.Name == "" {
+			// 二维码中没有姓名时以学号标识学生，不采用手写姓名的识别结果
+			result.Name = identity.StudentID
+		}
+	}
+	if identity.Name != "" {
+		result.Name = identity.Name
+	}
+	if identity.Class != "" {
+		result.Class = identity.Class
+	}
+}
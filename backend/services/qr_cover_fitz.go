@@ -0,0 +1,46 @@
+//go:build cgo
+
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/gen2brain/go-fitz"
+)
+
+// QRSplitAvailable 是否支持按封面二维码拆分学生，页面栅格化使用的go-fitz需要启用cgo编译
+const QRSplitAvailable = true
+
+// qrRasterDPI 识别二维码时页面栅格化的分辨率
+const qrRasterDPI = 150
+
+// DecodePageQRCodes 将PDF的每一页栅格化并识别其中的二维码，返回按页排列的二维码内容，没有二维码的页为nil
+func DecodePageQRCodes(pdfPath string) ([]*models.CoverCode, error) {
+	doc, err := fitz.New(pdfPath)
+	if err != nil {
+		return nil, fmt.Errorf("打开PDF失败: %v", err)
+	}
+	defer doc.Close()
+
+	codes := make([]*models.CoverCode, doc.NumPage())
+	for page := range codes {
+		img, err := doc.ImageDPI(page, qrRasterDPI)
+		if err != nil {
+			return nil, fmt.Errorf("栅格化第%d页失败: %v", page+1, err)
+		}
+
+		text, ok := decodeQRCode(img)
+		if !ok {
+			continue
+		}
+		code, err := ParseCoverCode(text)
+		if err != nil {
+			log.Printf("[WARN] 第%d页的二维码无法识别为学生信息: %v", page+1, err)
+			continue
+		}
+		codes[page] = code
+	}
+	return codes, nil
+}
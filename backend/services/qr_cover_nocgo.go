@@ -0,0 +1,17 @@
+//go:build !cgo
+
+package services
+
+import (
+	"fmt"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// QRSplitAvailable 是否支持按封面二维码拆分学生，没有启用cgo编译时go-fitz无法栅格化页面
+const QRSplitAvailable = false
+
+// DecodePageQRCodes 没有启用cgo编译时不支持识别二维码
+func DecodePageQRCodes(pdfPath string) ([]*models.CoverCode, error) {
+	return nil, fmt.Errorf("服务未启用cgo编译，不支持识别二维码")
+}
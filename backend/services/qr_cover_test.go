@@ -0,0 +1,119 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// writeQRCodePDF 生成测试PDF，contents中每一项对应一页，为空时生成没有二维码的页面
+func writeQRCodePDF(t *testing.T, path string, contents []string) {
+	t.Helper()
+
+	var imageFiles []string
+	for i, content := range contents {
+		img := image.NewGray(image.Rect(0, 0, 200, 200))
+		for x := 0; x < 200; x++ {
+			for y := 0; y < 200; y++ {
+				img.Set(x, y, color.White)
+			}
+		}
+		if content != "" {
+			matrix, err := qrcode.NewQRCodeWriter().Encode(content, gozxing.BarcodeFormat_QR_CODE, 200, 200, nil)
+			if err != nil {
+				t.Fatalf("生成二维码失败: %v", err)
+			}
+			for x := 0; x < 200; x++ {
+				for y := 0; y < 200; y++ {
+					if matrix.Get(x, y) {
+						img.Set(x, y, color.Black)
+					}
+				}
+			}
+		}
+
+		imagePath := filepath.Join(t.TempDir(), "page"+strconv.Itoa(i)+".png")
+		file, err := os.Create(imagePath)
+		if err != nil {
+			t.Fatalf("创建测试图片失败: %v", err)
+		}
+		if err := png.Encode(file, img); err != nil {
+			t.Fatalf("写入测试图片失败: %v", err)
+		}
+		file.Close()
+		imageFiles = append(imageFiles, imagePath)
+	}
+
+	if err := api.ImportImagesFile(imageFiles, path, nil, nil); err != nil {
+		t.Fatalf("生成测试PDF失败: %v", err)
+	}
+}
+
+// TestDecodePageQRCodesAndSplit 测试识别每页的二维码，并按二维码拆分学生和确定学生身份
+func TestDecodePageQRCodesAndSplit(t *testing.T) {
+	if !QRSplitAvailable {
+		t.Skip("未启用cgo编译，无法栅格化PDF页面")
+	}
+	pdfPath := filepath.Join(t.TempDir(), "batch.pdf")
+	writeQRCodePDF(t, pdfPath, []string{
+		"",
+		`{"studentId":"S001","assignmentId":"HW3","name":"张三"}`,
+		"",
+		"student=S002&assignment=HW3",
+		"student=S002&assignment=HW3",
+	})
+
+	codes, err := DecodePageQRCodes(pdfPath)
+	if err != nil {
+		t.Fatalf("识别二维码失败: %v", err)
+	}
+	if len(codes) != 5 || codes[0] != nil || codes[1] == nil || codes[1].StudentID != "S001" || codes[3] == nil || codes[3].AssignmentID != "HW3" {
+		t.Fatalf("二维码识别结果错误: %+v", codes)
+	}
+
+	ranges := SplitByQRCodes(codes)
+	expected := []models.StudentPageRange{
+		{StartPage: 1, EndPage: 1},
+		{StartPage: 2, EndPage: 3, Name: "张三", StudentID: "S001", AssignmentID: "HW3"},
+		{StartPage: 4, EndPage: 5, StudentID: "S002", AssignmentID: "HW3"},
+	}
+	if len(ranges) != len(expected) {
+		t.Fatalf("学生分页错误: %+v", ranges)
+	}
+	for i := range expected {
+		if ranges[i] != expected[i] {
+			t.Errorf("第%d个学生的分页错误: %+v，预期: %+v", i+1, ranges[i], expected[i])
+		}
+	}
+
+	// 二维码中没有姓名时以学号标识学生，不采用大模型识别的手写姓名
+	result := models.HomeworkResult{Name: "张山", Class: "一班"}
+	ApplyStudentIdentity(&result, ranges[2])
+	if result.Name != "S002" || result.StudentID != "S002" || result.AssignmentID != "HW3" || result.Class != "一班" {
+		t.Errorf("学生身份错误: %+v", result)
+	}
+
+	if _, err := ParseCoverCode("assignment=HW3"); err == nil {
+		t.Error("缺少学号的二维码应返回错误")
+	}
+
+	// 二维码中的作业必须是上传时引用的作业
+	if err := CheckCoverAssignment(ranges, "HW3"); err != nil {
+		t.Errorf("作业一致时不应返回错误: %v", err)
+	}
+	if err := CheckCoverAssignment(ranges, "HW4"); err == nil {
+		t.Error("二维码属于其他作业时应返回错误")
+	}
+	if err := CheckCoverAssignment(ranges, ""); err != nil {
+		t.Errorf("没有引用作业时不检查: %v", err)
+	}
+}
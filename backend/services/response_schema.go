@@ -0,0 +1,152 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// ResponseSchema 约束大模型JSON输出的结构（OpenAPI子集），各提供方转换为各自的格式
+type ResponseSchema struct {
+	Name        string                     // 结构名称，OpenAI的response_format需要
+	Type        string                     // object、array、string、number、integer、boolean
+	Description string                     // 字段说明
+	Properties  map[string]*ResponseSchema // 对象的字段
+	Items       *ResponseSchema            // 数组的元素
+	Required    []string                   // 对象的必填字段
+}
+
+// HomeworkResultSchema 单个学生批改结果的输出结构，与models.HomeworkResult对应
+var HomeworkResultSchema = &ResponseSchema{
+	Name: "homework_result",
+	Type: "object",
+	Properties: map[string]*ResponseSchema{
+		"name":  {Type: "string", Description: "学生姓名，无法识别时为空字符串"},
+		"class": {Type: "string", Description: "班级，无法识别时为空字符串"},
+		"answers": {
+			Type:        "array",
+			Description: "按题号顺序排列的每道题的答案",
+			Items: &ResponseSchema{
+				Type: "object",
+				Properties: map[string]*ResponseSchema{
+					"questionNumber": {Type: "string", Description: "题号"},
+					"studentAnswer":  {Type: "string", Description: "学生的手写答案"},
+					"isCorrect":      {Type: "boolean", Description: "答案是否正确"},
+					"correctAnswer":  {Type: "string", Description: "正确答案"},
+					"explanation":    {Type: "string", Description: "简短答案解释"},
+					"maxPoints":      {Type: "number", Description: "该题满分"},
+					"awardedPoints":  {Type: "number", Description: "该题得分，0到满分之间，部分正确时可给部分分"},
+					"rationale":      {Type: "string", Description: "给分理由，部分给分时说明扣分原因"},
+					"confidence":     {Type: "number", Description: "对识别和判定的把握，0到1之间，字迹难以辨认或无法确定对错时给较低的值"},
+					"illegible":      {Type: "boolean", Description: "学生的答案字迹无法辨认时为true"},
+				},
+				Required: []string{"questionNumber", "studentAnswer", "isCorrect", "correctAnswer", "maxPoints", "awardedPoints"},
+			},
+		},
+		"feedback": {Type: "string", Description: "整体评价和建议"},
+	},
+	Required: []string{"name", "class", "answers", "feedback"},
+}
+
+// toGenAI 转换为Vertex AI的Schema
+func (s *ResponseSchema) toGenAI() *genai.Schema {
+	if s == nil {
+		return nil
+	}
+
+	schema := &genai.Schema{
+		Description: s.Description,
+		Items:       s.Items.toGenAI(),
+		Required:    s.Required,
+	}
+	switch s.Type {
+	case "object":
+		schema.Type = genai.TypeObject
+	case "array":
+		schema.Type = genai.TypeArray
+	case "number":
+		schema.Type = genai.TypeNumber
+	case "integer":
+		schema.Type = genai.TypeInteger
+	case "boolean":
+		schema.Type = genai.TypeBoolean
+	default:
+		schema.Type = genai.TypeString
+	}
+	if len(s.Properties) > 0 {
+		schema.Properties = make(map[string]*genai.Schema, len(s.Properties))
+		for name, prop := range s.Properties {
+			schema.Properties[name] = prop.toGenAI()
+		}
+	}
+	return schema
+}
+
+// toJSONSchema 转换为JSON Schema，用于OpenAI兼容接口的response_format
+func (s *ResponseSchema) toJSONSchema() map[string]interface{} {
+	schema := map[string]interface{}{"type": s.Type}
+	if s.Description != "" {
+		schema["description"] = s.Description
+	}
+	if s.Items != nil {
+		schema["items"] = s.Items.toJSONSchema()
+	}
+	if len(s.Properties) > 0 {
+		properties := make(map[string]interface{}, len(s.Properties))
+		for name, prop := range s.Properties {
+			properties[name] = prop.toJSONSchema()
+		}
+		schema["properties"] = properties
+	}
+	if len(s.Required) > 0 {
+		schema["required"] = s.Required
+	}
+	return schema
+}
+
+// ValidateHomeworkResult 按HomeworkResultSchema校验大模型的输出并解析为批改结果
+// 校验失败时返回的错误说明具体问题，可直接用于纠正提示词
+func ValidateHomeworkResult(text string) (models.HomeworkResult, error) {
+	var result models.HomeworkResult
+
+	text = strings.TrimSpace(text)
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(text), &fields); err != nil {
+		return result, fmt.Errorf("返回内容不是有效的JSON对象: %v", err)
+	}
+
+	var missing []string
+	for _, name := range HomeworkResultSchema.Required {
+		if _, ok := fields[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return result, fmt.Errorf("缺少必填字段: %s", strings.Join(missing, ", "))
+	}
+
+	result, err := ParseHomeworkResult(text)
+	if err != nil {
+		return result, err
+	}
+
+	for i, answer := range result.Answers {
+		if strings.TrimSpace(answer.QuestionNumber) == "" {
+			return result, fmt.Errorf("第%d个答案缺少questionNumber", i+1)
+		}
+		if answer.IsCorrect == nil {
+			return result, fmt.Errorf("第%d个答案(题号%s)的isCorrect必须是true或false", i+1, answer.QuestionNumber)
+		}
+		if answer.MaxPoints == nil || *answer.MaxPoints < 0 {
+			return result, fmt.Errorf("第%d个答案(题号%s)的maxPoints必须是不小于0的数字", i+1, answer.QuestionNumber)
+		}
+		if answer.AwardedPoints == nil || *answer.AwardedPoints < 0 || *answer.AwardedPoints > *answer.MaxPoints {
+			return result, fmt.Errorf("第%d个答案(题号%s)的awardedPoints必须是0到maxPoints之间的数字", i+1, answer.QuestionNumber)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// AnswerOverride 教师对单题的复核，按题号指定题目
+// accept认可大模型的判定；reject将对错取反，得分改为满分或0分；edit修改给出的字段，
+// 只给出isCorrect时得分改为满分或0分，只给出awardedPoints时得到满分才算正确
+type AnswerOverride struct {
+	QuestionNumber string   `json:"questionNumber"`
+	Action         string   `json:"action"`
+	IsCorrect      *bool    `json:"isCorrect"`
+	AwardedPoints  *float64 `json:"awardedPoints"`
+	Explanation    *string  `json:"explanation"`
+	Suggestion     *string  `json:"suggestion"`
+}
+
+// ResultOverride 教师对一个学生批改结果的复核，没有修改任何题目时表示认可整份批改结果
+type ResultOverride struct {
+	Answers  []AnswerOverride `json:"answers"`
+	Feedback *string          `json:"feedback"` // 修改整体评价
+}
+
+// ReviewResult 按教师的复核修改批改结果：修改的题目记录复核人、时间和大模型原始的判定，
+// 按每题得分重新计算总得分，并将结果标记为已复核。返回错误时result可能已被部分修改，调用方应丢弃
+func ReviewResult(result *models.HomeworkResult, override ResultOverride, reviewerID string, opts ScoringOptions) error {
+	if result.Status == models.ResultStatusError {
+		return fmt.Errorf("批改失败的结果不能复核")
+	}
+	if len(override.Answers) > 0 && len(result.Criteria) > 0 {
+		return fmt.Errorf("作文按评分项计分，不能复核单题")
+	}
+
+	answerIndex := make(map[string]int, len(result.Answers))
+	for i, answer := range result.Answers {
+		answerIndex[normalizeQuestionNumber(answer.QuestionNumber)] = i
+	}
+
+	now := time.Now()
+	reviewed := make(map[int]bool, len(override.Answers))
+	for _, item := range override.Answers {
+		idx, ok := answerIndex[normalizeQuestionNumber(item.QuestionNumber)]
+		if !ok {
+			return fmt.Errorf("题号%s不存在", item.QuestionNumber)
+		}
+		if reviewed[idx] {
+			return fmt.Errorf("题号%s重复复核", item.QuestionNumber)
+		}
+		reviewed[idx] = true
+
+		answer := &result.Answers[idx]
+		original := models.AnswerVerdict{
+			IsCorrect:     answer.IsCorrect,
+			AwardedPoints: answer.AwardedPoints,
+			Explanation:   answer.Explanation,
+			Suggestion:    answer.Suggestion,
+		}
+		// 多次复核时保留大模型最初的判定
+		if answer.Review != nil {
+			original = answer.Review.Original
+		}
+		if err := applyAnswerOverride(answer, item); err != nil {
+			return err
+		}
+		answer.Review = &models.AnswerReview{
+			Action:     item.Action,
+			ReviewerID: reviewerID,
+			ReviewedAt: now,
+			Original:   original,
+		}
+	}
+
+	review := &models.ResultReview{
+		OriginalScore:    result.OverallScore,
+		OriginalFeedback: result.Feedback,
+	}
+	if result.Review != nil {
+		review.OriginalScore, review.OriginalFeedback = result.Review.OriginalScore, result.Review.OriginalFeedback
+	}
+	review.ReviewerID, review.ReviewedAt = reviewerID, now
+	result.Review = review
+
+	if override.Feedback != nil {
+		result.Feedback = strings.TrimSpace(*override.Feedback)
+	}
+	// 作文的总得分由评分项决定，单题复核不影响
+	if len(result.Criteria) == 0 {
+		ScoreResult(result, opts)
+	}
+	result.Status = models.ResultStatusReviewed
+	return nil
+}
+
+// applyAnswerOverride 按复核方式修改单题的对错、得分和评语，修改时替换指针而不改动原来指向的值
+func applyAnswerOverride(answer *models.HomeworkAnswer, item AnswerOverride) error {
+	maxPoints := 1.0
+	if answer.MaxPoints != nil {
+		maxPoints = *answer.MaxPoints
+	}
+	setCorrect := func(correct bool) {
+		awarded := 0.0
+		if correct {
+			awarded = maxPoints
+		}
+		answer.IsCorrect = &correct
+		answer.AwardedPoints = &awarded
+	}
+
+	switch item.Action {
+	case models.ReviewActionAccept:
+		return nil
+	case models.ReviewActionReject:
+		if answer.IsCorrect == nil {
+			return fmt.Errorf("题号%s没有判定对错，请使用edit修改", item.QuestionNumber)
+		}
+		setCorrect(!*answer.IsCorrect)
+		return nil
+	case models.ReviewActionEdit:
+	default:
+		return fmt.Errorf("题号%s的复核方式无效: %s，只支持accept、reject和edit", item.QuestionNumber, item.Action)
+	}
+
+	if item.IsCorrect == nil && item.AwardedPoints == nil && item.Explanation == nil && item.Suggestion == nil {
+		return fmt.Errorf("题号%s没有需要修改的内容", item.QuestionNumber)
+	}
+	if item.IsCorrect != nil {
+		setCorrect(*item.IsCorrect)
+	}
+	if item.AwardedPoints != nil {
+		awarded := *item.AwardedPoints
+		if awarded < 0 || awarded > maxPoints {
+			return fmt.Errorf("题号%s的得分必须在0到%s分之间", item.QuestionNumber, formatScore(maxPoints))
+		}
+		answer.AwardedPoints = &awarded
+		if item.IsCorrect == nil {
+			correct := awarded >= maxPoints
+			answer.IsCorrect = &correct
+		}
+	}
+	if item.Explanation != nil {
+		answer.Explanation = strings.TrimSpace(*item.Explanation)
+	}
+	if item.Suggestion != nil {
+		answer.Suggestion = strings.TrimSpace(*item.Suggestion)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// DefaultReviewConfidenceThreshold 默认的复核阈值，把握低于该值的判定进入复核队列
+const DefaultReviewConfidenceThreshold = 0.7
+
+// reviewReasonPriority 复核原因的处理顺序，数值越小越靠前
+var reviewReasonPriority = map[string]int{
+	models.ReviewReasonNameMissing:   0,
+	models.ReviewReasonNameUnmatched: 1,
+	models.ReviewReasonIllegible:     2,
+	models.ReviewReasonLowConfidence: 3,
+	models.ReviewReasonNameFuzzy:     4,
+}
+
+// BuildReviewQueue 收集任务中需要教师复核的学生结果和题目，tasks按开始时间从新到旧排列
+// 姓名没有识别出或没有匹配到名单的学生排在最前，其次是字迹无法辨认的题目，再按把握从低到高排列低于threshold的题目；
+// 同一原因内按任务、学生和题目的顺序排列，便于教师打开一份作业连续处理。教师已复核的题目和结果不再列出
+func BuildReviewQueue(tasks []*HomeworkTask, threshold float64) []models.ReviewQueueItem {
+	items := []models.ReviewQueueItem{}
+	for _, task := range tasks {
+		for _, result := range task.StudentResults {
+			if result == nil || result.Status == models.ResultStatusError {
+				continue
+			}
+			newItem := func(reason string) models.ReviewQueueItem {
+				return models.ReviewQueueItem{
+					Reason:       reason,
+					TaskID:       task.ID,
+					AssignmentID: task.AssignmentID,
+					ClassID:      task.ClassID,
+					StudentIndex: result.StudentIndex,
+					Name:         result.Name,
+					PdfURL:       result.PdfURL,
+				}
+			}
+
+			if reason := nameReviewReason(result); reason != "" {
+				items = append(items, newItem(reason))
+			}
+			if result.Status == models.ResultStatusReviewed {
+				continue
+			}
+			for _, answer := range result.Answers {
+				reason := answerReviewReason(answer, threshold)
+				if reason == "" {
+					continue
+				}
+				item := newItem(reason)
+				item.QuestionNumber = answer.QuestionNumber
+				item.StudentAnswer = answer.StudentAnswer
+				item.IsCorrect = answer.IsCorrect
+				item.MaxPoints = answer.MaxPoints
+				item.AwardedPoints = answer.AwardedPoints
+				item.Confidence = answer.Confidence
+				items = append(items, item)
+			}
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if reviewReasonPriority[a.Reason] != reviewReasonPriority[b.Reason] {
+			return reviewReasonPriority[a.Reason] < reviewReasonPriority[b.Reason]
+		}
+		if a.Reason == models.ReviewReasonLowConfidence {
+			return *a.Confidence < *b.Confidence
+		}
+		return false
+	})
+	return items
+}
+
+// nameReviewReason 返回学生结果需要核对姓名的原因，不需要核对时返回空字符串
+func nameReviewReason(result *models.HomeworkResult) string {
+	if result.RosterMatch != nil {
+		switch result.RosterMatch.Status {
+		case models.RosterMatchUnmatched:
+			return models.ReviewReasonNameUnmatched
+		case models.RosterMatchFuzzy:
+			return models.ReviewReasonNameFuzzy
+		}
+		return ""
+	}
+	if strings.TrimSpace(result.Name) == "" {
+		return models.ReviewReasonNameMissing
+	}
+	return ""
+}
+
+// answerReviewReason 返回题目需要复核的原因，已复核或不需要复核时返回空字符串
+func answerReviewReason(answer models.HomeworkAnswer, threshold float64) string {
+	switch {
+	case answer.Review != nil:
+		return ""
+	case answer.Illegible:
+		return models.ReviewReasonIllegible
+	case answer.Confidence != nil && *answer.Confidence < threshold:
+		return models.ReviewReasonLowConfidence
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// TestParseAnswerConfidence 测试解析大模型给出的把握程度和字迹无法辨认的标记
+func TestParseAnswerConfidence(t *testing.T) {
+	result, err := ParseHomeworkResult(`{"name":"张三","answers":[
+		{"questionNumber":"1","confidence":0.45,"illegible":"true"},
+		{"questionNumber":"2","confidence":"85"},
+		{"questionNumber":"3","confidence":"很高"}
+	]}`)
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	answers := result.Answers
+	if answers[0].Confidence == nil || *answers[0].Confidence != 0.45 || !answers[0].Illegible {
+		t.Errorf("第1题解析错误: %+v", answers[0])
+	}
+	if answers[1].Confidence == nil || *answers[1].Confidence != 0.85 || answers[1].Illegible {
+		t.Errorf("百分数应换算为0到1之间: %+v", answers[1])
+	}
+	if answers[2].Confidence != nil {
+		t.Errorf("无法解析的把握程度应为nil: %+v", answers[2])
+	}
+}
+
+// TestBuildReviewQueue 测试复核队列的筛选和排序：姓名问题在前，其次是字迹无法辨认，再按把握从低到高
+func TestBuildReviewQueue(t *testing.T) {
+	confidence := func(value float64) *float64 { return &value }
+	answer := func(number string, value *float64) models.HomeworkAnswer {
+		return models.HomeworkAnswer{QuestionNumber: number, Confidence: value}
+	}
+
+	tasks := []*HomeworkTask{
+		{ID: "task-2", StartTime: time.Now(), StudentResults: []*models.HomeworkResult{
+			{StudentIndex: 0, Name: "张三", Status: models.ResultStatusGraded, Answers: []models.HomeworkAnswer{
+				answer("1", confidence(0.6)),
+				answer("2", confidence(0.9)),
+				answer("3", nil),
+				{QuestionNumber: "4", Confidence: confidence(0.1), Review: &models.AnswerReview{Action: models.ReviewActionAccept}},
+			}},
+			{StudentIndex: 1, Name: "", Status: models.ResultStatusGraded, Answers: []models.HomeworkAnswer{
+				{QuestionNumber: "1", Illegible: true},
+			}},
+			{StudentIndex: 2, Status: models.ResultStatusError},
+			nil,
+		}},
+		{ID: "task-1", StartTime: time.Now().Add(-time.Hour), StudentResults: []*models.HomeworkResult{
+			{StudentIndex: 0, Name: "李四", Status: models.ResultStatusGraded,
+				RosterMatch: &models.RosterMatch{Status: models.RosterMatchFuzzy},
+				Answers:     []models.HomeworkAnswer{answer("1", confidence(0.3))}},
+			{StudentIndex: 1, Name: "王五", Status: models.ResultStatusReviewed,
+				RosterMatch: &models.RosterMatch{Status: models.RosterMatchUnmatched},
+				Answers:     []models.HomeworkAnswer{answer("1", confidence(0.2))}},
+		}},
+	}
+
+	items := BuildReviewQueue(tasks, 0.7)
+	expected := []struct {
+		reason, taskID string
+		studentIndex   int
+		question       string
+	}{
+		{models.ReviewReasonNameMissing, "task-2", 1, ""},
+		{models.ReviewReasonNameUnmatched, "task-1", 1, ""},
+		{models.ReviewReasonIllegible, "task-2", 1, "1"},
+		{models.ReviewReasonLowConfidence, "task-1", 0, "1"},
+		{models.ReviewReasonLowConfidence, "task-2", 0, "1"},
+		{models.ReviewReasonNameFuzzy, "task-1", 0, ""},
+	}
+	if len(items) != len(expected) {
+		t.Fatalf("复核队列应有%d项，实际: %+v", len(expected), items)
+	}
+	for i, want := range expected {
+		item := items[i]
+		if item.Reason != want.reason || item.TaskID != want.taskID || item.StudentIndex != want.studentIndex || item.QuestionNumber != want.question {
+			t.Errorf("第%d项错误: %+v", i+1, item)
+		}
+	}
+
+	if items := BuildReviewQueue(tasks, 0.5); len(items) != 5 {
+		t.Errorf("阈值为0.5时应有5项，实际: %d", len(items))
+	}
+}
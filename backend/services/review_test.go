@@ -0,0 +1,78 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// TestReviewResult 测试教师复核单题后保留大模型原始的判定并重新计算总得分
+func TestReviewResult(t *testing.T) {
+	correct, wrong := true, false
+	two, zero, one := 2.0, 0.0, 1.0
+	result := models.HomeworkResult{
+		Status: models.ResultStatusGraded,
+		Answers: []models.HomeworkAnswer{
+			{QuestionNumber: "1", IsCorrect: &correct, MaxPoints: &two, AwardedPoints: &two},
+			{QuestionNumber: "2", IsCorrect: &wrong, MaxPoints: &two, AwardedPoints: &zero, Explanation: "计算错误"},
+			{QuestionNumber: "3", IsCorrect: &wrong, MaxPoints: &one, AwardedPoints: &zero},
+		},
+		Feedback: "继续努力",
+	}
+	ScoreResult(&result, DefaultScoringOptions())
+	opts := DefaultScoringOptions()
+
+	explanation := "方法正确，结果有笔误"
+	partial := 1.5
+	feedback := "整体不错"
+	err := ReviewResult(&result, ResultOverride{
+		Answers: []AnswerOverride{
+			{QuestionNumber: "1", Action: models.ReviewActionAccept},
+			{QuestionNumber: "第2题", Action: models.ReviewActionEdit, AwardedPoints: &partial, Explanation: &explanation},
+			{QuestionNumber: "3", Action: models.ReviewActionReject},
+		},
+		Feedback: &feedback,
+	}, "teacher-1", opts)
+	if err != nil {
+		t.Fatalf("复核失败: %v", err)
+	}
+
+	// (2+1.5+1)/5
+	if result.OverallScore != "90" || result.Status != models.ResultStatusReviewed || result.Feedback != feedback {
+		t.Errorf("复核后的结果错误: %s %s %s", result.OverallScore, result.Status, result.Feedback)
+	}
+	if result.Review == nil || result.Review.OriginalScore != "40" || result.Review.OriginalFeedback != "继续努力" || result.Review.ReviewerID != "teacher-1" {
+		t.Errorf("应保存复核前的总得分和评价: %+v", result.Review)
+	}
+	second := result.Answers[1]
+	if *second.IsCorrect || second.Explanation != explanation || second.Review == nil ||
+		*second.Review.Original.AwardedPoints != 0 || second.Review.Original.Explanation != "计算错误" {
+		t.Errorf("第2题的复核错误: %+v %+v", second, second.Review)
+	}
+	if third := result.Answers[2]; !*third.IsCorrect || *third.AwardedPoints != 1 || *third.Review.Original.IsCorrect {
+		t.Errorf("第3题应改为正确: %+v", third)
+	}
+
+	// 再次复核时保留大模型最初的判定
+	if err := ReviewResult(&result, ResultOverride{Answers: []AnswerOverride{{QuestionNumber: "2", Action: models.ReviewActionReject}}}, "teacher-2", opts); err != nil {
+		t.Fatalf("再次复核失败: %v", err)
+	}
+	if second := result.Answers[1]; !*second.IsCorrect || *second.Review.Original.IsCorrect || result.Review.OriginalScore != "40" || result.OverallScore != "100" {
+		t.Errorf("再次复核的结果错误: %+v %s", second, result.OverallScore)
+	}
+
+	tooMany := 3.0
+	invalid := []AnswerOverride{
+		{QuestionNumber: "9", Action: models.ReviewActionAccept},
+		{QuestionNumber: "1", Action: "approve"},
+		{QuestionNumber: "1", Action: models.ReviewActionEdit},
+		{QuestionNumber: "1", Action: models.ReviewActionEdit, AwardedPoints: &tooMany},
+	}
+	for _, item := range invalid {
+		copied := result
+		copied.Answers = append([]models.HomeworkAnswer(nil), result.Answers...)
+		if err := ReviewResult(&copied, ResultOverride{Answers: []AnswerOverride{item}}, "teacher-1", opts); err == nil {
+			t.Errorf("无效的复核应返回错误: %+v", item)
+		}
+	}
+}
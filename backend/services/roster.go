@@ -0,0 +1,606 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+var (
+	// classesBucket 班级在BoltDB中的存储桶名称，按班级ID保存
+	classesBucket = []byte("classes")
+	// studentsBucket 学生在BoltDB中的存储桶名称，按学生ID保存
+	studentsBucket = []byte("students")
+)
+
+var (
+	// ErrClassNotFound 班级不存在
+	ErrClassNotFound = errors.New("班级不存在")
+	// ErrStudentNotFound 学生不存在
+	ErrStudentNotFound = errors.New("学生不存在")
+	// ErrStudentNumberTaken 学号在班级中已存在
+	ErrStudentNumberTaken = errors.New("学号在班级中已存在")
+)
+
+// 班级名称和学生姓名的长度限制
+const (
+	maxClassNameLength   = 64
+	maxStudentNameLength = 32
+)
+
+// Rosters 班级和学生名单，数据保存在内存中，db不为nil时同时写入BoltDB
+type Rosters struct {
+	mutex    sync.RWMutex
+	db       *bolt.DB
+	classes  map[string]models.Class
+	students map[string]models.Student
+}
+
+// NewRosters 创建班级名单存储，并加载已保存的班级和学生；db为nil时不做持久化
+func NewRosters(db *bolt.DB) (*Rosters, error) {
+	r := &Rosters{
+		db:       db,
+		classes:  make(map[string]models.Class),
+		students: make(map[string]models.Student),
+	}
+	if db == nil {
+		return r, nil
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		classes, err := tx.CreateBucketIfNotExists(classesBucket)
+		if err != nil {
+			return err
+		}
+		students, err := tx.CreateBucketIfNotExists(studentsBucket)
+		if err != nil {
+			return err
+		}
+
+		err = classes.ForEach(func(k, v []byte) error {
+			var class models.Class
+			if err := json.Unmarshal(v, &class); err != nil {
+				log.Printf("[ERROR] 解析已保存的班级 %s 失败: %v", string(k), err)
+				return nil
+			}
+			r.classes[class.ID] = class
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return students.ForEach(func(k, v []byte) error {
+			var student models.Student
+			if err := json.Unmarshal(v, &student); err != nil {
+				log.Printf("[ERROR] 解析已保存的学生 %s 失败: %v", string(k), err)
+				return nil
+			}
+			r.students[student.ID] = student
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("加载班级名单失败: %v", err)
+	}
+
+	log.Printf("[INFO] 已加载 %d 个班级、%d 名学生", len(r.classes), len(r.students))
+	return r, nil
+}
+
+// ListClasses 返回match为true的班级，按名称排序
+func (r *Rosters) ListClasses(match func(class models.Class) bool) []models.Class {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	classes := make([]models.Class, 0, len(r.classes))
+	for _, class := range r.classes {
+		if match == nil || match(class) {
+			classes = append(classes, class)
+		}
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if classes[i].Name != classes[j].Name {
+			return classes[i].Name < classes[j].Name
+		}
+		return classes[i].CreatedAt.Before(classes[j].CreatedAt)
+	})
+	return classes
+}
+
+// GetClass 按班级ID获取班级，不存在时返回ErrClassNotFound
+func (r *Rosters) GetClass(id string) (models.Class, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	class, exists := r.classes[id]
+	if !exists {
+		return models.Class{}, ErrClassNotFound
+	}
+	return class, nil
+}
+
+// CreateClass 创建由teacherID管理的班级
+func (r *Rosters) CreateClass(name, grade, teacherID string) (models.Class, error) {
+	name, err := validRosterName(name, "班级名称", maxClassNameLength)
+	if err != nil {
+		return models.Class{}, err
+	}
+
+	now := time.Now()
+	class := models.Class{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Grade:     strings.TrimSpace(grade),
+		TeacherID: teacherID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.put(classesBucket, class.ID, class); err != nil {
+		return class, err
+	}
+	r.classes[class.ID] = class
+
+	log.Printf("[INFO] 用户 %s 创建了班级 %s (%s)", teacherID, class.ID, class.Name)
+	return class, nil
+}
+
+// ClassUpdate 修改班级的字段，为nil的字段保持不变
+type ClassUpdate struct {
+	Name      *string `json:"name"`
+	Grade     *string `json:"grade"`
+	TeacherID *string `json:"teacherId"` // 转交给其他教师管理，只允许管理员修改
+}
+
+// UpdateClass 修改班级的名称、年级或管理的教师
+func (r *Rosters) UpdateClass(id string, update ClassUpdate) (models.Class, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	class, exists := r.classes[id]
+	if !exists {
+		return class, ErrClassNotFound
+	}
+	if update.Name != nil {
+		name, err := validRosterName(*update.Name, "班级名称", maxClassNameLength)
+		if err != nil {
+			return class, err
+		}
+		class.Name = name
+	}
+	if update.Grade != nil {
+		class.Grade = strings.TrimSpace(*update.Grade)
+	}
+	if update.TeacherID != nil {
+		class.TeacherID = *update.TeacherID
+	}
+	class.UpdatedAt = time.Now()
+
+	if err := r.put(classesBucket, class.ID, class); err != nil {
+		return class, err
+	}
+	r.classes[class.ID] = class
+	return class, nil
+}
+
+// DeleteClass 删除班级及其名单中的所有学生
+func (r *Rosters) DeleteClass(id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.classes[id]; !exists {
+		return ErrClassNotFound
+	}
+
+	var studentIDs []string
+	for _, student := range r.students {
+		if student.ClassID == id {
+			studentIDs = append(studentIDs, student.ID)
+		}
+	}
+
+	if r.db != nil {
+		err := r.db.Update(func(tx *bolt.Tx) error {
+			for _, studentID := range studentIDs {
+				if err := tx.Bucket(studentsBucket).Delete([]byte(studentID)); err != nil {
+					return err
+				}
+			}
+			return tx.Bucket(classesBucket).Delete([]byte(id))
+		})
+		if err != nil {
+			return fmt.Errorf("删除班级失败: %v", err)
+		}
+	}
+
+	for _, studentID := range studentIDs {
+		delete(r.students, studentID)
+	}
+	delete(r.classes, id)
+
+	log.Printf("[INFO] 已删除班级 %s 及其 %d 名学生", id, len(studentIDs))
+	return nil
+}
+
+// ListStudents 返回班级名单中的学生，按学号和姓名排序，没有学号的学生排在最后
+func (r *Rosters) ListStudents(classID string) []models.Student {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.studentsOf(classID)
+}
+
+// studentsOf 返回班级的学生，调用方需要持有锁
+func (r *Rosters) studentsOf(classID string) []models.Student {
+	students := make([]models.Student, 0)
+	for _, student := range r.students {
+		if student.ClassID == classID {
+			students = append(students, student)
+		}
+	}
+	sort.Slice(students, func(i, j int) bool {
+		a, b := students[i].StudentNumber, students[j].StudentNumber
+		if a != b {
+			return b == "" || (a != "" && a < b)
+		}
+		return students[i].Name < students[j].Name
+	})
+	return students
+}
+
+// GetStudent 按学生ID获取学生，不存在时返回ErrStudentNotFound
+func (r *Rosters) GetStudent(id string) (models.Student, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	student, exists := r.students[id]
+	if !exists {
+		return models.Student{}, ErrStudentNotFound
+	}
+	return student, nil
+}
+
+// AddStudent 向班级名单添加学生，学号在班级中不能重复
+func (r *Rosters) AddStudent(classID, name, studentNumber string) (models.Student, error) {
+	name, err := validRosterName(name, "学生姓名", maxStudentNameLength)
+	if err != nil {
+		return models.Student{}, err
+	}
+	studentNumber = strings.TrimSpace(studentNumber)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.classes[classID]; !exists {
+		return models.Student{}, ErrClassNotFound
+	}
+	if studentNumber != "" && r.findByNumber(classID, studentNumber) != nil {
+		return models.Student{}, ErrStudentNumberTaken
+	}
+
+	now := time.Now()
+	student := models.Student{
+		ID:            uuid.New().String(),
+		ClassID:       classID,
+		Name:          name,
+		StudentNumber: studentNumber,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := r.put(studentsBucket, student.ID, student); err != nil {
+		return student, err
+	}
+	r.students[student.ID] = student
+	return student, nil
+}
+
+// StudentUpdate 修改学生的字段，为nil的字段保持不变
+type StudentUpdate struct {
+	Name          *string `json:"name"`
+	StudentNumber *string `json:"studentNumber"`
+}
+
+// UpdateStudent 修改班级名单中学生的姓名或学号
+func (r *Rosters) UpdateStudent(classID, id string, update StudentUpdate) (models.Student, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	student, exists := r.students[id]
+	if !exists || student.ClassID != classID {
+		return student, ErrStudentNotFound
+	}
+	if update.Name != nil {
+		name, err := validRosterName(*update.Name, "学生姓名", maxStudentNameLength)
+		if err != nil {
+			return student, err
+		}
+		student.Name = name
+	}
+	if update.StudentNumber != nil {
+		number := strings.TrimSpace(*update.StudentNumber)
+		if other := r.findByNumber(classID, number); number != "" && other != nil && other.ID != id {
+			return student, ErrStudentNumberTaken
+		}
+		student.StudentNumber = number
+	}
+	student.UpdatedAt = time.Now()
+
+	if err := r.put(studentsBucket, student.ID, student); err != nil {
+		return student, err
+	}
+	r.students[student.ID] = student
+	return student, nil
+}
+
+// DeleteStudent 从班级名单中删除学生
+func (r *Rosters) DeleteStudent(classID, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	student, exists := r.students[id]
+	if !exists || student.ClassID != classID {
+		return ErrStudentNotFound
+	}
+	if r.db != nil {
+		err := r.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(studentsBucket).Delete([]byte(id))
+		})
+		if err != nil {
+			return fmt.Errorf("删除学生失败: %v", err)
+		}
+	}
+	delete(r.students, id)
+	return nil
+}
+
+// RosterImportResult CSV名单导入的结果
+type RosterImportResult struct {
+	Created   int      `json:"created"`          // 新增的学生数
+	Updated   int      `json:"updated"`          // 修改了姓名或学号的学生数
+	Unchanged int      `json:"unchanged"`        // 与名单一致、没有修改的学生数
+	Errors    []string `json:"errors,omitempty"` // 无法导入的行及原因
+}
+
+// ImportStudents 从CSV导入班级名单，已有学生按学号（没有学号时按姓名）匹配后更新，其他学生新增
+// CSV的列为姓名和学号，可以有表头（姓名/name、学号/studentNumber），支持UTF-8和Excel导出的GBK编码
+func (r *Rosters) ImportStudents(classID string, reader io.Reader) (RosterImportResult, error) {
+	var result RosterImportResult
+
+	rows, err := parseRosterCSV(reader)
+	if err != nil {
+		return result, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.classes[classID]; !exists {
+		return result, ErrClassNotFound
+	}
+
+	seen := make(map[string]int)
+	for _, row := range rows {
+		name, err := validRosterName(row.name, "学生姓名", maxStudentNameLength)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("第%d行: %v", row.line, err))
+			continue
+		}
+
+		key := "name:" + normalizeName(name)
+		if row.number != "" {
+			key = "number:" + row.number
+		}
+		if line, exists := seen[key]; exists {
+			result.Errors = append(result.Errors, fmt.Sprintf("第%d行: 与第%d行重复", row.line, line))
+			continue
+		}
+		seen[key] = row.line
+
+		var existing *models.Student
+		if row.number != "" {
+			existing = r.findByNumber(classID, row.number)
+		}
+		if existing == nil {
+			existing = r.findByName(classID, name)
+			// 按姓名匹配到的学生已有不同的学号时视为同名的另一名学生
+			if existing != nil && row.number != "" && existing.StudentNumber != "" {
+				existing = nil
+			}
+		}
+
+		now := time.Now()
+		student := models.Student{
+			ID:            uuid.New().String(),
+			ClassID:       classID,
+			Name:          name,
+			StudentNumber: row.number,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if existing != nil {
+			if existing.Name == name && (row.number == "" || existing.StudentNumber == row.number) {
+				result.Unchanged++
+				continue
+			}
+			student = *existing
+			student.Name = name
+			if row.number != "" {
+				student.StudentNumber = row.number
+			}
+			student.UpdatedAt = now
+		}
+
+		if err := r.put(studentsBucket, student.ID, student); err != nil {
+			return result, err
+		}
+		r.students[student.ID] = student
+		if existing != nil {
+			result.Updated++
+		} else {
+			result.Created++
+		}
+	}
+
+	log.Printf("[INFO] 班级 %s 导入名单: 新增 %d, 更新 %d, 未变 %d, 错误 %d",
+		classID, result.Created, result.Updated, result.Unchanged, len(result.Errors))
+	return result, nil
+}
+
+// Matcher 创建按这些班级的名单匹配批改结果的匹配器，名单为空时返回nil（不做匹配）
+func (r *Rosters) Matcher(classes []models.Class) *RosterMatcher {
+	if r == nil {
+		return nil
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var students []models.Student
+	for _, class := range classes {
+		students = append(students, r.studentsOf(class.ID)...)
+	}
+	if len(students) == 0 {
+		return nil
+	}
+	return NewRosterMatcher(classes, students)
+}
+
+// findByNumber 按学号查找班级中的学生，调用方需要持有锁
+func (r *Rosters) findByNumber(classID, studentNumber string) *models.Student {
+	for _, student := range r.students {
+		if student.ClassID == classID && student.StudentNumber == studentNumber {
+			return &student
+		}
+	}
+	return nil
+}
+
+// findByName 按规范化后的姓名查找班级中的学生，调用方需要持有锁
+func (r *Rosters) findByName(classID, name string) *models.Student {
+	key := normalizeName(name)
+	for _, student := range r.students {
+		if student.ClassID == classID && normalizeName(student.Name) == key {
+			return &student
+		}
+	}
+	return nil
+}
+
+// put 将班级或学生写入BoltDB，调用方需要持有锁
+func (r *Rosters) put(bucket []byte, id string, value interface{}) error {
+	if r.db == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("序列化名单数据失败: %v", err)
+	}
+	err = r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(id), data)
+	})
+	if err != nil {
+		return fmt.Errorf("保存名单数据失败: %v", err)
+	}
+	return nil
+}
+
+// validRosterName 去掉首尾空白后检查名称不为空且不超过最大长度
+func validRosterName(name, field string, maxLength int) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%s不能为空", field)
+	}
+	if utf8.RuneCountInString(name) > maxLength {
+		return "", fmt.Errorf("%s不能超过%d个字符", field, maxLength)
+	}
+	return name, nil
+}
+
+// rosterRow CSV名单中的一行
+type rosterRow struct {
+	line   int
+	name   string
+	number string
+}
+
+// parseRosterCSV 解析CSV名单，识别可选的表头；没有表头时第一列为姓名、第二列为学号
+func parseRosterCSV(reader io.Reader) ([]rosterRow, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("读取名单失败: %v", err)
+	}
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	// Excel在中文系统中默认以GBK编码导出CSV
+	if !utf8.Valid(data) {
+		if data, err = simplifiedchinese.GB18030.NewDecoder().Bytes(data); err != nil {
+			return nil, fmt.Errorf("名单编码无法识别，请保存为UTF-8编码的CSV: %v", err)
+		}
+	}
+
+	csvReader := csv.NewReader(bytes.NewReader(data))
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("解析CSV名单失败: %v", err)
+	}
+
+	nameColumn, numberColumn, start := 0, 1, 0
+	if len(records) > 0 {
+		if header, ok := rosterHeader(records[0]); ok {
+			nameColumn, numberColumn = header[0], header[1]
+			start = 1
+		}
+	}
+
+	var rows []rosterRow
+	for i := start; i < len(records); i++ {
+		record := records[i]
+		row := rosterRow{line: i + 1}
+		if nameColumn < len(record) {
+			row.name = strings.TrimSpace(record[nameColumn])
+		}
+		if numberColumn >= 0 && numberColumn < len(record) {
+			row.number = strings.TrimSpace(record[numberColumn])
+		}
+		// 跳过空行
+		if row.name == "" && row.number == "" {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("名单中没有学生")
+	}
+	return rows, nil
+}
+
+// rosterHeader 识别表头中姓名和学号所在的列，没有学号列时学号列为-1
+func rosterHeader(record []string) ([2]int, bool) {
+	columns := [2]int{-1, -1}
+	for i, field := range record {
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "姓名", "学生姓名", "name":
+			columns[0] = i
+		case "学号", "studentnumber", "student_number", "number":
+			columns[1] = i
+		}
+	}
+	return columns, columns[0] >= 0
+}
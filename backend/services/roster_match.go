@@ -0,0 +1,218 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// 名单匹配的阈值
+const (
+	// fuzzyMatchThreshold 姓名相似度不低于该值时视为近似匹配，三个字的姓名错一个字约为0.67
+	fuzzyMatchThreshold = 0.6
+	// fuzzyMatchMargin 近似匹配时最接近的学生需要比第二接近的学生高出的分数，否则视为无法确定
+	fuzzyMatchMargin = 0.1
+	// classMatchWeight 识别的班级与名单班级的相似度在排序中的权重
+	classMatchWeight = 0.2
+	// classMatchThreshold 名单中有同名学生时，识别的班级与学生班级的相似度不低于该值才能确定是哪一个学生
+	classMatchThreshold = 0.9
+	// maxRosterCandidates 未匹配时返回的候选学生数量
+	maxRosterCandidates = 3
+)
+
+// RosterMatcher 将大模型识别的姓名、班级和封面二维码中的学号匹配到班级名单中的学生
+type RosterMatcher struct {
+	classes  map[string]models.Class
+	students []models.Student
+}
+
+// NewRosterMatcher 创建按指定班级名单匹配的匹配器
+func NewRosterMatcher(classes []models.Class, students []models.Student) *RosterMatcher {
+	m := &RosterMatcher{
+		classes:  make(map[string]models.Class, len(classes)),
+		students: students,
+	}
+	for _, class := range classes {
+		m.classes[class.ID] = class
+	}
+	return m
+}
+
+// rosterScore 名单中一名学生与批改结果的相似度
+type rosterScore struct {
+	student models.Student
+	name    float64 // 姓名相似度
+	class   float64 // 班级相似度，没有识别到班级时为0
+	rank    float64 // 加上班级相似度后用于排序的分数
+}
+
+// Match 将批改结果匹配到名单中的学生，结果记录在result.RosterMatch中
+// 学号一致或姓名完全一致时为exact，姓名近似且没有歧义时为fuzzy，否则为unmatched并附上最接近的几个学生
+func (m *RosterMatcher) Match(result *models.HomeworkResult) {
+	if m == nil || result.Status == models.ResultStatusError {
+		return
+	}
+
+	// 封面二维码中的学号最可靠，匹配后以名单中的姓名作为学生姓名
+	if result.StudentID != "" {
+		for _, student := range m.students {
+			if student.StudentNumber != "" && student.StudentNumber == result.StudentID {
+				result.RosterMatch = newRosterMatch(models.RosterMatchExact, student, 1)
+				result.Name = student.Name
+				return
+			}
+		}
+	}
+
+	name := normalizeName(result.Name)
+	if name == "" {
+		result.RosterMatch = &models.RosterMatch{Status: models.RosterMatchUnmatched}
+		return
+	}
+	className := normalizeClassName(result.Class)
+
+	scores := make([]rosterScore, 0, len(m.students))
+	for _, student := range m.students {
+		score := rosterScore{student: student, name: similarity(name, normalizeName(student.Name))}
+		if className != "" && len(m.classes) > 1 {
+			score.class = similarity(className, normalizeClassName(m.classes[student.ClassID].Name))
+		}
+		score.rank = score.name + classMatchWeight*score.class
+		scores = append(scores, score)
+	}
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].rank > scores[j].rank
+	})
+
+	// 姓名完全一致时，名单中的同名学生只能按识别的班级区分
+	var sameName []rosterScore
+	for _, score := range scores {
+		if score.name == 1 {
+			sameName = append(sameName, score)
+		}
+	}
+	if len(sameName) == 1 || (len(sameName) > 1 && sameName[0].class >= classMatchThreshold && sameName[0].class > sameName[1].class) {
+		result.RosterMatch = newRosterMatch(models.RosterMatchExact, sameName[0].student, 1)
+		return
+	}
+
+	best := scores[0]
+	unique := len(scores) == 1 || best.rank-scores[1].rank >= fuzzyMatchMargin
+	if len(sameName) == 0 && best.name >= fuzzyMatchThreshold && unique {
+		result.RosterMatch = newRosterMatch(models.RosterMatchFuzzy, best.student, best.name)
+		result.RosterMatch.Candidates = rosterCandidates(scores)
+		return
+	}
+
+	result.RosterMatch = &models.RosterMatch{
+		Status:     models.RosterMatchUnmatched,
+		Candidates: rosterCandidates(scores),
+	}
+}
+
+// newRosterMatch 创建匹配到某个学生的匹配结果
+func newRosterMatch(status string, student models.Student, score float64) *models.RosterMatch {
+	return &models.RosterMatch{
+		Status:        status,
+		StudentID:     student.ID,
+		StudentName:   student.Name,
+		StudentNumber: student.StudentNumber,
+		ClassID:       student.ClassID,
+		Score:         roundScore(score),
+	}
+}
+
+// NewManualRosterMatch 创建教师手动指定学生的匹配结果
+func NewManualRosterMatch(student models.Student) *models.RosterMatch {
+	return newRosterMatch(models.RosterMatchManual, student, 1)
+}
+
+// rosterCandidates 返回相似度最高的几个学生
+func rosterCandidates(scores []rosterScore) []models.RosterCandidate {
+	var candidates []models.RosterCandidate
+	for _, score := range scores {
+		if len(candidates) == maxRosterCandidates || score.name == 0 {
+			break
+		}
+		candidates = append(candidates, models.RosterCandidate{
+			StudentID:   score.student.ID,
+			StudentName: score.student.Name,
+			ClassID:     score.student.ClassID,
+			Score:       roundScore(score.name),
+		})
+	}
+	return candidates
+}
+
+// roundScore 相似度保留两位小数
+func roundScore(score float64) float64 {
+	return math.Round(score*100) / 100
+}
+
+// normalizeName 规范化姓名：全角字符转半角、转为小写，去掉空白和姓名中常见的分隔符
+func normalizeName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		// 全角ASCII字符（如全角字母和数字）转换为半角
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		}
+		if unicode.IsSpace(r) || r == '·' || r == '•' || r == '.' || r == '-' || r == '_' || r == '　' {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// chineseDigits 班级名称中常见的中文数字
+var chineseDigits = map[rune]rune{
+	'一': '1', '二': '2', '三': '3', '四': '4', '五': '5',
+	'六': '6', '七': '7', '八': '8', '九': '9', '〇': '0', '零': '0',
+}
+
+// normalizeClassName 规范化班级名称：在normalizeName的基础上把中文数字转换为阿拉伯数字，
+// 使“三年级二班”和“3年级2班”一致
+func normalizeClassName(name string) string {
+	runes := []rune(normalizeName(name))
+	for i, r := range runes {
+		if digit, ok := chineseDigits[r]; ok {
+			runes[i] = digit
+		}
+	}
+	return string(runes)
+}
+
+// similarity 按编辑距离计算两个字符串的相似度，1为完全一致
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+// levenshtein 计算两个字符序列的编辑距离
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(min(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
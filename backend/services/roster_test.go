@@ -0,0 +1,109 @@
+package services
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// TestImportStudents 测试导入CSV名单：识别表头和BOM，按学号更新已有学生，报告重复和无效的行，重启后名单仍在
+func TestImportStudents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roster.db")
+	db, err := OpenBoltDB(path)
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	rosters, err := NewRosters(db)
+	if err != nil {
+		t.Fatalf("创建名单存储失败: %v", err)
+	}
+	class, _ := rosters.CreateClass("三年级二班", "三年级", "teacher-1")
+	rosters.AddStudent(class.ID, "张山", "S001")
+
+	csv := "\xEF\xBB\xBF学号,姓名\nS001,张三\nS002,李四\n,王五\nS002,李四\nS004,\n"
+	result, err := rosters.ImportStudents(class.ID, strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("导入名单失败: %v", err)
+	}
+	if result.Created != 2 || result.Updated != 1 || len(result.Errors) != 2 {
+		t.Errorf("导入结果错误: %+v", result)
+	}
+
+	// 再次导入相同的名单不产生修改；没有表头时第一列为姓名，Excel导出的GBK编码也能识别
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String("张三,S001\n赵六,S005\n")
+	result, err = rosters.ImportStudents(class.ID, strings.NewReader(gbk))
+	if err != nil {
+		t.Fatalf("导入GBK名单失败: %v", err)
+	}
+	if result.Created != 1 || result.Unchanged != 1 {
+		t.Errorf("导入GBK名单结果错误: %+v", result)
+	}
+
+	db.Close()
+	db, err = OpenBoltDB(path)
+	if err != nil {
+		t.Fatalf("重新打开数据库失败: %v", err)
+	}
+	defer db.Close()
+	rosters, _ = NewRosters(db)
+	students := rosters.ListStudents(class.ID)
+	if len(students) != 4 || students[0].Name != "张三" || students[0].StudentNumber != "S001" {
+		t.Errorf("重启后的名单错误: %+v", students)
+	}
+
+	if err := rosters.DeleteClass(class.ID); err != nil || len(rosters.ListStudents(class.ID)) != 0 {
+		t.Errorf("删除班级应同时删除学生: %v", err)
+	}
+}
+
+// TestRosterMatcher 测试按学号、姓名和班级匹配名单中的学生
+func TestRosterMatcher(t *testing.T) {
+	classes := []models.Class{{ID: "c1", Name: "三年级一班"}, {ID: "c2", Name: "三年级二班"}}
+	students := []models.Student{
+		{ID: "s1", ClassID: "c1", Name: "王伟", StudentNumber: "S001"},
+		{ID: "s2", ClassID: "c2", Name: "王伟", StudentNumber: "S101"},
+		{ID: "s3", ClassID: "c1", Name: "欧阳娜娜", StudentNumber: "S002"},
+		{ID: "s4", ClassID: "c2", Name: "Li Lei", StudentNumber: "S102"},
+	}
+	matcher := NewRosterMatcher(classes, students)
+
+	tests := []struct {
+		name      string
+		result    models.HomeworkResult
+		status    string
+		studentID string
+	}{
+		{"二维码学号", models.HomeworkResult{Name: "S101", StudentID: "S101"}, models.RosterMatchExact, "s2"},
+		{"同名学生按班级区分", models.HomeworkResult{Name: "王伟", Class: "3年级2班"}, models.RosterMatchExact, "s2"},
+		{"同名学生没有班级", models.HomeworkResult{Name: "王伟"}, models.RosterMatchUnmatched, ""},
+		{"错一个字", models.HomeworkResult{Name: "欧阳那娜"}, models.RosterMatchFuzzy, "s3"},
+		{"全角和大小写", models.HomeworkResult{Name: "ＬＩ　LEI"}, models.RosterMatchExact, "s4"},
+		{"不在名单中", models.HomeworkResult{Name: "赵六"}, models.RosterMatchUnmatched, ""},
+	}
+	for _, tt := range tests {
+		result := tt.result
+		matcher.Match(&result)
+		if result.RosterMatch == nil || result.RosterMatch.Status != tt.status || result.RosterMatch.StudentID != tt.studentID {
+			t.Errorf("%s: 匹配结果错误: %+v", tt.name, result.RosterMatch)
+		}
+	}
+
+	// 同名学生无法区分时给出候选学生
+	result := models.HomeworkResult{Name: "王伟"}
+	matcher.Match(&result)
+	if len(result.RosterMatch.Candidates) != 2 {
+		t.Errorf("应返回两个同名的候选学生: %+v", result.RosterMatch.Candidates)
+	}
+
+	// 批改失败的结果和没有名单时不做匹配
+	failed := NewErrorResult(0, "失败")
+	matcher.Match(&failed)
+	var empty *RosterMatcher
+	empty.Match(&result)
+	if failed.RosterMatch != nil {
+		t.Error("批改失败的结果不应匹配")
+	}
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// HomeworkTypeEssay 按评分标准批改作文的作业类型
+const HomeworkTypeEssay = "essay"
+
+// EssayResultSchema 作文批改结果的输出结构
+var EssayResultSchema = &ResponseSchema{
+	Name: "essay_result",
+	Type: "object",
+	Properties: map[string]*ResponseSchema{
+		"name":  {Type: "string", Description: "学生姓名，无法识别时为空字符串"},
+		"class": {Type: "string", Description: "班级，无法识别时为空字符串"},
+		"criteria": {
+			Type:        "array",
+			Description: "按评分标准的顺序，每个评分项的得分",
+			Items: &ResponseSchema{
+				Type: "object",
+				Properties: map[string]*ResponseSchema{
+					"name":     {Type: "string", Description: "评分项名称，与评分标准一致"},
+					"score":    {Type: "number", Description: "该项得分，0到该项满分之间"},
+					"band":     {Type: "string", Description: "所属档次"},
+					"evidence": {Type: "string", Description: "支持该得分的作文原文引用"},
+					"comment":  {Type: "string", Description: "该项的评语"},
+				},
+				Required: []string{"name", "score", "evidence"},
+			},
+		},
+		"feedback": {Type: "string", Description: "整体评价和修改建议"},
+	},
+	Required: []string{"name", "class", "criteria", "feedback"},
+}
+
+// DefaultEssayRubric 教师未提供评分标准时使用的默认作文评分标准
+func DefaultEssayRubric() *models.Rubric {
+	bands := func(excellent, good, fair, poor string) []models.RubricBand {
+		return []models.RubricBand{
+			{Label: "一类", Min: 9, Max: 10, Description: excellent},
+			{Label: "二类", Min: 7, Max: 8, Description: good},
+			{Label: "三类", Min: 5, Max: 6, Description: fair},
+			{Label: "四类", Min: 0, Max: 4, Description: poor},
+		}
+	}
+
+	return &models.Rubric{Criteria: []models.RubricCriterion{
+		{Name: "内容", Weight: 40, MaxScore: 10, Bands: bands("切合题意，中心突出，内容充实", "符合题意，中心明确，内容较充实", "基本符合题意，中心基本明确", "偏离题意，内容空洞")},
+		{Name: "结构", Weight: 25, MaxScore: 10, Bands: bands("结构严谨，层次分明", "结构完整，条理清楚", "结构基本完整", "结构混乱")},
+		{Name: "语言", Weight: 25, MaxScore: 10, Bands: bands("语言流畅，用词准确，句式丰富", "语言通顺，少有语病", "语言基本通顺，有语病", "语病多，表达不清")},
+		{Name: "书写", Weight: 10, MaxScore: 10, Bands: bands("书写工整美观，标点正确", "书写清楚", "书写潦草", "难以辨认")},
+	}}
+}
+
+// ParseRubricJSON 解析并检查教师提供的评分标准，未给出满分时取分数段的最高分，未给出权重时取满分
+func ParseRubricJSON(data []byte) (*models.Rubric, error) {
+	rubric := &models.Rubric{}
+	if err := json.Unmarshal(data, rubric); err != nil {
+		return nil, fmt.Errorf("解析评分标准失败: %v", err)
+	}
+	if err := validateRubric(rubric); err != nil {
+		return nil, err
+	}
+	return rubric, nil
+}
+
+// validateRubric 检查评分标准，并补全未给出的满分和权重
+func validateRubric(rubric *models.Rubric) error {
+	if len(rubric.Criteria) == 0 {
+		return fmt.Errorf("评分标准至少需要一个评分项")
+	}
+
+	seen := make(map[string]bool, len(rubric.Criteria))
+	for i := range rubric.Criteria {
+		criterion := &rubric.Criteria[i]
+		criterion.Name = strings.TrimSpace(criterion.Name)
+		if criterion.Name == "" {
+			return fmt.Errorf("第%d个评分项缺少名称", i+1)
+		}
+		if seen[normalizeCriterionName(criterion.Name)] {
+			return fmt.Errorf("评分项名称重复: %s", criterion.Name)
+		}
+		seen[normalizeCriterionName(criterion.Name)] = true
+
+		bandMax := 0.0
+		for _, band := range criterion.Bands {
+			if band.Min < 0 || band.Min > band.Max {
+				return fmt.Errorf("评分项%s的分数段无效: %v-%v", criterion.Name, band.Min, band.Max)
+			}
+			bandMax = math.Max(bandMax, band.Max)
+		}
+		if criterion.MaxScore == 0 {
+			criterion.MaxScore = bandMax
+		} else if bandMax > criterion.MaxScore {
+			return fmt.Errorf("评分项%s的分数段超过满分%v", criterion.Name, criterion.MaxScore)
+		}
+		if criterion.MaxScore <= 0 {
+			return fmt.Errorf("评分项%s需要满分或分数段", criterion.Name)
+		}
+
+		if criterion.Weight < 0 {
+			return fmt.Errorf("评分项%s的权重不能为负数", criterion.Name)
+		}
+		if criterion.Weight == 0 {
+			criterion.Weight = criterion.MaxScore
+		}
+	}
+	return nil
+}
+
+// RubricInstruction 生成附加到系统指令中的评分标准说明
+func RubricInstruction(rubric *models.Rubric) string {
+	if rubric == nil || len(rubric.Criteria) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n\n评分标准如下，请逐项给出得分、档次、作文原文中支持该得分的引用和评语，评分项名称与评分标准保持一致：\n")
+	for i, criterion := range rubric.Criteria {
+		fmt.Fprintf(&sb, "%d. %s（满分%s分）", i+1, criterion.Name, formatScore(criterion.MaxScore))
+		if criterion.Description != "" {
+			fmt.Fprintf(&sb, "：%s", criterion.Description)
+		}
+		sb.WriteString("\n")
+		for _, band := range criterion.Bands {
+			fmt.Fprintf(&sb, "   - %s %s-%s分：%s\n", band.Label, formatScore(band.Min), formatScore(band.Max), band.Description)
+		}
+	}
+	return sb.String()
+}
+
+// ValidateEssayResult 按EssayResultSchema和评分标准校验大模型的输出并解析为作文批改结果
+// 每个评分项都必须给出0到该项满分之间的得分和原文引用
+func ValidateEssayResult(text string, rubric *models.Rubric) (models.HomeworkResult, error) {
+	var result models.HomeworkResult
+
+	text = strings.TrimSpace(text)
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(text), &fields); err != nil {
+		return result, fmt.Errorf("返回内容不是有效的JSON对象: %v", err)
+	}
+
+	var missing []string
+	for _, name := range EssayResultSchema.Required {
+		if _, ok := fields[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return result, fmt.Errorf("缺少必填字段: %s", strings.Join(missing, ", "))
+	}
+
+	result, err := ParseHomeworkResult(text)
+	if err != nil {
+		return result, err
+	}
+
+	scores := make(map[string]models.CriterionScore, len(result.Criteria))
+	for _, score := range result.Criteria {
+		scores[normalizeCriterionName(score.Name)] = score
+	}
+	for _, criterion := range rubric.Criteria {
+		score, ok := scores[normalizeCriterionName(criterion.Name)]
+		if !ok {
+			return result, fmt.Errorf("缺少评分项: %s", criterion.Name)
+		}
+		if score.Score == nil || *score.Score < 0 || *score.Score > criterion.MaxScore {
+			return result, fmt.Errorf("评分项%s的score必须是0到%s之间的数字", criterion.Name, formatScore(criterion.MaxScore))
+		}
+		if strings.TrimSpace(score.Evidence) == "" {
+			return result, fmt.Errorf("评分项%s缺少原文引用evidence", criterion.Name)
+		}
+	}
+	return result, nil
+}
+
+// GradeEssayFile 以schema约束的JSON模式按评分标准批改作文，校验失败时带纠正提示词重新请求
+func GradeEssayFile(ctx context.Context, llm LLMProvider, systemInstruction, filePath, mimeType, textPrompt string, rubric *models.Rubric) (models.HomeworkResult, error) {
+	return gradeWithSchema(ctx, llm, systemInstruction, filePath, mimeType, textPrompt, EssayResultSchema, func(text string) (models.HomeworkResult, error) {
+		return ValidateEssayResult(text, rubric)
+	})
+}
+
+// ScoreEssayResult 按评分标准的顺序整理每项得分，并按权重汇总为总得分
+// 总得分 = Σ(该项得分/该项满分×权重) / Σ权重 × 满分
+func ScoreEssayResult(result *models.HomeworkResult, rubric *models.Rubric, opts ScoringOptions) {
+	scores := make(map[string]models.CriterionScore, len(result.Criteria))
+	for _, score := range result.Criteria {
+		scores[normalizeCriterionName(score.Name)] = score
+	}
+
+	criteria := make([]models.CriterionScore, 0, len(rubric.Criteria))
+	var weightTotal, weightedScore float64
+	for _, criterion := range rubric.Criteria {
+		score := scores[normalizeCriterionName(criterion.Name)]
+		value := 0.0
+		if score.Score != nil {
+			value = math.Min(math.Max(*score.Score, 0), criterion.MaxScore)
+		}
+
+		score.Name = criterion.Name
+		score.Score = &value
+		score.MaxScore = criterion.MaxScore
+		score.Weight = criterion.Weight
+		criteria = append(criteria, score)
+
+		weightTotal += criterion.Weight
+		weightedScore += value / criterion.MaxScore * criterion.Weight
+	}
+	result.Criteria = criteria
+
+	total := 0.0
+	if weightTotal > 0 {
+		total = opts.round(weightedScore / weightTotal * opts.FullMarks)
+	}
+	result.OverallScore = strconv.FormatFloat(total, 'f', -1, 64)
+	result.FullMarks = opts.FullMarks
+}
+
+// normalizeCriterionName 统一评分项名称，忽略空白和大小写
+func normalizeCriterionName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(toHalfWidth(name)), ""))
+}
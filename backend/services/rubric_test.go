@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParseRubricJSON 测试评分标准的默认满分、默认权重和无效输入
+func TestParseRubricJSON(t *testing.T) {
+	rubric, err := ParseRubricJSON([]byte(`{"criteria":[
+		{"name":"内容","weight":60,"bands":[{"label":"一类","min":16,"max":20},{"label":"二类","min":0,"max":15}]},
+		{"name":"书写","maxScore":5}
+	]}`))
+	if err != nil {
+		t.Fatalf("解析评分标准失败: %v", err)
+	}
+	if rubric.Criteria[0].MaxScore != 20 || rubric.Criteria[1].Weight != 5 {
+		t.Errorf("默认满分或权重错误: %+v", rubric.Criteria)
+	}
+
+	invalid := []string{
+		`{"criteria":[]}`,
+		`{"criteria":[{"name":"内容"}]}`,
+		`{"criteria":[{"name":"内容","maxScore":10},{"name":" 内容 ","maxScore":10}]}`,
+		`{"criteria":[{"name":"内容","maxScore":10,"bands":[{"min":8,"max":12}]}]}`,
+	}
+	for _, text := range invalid {
+		if _, err := ParseRubricJSON([]byte(text)); err == nil {
+			t.Errorf("预期解析失败: %s", text)
+		}
+	}
+}
+
+// TestGradeEssayFile 测试作文缺少评分项时带纠正提示词重试，并按权重汇总总分
+func TestGradeEssayFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "essay.jpg")
+	if err := os.WriteFile(filePath, []byte("fake image"), 0644); err != nil {
+		t.Fatalf("创建测试文件失败: %v", err)
+	}
+
+	rubric, err := ParseRubricJSON([]byte(`{"criteria":[{"name":"内容","weight":60,"maxScore":20},{"name":"语言","weight":40,"maxScore":10}]}`))
+	if err != nil {
+		t.Fatalf("解析评分标准失败: %v", err)
+	}
+
+	llm := NewFakeLLMProvider(
+		FakeLLMResponse{Text: `{"name":"张三","class":"一班","criteria":[{"name":"内容","score":15,"evidence":"夕阳"}],"feedback":"不错"}`, Times: 1},
+		FakeLLMResponse{Text: `{"name":"张三","class":"一班","criteria":[{"name":"语言","score":"7","evidence":"金色的街道"},{"name":"内容","score":15,"evidence":"夕阳","band":"二类"}],"feedback":"不错"}`},
+	)
+
+	systemInstruction := RubricInstruction(rubric)
+	if !strings.Contains(systemInstruction, "内容（满分20分）") {
+		t.Errorf("评分标准说明错误: %s", systemInstruction)
+	}
+
+	result, err := GradeEssayFile(context.Background(), llm, systemInstruction, filePath, "image/jpeg", "请批改作文", rubric)
+	if err != nil {
+		t.Fatalf("批改作文失败: %v", err)
+	}
+	if calls := llm.Calls(); len(calls) != 2 || !strings.Contains(calls[1].Prompt, "缺少评分项: 语言") {
+		t.Errorf("缺少评分项时应带校验错误重新请求: %+v", calls)
+	}
+
+	ScoreEssayResult(&result, rubric, DefaultScoringOptions())
+
+	// (15/20×60 + 7/10×40) / 100 × 100 = 73
+	if result.OverallScore != "73" {
+		t.Errorf("总得分错误: %s", result.OverallScore)
+	}
+	if len(result.Criteria) != 2 || result.Criteria[0].Name != "内容" || result.Criteria[0].Band != "二类" || result.Criteria[1].MaxScore != 10 || result.Criteria[1].Weight != 40 {
+		t.Errorf("评分项应按评分标准顺序整理: %+v", result.Criteria)
+	}
+}
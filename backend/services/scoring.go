@@ -0,0 +1,137 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// 总得分的取整方式
+const (
+	RoundingRound = "round" // 四舍五入
+	RoundingFloor = "floor" // 向下取整
+	RoundingCeil  = "ceil"  // 向上取整
+)
+
+// ScoringOptions 服务端计算总得分的参数
+type ScoringOptions struct {
+	FullMarks float64 `json:"fullMarks"` // 满分，总得分按每题得分占比换算到该满分
+	Rounding  string  `json:"rounding"`  // 取整方式：round、floor、ceil
+	Precision int     `json:"precision"` // 保留的小数位数
+}
+
+// DefaultScoringOptions 默认按百分制计算，四舍五入保留一位小数
+func DefaultScoringOptions() ScoringOptions {
+	return ScoringOptions{
+		FullMarks: 100,
+		Rounding:  RoundingRound,
+		Precision: 1,
+	}
+}
+
+// ParseScoringOptions 解析上传请求中的满分、取整方式和小数位数，为空的参数使用默认值
+// 未提供满分时FullMarks为0，批改前由ResolveFullMarks按标准答案或评分标准的总分确定
+func ParseScoringOptions(fullMarks, rounding, precision string) (ScoringOptions, error) {
+	opts := DefaultScoringOptions()
+	opts.FullMarks = 0
+
+	if fullMarks = strings.TrimSpace(fullMarks); fullMarks != "" {
+		value, err := strconv.ParseFloat(fullMarks, 64)
+		if err != nil || value <= 0 {
+			return opts, fmt.Errorf("满分必须是大于0的数字: %s", fullMarks)
+		}
+		opts.FullMarks = value
+	}
+
+	if rounding = strings.ToLower(strings.TrimSpace(rounding)); rounding != "" {
+		if rounding != RoundingRound && rounding != RoundingFloor && rounding != RoundingCeil {
+			return opts, fmt.Errorf("取整方式只支持round、floor和ceil: %s", rounding)
+		}
+		opts.Rounding = rounding
+	}
+
+	if precision = strings.TrimSpace(precision); precision != "" {
+		value, err := strconv.Atoi(precision)
+		if err != nil || value < 0 || value > 4 {
+			return opts, fmt.Errorf("小数位数必须是0-4之间的整数: %s", precision)
+		}
+		opts.Precision = value
+	}
+
+	return opts, nil
+}
+
+// ResolveFullMarks 未指定满分时，以评分标准的权重之和或标准答案各题分值之和作为满分，都没有时按百分制
+// 避免标准答案共150分时总得分被换算为百分制
+func (o ScoringOptions) ResolveFullMarks(answerKey *models.AnswerKey, rubric *models.Rubric) ScoringOptions {
+	if o.FullMarks > 0 {
+		return o
+	}
+
+	total := 0.0
+	switch {
+	case rubric != nil:
+		for _, criterion := range rubric.Criteria {
+			total += criterion.Weight
+		}
+	case answerKey != nil:
+		for _, item := range answerKey.Items {
+			total += item.Points
+		}
+	}
+	if total <= 0 {
+		total = DefaultScoringOptions().FullMarks
+	}
+	o.FullMarks = total
+	return o
+}
+
+// ScoreResult 根据每题的满分和得分计算总得分，不采用大模型给出的总分
+// 缺少分值的题目按1分计算，缺少得分时按isCorrect给满分或0分，得分限制在0到满分之间
+func ScoreResult(result *models.HomeworkResult, opts ScoringOptions) {
+	var maxTotal, awardedTotal float64
+	for i := range result.Answers {
+		answer := &result.Answers[i]
+
+		maxPoints := 1.0
+		if answer.MaxPoints != nil && *answer.MaxPoints >= 0 {
+			maxPoints = *answer.MaxPoints
+		}
+
+		awarded := 0.0
+		if answer.AwardedPoints != nil {
+			awarded = math.Min(math.Max(*answer.AwardedPoints, 0), maxPoints)
+		} else if answer.IsCorrect != nil && *answer.IsCorrect {
+			awarded = maxPoints
+		}
+
+		answer.MaxPoints = &maxPoints
+		answer.AwardedPoints = &awarded
+		maxTotal += maxPoints
+		awardedTotal += awarded
+	}
+
+	score := 0.0
+	if maxTotal > 0 {
+		score = opts.round(awardedTotal / maxTotal * opts.FullMarks)
+	}
+	result.OverallScore = strconv.FormatFloat(score, 'f', -1, 64)
+	result.FullMarks = opts.FullMarks
+}
+
+// round 按取整方式和小数位数取整
+func (o ScoringOptions) round(score float64) float64 {
+	scale := math.Pow(10, float64(o.Precision))
+	switch o.Rounding {
+	case RoundingFloor:
+		// 加上极小值，避免浮点误差导致 59.99999 被向下取整
+		return math.Floor(score*scale+1e-9) / scale
+	case RoundingCeil:
+		return math.Ceil(score*scale-1e-9) / scale
+	default:
+		return math.Round(score*scale) / scale
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// TestScoreResult 测试按每题得分计算总分，包括部分分、缺少分值时的兜底和取整方式
+func TestScoreResult(t *testing.T) {
+	floatPtr := func(v float64) *float64 { return &v }
+	correct, wrong := true, false
+
+	newResult := func() models.HomeworkResult {
+		return models.HomeworkResult{
+			OverallScore: "100",
+			Answers: []models.HomeworkAnswer{
+				{QuestionNumber: "1", MaxPoints: floatPtr(5), AwardedPoints: floatPtr(2.5)},
+				{QuestionNumber: "2", MaxPoints: floatPtr(5), AwardedPoints: floatPtr(8)}, // 超过满分按满分计
+				{QuestionNumber: "3", IsCorrect: &correct},                                // 缺少分值按1分计
+				{QuestionNumber: "4", IsCorrect: &wrong},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		opts     ScoringOptions
+		expected string
+	}{
+		{"默认百分制", DefaultScoringOptions(), "70.8"},
+		{"向下取整", ScoringOptions{FullMarks: 100, Rounding: RoundingFloor}, "70"},
+		{"向上取整", ScoringOptions{FullMarks: 100, Rounding: RoundingCeil}, "71"},
+		{"满分120", ScoringOptions{FullMarks: 120, Rounding: RoundingRound}, "85"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := newResult()
+			ScoreResult(&result, tc.opts)
+			if result.OverallScore != tc.expected || result.FullMarks != tc.opts.FullMarks {
+				t.Errorf("预期总分%s，实际: %s/%v", tc.expected, result.OverallScore, result.FullMarks)
+			}
+			if *result.Answers[1].AwardedPoints != 5 || *result.Answers[2].MaxPoints != 1 || *result.Answers[3].AwardedPoints != 0 {
+				t.Errorf("每题分值计算错误: %+v", result.Answers)
+			}
+		})
+	}
+
+	if _, err := ParseScoringOptions("0", "", ""); err == nil {
+		t.Error("满分为0时应返回错误")
+	}
+	if _, err := ParseScoringOptions("", "half", ""); err == nil {
+		t.Error("不支持的取整方式应返回错误")
+	}
+	if opts, err := ParseScoringOptions("150", "CEIL", "2"); err != nil || opts.FullMarks != 150 || opts.Rounding != RoundingCeil || opts.Precision != 2 {
+		t.Errorf("解析评分参数错误: %+v %v", opts, err)
+	}
+}
+
+// TestResolveFullMarks 测试未指定满分时按评分标准或标准答案的总分计算，都没有时按百分制
+func TestResolveFullMarks(t *testing.T) {
+	opts, err := ParseScoringOptions("", "", "")
+	if err != nil || opts.FullMarks != 0 {
+		t.Fatalf("未提供满分时FullMarks应为0: %+v %v", opts, err)
+	}
+
+	answerKey := &models.AnswerKey{Items: []models.AnswerKeyItem{{QuestionNumber: "1", Answer: "A", Points: 100}, {QuestionNumber: "2", Answer: "B", Points: 50}}}
+	if resolved := opts.ResolveFullMarks(answerKey, nil); resolved.FullMarks != 150 {
+		t.Errorf("应以标准答案的总分为满分，实际: %v", resolved.FullMarks)
+	}
+	rubric := &models.Rubric{Criteria: []models.RubricCriterion{{Name: "内容", Weight: 30, MaxScore: 10}, {Name: "语言", Weight: 20, MaxScore: 10}}}
+	if resolved := opts.ResolveFullMarks(answerKey, rubric); resolved.FullMarks != 50 {
+		t.Errorf("应以评分标准的权重之和为满分，实际: %v", resolved.FullMarks)
+	}
+	if resolved := opts.ResolveFullMarks(nil, nil); resolved.FullMarks != 100 {
+		t.Errorf("没有标准答案和评分标准时应按百分制，实际: %v", resolved.FullMarks)
+	}
+
+	// 指定的满分优先
+	opts.FullMarks = 120
+	if resolved := opts.ResolveFullMarks(answerKey, nil); resolved.FullMarks != 120 {
+		t.Errorf("应使用指定的满分，实际: %v", resolved.FullMarks)
+	}
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GiantClam/homework_marking/models"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// 拆分学生的方式
+const (
+	SplitModeFixed = "fixed" // 按固定的每个学生页数拆分
+	SplitModeAuto  = "auto"  // 识别每个学生的起始页，由教师确认后拆分
+)
+
+// PageClassificationSchema 扫描件逐页识别结果的输出结构
+var PageClassificationSchema = &ResponseSchema{
+	Name: "page_classification",
+	Type: "object",
+	Properties: map[string]*ResponseSchema{
+		"pages": {
+			Type:        "array",
+			Description: "按页码顺序排列的每一页的识别结果",
+			Items: &ResponseSchema{
+				Type: "object",
+				Properties: map[string]*ResponseSchema{
+					"page":  {Type: "integer", Description: "页码，从1开始"},
+					"kind":  {Type: "string", Description: "header：带姓名/班级栏的作业首页；cover：封面；blank：空白分隔页；continuation：上一页的后续页"},
+					"name":  {Type: "string", Description: "首页或封面上的学生姓名，没有时为空字符串"},
+					"class": {Type: "string", Description: "首页或封面上的班级，没有时为空字符串"},
+				},
+				Required: []string{"page", "kind"},
+			},
+		},
+	},
+	Required: []string{"pages"},
+}
+
+// DetectStudentBoundaries 让大模型逐页识别批量扫描件的首页、封面和空白分隔页，返回建议的学生分页
+func DetectStudentBoundaries(ctx context.Context, llm LLMProvider, pdfPath string) ([]models.StudentPageRange, int, error) {
+	log.Printf("[INFO] 识别批量扫描件中每个学生的起始页: %s", pdfPath)
+
+	pageCount, err := api.PageCountFile(pdfPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("获取PDF页数失败: %v", err)
+	}
+
+	systemInstruction := "你是一位老师的助手，这份PDF是多个学生的作业连续扫描而成。请逐页判断页面类型：" +
+		"页面顶部有姓名、班级等信息栏的作业首页为header，作业封面为cover，空白或仅有分隔标记的页面为blank，其余为continuation。" +
+		"header和cover页请识别学生姓名和班级。"
+	prompt := fmt.Sprintf("这份PDF共%d页，请按页码顺序返回每一页的识别结果。", pageCount)
+	response, err := llm.GenerateJSONWithFile(ctx, systemInstruction, pdfPath, "application/pdf", prompt, PageClassificationSchema)
+	if err != nil {
+		return nil, pageCount, fmt.Errorf("识别学生分页失败: %v", err)
+	}
+
+	var classification struct {
+		Pages []models.PageClassification `json:"pages"`
+	}
+	if err := json.Unmarshal([]byte(CleanMarkdownCodeBlock(response)), &classification); err != nil {
+		return nil, pageCount, fmt.Errorf("解析分页识别结果失败: %v", err)
+	}
+
+	ranges := ProposeStudentSplit(classification.Pages, pageCount)
+	if len(ranges) == 0 {
+		return nil, pageCount, fmt.Errorf("未识别到学生作业页")
+	}
+	log.Printf("[INFO] 共%d页，识别出%d个学生", pageCount, len(ranges))
+	return ranges, pageCount, nil
+}
+
+// ProposeStudentSplit 根据逐页识别结果生成建议的学生分页
+// header和cover页开始一个新学生（紧跟在封面后的首页除外），blank页结束当前学生且不属于任何学生，未识别的页按continuation处理
+func ProposeStudentSplit(pages []models.PageClassification, pageCount int) []models.StudentPageRange {
+	classified := make([]models.PageClassification, pageCount+1)
+	for _, page := range pages {
+		if page.Page >= 1 && page.Page <= pageCount {
+			classified[page.Page] = page
+		}
+	}
+
+	var ranges []models.StudentPageRange
+	current := -1
+	previousKind := ""
+	for page := 1; page <= pageCount; page++ {
+		info := classified[page]
+		kind := strings.ToLower(strings.TrimSpace(info.Kind))
+
+		switch {
+		case kind == models.PageKindBlank:
+			current = -1
+		case current < 0, kind == models.PageKindCover, kind == models.PageKindHeader && previousKind != models.PageKindCover:
+			ranges = append(ranges, models.StudentPageRange{
+				StartPage: page,
+				EndPage:   page,
+				Name:      strings.TrimSpace(info.Name),
+				Class:     strings.TrimSpace(info.Class),
+			})
+			current = len(ranges) - 1
+		default:
+			ranges[current].EndPage = page
+			if ranges[current].Name == "" {
+				ranges[current].Name = strings.TrimSpace(info.Name)
+			}
+			if ranges[current].Class == "" {
+				ranges[current].Class = strings.TrimSpace(info.Class)
+			}
+		}
+		previousKind = kind
+	}
+	return ranges
+}
+
+// ValidateStudentSplit 检查教师确认或修改后的学生分页：页码在范围内、按顺序排列且互不重叠，允许跳过空白页
+func ValidateStudentSplit(ranges []models.StudentPageRange, pageCount int) error {
+	if len(ranges) == 0 {
+		return fmt.Errorf("学生分页不能为空")
+	}
+
+	lastPage := 0
+	for i, r := range ranges {
+		if r.StartPage < 1 || r.EndPage < r.StartPage || r.EndPage > pageCount {
+			return fmt.Errorf("第%d个学生的页码范围无效: %d-%d（共%d页）", i+1, r.StartPage, r.EndPage, pageCount)
+		}
+		if r.StartPage <= lastPage {
+			return fmt.Errorf("第%d个学生的页码与前一个学生重叠或顺序错误: %d-%d", i+1, r.StartPage, r.EndPage)
+		}
+		lastPage = r.EndPage
+	}
+	return nil
+}
+
+// SplitPDFByRanges 按确认后的学生分页拆分PDF，每个学生生成一个文件
+func SplitPDFByRanges(inputFile string, ranges []models.StudentPageRange) ([]string, error) {
+	log.Printf("[INFO] 按学生分页拆分PDF文件: %s, 共 %d 个学生", inputFile, len(ranges))
+
+	baseFileName := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
+	splitSessionDir := filepath.Join("uploads", "split", fmt.Sprintf("%s_%s", baseFileName, time.Now().Format("20060102_150405")))
+	if err := os.MkdirAll(splitSessionDir, 0755); err != nil {
+		return nil, fmt.Errorf("创建分割会话目录失败: %v", err)
+	}
+
+	outputFiles := make([]string, 0, len(ranges))
+	for i, r := range ranges {
+		studentPDFFile := filepath.Join(splitSessionDir, fmt.Sprintf("student_%d.pdf", i+1))
+		pageRange := fmt.Sprintf("%d-%d", r.StartPage, r.EndPage)
+		if err := api.CollectFile(inputFile, studentPDFFile, []string{pageRange}, nil); err != nil {
+			return nil, fmt.Errorf("提取学生 %d 的页面 %s 失败: %v", i+1, pageRange, err)
+		}
+		outputFiles = append(outputFiles, studentPDFFile)
+	}
+
+	log.Printf("[INFO] PDF拆分完成，生成了 %d 个文件", len(outputFiles))
+	return outputFiles, nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// TestProposeStudentSplit 测试按首页、封面和空白分隔页生成建议的学生分页
+func TestProposeStudentSplit(t *testing.T) {
+	pages := []models.PageClassification{
+		{Page: 1, Kind: "continuation"},
+		{Page: 2, Kind: "header", Name: "张三", Class: "一班"},
+		{Page: 3, Kind: "continuation"},
+		{Page: 4, Kind: "blank"},
+		{Page: 5, Kind: "cover", Name: "李四"},
+		{Page: 6, Kind: "header", Class: "二班"},
+		{Page: 7, Kind: "continuation"},
+		{Page: 9, Kind: "HEADER", Name: "王五"},
+	}
+
+	ranges := ProposeStudentSplit(pages, 9)
+	expected := []models.StudentPageRange{
+		{StartPage: 1, EndPage: 1},
+		{StartPage: 2, EndPage: 3, Name: "张三", Class: "一班"},
+		{StartPage: 5, EndPage: 8, Name: "李四", Class: "二班"},
+		{StartPage: 9, EndPage: 9, Name: "王五"},
+	}
+	if len(ranges) != len(expected) {
+		t.Fatalf("学生数量错误: %+v", ranges)
+	}
+	for i := range expected {
+		if ranges[i] != expected[i] {
+			t.Errorf("第%d个学生的分页错误: %+v，预期: %+v", i+1, ranges[i], expected[i])
+		}
+	}
+}
+
+// TestValidateStudentSplit 测试学生分页的页码范围、顺序和重叠检查
+func TestValidateStudentSplit(t *testing.T) {
+	if err := ValidateStudentSplit([]models.StudentPageRange{{StartPage: 1, EndPage: 2}, {StartPage: 4, EndPage: 5}}, 5); err != nil {
+		t.Errorf("跳过空白页的分页应有效: %v", err)
+	}
+
+	invalid := [][]models.StudentPageRange{
+		nil,
+		{{StartPage: 0, EndPage: 1}},
+		{{StartPage: 2, EndPage: 1}},
+		{{StartPage: 1, EndPage: 6}},
+		{{StartPage: 1, EndPage: 3}, {StartPage: 3, EndPage: 4}},
+		{{StartPage: 3, EndPage: 4}, {StartPage: 1, EndPage: 2}},
+	}
+	for _, ranges := range invalid {
+		if err := ValidateStudentSplit(ranges, 5); err == nil {
+			t.Errorf("无效的分页应返回错误: %+v", ranges)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"log"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// 任务事件类型
+const (
+	TaskEventStatus        = "status"         // 任务状态或处理阶段变化
+	TaskEventProgress      = "progress"       // 处理进度变化
+	TaskEventStudentResult = "student_result" // 某个学生批改完成
+	TaskEventStudentFailed = "student_failed" // 某个学生批改失败
+	TaskEventDone          = "done"           // 任务结束（完成、失败、取消或中断）
+)
+
+// taskEventBufferSize 每个订阅者的事件缓冲区大小
+const taskEventBufferSize = 256
+
+// TaskEvent 推送给订阅者的任务事件
+type TaskEvent struct {
+	Type          string                 `json:"type"`
+	TaskID        string                 `json:"task_id"`
+	Status        TaskStatus             `json:"status"`
+	Message       string                 `json:"message,omitempty"`
+	TotalStudents int                    `json:"total_students"`
+	Processed     int                    `json:"processed"`
+	Failed        int                    `json:"failed"`
+	StudentIndex  *int                   `json:"student_index,omitempty"`
+	Result        *models.HomeworkResult `json:"result,omitempty"`
+	Error         string                 `json:"error,omitempty"`
+}
+
+// isTerminal 任务是否已经结束
+func (s TaskStatus) isTerminal() bool {
+	switch s {
+	case TaskStatusCompleted, TaskStatusFailed, TaskStatusCancelled, TaskStatusInterrupted:
+		return true
+	}
+	return false
+}
+
+// Subscribe 订阅任务事件，返回事件通道和取消订阅函数
+// 任务结束后通道会被关闭，订阅者应随后通过GetTask读取最终结果；任务已结束时返回已关闭的通道
+func (q *TaskQueue) Subscribe(taskID string) (<-chan TaskEvent, func(), error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	task, exists := q.tasks[taskID]
+	if !exists {
+		return nil, nil, ErrTaskNotFound
+	}
+
+	ch := make(chan TaskEvent, taskEventBufferSize)
+	if task.Status.isTerminal() {
+		close(ch)
+		return ch, func() {}, nil
+	}
+
+	q.subscribers[taskID] = append(q.subscribers[taskID], ch)
+	unsubscribe := func() {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+
+		subs := q.subscribers[taskID]
+		for i, sub := range subs {
+			if sub == ch {
+				q.subscribers[taskID] = append(subs[:i], subs[i+1:]...)
+				close(ch)
+				break
+			}
+		}
+		if len(q.subscribers[taskID]) == 0 {
+			delete(q.subscribers, taskID)
+		}
+	}
+	return ch, unsubscribe, nil
+}
+
+// newTaskEvent 根据任务当前状态创建事件
+func newTaskEvent(eventType string, task *HomeworkTask) TaskEvent {
+	return TaskEvent{
+		Type:          eventType,
+		TaskID:        task.ID,
+		Status:        task.Status,
+		Message:       task.Message,
+		TotalStudents: task.TotalStudents,
+		Processed:     task.ProcessedCount,
+		Failed:        task.FailedCount,
+		Error:         task.Error,
+	}
+}
+
+// publish 向任务的所有订阅者推送事件，调用方需持有锁
+// 订阅者缓冲区已满时丢弃该事件，避免阻塞批改流程
+func (q *TaskQueue) publish(event TaskEvent) {
+	for _, ch := range q.subscribers[event.TaskID] {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("[WARN] 任务 %s 的订阅者处理过慢，丢弃事件: %s", event.TaskID, event.Type)
+		}
+	}
+}
+
+// publishStatus 推送任务状态变化，任务结束时关闭所有订阅者的通道，调用方需持有锁
+func (q *TaskQueue) publishStatus(task *HomeworkTask) {
+	if !task.Status.isTerminal() {
+		q.publish(newTaskEvent(TaskEventStatus, task))
+		return
+	}
+
+	for _, ch := range q.subscribers[task.ID] {
+		close(ch)
+	}
+	delete(q.subscribers, task.ID)
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// TestTaskQueueSubscribe 测试订阅者能按顺序收到进度和学生结果事件，任务结束后通道关闭
+func TestTaskQueueSubscribe(t *testing.T) {
+	queue := NewTaskQueue(0)
+	taskID := queue.CreateTask("homework_processing", "正在处理文件...")
+
+	events, unsubscribe, err := queue.Subscribe(taskID)
+	if err != nil {
+		t.Fatalf("订阅失败: %v", err)
+	}
+	defer unsubscribe()
+
+	queue.UpdateTaskStatus(taskID, "processing", "正在分析文件内容...")
+	queue.UpdateTaskTotalStudents(taskID, 2)
+	queue.RecordStudentResult(taskID, 1, models.HomeworkResult{Name: "李四"})
+	queue.RecordStudentFailure(taskID, 0, "超时")
+	queue.CompleteTask(taskID, []models.HomeworkResult{{Name: "李四"}})
+
+	var received []TaskEvent
+	for event := range events {
+		received = append(received, event)
+	}
+
+	expectedTypes := []string{TaskEventStatus, TaskEventProgress, TaskEventStudentResult, TaskEventStudentFailed}
+	if len(received) != len(expectedTypes) {
+		t.Fatalf("预期收到%d个事件，实际: %+v", len(expectedTypes), received)
+	}
+	for i, eventType := range expectedTypes {
+		if received[i].Type != eventType {
+			t.Errorf("第%d个事件类型错误: 预期%s，实际%s", i+1, eventType, received[i].Type)
+		}
+	}
+
+	studentEvent := received[2]
+	if studentEvent.StudentIndex == nil || *studentEvent.StudentIndex != 1 || studentEvent.Result == nil || studentEvent.Result.Name != "李四" || studentEvent.Processed != 1 {
+		t.Errorf("学生结果事件错误: %+v", studentEvent)
+	}
+	if failedEvent := received[3]; failedEvent.Error != "超时" || failedEvent.Failed != 1 || failedEvent.Processed != 2 {
+		t.Errorf("学生失败事件错误: %+v", failedEvent)
+	}
+
+	// 已结束的任务返回已关闭的通道
+	finished, _, err := queue.Subscribe(taskID)
+	if err != nil {
+		t.Fatalf("订阅已结束任务失败: %v", err)
+	}
+	if _, ok := <-finished; ok {
+		t.Error("已结束任务的事件通道应已关闭")
+	}
+
+	if _, _, err := queue.Subscribe("not-exist"); err != ErrTaskNotFound {
+		t.Errorf("订阅不存在的任务预期ErrTaskNotFound，实际: %v", err)
+	}
+}
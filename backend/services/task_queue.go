@@ -1,9 +1,18 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math/rand"
+	"os"
+	"slices"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/GiantClam/homework_marking/models"
 )
 
 // TaskStatus 表示任务状态
@@ -14,42 +23,123 @@ const (
 	TaskStatusProcessing TaskStatus = "processing" // 处理中
 	TaskStatusCompleted TaskStatus = "completed" // 已完成
 	TaskStatusFailed    TaskStatus = "failed"    // 失败
+	TaskStatusInterrupted TaskStatus = "interrupted" // 服务重启导致中断
+	TaskStatusCancelled TaskStatus = "cancelled" // 已被用户取消
+	TaskStatusAwaitingConfirmation TaskStatus = "awaiting_confirmation" // 等待教师确认学生分页
+)
+
+var (
+	// ErrTaskNotFound 任务不存在
+	ErrTaskNotFound = errors.New("任务不存在")
+	// ErrTaskNotCancellable 任务已结束，无法取消
+	ErrTaskNotCancellable = errors.New("任务已结束，无法取消")
+	// ErrTaskNotAwaitingConfirmation 任务不在等待确认学生分页的状态
+	ErrTaskNotAwaitingConfirmation = errors.New("任务不在等待确认学生分页的状态")
+	// ErrSplitConfirmationTimeout 教师没有在规定时间内确认学生分页
+	ErrSplitConfirmationTimeout = errors.New("等待确认学生分页超时")
+	// ErrStudentResultNotFound 任务中没有该学生的批改结果
+	ErrStudentResultNotFound = errors.New("学生的批改结果不存在")
 )
 
+// DefaultSplitConfirmationTimeout 默认等待教师确认学生分页的最长时间，超时的任务标记为失败
+const DefaultSplitConfirmationTimeout = 24 * time.Hour
+
 // HomeworkTask 表示一个作业处理任务
 type HomeworkTask struct {
 	ID              string                 `json:"id"`              // 任务ID
+	UserID          string                 `json:"userId,omitempty"` // 创建任务的用户，只有该用户（和管理员）可以查看和操作任务
+	ClassID         string                 `json:"classId,omitempty"` // 作业所属的班级，分配了该班级的只读用户可以查看
+	AssignmentID    string                 `json:"assignmentId,omitempty"` // 上传时引用的作业
 	FilePath        string                 `json:"filePath"`        // 文件路径
+	Files           []string               `json:"files,omitempty"` // 上传的文件和处理中生成的文件，清理任务时一并删除
 	HomeworkType    string                 `json:"homeworkType"`    // 作业类型
 	PagesPerStudent int                    `json:"pagesPerStudent"` // 每个学生的页数
 	Layout          string                 `json:"layout"`          // 布局方式
+	Scoring         *ScoringOptions        `json:"scoring,omitempty"` // 计算总得分的方式，教师复核后按此重新计算
+	PageCount       int                    `json:"pageCount,omitempty"`     // 拆分前的总页数（自动识别学生分页时记录）
+	ProposedSplit   []models.StudentPageRange `json:"proposedSplit,omitempty"` // 建议或已确认的学生分页
 	TotalStudents   int                    `json:"totalStudents"`   // 学生总数
-	ProcessedCount  int                    `json:"processedCount"`  // 已处理学生数
+	ProcessedCount  int                    `json:"processedCount"`  // 已处理学生数（包括失败的学生）
+	FailedCount     int                    `json:"failedCount"`     // 处理失败的学生数
+	Message         string                 `json:"message"`         // 当前处理阶段的说明
 	StartTime       time.Time              `json:"startTime"`       // 开始时间
 	EndTime         *time.Time             `json:"endTime"`         // 结束时间
 	Status          TaskStatus             `json:"status"`          // 任务状态
 	Error           string                 `json:"error,omitempty"` // 错误信息
-	Results         []string               `json:"results"`         // 每个学生的处理结果
+	Results         []models.HomeworkResult  `json:"results"`         // 最终结果，按学生顺序，包括批改失败的学生
+	StudentResults  []*models.HomeworkResult `json:"studentResults"`  // 按学生顺序保存的处理结果，未完成的为nil
 	Params          map[string]interface{} `json:"params"`          // 其他参数
 	ProcessFunc     TaskProcessFunc        `json:"-"`              // 处理函数，不导出到JSON
 }
 
-// TaskQueue 简单的内存任务队列
+// TaskQueue 任务队列，任务保存在内存中并写入TaskStore持久化
 type TaskQueue struct {
 	tasks     map[string]*HomeworkTask
 	tasksChan chan *HomeworkTask
 	mutex     sync.RWMutex
 	wg        sync.WaitGroup
 	workerCount int
+	store     TaskStore
+	cancelFuncs map[string]context.CancelFunc // 正在处理的任务的取消函数
+	splitConfirmations map[string]chan []models.StudentPageRange // 等待教师确认学生分页的任务
+	splitConfirmationTimeout time.Duration                   // 等待教师确认学生分页的最长时间
+	subscribers map[string][]chan TaskEvent   // 任务事件的订阅者
+	pendingSaves map[string]*HomeworkTask      // 等待写入存储的任务快照，同一任务只保留最新的快照
+	pendingMutex sync.Mutex                    // 保护pendingSaves，不与mutex嵌套等待磁盘写入
+	flushMutex   sync.Mutex                    // 保证快照按变更的顺序写入存储
+	persistSignal chan struct{}                // 有新快照时通知后台写入协程
 }
 
-// NewTaskQueue 创建一个新的任务队列
+// NewTaskQueue 创建一个新的任务队列（不做持久化）
 func NewTaskQueue(workerCount int) *TaskQueue {
+	return newTaskQueue(workerCount, NewMemoryTaskStore())
+}
+
+// NewTaskQueueWithStore 创建使用指定存储的任务队列，并恢复已保存的任务
+// 重启前处于待处理、处理中或等待确认的任务会被标记为已中断
+func NewTaskQueueWithStore(workerCount int, store TaskStore) (*TaskQueue, error) {
+	tasks, err := store.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	q := newTaskQueue(workerCount, store)
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	interrupted := 0
+	for _, task := range tasks {
+		if task.Status == TaskStatusPending || task.Status == TaskStatusProcessing || task.Status == TaskStatusAwaitingConfirmation {
+			now := time.Now()
+			task.Status = TaskStatusInterrupted
+			task.Error = "服务重启，任务处理被中断，请重新上传"
+			task.EndTime = &now
+			q.persist(task)
+			interrupted++
+		}
+		q.tasks[task.ID] = task
+	}
+
+	log.Printf("[INFO] 已恢复 %d 个任务，其中 %d 个未完成的任务被标记为已中断", len(tasks), interrupted)
+	return q, nil
+}
+
+// newTaskQueue 创建任务队列并启动工作协程
+func newTaskQueue(workerCount int, store TaskStore) *TaskQueue {
 	q := &TaskQueue{
 		tasks:       make(map[string]*HomeworkTask),
 		tasksChan:   make(chan *HomeworkTask, 100), // 缓冲大小
 		workerCount: workerCount,
+		store:       store,
+		cancelFuncs: make(map[string]context.CancelFunc),
+		splitConfirmations: make(map[string]chan []models.StudentPageRange),
+		splitConfirmationTimeout: DefaultSplitConfirmationTimeout,
+		subscribers: make(map[string][]chan TaskEvent),
+		pendingSaves: make(map[string]*HomeworkTask),
+		persistSignal: make(chan struct{}, 1),
 	}
+	go q.persistLoop()
 
 	// 启动工作协程
 	for i := 0; i < workerCount; i++ {
@@ -59,6 +149,52 @@ func NewTaskQueue(workerCount int) *TaskQueue {
 	return q
 }
 
+// persist 记录任务的快照并通知后台协程写入存储，调用方需持有锁
+// 磁盘写入不占用任务队列的锁；写入前同一任务多次变更时只写入最新的快照
+func (q *TaskQueue) persist(task *HomeworkTask) {
+	snapshot := task.snapshot()
+	q.pendingMutex.Lock()
+	q.pendingSaves[task.ID] = snapshot
+	q.pendingMutex.Unlock()
+
+	select {
+	case q.persistSignal <- struct{}{}:
+	default:
+	}
+}
+
+// persistLoop 后台写入任务快照的协程
+func (q *TaskQueue) persistLoop() {
+	for range q.persistSignal {
+		q.Flush()
+	}
+}
+
+// Flush 立即将尚未写入的任务快照写入存储
+func (q *TaskQueue) Flush() {
+	q.flushMutex.Lock()
+	defer q.flushMutex.Unlock()
+
+	q.pendingMutex.Lock()
+	pending := q.pendingSaves
+	q.pendingSaves = make(map[string]*HomeworkTask)
+	q.pendingMutex.Unlock()
+
+	for _, task := range pending {
+		if err := q.store.Save(task); err != nil {
+			log.Printf("[ERROR] 保存任务 %s 失败: %v", task.ID, err)
+		}
+	}
+}
+
+// snapshot 复制任务，结果按写时复制的方式修改，复制切片即可与后续的修改隔离
+func (t *HomeworkTask) snapshot() *HomeworkTask {
+	snapshot := *t
+	snapshot.Results = append([]models.HomeworkResult(nil), t.Results...)
+	snapshot.StudentResults = append([]*models.HomeworkResult(nil), t.StudentResults...)
+	return &snapshot
+}
+
 // worker 处理任务的工作协程
 func (q *TaskQueue) worker(id int) {
 	log.Printf("[INFO] 启动工作协程 #%d", id)
@@ -80,10 +216,15 @@ func (q *TaskQueue) processTask(task *HomeworkTask) {
 			log.Printf("[ERROR] 任务处理出现panic: %v", r)
 			now := time.Now()
 			
+			// 已取消的任务保持取消状态，不再发布失败事件
 			q.mutex.Lock()
-			task.Status = TaskStatusFailed
-			task.Error = "处理过程中出现未知错误"
-			task.EndTime = &now
+			if task.Status != TaskStatusCancelled {
+				task.Status = TaskStatusFailed
+				task.Error = "处理过程中出现未知错误"
+				task.EndTime = &now
+				q.persist(task)
+				q.publishStatus(task)
+			}
 			q.mutex.Unlock()
 		}
 		
@@ -96,8 +237,10 @@ func (q *TaskQueue) processTask(task *HomeworkTask) {
 		task.ProcessFunc(task)
 	} else {
 		log.Printf("[ERROR] 任务 %s 没有设置处理函数", task.ID)
-		q.updateTaskStatus(task.ID, TaskStatusFailed)
+		q.mutex.Lock()
 		task.Error = "未设置处理函数"
+		q.mutex.Unlock()
+		q.updateTaskStatus(task.ID, TaskStatusFailed)
 	}
 }
 
@@ -126,12 +269,12 @@ type ProcessFunc func(task *HomeworkTask)
 // AddTask 添加新任务到队列
 func (q *TaskQueue) AddTask(task *HomeworkTask) {
 	q.mutex.Lock()
-	q.tasks[task.ID] = task
-	q.mutex.Unlock()
-
 	task.Status = TaskStatusPending
 	task.StartTime = time.Now()
 	task.ProcessedCount = 0
+	q.tasks[task.ID] = task
+	q.persist(task)
+	q.mutex.Unlock()
 
 	// 增加等待计数
 	q.wg.Add(1)
@@ -142,13 +285,17 @@ func (q *TaskQueue) AddTask(task *HomeworkTask) {
 	log.Printf("[INFO] 添加任务到队列: %s", task.ID)
 }
 
-// GetTask 获取任务信息
+// GetTask 获取任务信息，返回任务的快照，避免与处理中的协程产生数据竞争
 func (q *TaskQueue) GetTask(taskID string) (*HomeworkTask, bool) {
 	q.mutex.RLock()
 	defer q.mutex.RUnlock()
 	
 	task, exists := q.tasks[taskID]
-	return task, exists
+	if !exists {
+		return nil, false
+	}
+
+	return task.snapshot(), true
 }
 
 // updateTaskStatus 更新任务状态
@@ -156,25 +303,26 @@ func (q *TaskQueue) updateTaskStatus(taskID string, status TaskStatus) {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 	
-	if task, exists := q.tasks[taskID]; exists {
+	if task, exists := q.tasks[taskID]; exists && task.Status != TaskStatusCancelled {
 		task.Status = status
 		if status == TaskStatusCompleted || status == TaskStatusFailed {
 			now := time.Now()
 			task.EndTime = &now
 		}
+		q.persist(task)
+		q.publishStatus(task)
 	}
 }
 
 // UpdateTaskProgress 更新任务处理进度
-func (q *TaskQueue) UpdateTaskProgress(taskID string, processedCount int, result string) {
+func (q *TaskQueue) UpdateTaskProgress(taskID string, processedCount int) {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 	
 	if task, exists := q.tasks[taskID]; exists {
 		task.ProcessedCount = processedCount
-		if result != "" {
-			task.Results = append(task.Results, result)
-		}
+		q.persist(task)
+		q.publish(newTaskEvent(TaskEventProgress, task))
 	}
 }
 
@@ -185,6 +333,13 @@ func (q *TaskQueue) UpdateTaskTotalStudents(taskID string, totalStudents int) {
 	
 	if task, exists := q.tasks[taskID]; exists {
 		task.TotalStudents = totalStudents
+		if len(task.StudentResults) < totalStudents {
+			studentResults := make([]*models.HomeworkResult, totalStudents)
+			copy(studentResults, task.StudentResults)
+			task.StudentResults = studentResults
+		}
+		q.persist(task)
+		q.publish(newTaskEvent(TaskEventProgress, task))
 		log.Printf("[INFO] 更新任务 %s 的学生总数: %d", taskID, totalStudents)
 	} else {
 		log.Printf("[ERROR] 更新学生总数失败: 任务 %s 不存在", taskID)
@@ -192,12 +347,13 @@ func (q *TaskQueue) UpdateTaskTotalStudents(taskID string, totalStudents int) {
 }
 
 // AddTaskResult 添加任务处理结果
-func (q *TaskQueue) AddTaskResult(taskID string, result string) {
+func (q *TaskQueue) AddTaskResult(taskID string, result models.HomeworkResult) {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 	
 	if task, exists := q.tasks[taskID]; exists {
 		task.Results = append(task.Results, result)
+		q.persist(task)
 		log.Printf("[INFO] 添加任务 %s 的结果，目前共 %d 个结果", taskID, len(task.Results))
 	} else {
 		log.Printf("[ERROR] 添加结果失败: 任务 %s 不存在", taskID)
@@ -211,6 +367,8 @@ func (q *TaskQueue) IncrementProcessedCount(taskID string) {
 	
 	if task, exists := q.tasks[taskID]; exists {
 		task.ProcessedCount++
+		q.persist(task)
+		q.publish(newTaskEvent(TaskEventProgress, task))
 		log.Printf("[INFO] 更新任务 %s 的处理进度: %d/%d", 
 			taskID, task.ProcessedCount, task.TotalStudents)
 	} else {
@@ -218,17 +376,178 @@ func (q *TaskQueue) IncrementProcessedCount(taskID string) {
 	}
 }
 
-// CompleteTask 将任务标记为完成
-func (q *TaskQueue) CompleteTask(taskID string, result string) {
+// SetTaskInfo 记录任务对应的上传文件和处理参数
+func (q *TaskQueue) SetTaskInfo(taskID, filePath, homeworkType string, pagesPerStudent int, layout string) {
 	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
 	if task, exists := q.tasks[taskID]; exists {
+		task.FilePath = filePath
+		task.HomeworkType = homeworkType
+		task.PagesPerStudent = pagesPerStudent
+		task.Layout = layout
+		q.persist(task)
+	}
+}
+
+// AddTaskFiles 登记属于任务的文件（上传的文件、规范化后的图片等），清理任务时一并删除
+func (q *TaskQueue) AddTaskFiles(taskID string, paths ...string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if task, exists := q.tasks[taskID]; exists {
+		task.Files = append(append([]string(nil), task.Files...), paths...)
+		q.persist(task)
+	}
+}
+
+// SetTaskOwner 记录创建任务的用户和作业所属的班级
+func (q *TaskQueue) SetTaskOwner(taskID, userID, classID string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if task, exists := q.tasks[taskID]; exists {
+		task.UserID = userID
+		task.ClassID = classID
+		q.persist(task)
+	}
+}
+
+// SetTaskScoring 记录任务计算总得分的方式
+func (q *TaskQueue) SetTaskScoring(taskID string, scoring ScoringOptions) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if task, exists := q.tasks[taskID]; exists {
+		task.Scoring = &scoring
+		q.persist(task)
+	}
+}
+
+// SetTaskAssignment 记录上传时引用的作业，用于按作业汇总各班级的成绩
+func (q *TaskQueue) SetTaskAssignment(taskID, assignmentID string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if task, exists := q.tasks[taskID]; exists {
+		task.AssignmentID = assignmentID
+		q.persist(task)
+	}
+}
+
+// UpdateStudentResult 修改任务中已保存的某个学生的批改结果，同时更新最终结果中的同一学生
+// update返回错误时不做修改
+func (q *TaskQueue) UpdateStudentResult(taskID string, studentIndex int, update func(result *models.HomeworkResult) error) (models.HomeworkResult, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	task, exists := q.tasks[taskID]
+	if !exists {
+		return models.HomeworkResult{}, ErrTaskNotFound
+	}
+	if studentIndex < 0 || studentIndex >= len(task.StudentResults) || task.StudentResults[studentIndex] == nil {
+		return models.HomeworkResult{}, ErrStudentResultNotFound
+	}
+
+	// 复制答案和评分项，update返回错误时不影响已保存的结果
+	result := *task.StudentResults[studentIndex]
+	result.Answers = slices.Clone(result.Answers)
+	result.Criteria = slices.Clone(result.Criteria)
+	if err := update(&result); err != nil {
+		return result, err
+	}
+	task.StudentResults[studentIndex] = &result
+	for i := range task.Results {
+		if task.Results[i].StudentIndex == studentIndex {
+			task.Results[i] = result
+		}
+	}
+	q.persist(task)
+	return result, nil
+}
+
+// RecordStudentResult 保存某个学生的批改结果并增加已处理数量
+func (q *TaskQueue) RecordStudentResult(taskID string, studentIndex int, result models.HomeworkResult) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	task, exists := q.tasks[taskID]
+	if !exists {
+		log.Printf("[ERROR] 保存学生结果失败: 任务 %s 不存在", taskID)
+		return
+	}
+	if studentIndex < 0 || studentIndex >= len(task.StudentResults) {
+		log.Printf("[ERROR] 保存学生结果失败: 任务 %s 的学生序号 %d 无效", taskID, studentIndex)
+		return
+	}
+
+	result.StudentIndex = studentIndex
+	task.StudentResults[studentIndex] = &result
+	task.ProcessedCount++
+	q.persist(task)
+
+	event := newTaskEvent(TaskEventStudentResult, task)
+	event.StudentIndex = &studentIndex
+	event.Result = &result
+	q.publish(event)
+	log.Printf("[INFO] 任务 %s 的学生 %d 处理完成，进度: %d/%d",
+		taskID, studentIndex+1, task.ProcessedCount, task.TotalStudents)
+}
+
+// RecordStudentFailure 记录某个学生处理失败并增加已处理数量，失败的学生以错误条目保存在结果中
+func (q *TaskQueue) RecordStudentFailure(taskID string, studentIndex int, errMsg string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	task, exists := q.tasks[taskID]
+	if !exists {
+		log.Printf("[ERROR] 记录学生失败: 任务 %s 不存在", taskID)
+		return
+	}
+
+	if studentIndex >= 0 && studentIndex < len(task.StudentResults) {
+		errorResult := NewErrorResult(studentIndex, errMsg)
+		task.StudentResults[studentIndex] = &errorResult
+	}
+	task.ProcessedCount++
+	task.FailedCount++
+	q.persist(task)
+
+	event := newTaskEvent(TaskEventStudentFailed, task)
+	event.StudentIndex = &studentIndex
+	event.Error = errMsg
+	q.publish(event)
+	log.Printf("[WARN] 任务 %s 的学生 %d 处理失败: %s，进度: %d/%d",
+		taskID, studentIndex+1, errMsg, task.ProcessedCount, task.TotalStudents)
+}
+
+// UpdateTaskMessage 更新任务当前处理阶段的说明
+func (q *TaskQueue) UpdateTaskMessage(taskID, message string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if task, exists := q.tasks[taskID]; exists {
+		task.Message = message
+		q.persist(task)
+		q.publishStatus(task)
+	}
+}
+
+// CompleteTask 将任务标记为完成并保存最终结果
+func (q *TaskQueue) CompleteTask(taskID string, results []models.HomeworkResult) {
+	q.mutex.Lock()
+	if task, exists := q.tasks[taskID]; exists {
+		if task.Status == TaskStatusCancelled {
+			q.mutex.Unlock()
+			log.Printf("[INFO] 任务 %s 已取消，不再标记为完成", taskID)
+			return
+		}
 		task.Status = TaskStatusCompleted
 		now := time.Now()
 		task.EndTime = &now
-		if result != "" {
-			// 添加最终结果
-			task.Results = append(task.Results, result)
-		}
+		task.Results = results
+		q.persist(task)
+		q.publishStatus(task)
 	}
 	q.mutex.Unlock()
 	
@@ -239,39 +558,249 @@ func (q *TaskQueue) CompleteTask(taskID string, result string) {
 func (q *TaskQueue) FailTask(taskID string, err string) {
 	q.mutex.Lock()
 	if task, exists := q.tasks[taskID]; exists {
+		if task.Status == TaskStatusCancelled {
+			q.mutex.Unlock()
+			log.Printf("[INFO] 任务 %s 已取消，不再标记为失败", taskID)
+			return
+		}
 		task.Status = TaskStatusFailed
 		task.Error = err
 		now := time.Now()
 		task.EndTime = &now
+		q.persist(task)
+		q.publishStatus(task)
 	}
 	q.mutex.Unlock()
 	
 	log.Printf("[ERROR] 任务失败: %s, 错误: %s", taskID, err)
 }
 
+// ProposeSplit 记录建议的学生分页，任务进入等待教师确认的状态
+func (q *TaskQueue) ProposeSplit(taskID string, pageCount int, ranges []models.StudentPageRange) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	task, exists := q.tasks[taskID]
+	if !exists || task.Status == TaskStatusCancelled {
+		return
+	}
+
+	task.Status = TaskStatusAwaitingConfirmation
+	task.Message = fmt.Sprintf("识别出%d个学生，请确认学生分页", len(ranges))
+	task.PageCount = pageCount
+	task.ProposedSplit = ranges
+	if _, exists := q.splitConfirmations[taskID]; !exists {
+		q.splitConfirmations[taskID] = make(chan []models.StudentPageRange, 1)
+	}
+	q.persist(task)
+	q.publishStatus(task)
+}
+
+// SetStudentSplit 记录无需确认的学生分页（如按二维码拆分），便于查询每个学生对应的页码
+func (q *TaskQueue) SetStudentSplit(taskID string, pageCount int, ranges []models.StudentPageRange) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if task, exists := q.tasks[taskID]; exists {
+		task.PageCount = pageCount
+		task.ProposedSplit = ranges
+		q.persist(task)
+	}
+}
+
+// ConfirmSplit 教师确认或修改学生分页，ranges为空时采用建议的分页，任务恢复为处理中
+func (q *TaskQueue) ConfirmSplit(taskID string, ranges []models.StudentPageRange) ([]models.StudentPageRange, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	task, exists := q.tasks[taskID]
+	if !exists {
+		return nil, ErrTaskNotFound
+	}
+	confirmations, waiting := q.splitConfirmations[taskID]
+	if task.Status != TaskStatusAwaitingConfirmation || !waiting {
+		return nil, ErrTaskNotAwaitingConfirmation
+	}
+
+	if len(ranges) == 0 {
+		ranges = task.ProposedSplit
+	}
+	if err := ValidateStudentSplit(ranges, task.PageCount); err != nil {
+		return nil, err
+	}
+
+	task.Status = TaskStatusProcessing
+	task.Message = fmt.Sprintf("学生分页已确认，共%d个学生", len(ranges))
+	task.ProposedSplit = ranges
+	delete(q.splitConfirmations, taskID)
+	confirmations <- ranges
+	q.persist(task)
+	q.publishStatus(task)
+
+	log.Printf("[INFO] 任务 %s 的学生分页已确认: %d个学生", taskID, len(ranges))
+	return ranges, nil
+}
+
+// SetSplitConfirmationTimeout 设置等待教师确认学生分页的最长时间
+func (q *TaskQueue) SetSplitConfirmationTimeout(timeout time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.splitConfirmationTimeout = timeout
+}
+
+// WaitForSplitConfirmation 等待教师确认学生分页，任务取消时返回上下文的错误
+// 超过等待时间仍未确认时任务标记为失败，以便CleanupTasks清理，并返回ErrSplitConfirmationTimeout
+func (q *TaskQueue) WaitForSplitConfirmation(ctx context.Context, taskID string) ([]models.StudentPageRange, error) {
+	q.mutex.RLock()
+	confirmations, exists := q.splitConfirmations[taskID]
+	timeout := q.splitConfirmationTimeout
+	q.mutex.RUnlock()
+	if !exists {
+		return nil, ErrTaskNotAwaitingConfirmation
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case ranges := <-confirmations:
+		return ranges, nil
+	case <-ctx.Done():
+		q.mutex.Lock()
+		delete(q.splitConfirmations, taskID)
+		q.mutex.Unlock()
+		return nil, ctx.Err()
+	case <-timer.C:
+		return q.expireSplitConfirmation(taskID, confirmations, timeout)
+	}
+}
+
+// expireSplitConfirmation 等待确认超时后将任务标记为失败；超时的同时教师已确认时仍采用确认的分页
+func (q *TaskQueue) expireSplitConfirmation(taskID string, confirmations chan []models.StudentPageRange, timeout time.Duration) ([]models.StudentPageRange, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if _, waiting := q.splitConfirmations[taskID]; !waiting {
+		select {
+		case ranges := <-confirmations:
+			return ranges, nil
+		default:
+			return nil, ErrTaskNotAwaitingConfirmation
+		}
+	}
+	delete(q.splitConfirmations, taskID)
+
+	if task, exists := q.tasks[taskID]; exists && task.Status == TaskStatusAwaitingConfirmation {
+		now := time.Now()
+		task.Status = TaskStatusFailed
+		task.Error = fmt.Sprintf("超过%v没有确认学生分页，请重新上传", timeout)
+		task.EndTime = &now
+		q.persist(task)
+		q.publishStatus(task)
+	}
+
+	log.Printf("[WARN] 任务 %s 等待确认学生分页超时", taskID)
+	return nil, ErrSplitConfirmationTimeout
+}
+
+// RegisterCancelFunc 登记任务的取消函数，CancelTask时调用以停止正在进行的处理
+func (q *TaskQueue) RegisterCancelFunc(taskID string, cancel context.CancelFunc) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.cancelFuncs[taskID] = cancel
+}
+
+// UnregisterCancelFunc 任务处理结束后移除并调用其取消函数，释放上下文资源
+func (q *TaskQueue) UnregisterCancelFunc(taskID string) {
+	q.mutex.Lock()
+	cancel, exists := q.cancelFuncs[taskID]
+	delete(q.cancelFuncs, taskID)
+	q.mutex.Unlock()
+
+	if exists {
+		cancel()
+	}
+}
+
+// CancelTask 取消待处理、处理中或等待确认的任务
+// 任务被标记为已取消，已完成的学生结果保留，正在进行的处理通过上下文停止
+func (q *TaskQueue) CancelTask(taskID string) error {
+	q.mutex.Lock()
+	task, exists := q.tasks[taskID]
+	if !exists {
+		q.mutex.Unlock()
+		return ErrTaskNotFound
+	}
+	if task.Status != TaskStatusPending && task.Status != TaskStatusProcessing && task.Status != TaskStatusAwaitingConfirmation {
+		q.mutex.Unlock()
+		return ErrTaskNotCancellable
+	}
+
+	now := time.Now()
+	task.Status = TaskStatusCancelled
+	task.Message = "任务已取消"
+	task.EndTime = &now
+	q.persist(task)
+	q.publishStatus(task)
+
+	cancel, hasCancel := q.cancelFuncs[taskID]
+	delete(q.cancelFuncs, taskID)
+	q.mutex.Unlock()
+
+	if hasCancel {
+		cancel()
+	}
+
+	log.Printf("[INFO] 任务已取消: %s", taskID)
+	return nil
+}
+
 // Wait 等待所有任务完成
 func (q *TaskQueue) Wait() {
 	q.wg.Wait()
 }
 
-// Close 关闭任务队列
+// Close 关闭任务队列，并写入尚未保存的任务快照
 func (q *TaskQueue) Close() {
 	close(q.tasksChan)
+	q.Flush()
 }
 
-// CleanupTasks 清理旧任务
+// CleanupTasks 清理旧任务，并删除任务登记的文件
 func (q *TaskQueue) CleanupTasks(ageHours int) {
 	q.mutex.Lock()
-	defer q.mutex.Unlock()
-	
 	cutoff := time.Now().Add(-time.Duration(ageHours) * time.Hour)
 	
+	var removed, files []string
 	for id, task := range q.tasks {
 		if task.EndTime != nil && task.EndTime.Before(cutoff) {
 			delete(q.tasks, id)
+			removed = append(removed, id)
+			files = append(files, task.Files...)
 			log.Printf("[INFO] 清理了旧任务: %s", id)
 		}
 	}
+	q.mutex.Unlock()
+	
+	for _, path := range files {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("[WARN] 删除任务文件 %s 失败: %v", path, err)
+		}
+	}
+	
+	// 在锁外删除已保存的任务，并丢弃尚未写入的快照，避免删除后又被写回存储
+	q.flushMutex.Lock()
+	defer q.flushMutex.Unlock()
+	for _, id := range removed {
+		q.pendingMutex.Lock()
+		delete(q.pendingSaves, id)
+		q.pendingMutex.Unlock()
+		if err := q.store.Delete(id); err != nil {
+			log.Printf("[ERROR] 删除已保存的任务 %s 失败: %v", id, err)
+		}
+	}
 }
 
 // GetTasksCount 获取队列中的任务数量
@@ -284,6 +813,9 @@ func (q *TaskQueue) GetTasksCount() map[TaskStatus]int {
 		TaskStatusProcessing: 0,
 		TaskStatusCompleted:  0,
 		TaskStatusFailed:     0,
+		TaskStatusInterrupted: 0,
+		TaskStatusCancelled:  0,
+		TaskStatusAwaitingConfirmation: 0,
 	}
 	
 	for _, task := range q.tasks {
@@ -299,13 +831,15 @@ func (q *TaskQueue) CreateTask(taskType, message string) string {
 	task := &HomeworkTask{
 		ID:        taskID,
 		Status:    TaskStatusPending,
+		Message:   message,
 		StartTime: time.Now(),
-		Results:   make([]string, 0),
+		Results:   make([]models.HomeworkResult, 0),
 		Params:    map[string]interface{}{"type": taskType, "message": message},
 	}
 	
 	q.mutex.Lock()
 	q.tasks[taskID] = task
+	q.persist(task)
 	q.mutex.Unlock()
 	
 	log.Printf("[INFO] 创建任务: %s, 类型: %s", taskID, taskType)
@@ -322,6 +856,10 @@ func (q *TaskQueue) UpdateTaskStatus(taskID, status, message string) {
 		log.Printf("[ERROR] 更新状态失败: 任务 %s 不存在", taskID)
 		return
 	}
+	if task.Status == TaskStatusCancelled {
+		log.Printf("[INFO] 任务 %s 已取消，忽略状态更新: %s", taskID, status)
+		return
+	}
 	
 	// 将字符串状态转换为TaskStatus类型
 	var taskStatus TaskStatus
@@ -345,11 +883,11 @@ func (q *TaskQueue) UpdateTaskStatus(taskID, status, message string) {
 	}
 	
 	task.Status = taskStatus
-	
-	// 如果是完成状态，将消息添加到结果中
-	if taskStatus == TaskStatusCompleted && message != "" {
-		task.Results = append(task.Results, message)
+	if taskStatus != TaskStatusFailed {
+		task.Message = message
 	}
+	q.persist(task)
+	q.publishStatus(task)
 	
 	log.Printf("[INFO] 更新任务状态: %s -> %s", taskID, status)
 }
@@ -360,21 +898,75 @@ func GenerateTaskID() string {
 		"_" + RandStringRunes(6)
 }
 
+// taskIDRunes 任务ID随机部分使用的字符
+var taskIDRunes = []rune("abcdefghijklmnopqrstuvwxyz0123456789")
+
 // RandStringRunes 生成随机字符串
+// 任务按ID持久化，同一秒内创建的任务不能重复
 func RandStringRunes(n int) string {
-	// 简化实现，实际使用时可以使用uuid等库
-	return time.Now().Format("150405")
+	b := make([]rune, n)
+	for i := range b {
+		b[i] = taskIDRunes[rand.Intn(len(taskIDRunes))]
+	}
+	return string(b)
 }
 
-// GetAllTasks 获取所有任务的列表
+// GetAllTasks 获取所有任务的快照
 func (q *TaskQueue) GetAllTasks() []*HomeworkTask {
 	q.mutex.RLock()
 	defer q.mutex.RUnlock()
 	
 	tasks := make([]*HomeworkTask, 0, len(q.tasks))
 	for _, task := range q.tasks {
-		tasks = append(tasks, task)
+		tasks = append(tasks, task.snapshot())
 	}
 	
 	return tasks
-} 
\ No newline at end of file
+} 
+
+// ListTasks 返回满足条件的任务的快照，按开始时间从新到旧排列
+func (q *TaskQueue) ListTasks(match func(task *HomeworkTask) bool) []*HomeworkTask {
+	q.mutex.RLock()
+	var ids []string
+	for id, task := range q.tasks {
+		if match(task) {
+			ids = append(ids, id)
+		}
+	}
+	q.mutex.RUnlock()
+
+	tasks := make([]*HomeworkTask, 0, len(ids))
+	for _, id := range ids {
+		if task, exists := q.GetTask(id); exists {
+			tasks = append(tasks, task)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].StartTime.After(tasks[j].StartTime)
+	})
+	return tasks
+}
+
+// FindTaskByResultFile 查找拆分后的学生PDF（相对uploads/split的路径）所属的任务，返回任务的快照
+func (q *TaskQueue) FindTaskByResultFile(pdfURL string) (*HomeworkTask, bool) {
+	q.mutex.RLock()
+	taskID := ""
+	for id, task := range q.tasks {
+		for _, result := range task.Results {
+			if result.PdfURL == pdfURL {
+				taskID = id
+			}
+		}
+		for _, result := range task.StudentResults {
+			if result != nil && result.PdfURL == pdfURL {
+				taskID = id
+			}
+		}
+	}
+	q.mutex.RUnlock()
+
+	if taskID == "" {
+		return nil, false
+	}
+	return q.GetTask(taskID)
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// TestSplitConfirmationTimeout 测试教师没有按时确认学生分页时任务标记为失败，并能被清理
+func TestSplitConfirmationTimeout(t *testing.T) {
+	queue := NewTaskQueue(1)
+	queue.SetSplitConfirmationTimeout(50 * time.Millisecond)
+
+	taskID := queue.CreateTask("homework_processing", "")
+	queue.ProposeSplit(taskID, 2, []models.StudentPageRange{{StartPage: 1, EndPage: 2}})
+	if _, err := queue.WaitForSplitConfirmation(context.Background(), taskID); !errors.Is(err, ErrSplitConfirmationTimeout) {
+		t.Fatalf("预期等待确认超时，实际: %v", err)
+	}
+
+	task, _ := queue.GetTask(taskID)
+	if task.Status != TaskStatusFailed || task.Error == "" || task.EndTime == nil {
+		t.Errorf("超时的任务应被标记为失败: %+v", task)
+	}
+	if _, err := queue.ConfirmSplit(taskID, nil); !errors.Is(err, ErrTaskNotAwaitingConfirmation) {
+		t.Errorf("超时后不应再接受确认: %v", err)
+	}
+
+	queue.CleanupTasks(0)
+	if _, exists := queue.GetTask(taskID); exists {
+		t.Error("超时的任务应被清理")
+	}
+}
+
+// TestCleanupTasksRemovesFiles 测试清理任务时删除上传的文件和规范化后的图片
+func TestCleanupTasksRemovesFiles(t *testing.T) {
+	dir := t.TempDir()
+	upload := filepath.Join(dir, "photo.heic")
+	normalized := filepath.Join(dir, "photo_normalized.jpg")
+	for _, path := range []string{upload, normalized} {
+		if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+			t.Fatalf("写入测试文件失败: %v", err)
+		}
+	}
+
+	queue := NewTaskQueue(1)
+	taskID := queue.CreateTask("homework_processing", "")
+	queue.AddTaskFiles(taskID, upload)
+	queue.AddTaskFiles(taskID, normalized)
+	queue.CompleteTask(taskID, nil)
+
+	queue.CleanupTasks(0)
+	for _, path := range []string{upload, normalized} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("任务的文件应被删除: %s", path)
+		}
+	}
+}
+
+// TestPanicAfterCancelKeepsCancelled 测试已取消的任务在处理中出现panic时保持取消状态
+func TestPanicAfterCancelKeepsCancelled(t *testing.T) {
+	queue := NewTaskQueue(1)
+	task := &HomeworkTask{ID: "task-panic"}
+	task.WithProcessFunc(func(task *HomeworkTask) {
+		if err := queue.CancelTask(task.ID); err != nil {
+			t.Errorf("取消任务失败: %v", err)
+		}
+		panic("处理中出现错误")
+	})
+	queue.AddTask(task)
+	queue.Wait()
+
+	if snapshot, _ := queue.GetTask(task.ID); snapshot.Status != TaskStatusCancelled {
+		t.Errorf("任务应保持已取消，实际: %s", snapshot.Status)
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// tasksBucket 任务在BoltDB中的存储桶名称
+var tasksBucket = []byte("tasks")
+
+// TaskStore 任务存储接口
+// TaskQueue 在内存中保存任务，并在每次变更后写入存储，以便服务重启后恢复
+type TaskStore interface {
+	// Save 保存（新增或覆盖）一个任务
+	Save(task *HomeworkTask) error
+	// Delete 删除一个任务
+	Delete(taskID string) error
+	// LoadAll 加载所有已保存的任务
+	LoadAll() ([]*HomeworkTask, error)
+}
+
+// OpenBoltDB 打开（必要时创建）嵌入式BoltDB数据库文件
+func OpenBoltDB(path string) (*bolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建数据目录失败: %v", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开数据库失败: %v", err)
+	}
+
+	log.Printf("[INFO] 已打开数据库: %s", path)
+	return db, nil
+}
+
+// memoryTaskStore 不做持久化的任务存储，服务重启后任务丢失
+type memoryTaskStore struct{}
+
+// NewMemoryTaskStore 创建不做持久化的任务存储
+func NewMemoryTaskStore() TaskStore {
+	return memoryTaskStore{}
+}
+
+func (memoryTaskStore) Save(task *HomeworkTask) error     { return nil }
+func (memoryTaskStore) Delete(taskID string) error        { return nil }
+func (memoryTaskStore) LoadAll() ([]*HomeworkTask, error) { return nil, nil }
+
+// BoltTaskStore 基于BoltDB的任务存储，任务以JSON格式保存
+type BoltTaskStore struct {
+	db *bolt.DB
+}
+
+// NewBoltTaskStore 创建基于BoltDB的任务存储
+func NewBoltTaskStore(db *bolt.DB) (*BoltTaskStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tasksBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建任务存储桶失败: %v", err)
+	}
+
+	return &BoltTaskStore{db: db}, nil
+}
+
+// Save 保存任务
+func (s *BoltTaskStore) Save(task *HomeworkTask) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("序列化任务失败: %v", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tasksBucket).Put([]byte(task.ID), data)
+	})
+}
+
+// Delete 删除任务
+func (s *BoltTaskStore) Delete(taskID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tasksBucket).Delete([]byte(taskID))
+	})
+}
+
+// LoadAll 加载所有任务
+func (s *BoltTaskStore) LoadAll() ([]*HomeworkTask, error) {
+	var tasks []*HomeworkTask
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tasksBucket).ForEach(func(k, v []byte) error {
+			var task HomeworkTask
+			if err := json.Unmarshal(v, &task); err != nil {
+				// 单个任务损坏不影响其他任务的恢复
+				log.Printf("[ERROR] 解析已保存的任务 %s 失败: %v", string(k), err)
+				return nil
+			}
+			tasks = append(tasks, &task)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("加载任务失败: %v", err)
+	}
+
+	return tasks, nil
+}
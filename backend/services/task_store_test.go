@@ -0,0 +1,146 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GiantClam/homework_marking/models"
+)
+
+// TestTaskQueueRecoversFromBoltStore 测试服务重启后从存储中恢复任务
+func TestTaskQueueRecoversFromBoltStore(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "tasks.db")
+
+	db, err := OpenBoltDB(dbPath)
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	store, err := NewBoltTaskStore(db)
+	if err != nil {
+		t.Fatalf("创建任务存储失败: %v", err)
+	}
+	queue, err := NewTaskQueueWithStore(1, store)
+	if err != nil {
+		t.Fatalf("创建任务队列失败: %v", err)
+	}
+
+	// 一个已完成的任务和一个处理到一半的任务
+	completedID := queue.CreateTask("homework_processing", "")
+	queue.UpdateTaskTotalStudents(completedID, 2)
+	queue.CompleteTask(completedID, []models.HomeworkResult{{Name: "张三"}, {Name: "李四"}})
+
+	processingID := queue.CreateTask("homework_processing", "")
+	queue.UpdateTaskStatus(processingID, "processing", "")
+	queue.UpdateTaskTotalStudents(processingID, 3)
+	queue.RecordStudentResult(processingID, 0, models.HomeworkResult{Name: "王五"})
+
+	if completedID == processingID {
+		t.Fatalf("任务ID重复: %s", completedID)
+	}
+
+	// 模拟服务重启
+	queue.Flush()
+	if err := db.Close(); err != nil {
+		t.Fatalf("关闭数据库失败: %v", err)
+	}
+	db, err = OpenBoltDB(dbPath)
+	if err != nil {
+		t.Fatalf("重新打开数据库失败: %v", err)
+	}
+	defer db.Close()
+	store, err = NewBoltTaskStore(db)
+	if err != nil {
+		t.Fatalf("创建任务存储失败: %v", err)
+	}
+	queue, err = NewTaskQueueWithStore(1, store)
+	if err != nil {
+		t.Fatalf("恢复任务队列失败: %v", err)
+	}
+
+	completed, exists := queue.GetTask(completedID)
+	if !exists {
+		t.Fatalf("已完成的任务没有被恢复")
+	}
+	if completed.Status != TaskStatusCompleted || len(completed.Results) != 2 || completed.TotalStudents != 2 {
+		t.Errorf("已完成的任务恢复错误: %+v", completed)
+	}
+
+	interrupted, exists := queue.GetTask(processingID)
+	if !exists {
+		t.Fatalf("处理中的任务没有被恢复")
+	}
+	if interrupted.Status != TaskStatusInterrupted || interrupted.Error == "" || interrupted.EndTime == nil {
+		t.Errorf("处理中的任务应被标记为已中断: %+v", interrupted)
+	}
+	if interrupted.ProcessedCount != 1 || len(interrupted.StudentResults) != 3 ||
+		interrupted.StudentResults[0] == nil || interrupted.StudentResults[0].Name != "王五" {
+		t.Errorf("已中断任务的部分结果应保留: %+v", interrupted)
+	}
+
+	// 中断状态也应写回存储
+	queue.Flush()
+	tasks, err := store.LoadAll()
+	if err != nil {
+		t.Fatalf("加载任务失败: %v", err)
+	}
+	for _, task := range tasks {
+		if task.ID == processingID && task.Status != TaskStatusInterrupted {
+			t.Errorf("中断状态没有写回存储: %s", task.Status)
+		}
+	}
+}
+
+// blockingTaskStore 写入时阻塞直到release被关闭的任务存储
+type blockingTaskStore struct {
+	release chan struct{}
+	saved   chan int // 每次写入时任务的已处理学生数
+}
+
+func (s *blockingTaskStore) Save(task *HomeworkTask) error {
+	<-s.release
+	s.saved <- task.ProcessedCount
+	return nil
+}
+
+func (s *blockingTaskStore) Delete(taskID string) error { return nil }
+
+func (s *blockingTaskStore) LoadAll() ([]*HomeworkTask, error) { return nil, nil }
+
+// TestTaskQueuePersistsOutsideLock 测试写入存储时不占用任务队列的锁，同一任务的多次变更合并写入
+func TestTaskQueuePersistsOutsideLock(t *testing.T) {
+	store := &blockingTaskStore{release: make(chan struct{}), saved: make(chan int, 10)}
+	queue, err := NewTaskQueueWithStore(1, store)
+	if err != nil {
+		t.Fatalf("创建任务队列失败: %v", err)
+	}
+
+	taskID := queue.CreateTask("homework_processing", "")
+	done := make(chan struct{})
+	go func() {
+		for i := 1; i <= 5; i++ {
+			queue.UpdateTaskProgress(taskID, i)
+			queue.GetTask(taskID)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("存储写入阻塞了任务队列")
+	}
+
+	close(store.release)
+	queue.Flush()
+	saves := len(store.saved)
+	if saves < 1 || saves > 3 {
+		t.Errorf("同一任务的多次变更应合并写入，实际写入%d次", saves)
+	}
+	last := 0
+	for i := 0; i < saves; i++ {
+		last = <-store.saved
+	}
+	if last != 5 {
+		t.Errorf("最后写入的应是最新的进度，实际: %d", last)
+	}
+}
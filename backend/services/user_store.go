@@ -0,0 +1,399 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/GiantClam/homework_marking/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// usersBucket 用户在BoltDB中的存储桶名称，按用户ID保存
+	usersBucket = []byte("users")
+	// usernamesBucket 用户名到用户ID的索引
+	usernamesBucket = []byte("usernames")
+)
+
+var (
+	// ErrUserNotFound 用户不存在
+	ErrUserNotFound = errors.New("用户不存在")
+	// ErrUsernameTaken 用户名已被注册
+	ErrUsernameTaken = errors.New("用户名已被注册")
+)
+
+// UserRecord 保存在存储中的用户及其密码哈希
+type UserRecord struct {
+	models.User
+	PasswordHash string `json:"passwordHash"`
+}
+
+// UserStore 用户存储接口，用户名不区分大小写
+type UserStore interface {
+	// Create 保存新用户，用户名已存在时返回ErrUsernameTaken
+	Create(record *UserRecord) error
+	// Register 保存自行注册的用户，检查已有用户和写入一次完成：还没有用户时以管理员角色保存，
+	// 已有用户且closed为true时返回ErrRegistrationClosed，用户名已存在时返回ErrUsernameTaken
+	Register(record *UserRecord, closed bool) error
+	// GetByID 按用户ID查找用户，不存在时返回ErrUserNotFound
+	GetByID(id string) (*UserRecord, error)
+	// GetByUsername 按用户名查找用户，不存在时返回ErrUserNotFound
+	GetByUsername(username string) (*UserRecord, error)
+	// Count 返回用户数量
+	Count() (int, error)
+	// List 返回所有用户，按创建时间排序
+	List() ([]*UserRecord, error)
+	// Update 更新已存在的用户（用户名不能修改），不存在时返回ErrUserNotFound；
+	// 检查其他管理员和写入一次完成，降级最后一个管理员时返回ErrLastAdmin
+	Update(record *UserRecord) error
+	// Delete 删除用户，不存在时返回ErrUserNotFound；删除最后一个管理员时返回ErrLastAdmin
+	Delete(id string) error
+}
+
+// usernameKey 用户名索引使用的键
+func usernameKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// memoryUserStore 保存在内存中的用户存储，用于测试
+type memoryUserStore struct {
+	mutex     sync.RWMutex
+	users     map[string]UserRecord
+	usernames map[string]string
+}
+
+// NewMemoryUserStore 创建保存在内存中的用户存储，服务重启后用户丢失
+func NewMemoryUserStore() UserStore {
+	return &memoryUserStore{
+		users:     make(map[string]UserRecord),
+		usernames: make(map[string]string),
+	}
+}
+
+func (s *memoryUserStore) Create(record *UserRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := usernameKey(record.Username)
+	if _, exists := s.usernames[key]; exists {
+		return ErrUsernameTaken
+	}
+	s.users[record.ID] = *record
+	s.usernames[key] = record.ID
+	return nil
+}
+
+func (s *memoryUserStore) Register(record *UserRecord, closed bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.users) == 0 {
+		record.Role = models.RoleAdmin
+	} else if closed {
+		return ErrRegistrationClosed
+	}
+	key := usernameKey(record.Username)
+	if _, exists := s.usernames[key]; exists {
+		return ErrUsernameTaken
+	}
+	s.users[record.ID] = *record
+	s.usernames[key] = record.ID
+	return nil
+}
+
+func (s *memoryUserStore) GetByID(id string) (*UserRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	record, exists := s.users[id]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	return &record, nil
+}
+
+func (s *memoryUserStore) GetByUsername(username string) (*UserRecord, error) {
+	s.mutex.RLock()
+	id, exists := s.usernames[usernameKey(username)]
+	s.mutex.RUnlock()
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	return s.GetByID(id)
+}
+
+func (s *memoryUserStore) Count() (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.users), nil
+}
+
+func (s *memoryUserStore) List() ([]*UserRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	records := make([]*UserRecord, 0, len(s.users))
+	for _, record := range s.users {
+		record := record
+		records = append(records, &record)
+	}
+	sortUserRecords(records)
+	return records, nil
+}
+
+func (s *memoryUserStore) Update(record *UserRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	existing, exists := s.users[record.ID]
+	if !exists {
+		return ErrUserNotFound
+	}
+	if existing.Role == models.RoleAdmin && record.Role != models.RoleAdmin && !s.hasOtherAdmin(record.ID) {
+		return ErrLastAdmin
+	}
+	record.Username = existing.Username
+	s.users[record.ID] = *record
+	return nil
+}
+
+func (s *memoryUserStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, exists := s.users[id]
+	if !exists {
+		return ErrUserNotFound
+	}
+	if record.Role == models.RoleAdmin && !s.hasOtherAdmin(id) {
+		return ErrLastAdmin
+	}
+	delete(s.usernames, usernameKey(record.Username))
+	delete(s.users, id)
+	return nil
+}
+
+// hasOtherAdmin 判断除该用户外是否还有管理员，调用方需要持有锁
+func (s *memoryUserStore) hasOtherAdmin(id string) bool {
+	for _, record := range s.users {
+		if record.ID != id && record.Role == models.RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// BoltUserStore 基于BoltDB的用户存储，用户以JSON格式保存
+type BoltUserStore struct {
+	db *bolt.DB
+}
+
+// NewBoltUserStore 创建基于BoltDB的用户存储
+func NewBoltUserStore(db *bolt.DB) (*BoltUserStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(usersBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(usernamesBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建用户存储桶失败: %v", err)
+	}
+
+	return &BoltUserStore{db: db}, nil
+}
+
+// Create 保存新用户，用户名索引和用户在同一个事务中写入
+func (s *BoltUserStore) Create(record *UserRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("序列化用户失败: %v", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		usernames := tx.Bucket(usernamesBucket)
+		key := []byte(usernameKey(record.Username))
+		if usernames.Get(key) != nil {
+			return ErrUsernameTaken
+		}
+		if err := usernames.Put(key, []byte(record.ID)); err != nil {
+			return err
+		}
+		return tx.Bucket(usersBucket).Put([]byte(record.ID), data)
+	})
+}
+
+// Register 保存自行注册的用户，在同一个写事务中检查是否已有用户，避免同时注册的两个账号都成为管理员
+func (s *BoltUserStore) Register(record *UserRecord, closed bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if first, _ := tx.Bucket(usersBucket).Cursor().First(); first == nil {
+			record.Role = models.RoleAdmin
+		} else if closed {
+			return ErrRegistrationClosed
+		}
+
+		usernames := tx.Bucket(usernamesBucket)
+		key := []byte(usernameKey(record.Username))
+		if usernames.Get(key) != nil {
+			return ErrUsernameTaken
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("序列化用户失败: %v", err)
+		}
+		if err := usernames.Put(key, []byte(record.ID)); err != nil {
+			return err
+		}
+		return tx.Bucket(usersBucket).Put([]byte(record.ID), data)
+	})
+}
+
+// GetByID 按用户ID查找用户
+func (s *BoltUserStore) GetByID(id string) (*UserRecord, error) {
+	var record *UserRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		record, err = getUserRecord(tx, []byte(id))
+		return err
+	})
+	return record, err
+}
+
+// GetByUsername 按用户名查找用户
+func (s *BoltUserStore) GetByUsername(username string) (*UserRecord, error) {
+	var record *UserRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(usernamesBucket).Get([]byte(usernameKey(username)))
+		if id == nil {
+			return ErrUserNotFound
+		}
+		var err error
+		record, err = getUserRecord(tx, id)
+		return err
+	})
+	return record, err
+}
+
+// Count 返回用户数量
+func (s *BoltUserStore) Count() (int, error) {
+	count := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(usersBucket).Stats().KeyN
+		return nil
+	})
+	return count, err
+}
+
+// List 返回所有用户
+func (s *BoltUserStore) List() ([]*UserRecord, error) {
+	var records []*UserRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			var record UserRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				log.Printf("[ERROR] 解析用户 %s 失败: %v", string(k), err)
+				return nil
+			}
+			records = append(records, &record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("加载用户失败: %v", err)
+	}
+	sortUserRecords(records)
+	return records, nil
+}
+
+// Update 更新已存在的用户，用户名保持不变
+// 在同一个写事务中检查其他管理员，避免两个管理员同时互相降级后没有管理员
+func (s *BoltUserStore) Update(record *UserRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		existing, err := getUserRecord(tx, []byte(record.ID))
+		if err != nil {
+			return err
+		}
+		if existing.Role == models.RoleAdmin && record.Role != models.RoleAdmin {
+			if err := ensureOtherAdmin(tx, record.ID); err != nil {
+				return err
+			}
+		}
+		record.Username = existing.Username
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("序列化用户失败: %v", err)
+		}
+		return tx.Bucket(usersBucket).Put([]byte(record.ID), data)
+	})
+}
+
+// Delete 删除用户和用户名索引，与Update一样在同一个写事务中检查其他管理员
+func (s *BoltUserStore) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		record, err := getUserRecord(tx, []byte(id))
+		if err != nil {
+			return err
+		}
+		if record.Role == models.RoleAdmin {
+			if err := ensureOtherAdmin(tx, id); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(usernamesBucket).Delete([]byte(usernameKey(record.Username))); err != nil {
+			return err
+		}
+		return tx.Bucket(usersBucket).Delete([]byte(id))
+	})
+}
+
+// sortUserRecords 按创建时间排序用户
+func sortUserRecords(records []*UserRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+}
+
+// ensureOtherAdmin 在事务中确认除该用户外还有管理员，没有时返回ErrLastAdmin
+func ensureOtherAdmin(tx *bolt.Tx, id string) error {
+	found := false
+	err := tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+		if found || string(k) == id {
+			return nil
+		}
+		var record UserRecord
+		if err := json.Unmarshal(v, &record); err != nil {
+			log.Printf("[ERROR] 解析用户 %s 失败: %v", string(k), err)
+			return nil
+		}
+		found = record.Role == models.RoleAdmin
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// getUserRecord 在事务中读取并解析用户
+func getUserRecord(tx *bolt.Tx, id []byte) (*UserRecord, error) {
+	data := tx.Bucket(usersBucket).Get(id)
+	if data == nil {
+		return nil, ErrUserNotFound
+	}
+
+	var record UserRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("解析用户 %s 失败: %v", string(id), err)
+	}
+	return &record, nil
+}
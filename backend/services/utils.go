@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -713,7 +714,7 @@ func SplitPDFByStudents(pdfPath string, pagesPerStudent int) ([]string, error) {
 }
 
 // 直接使用PDF进行Gemini内容生成
-func GenerateContentWithPDF(client *VertexAIClient, systemInstruction, pdfPath, textPrompt string) (string, error) {
+func GenerateContentWithPDF(ctx context.Context, client LLMProvider, systemInstruction, pdfPath, textPrompt string) (string, error) {
 	log.Printf("[INFO] 使用PDF文件生成内容: %s", pdfPath)
 	
 	// 增强文件存在性检查
@@ -752,7 +753,7 @@ func GenerateContentWithPDF(client *VertexAIClient, systemInstruction, pdfPath,
 	
 	log.Printf("[INFO] PDF文件有效，页数: %d, 文件大小: %d字节", pageCount, fileInfo.Size())
 	
-	// 调用VertexAI处理PDF
-	log.Printf("[INFO] 发送PDF文件到AI服务进行处理")
-	return client.GenerateContentWithFile(systemInstruction, pdfPath, mimeType, textPrompt)
+	// 调用大模型处理PDF
+	log.Printf("[INFO] 发送PDF文件到AI服务(%s)进行处理", client.Name())
+	return client.GenerateContentWithFile(ctx, systemInstruction, pdfPath, mimeType, textPrompt)
 }
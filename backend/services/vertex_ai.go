@@ -13,7 +13,9 @@ import (
 	"unicode/utf8"
 
 	"cloud.google.com/go/vertexai/genai"
+	"github.com/GiantClam/homework_marking/models"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
@@ -37,6 +39,11 @@ func NewVertexAIClient() *VertexAIClient {
 	}
 }
 
+// Name 返回提供方名称
+func (c *VertexAIClient) Name() string {
+	return ProviderVertex
+}
+
 // 创建带代理设置的 HTTP 客户端选项
 func getClientOptions(credentialsFile string) []option.ClientOption {
 	// 仅返回凭证文件选项，不再设置 HTTP 客户端
@@ -146,13 +153,13 @@ func sanitizeUTF8(s string) string {
 }
 
 // GenerateContent 使用文本内容生成AI回复，不需要附加文件
-func (c *VertexAIClient) GenerateContent(systemInstruction, textPrompt string) (string, error) {
+func (c *VertexAIClient) GenerateContent(ctx context.Context, systemInstruction, textPrompt string) (string, error) {
 	log.Printf("[INFO] 开始生成AI内容...")
 	log.Printf("[DEBUG] 系统指令长度: %d 字符", len(systemInstruction))
 	log.Printf("[DEBUG] 文本提示词长度: %d 字符", len(textPrompt))
 
-	// 创建一个上下文，可以在必要时取消请求
-	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Second)
+	// 在调用方上下文的基础上增加超时，调用方取消时请求随之取消
+	ctx, cancel := context.WithTimeout(ctx, 200*time.Second)
 	defer cancel()
 
 	// 创建客户端
@@ -254,7 +261,19 @@ func (c *VertexAIClient) GenerateContent(systemInstruction, textPrompt string) (
 }
 
 // GenerateContentWithFile 使用文件内容生成AI回复
-func (c *VertexAIClient) GenerateContentWithFile(systemInstruction, filePath, mimeType, textPrompt string) (string, error) {
+// ctx被取消时停止重试并返回ctx的错误
+func (c *VertexAIClient) GenerateContentWithFile(ctx context.Context, systemInstruction, filePath, mimeType, textPrompt string) (string, error) {
+	return c.generateContentWithFile(ctx, systemInstruction, filePath, mimeType, textPrompt, nil)
+}
+
+// GenerateJSONWithFile 使用文件内容生成受schema约束的JSON回复
+// 通过ResponseMIMEType和ResponseSchema启用JSON模式，返回原始文本不做修复
+func (c *VertexAIClient) GenerateJSONWithFile(ctx context.Context, systemInstruction, filePath, mimeType, textPrompt string, schema *ResponseSchema) (string, error) {
+	return c.generateContentWithFile(ctx, systemInstruction, filePath, mimeType, textPrompt, schema)
+}
+
+// generateContentWithFile 使用文件内容生成AI回复，schema不为空时启用JSON模式
+func (c *VertexAIClient) generateContentWithFile(parentCtx context.Context, systemInstruction, filePath, mimeType, textPrompt string, schema *ResponseSchema) (string, error) {
 	log.Printf("[INFO] 开始生成带文件的AI内容...")
 	log.Printf("[DEBUG] 系统指令长度: %d 字符", len(systemInstruction))
 	log.Printf("[DEBUG] 文件路径: %s", filePath)
@@ -264,7 +283,19 @@ func (c *VertexAIClient) GenerateContentWithFile(systemInstruction, filePath, mi
 	// 检查模拟模式
 	if UseMockMode {
 		log.Printf("[INFO] 使用模拟模式，将生成模拟响应")
-		return GenerateMockHomeworkResult(filePath, textPrompt)
+		if schema == EssayResultSchema {
+			return GenerateMockEssayResult()
+		}
+		mockResult, err := GenerateMockHomeworkResult(filePath, textPrompt)
+		if err != nil || schema == nil || !strings.HasPrefix(mockResult, "[") {
+			return mockResult, err
+		}
+		// JSON模式下每次只批改一个学生，取第一个模拟结果
+		var mockResults []json.RawMessage
+		if err := json.Unmarshal([]byte(mockResult), &mockResults); err != nil || len(mockResults) == 0 {
+			return mockResult, err
+		}
+		return string(mockResults[0]), nil
 	}
 
 	// 检查文件是否存在和可访问
@@ -325,12 +356,17 @@ func (c *VertexAIClient) GenerateContentWithFile(systemInstruction, filePath, mi
 		if retryCount > 0 {
 			backoffTime := time.Duration(retryCount*5) * time.Second
 			log.Printf("[INFO] 第 %d 次重试，等待 %v 后进行...", retryCount, backoffTime)
-			time.Sleep(backoffTime)
+			if err := SleepWithContext(parentCtx, backoffTime); err != nil {
+				return "", fmt.Errorf("AI服务请求已取消: %w", err)
+			}
+		}
+		if err := parentCtx.Err(); err != nil {
+			return "", fmt.Errorf("AI服务请求已取消: %w", err)
 		}
 
 		// 每次尝试创建新的上下文，增加超时时间
 		timeoutSeconds := 300 + retryCount*60 // 每次重试多增加60秒的超时时间
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSeconds)*time.Second)
+		ctx, cancel := context.WithTimeout(parentCtx, time.Duration(timeoutSeconds)*time.Second)
 		defer cancel()
 
 		// 创建客户端
@@ -355,6 +391,12 @@ func (c *VertexAIClient) GenerateContentWithFile(systemInstruction, filePath, mi
 		model.TopK = &topK
 		model.MaxOutputTokens = &maxOutputTokens
 
+		// JSON模式，约束输出结构
+		if schema != nil {
+			model.ResponseMIMEType = "application/json"
+			model.ResponseSchema = schema.toGenAI()
+		}
+
 		// 如果系统指令不为空，设置系统指令
 		if systemInstruction != "" {
 			model.SystemInstruction = &genai.Content{
@@ -469,6 +511,11 @@ func (c *VertexAIClient) GenerateContentWithFile(systemInstruction, filePath, mi
 			log.Printf("[DEBUG] AI响应文本: %s", responseText)
 		}
 
+		// JSON模式下返回原始文本，由调用方按schema校验，避免修复后掩盖错误
+		if schema != nil {
+			return sanitizeUTF8(strings.TrimSpace(responseText)), nil
+		}
+
 		// 处理JSON格式
 		// 如果响应文本看起来是JSON格式，尝试清理和验证
 		if strings.Contains(responseText, "{") || strings.Contains(responseText, "[") {
@@ -540,6 +587,9 @@ func GenerateMockHomeworkResult(filePath, textPrompt string) (string, error) {
       "questionNumber": "%d",
       "studentAnswer": "This is a correct answer for question %d",
       "isCorrect": true,
+      "maxPoints": 10,
+      "awardedPoints": 10,
+      "correctAnswer": "与学生答案一致",
       "explanation": "答案正确，表达流畅"
     }`, j, j)
 					answers = append(answers, answer)
@@ -549,6 +599,9 @@ func GenerateMockHomeworkResult(filePath, textPrompt string) (string, error) {
       "questionNumber": "%d",
       "studentAnswer": "This is an incorrect answer for question %d",
       "isCorrect": false,
+      "maxPoints": 10,
+      "awardedPoints": 4,
+      "rationale": "思路部分正确，给部分分",
       "correctAnswer": "The correct answer for question %d",
       "explanation": "答案有误，需要改进"
     }`, j, j, j)
@@ -559,6 +612,7 @@ func GenerateMockHomeworkResult(filePath, textPrompt string) (string, error) {
 			// 合并成完整的学生结果 - 分数直接使用整数
 			studentResult = fmt.Sprintf(`{
   "name": "%s",
+  "class": "模拟班级",
   "answers": [%s
   ],
   "overallScore": "%d",
@@ -583,6 +637,9 @@ func GenerateMockHomeworkResult(filePath, textPrompt string) (string, error) {
       "questionNumber": "%d",
       "studentAnswer": "x = %d",
       "isCorrect": true,
+      "maxPoints": 10,
+      "awardedPoints": 10,
+      "correctAnswer": "与学生答案一致",
       "explanation": "计算正确，方法得当"
     }`, j, 2*j)
 					answers = append(answers, answer)
@@ -594,6 +651,9 @@ func GenerateMockHomeworkResult(filePath, textPrompt string) (string, error) {
       "questionNumber": "%d",
       "studentAnswer": "x = %d",
       "isCorrect": false,
+      "maxPoints": 10,
+      "awardedPoints": 4,
+      "rationale": "思路部分正确，给部分分",
       "correctAnswer": "x = %d",
       "explanation": "计算有误，应为%d"
     }`, j, wrongValue, correctValue, correctValue)
@@ -604,6 +664,7 @@ func GenerateMockHomeworkResult(filePath, textPrompt string) (string, error) {
 			// 合并成完整的学生结果 - 分数直接使用整数
 			studentResult = fmt.Sprintf(`{
   "name": "%s",
+  "class": "模拟班级",
   "answers": [%s
   ],
   "overallScore": "%d",
@@ -626,6 +687,9 @@ func GenerateMockHomeworkResult(filePath, textPrompt string) (string, error) {
       "questionNumber": "%d",
       "studentAnswer": "语文题目%d的正确回答",
       "isCorrect": true,
+      "maxPoints": 10,
+      "awardedPoints": 10,
+      "correctAnswer": "与学生答案一致",
       "evaluation": "理解深刻，表达流畅",
       "suggestion": "可以再增加一些文学性的表达"
     }`, j, j)
@@ -636,6 +700,9 @@ func GenerateMockHomeworkResult(filePath, textPrompt string) (string, error) {
       "questionNumber": "%d",
       "studentAnswer": "语文题目%d的不完全正确回答",
       "isCorrect": false,
+      "maxPoints": 10,
+      "awardedPoints": 4,
+      "rationale": "思路部分正确，给部分分",
       "correctAnswer": "语文题目%d的标准答案",
       "evaluation": "基本理解了题意，但表达不够准确",
       "suggestion": "需要注意遣词造句，提高表达准确性"
@@ -647,6 +714,7 @@ func GenerateMockHomeworkResult(filePath, textPrompt string) (string, error) {
 			// 合并成完整的学生结果 - 分数直接使用整数
 			studentResult = fmt.Sprintf(`{
   "name": "%s",
+  "class": "模拟班级",
   "answers": [%s
   ],
   "overallScore": "%d",
@@ -658,17 +726,24 @@ func GenerateMockHomeworkResult(filePath, textPrompt string) (string, error) {
 			score := 75 + rand.Intn(25)
 			studentResult = fmt.Sprintf(`{
   "name": "%s",
+  "class": "模拟班级",
   "answers": [
     {
       "questionNumber": "1",
       "studentAnswer": "这是学生的第一个回答",
       "isCorrect": true,
+      "maxPoints": 10,
+      "awardedPoints": 10,
+      "correctAnswer": "与学生答案一致",
       "evaluation": "回答基本正确"
     },
     {
       "questionNumber": "2",
       "studentAnswer": "这是学生的第二个回答",
       "isCorrect": false,
+      "maxPoints": 10,
+      "awardedPoints": 4,
+      "rationale": "思路部分正确，给部分分",
       "correctAnswer": "标准答案",
       "evaluation": "有一些小错误需要修正"
     },
@@ -676,6 +751,9 @@ func GenerateMockHomeworkResult(filePath, textPrompt string) (string, error) {
       "questionNumber": "3",
       "studentAnswer": "这是学生的第三个回答",
       "isCorrect": true,
+      "maxPoints": 10,
+      "awardedPoints": 10,
+      "correctAnswer": "与学生答案一致",
       "evaluation": "回答完全正确"
     }
   ],
@@ -698,8 +776,33 @@ func GenerateMockHomeworkResult(filePath, textPrompt string) (string, error) {
 	}
 }
 
+// GenerateMockEssayResult 按默认评分标准生成模拟的作文批改结果
+func GenerateMockEssayResult() (string, error) {
+	result := models.HomeworkResult{
+		Name:     "张三",
+		Class:    "模拟班级",
+		Feedback: "文章中心明确，结构完整，语言还可以更加生动。",
+	}
+	for _, criterion := range DefaultEssayRubric().Criteria {
+		score := float64(6 + rand.Intn(5))
+		result.Criteria = append(result.Criteria, models.CriterionScore{
+			Name:     criterion.Name,
+			Score:    &score,
+			Evidence: "那天的夕阳，把整条街都染成了金色。",
+			Comment:  criterion.Name + "表现较好",
+		})
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("生成模拟作文结果失败: %v", err)
+	}
+	return string(data), nil
+}
+
 // GenerateContentStream 使用Vertex AI流式生成内容
-func (c *VertexAIClient) GenerateContentStream(ctx context.Context, systemInstruction, prompt string) (*genai.GenerateContentResponseIterator, error) {
+// 每收到一段文本调用一次onChunk
+func (c *VertexAIClient) GenerateContentStream(ctx context.Context, systemInstruction, prompt string, onChunk func(chunk string) error) error {
 	// 添加调试日志
 	log.Printf("[DEBUG] 准备调用 Vertex AI 流式生成内容")
 	log.Printf("[DEBUG] 项目ID: %s, 位置: %s, 模型: %s", c.projectID, c.location, c.model)
@@ -728,7 +831,7 @@ func (c *VertexAIClient) GenerateContentStream(ctx context.Context, systemInstru
 	// 检查凭证文件是否存在
 	if _, err := os.Stat(credentialsFile); os.IsNotExist(err) {
 		log.Printf("[ERROR] 凭证文件不存在: %s", credentialsFile)
-		return nil, fmt.Errorf("凭证文件不存在: %s", credentialsFile)
+		return fmt.Errorf("凭证文件不存在: %s", credentialsFile)
 	}
 
 	log.Printf("[DEBUG] 开始创建 Vertex AI 客户端...")
@@ -740,11 +843,9 @@ func (c *VertexAIClient) GenerateContentStream(ctx context.Context, systemInstru
 	client, err := genai.NewClient(ctx, c.projectID, c.location, opts...)
 	if err != nil {
 		log.Printf("[ERROR] 创建AI客户端失败: %v", err)
-		return nil, fmt.Errorf("创建AI客户端失败: %v", err)
+		return fmt.Errorf("创建AI客户端失败: %v", err)
 	}
-
-	// 保存客户端引用以便稍后关闭
-	c.client = client
+	defer client.Close()
 	log.Printf("[DEBUG] Vertex AI 客户端创建成功")
 
 	// 获取模型
@@ -772,9 +873,34 @@ func (c *VertexAIClient) GenerateContentStream(ctx context.Context, systemInstru
 
 	log.Printf("[DEBUG] 开始向 Vertex AI 发送流式请求...")
 
-	// 流式生成内容
+	// 流式生成内容，逐段回调
 	iter := model.GenerateContentStream(ctx, genai.Text(sanitizedPrompt))
-	return iter, nil
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			log.Printf("[ERROR] 流式生成内容失败: %v", err)
+			return fmt.Errorf("流式生成内容失败: %v", err)
+		}
+
+		for _, candidate := range resp.Candidates {
+			if candidate.FinishReason == genai.FinishReasonSafety {
+				return fmt.Errorf("内容被安全策略限制")
+			}
+			if candidate.Content == nil {
+				continue
+			}
+			for _, part := range candidate.Content.Parts {
+				if text, ok := part.(genai.Text); ok {
+					if err := onChunk(string(text)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
 }
 
 // BuildHomeworkAnalysisPrompt 构建作业分析提示
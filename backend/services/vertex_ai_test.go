@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"strings"
 	"testing"
 )
@@ -19,9 +20,9 @@ func TestMockMode(t *testing.T) {
 		homeworkType string
 		expectedKey  string
 	}{
-		{"数学作业", "数学", "解方程步骤正确"},
-		{"语文作业", "语文", "背诵正确"},
-		{"英语作业", "英语", "名词复数形式错误"},
+		{"数学作业", "数学", "计算正确"},
+		{"语文作业", "语文", "理解深刻"},
+		{"英语作业", "英语", "答案有误"},
 		{"未知类型", "物理", "学生整体表现良好"},
 	}
 
@@ -32,6 +33,7 @@ func TestMockMode(t *testing.T) {
 
 			// 调用模拟API
 			result, err := client.GenerateContentWithFile(
+				context.Background(),
 				"你是一个专业的作业批改助手",
 				"test_file.jpg", // 虚拟文件名
 				"image/jpeg",    // 虚拟MIME类型
@@ -49,6 +51,14 @@ func TestMockMode(t *testing.T) {
 				t.Errorf("预期结果包含'%s'，但没有找到。实际结果: %s",
 					tc.expectedKey, result)
 			}
+
+			// JSON模式下的模拟结果应符合批改结果的schema
+			graded, err := GradeHomeworkFile(context.Background(), client, "", "test_file.jpg", "image/jpeg", prompt)
+			if err != nil {
+				t.Errorf("模拟结果不符合schema: %v", err)
+			} else if len(graded.Answers) == 0 || graded.Answers[0].MaxPoints == nil {
+				t.Errorf("模拟结果缺少每题分值: %+v", graded)
+			}
 		})
 	}
 }
@@ -8,14 +8,35 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// devJWTKey 未设置JWT_SECRET时使用的默认密钥，仅用于开发和测试环境
+const devJWTKey = "homework_marking_default_dev_key"
+
+// publicJWTSecrets 代码和示例配置文件中公开的密钥，用它们签名的令牌任何人都可以伪造
+var publicJWTSecrets = map[string]bool{
+	devJWTKey: true,
+	"please_change_this_to_a_strong_random_secret_in_production": true, // .env.example
+	"your-secret-key-change-in-production":                       true,
+}
+
+// CheckJWTSecret 检查JWT_SECRET是否已设置为非公开的密钥，未设置或使用了示例配置中的密钥时返回错误
+func CheckJWTSecret() error {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return fmt.Errorf("未设置JWT_SECRET环境变量")
+	}
+	if publicJWTSecrets[jwtSecret] {
+		return fmt.Errorf("JWT_SECRET使用了示例配置中公开的密钥")
+	}
+	return nil
+}
+
 // 从环境变量获取JWT密钥，如果不存在则使用默认值
-// 在生产环境中应始终设置环境变量JWT_SECRET
+// 生产环境（GIN_MODE=release）中main会检查JWT_SECRET，未设置或使用公开的密钥时拒绝启动
 func getJWTKey() []byte {
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
 		// 默认密钥仅用于开发和测试环境
-		// 实际生产环境中，如果未设置环境变量应该抛出错误
-		jwtSecret = "homework_marking_default_dev_key"
+		jwtSecret = devJWTKey
 		fmt.Println("警告: 未设置JWT_SECRET环境变量，使用默认开发密钥")
 	}
 	return []byte(jwtSecret)
@@ -23,20 +44,22 @@ func getJWTKey() []byte {
 
 // 用户声明结构体
 type Claims struct {
-	UserID string `json:"user_id"`
-	Role   string `json:"role"`
+	UserID   string   `json:"user_id"`
+	Role     string   `json:"role"`
+	ClassIDs []string `json:"class_ids,omitempty"` // 只读用户可以查看的班级
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT 生成JWT令牌
-func GenerateJWT(userID, role string) (string, error) {
+// GenerateJWT 生成JWT令牌，认证时以账号最新的角色和班级为准
+func GenerateJWT(userID, role string, classIDs []string) (string, error) {
 	// 设置token过期时间为24小时
 	expirationTime := time.Now().Add(24 * time.Hour)
 
 	// 创建JWT声明
 	claims := &Claims{
-		UserID: userID,
-		Role:   role,
+		UserID:   userID,
+		Role:     role,
+		ClassIDs: classIDs,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
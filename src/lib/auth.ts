@@ -0,0 +1,79 @@
+import axios from 'axios';
+
+// 登录令牌在浏览器中的存储键
+const TOKEN_KEY = 'homework_marking_token';
+
+// 获取当前登录令牌，服务端渲染时返回null
+export function getToken(): string | null {
+  if (typeof window === 'undefined') {
+    return null;
+  }
+  return window.localStorage.getItem(TOKEN_KEY);
+}
+
+// 保存登录令牌
+export function setToken(token: string) {
+  window.localStorage.setItem(TOKEN_KEY, token);
+}
+
+// 清除登录令牌
+export function clearToken() {
+  window.localStorage.removeItem(TOKEN_KEY);
+}
+
+// 返回带Authorization的请求头，用于fetch和react-pdf加载文件
+export function authHeaders(): Record<string, string> {
+  const token = getToken();
+  return token ? { Authorization: `Bearer ${token}` } : {};
+}
+
+// 为新窗口打开的文件链接加上token查询参数，浏览器打开链接时无法设置请求头
+// 后端只在文件和任务事件接口接受该参数
+export function withTokenQuery(url: string): string {
+  const token = getToken();
+  if (!token) {
+    return url;
+  }
+  const separator = url.includes('?') ? '&' : '?';
+  return `${url}${separator}token=${encodeURIComponent(token)}`;
+}
+
+// 跳转到登录页，登录后返回当前页面
+export function redirectToLogin() {
+  if (typeof window === 'undefined' || window.location.pathname === '/login') {
+    return;
+  }
+  const redirect = encodeURIComponent(window.location.pathname + window.location.search);
+  window.location.href = `/login?redirect=${redirect}`;
+}
+
+let interceptorsInstalled = false;
+
+// 为axios请求加上登录令牌，令牌失效或缺失时清除令牌并跳转到登录页
+export function setupAuthInterceptors() {
+  if (interceptorsInstalled) {
+    return;
+  }
+  interceptorsInstalled = true;
+
+  axios.interceptors.request.use((config) => {
+    const token = getToken();
+    if (token) {
+      config.headers.set('Authorization', `Bearer ${token}`);
+    }
+    return config;
+  });
+
+  axios.interceptors.response.use(
+    (response) => response,
+    (error) => {
+      const url: string = error.config?.url || '';
+      // 登录和注册接口的401表示用户名或密码错误，由登录页自行提示
+      if (error.response?.status === 401 && !url.startsWith('/api/auth/')) {
+        clearToken();
+        redirectToLogin();
+      }
+      return Promise.reject(error);
+    }
+  );
+}
@@ -3,18 +3,36 @@ import type { AppProps } from 'next/app';
 import Link from 'next/link';
 import { Providers } from '../app/providers';
 import axios from 'axios';
-import { useEffect } from 'react';
+import { useEffect, useState } from 'react';
+import { useRouter } from 'next/router';
+import { setupAuthInterceptors, getToken, clearToken } from '../lib/auth';
 
 // 配置Axios默认行为
 axios.defaults.baseURL = process.env.NEXT_PUBLIC_API_URL || '';
 axios.defaults.headers.common['Content-Type'] = 'application/json';
+// 除登录和注册外，后端接口都需要登录令牌
+setupAuthInterceptors();
 
 export default function MyApp({ Component, pageProps }: AppProps) {
+  const router = useRouter();
+  const [loggedIn, setLoggedIn] = useState<boolean>(false);
+
   useEffect(() => {
     // 记录API请求路径，便于调试
     console.log(`API请求路径: ${axios.defaults.baseURL || '使用相对路径'}`);
   }, []);
 
+  // 令牌保存在浏览器中，切换页面时刷新登录状态
+  useEffect(() => {
+    setLoggedIn(!!getToken());
+  }, [router.asPath]);
+
+  const handleLogout = () => {
+    clearToken();
+    setLoggedIn(false);
+    router.push('/login');
+  };
+
   return (
     <Providers>
       <div className="min-h-screen flex flex-col">
@@ -31,6 +49,11 @@ export default function MyApp({ Component, pageProps }: AppProps) {
               <Link href="/" className="text-gray-700 hover:text-blue-600">首页</Link>
               <Link href="/homework" className="text-gray-700 hover:text-blue-600">作业上传</Link>
               <Link href="/mark-homework" className="text-gray-700 hover:text-blue-600">作业批改</Link>
+              {loggedIn ? (
+                <button onClick={handleLogout} className="text-gray-700 hover:text-blue-600">退出登录</button>
+              ) : (
+                <Link href="/login" className="text-gray-700 hover:text-blue-600">登录</Link>
+              )}
             </nav>
           </div>
         </header>
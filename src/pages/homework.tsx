@@ -7,6 +7,7 @@ import * as XLSX from 'xlsx';
 import { Document, Page, pdfjs } from 'react-pdf';
 import 'react-pdf/dist/esm/Page/AnnotationLayer.css';
 import 'react-pdf/dist/esm/Page/TextLayer.css';
+import { authHeaders, getToken, redirectToLogin, withTokenQuery } from '../lib/auth';
 
 // 使用 Worker 静态引入方式
 pdfjs.GlobalWorkerOptions.workerSrc = '/pdf.worker.min.js';
@@ -119,6 +120,18 @@ const Homework: React.FC = () => {
     standardFontDataUrl: 'https://cdn.jsdelivr.net/npm/pdfjs-dist@3.11.174/standard_fonts/'
   }), []); // 空依赖数组表示这个对象只会在组件首次渲染时创建
 
+  // PDF文件需要登录令牌，通过请求头传递；只在URL变化时重新创建，避免重复加载
+  const pdfFile = useMemo(() => (
+    currentPdfUrl ? { url: currentPdfUrl, httpHeaders: authHeaders() } : null
+  ), [currentPdfUrl]);
+
+  // 未登录时跳转到登录页
+  useEffect(() => {
+    if (!getToken()) {
+      redirectToLogin();
+    }
+  }, []);
+
   // 异步轮询任务状态
   useEffect(() => {
     console.log('组件初始化，设置清理函数');
@@ -630,7 +643,8 @@ const Homework: React.FC = () => {
         class: data.class || '',
         answers: [],
         score: data.overallScore || data.score || '0',
-        feedback: data.feedback || '',
+        // 批改失败的学生以错误条目返回
+        feedback: data.status === 'error' ? `批改失败: ${data.error || '未知错误'}` : (data.feedback || ''),
         totalQuestions: 0,
         correctCount: 0
       };
@@ -1331,7 +1345,7 @@ const Homework: React.FC = () => {
                     {currentPdfUrl ? (
                       <div key={currentPdfUrl} style={{ width: '100%', display: 'flex', justifyContent: 'center' }}>
                         <Document
-                          file={currentPdfUrl}
+                          file={pdfFile}
                           onLoadSuccess={onDocumentLoadSuccess}
                           onLoadProgress={(progress) => console.log(`[PDF] 加载进度: ${JSON.stringify(progress)}`)}
                           loading={<Spin tip="PDF正在下载中，请等待..." />}
@@ -1796,7 +1810,8 @@ const Homework: React.FC = () => {
     
     console.log(`[PDF] Opening PDF directly in browser: ${pdfUrl}`);
     // Open PDF in a new tab
-    window.open(pdfUrl, '_blank');
+    // 新标签页无法设置请求头，登录令牌通过查询参数传递
+    window.open(withTokenQuery(pdfUrl), '_blank');
   };
 
   // Function to view student's PDF alongside answers
@@ -1848,7 +1863,7 @@ const Homework: React.FC = () => {
       console.log(`[DEBUG] 状态已设置，确认Modal应该显示 (showSideBySide=${studentIndex})`);
       
       // 尝试主动获取PDF以验证URL是否有效
-      fetch(pdfUrl, { method: 'Get' })
+      fetch(pdfUrl, { method: 'GET', headers: authHeaders() })
         .then(response => {
           console.log(`[DEBUG] PDF URL 检查结果: ${response.status} ${response.statusText}`, response);
           if (!response.ok) {
@@ -1960,6 +1975,7 @@ const Homework: React.FC = () => {
                 <Select.Option value="math">数学作业</Select.Option>
                 <Select.Option value="english">英语作业</Select.Option>
                 <Select.Option value="chinese">语文作业</Select.Option>
+                <Select.Option value="essay">作文（按评分标准）</Select.Option>
               </Select>
             </Form.Item>
 
@@ -2136,7 +2152,7 @@ const Homework: React.FC = () => {
             {currentPdfUrl ? (
               <div key={currentPdfUrl} style={{ width: '100%', display: 'flex', justifyContent: 'center' }}>
                 <Document
-                  file={currentPdfUrl}
+                  file={pdfFile}
                   onLoadSuccess={onDocumentLoadSuccess}
                   onLoadProgress={(progress) => console.log(`[PDF] 加载进度: ${JSON.stringify(progress)}`)}
                   loading={<Spin tip="PDF正在下载中，请等待..." />}
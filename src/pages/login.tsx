@@ -0,0 +1,79 @@
+import React, { useState } from 'react';
+import { useRouter } from 'next/router';
+import { Form, Input, Button, Card, Typography, message } from 'antd';
+import axios from 'axios';
+import { setToken } from '../lib/auth';
+
+const { Title, Text } = Typography;
+
+interface LoginValues {
+  username: string;
+  password: string;
+  name?: string;
+}
+
+const Login: React.FC = () => {
+  const router = useRouter();
+  const [form] = Form.useForm<LoginValues>();
+  const [loading, setLoading] = useState<boolean>(false);
+  // 第一个注册的账号为管理员，之后是否开放注册由后端决定
+  const [isRegister, setIsRegister] = useState<boolean>(false);
+
+  const handleSubmit = async (values: LoginValues) => {
+    setLoading(true);
+    try {
+      const url = isRegister ? '/api/auth/register' : '/api/auth/login';
+      const response = await axios.post(url, values);
+      const token = response.data?.data?.token;
+      if (!token) {
+        message.error('响应中没有登录令牌');
+        return;
+      }
+      setToken(token);
+      message.success(isRegister ? '注册成功' : '登录成功');
+
+      // 只跳转到站内页面
+      const redirect = typeof router.query.redirect === 'string' ? router.query.redirect : '';
+      router.replace(redirect.startsWith('/') && !redirect.startsWith('//') ? redirect : '/homework');
+    } catch (error: any) {
+      console.error(isRegister ? '注册失败:' : '登录失败:', error);
+      message.error(error.response?.data?.error || (isRegister ? '注册失败，请重试' : '登录失败，请重试'));
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div className="flex justify-center py-16 px-4">
+      <Card className="w-full max-w-md">
+        <Title level={3}>{isRegister ? '注册账号' : '登录'}</Title>
+        <Form form={form} layout="vertical" onFinish={handleSubmit}>
+          <Form.Item name="username" label="用户名" rules={[{ required: true, message: '请输入用户名' }]}>
+            <Input autoComplete="username" />
+          </Form.Item>
+          {isRegister && (
+            <Form.Item name="name" label="姓名">
+              <Input />
+            </Form.Item>
+          )}
+          <Form.Item name="password" label="密码" rules={[{ required: true, message: '请输入密码' }]}>
+            <Input.Password autoComplete={isRegister ? 'new-password' : 'current-password'} />
+          </Form.Item>
+          <Form.Item>
+            <Button type="primary" htmlType="submit" loading={loading} block>
+              {isRegister ? '注册' : '登录'}
+            </Button>
+          </Form.Item>
+        </Form>
+        <Text type="secondary">
+          {isRegister ? '已有账号？' : '还没有账号？'}
+          <Button type="link" onClick={() => setIsRegister(!isRegister)}>
+            {isRegister ? '去登录' : '注册'}
+          </Button>
+        </Text>
+      </Card>
+    </div>
+  );
+};
+
+export default Login;